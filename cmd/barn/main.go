@@ -2,12 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/thebuh/barn/internal/action"
 	api "github.com/thebuh/barn/internal/api"
 	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/auth"
+	"github.com/thebuh/barn/internal/history"
+	"github.com/thebuh/barn/internal/metrics"
+	"github.com/thebuh/barn/internal/notify"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/record"
 	"github.com/thebuh/barn/pkg/discovery"
 )
 
@@ -37,14 +49,33 @@ weather:
 	viper.ReadConfig(bytes.NewBuffer(yamlExample))
 }
 
+func apiTLSConfig(v *viper.Viper) api.TLSConfig {
+	return api.TLSConfig{
+		CertFile:           v.GetString("api.tls.cert"),
+		KeyFile:            v.GetString("api.tls.key"),
+		ClientCAFile:       v.GetString("api.tls.client_ca"),
+		ClientCertOptional: v.GetBool("api.tls.client_cert_optional"),
+		HTTPPort:           v.GetUint32("api.tls.http_port"),
+		Redirect:           v.GetBool("api.tls.redirect"),
+	}
+}
+
 func main() {
 	viper.SetConfigName("barn")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 	viper.SetDefault("discovery.port", 32227)
 	viper.SetDefault("api.port", 8080)
+	viper.SetDefault("overrides.path", "overrides.json")
 	viper.ReadInConfig()
 	log.SetFormatter(&log.TextFormatter{})
+	if len(os.Args) > 1 && os.Args[1] == "override" {
+		server := fmt.Sprintf("http://127.0.0.1:%d", viper.GetUint32("api.port"))
+		if viper.IsSet("api.tls.cert") {
+			server = fmt.Sprintf("https://localhost:%d", viper.GetUint32("api.port"))
+		}
+		os.Exit(overrideCommand(os.Args[2:], server))
+	}
 	//fakeConfig()
 
 	//if err != nil {
@@ -54,16 +85,92 @@ func main() {
 	mCfg := viper.GetViper()
 	barnApp.LoadMonitorsFromConfig(mCfg)
 	barnApp.LoadWeatherFromConfig(mCfg)
+	barnApp.LoadDomesFromConfig(mCfg)
 
 	apiPort := viper.GetUint32("api.port")
 	discoveryPort := viper.GetUint32("discovery.port")
-	disc := discovery.NewDiscoverySever(discoveryPort, apiPort)
 	api := api.NewApiServer(barnApp, apiPort)
+	if viper.IsSet("api.tls.cert") {
+		err := api.UseTLS(apiTLSConfig(viper.GetViper()))
+		if err != nil {
+			log.WithError(err).Fatal("[BARN] TLS. Failed to load certificate")
+		}
+	}
+	disc := discovery.NewDiscoverySever(discoveryPort, api.DiscoveryPort())
+	barnApp.AddListener(api)
+	authenticator, err := auth.LoadFromConfig(mCfg)
+	if err != nil {
+		log.WithError(err).Fatal("[BARN] Auth. Invalid configuration")
+	}
+	if authenticator != nil {
+		api.UseAuth(authenticator)
+	}
+	api.UseSetup(barnApp)
+	overrides, err := override.Open(viper.GetString("overrides.path"))
+	if err != nil {
+		log.WithError(err).Fatal("[BARN] Override. Failed to load overrides")
+	}
+	barnApp.UseOverrides(overrides)
+	api.UseOverrides(overrides)
+	if viper.GetBool("metrics.enabled") {
+		m := metrics.New(barnApp)
+		barnApp.AddListener(m)
+		metricsPort := viper.GetUint32("metrics.port")
+		api.UseMetrics(m, metricsPort == 0 || metricsPort == apiPort)
+		if metricsPort != 0 && metricsPort != apiPort {
+			go api.StartMetrics(metricsPort)
+		}
+	}
+	if path := viper.GetString("history.path"); path != "" {
+		store, err := history.Open(path, viper.GetDuration("history.retention"))
+		if err != nil {
+			log.WithError(err).Fatal(fmt.Sprintf("[BARN] History. Failed to open [%s]", path))
+		}
+		defer store.Close()
+		barnApp.AddListener(store)
+		api.UseHistory(store)
+	}
+	if path := viper.GetString("recording.path"); path != "" {
+		recorder, err := record.Open(path)
+		if err != nil {
+			log.WithError(err).Fatal(fmt.Sprintf("[BARN] Record. Failed to open [%s]", path))
+		}
+		defer recorder.Close()
+		barnApp.UseRecorder(recorder)
+	}
+	notifier, err := notify.LoadFromConfig(mCfg)
+	if err != nil {
+		log.WithError(err).Fatal("[BARN] Notify. Invalid notifications config")
+	}
+	if notifier != nil {
+		defer notifier.Close()
+		barnApp.AddListener(notifier)
+	}
+	actions, err := action.LoadFromConfig(mCfg, barnApp, notifier)
+	if err != nil {
+		log.WithError(err).Fatal("[BARN] Action. Invalid actions config")
+	}
+	if actions != nil {
+		defer actions.Close()
+		barnApp.AddListener(actions)
+	}
 	go disc.Start()
 	defer disc.Close()
 	go api.Start()
+
+	// Return on SIGINT or SIGTERM, so the deferred Close calls flush and stop
+	// the notifier, actions, recorder and history
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
 	for {
 		barnApp.Refresh()
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			log.Info("[BARN] Shutting down")
+			return
+		case <-ticker.C:
+		}
 	}
 }
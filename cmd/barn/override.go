@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const overrideUsage = `Usage:
+  barn override list
+  barn override set <monitor> safe|unsafe --for <duration> [--reason <text>]
+  barn override set <monitor> safe|unsafe --until <RFC3339 time> [--reason <text>]
+  barn override clear <monitor>
+
+When the server requires authentication, pass an admin token with --token or
+the BARN_TOKEN environment variable. With a self-signed certificate, add
+--insecure.
+
+Flags:
+`
+
+// overrideCommand manages overrides on a running barn server through its
+// admin endpoint
+func overrideCommand(args []string, defaultServer string) int {
+	fs := flag.NewFlagSet("override", flag.ContinueOnError)
+	server := fs.String("server", defaultServer, "barn API address")
+	duration := fs.Duration("for", 0, "how long the override lasts")
+	until := fs.String("until", "", "when the override expires (RFC3339)")
+	reason := fs.String("reason", "", "why the monitor is overridden")
+	by := fs.String("by", currentUser(), "who sets the override")
+	token := fs.String("token", os.Getenv("BARN_TOKEN"), "bearer token for the admin endpoint")
+	insecure := fs.Bool("insecure", false, "skip verification of the server certificate")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), overrideUsage)
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second, Transport: clientTransport(*token, *insecure)}
+	base := strings.TrimRight(*server, "/") + "/admin/overrides"
+	switch {
+	case positional[0] == "list" && len(positional) == 1:
+		return overrideList(client, base)
+	case positional[0] == "set" && len(positional) == 3:
+		body := map[string]string{
+			"state":  positional[2],
+			"reason": *reason,
+			"set_by": *by,
+		}
+		if *duration > 0 {
+			body["duration"] = duration.String()
+		}
+		if *until != "" {
+			body["expires"] = *until
+		}
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPut, base+"/"+url.PathEscape(positional[1]), bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		return overrideDo(client, req)
+	case positional[0] == "clear" && len(positional) == 2:
+		req, _ := http.NewRequest(http.MethodDelete, base+"/"+url.PathEscape(positional[1])+"?by="+url.QueryEscape(*by), nil)
+		return overrideDo(client, req)
+	}
+	fs.Usage()
+	return 2
+}
+
+// parseInterspersed allows flags after positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// clientTransport adds the token to every request and optionally accepts
+// self-signed server certificates
+func clientTransport(token string, insecure bool) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if token == "" {
+		return transport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+		return transport.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func overrideList(client *http.Client, base string) int {
+	resp, err := client.Get(base)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return overrideFailed(resp)
+	}
+	var list []struct {
+		Monitor string    `json:"monitor"`
+		Safe    bool      `json:"safe"`
+		Reason  string    `json:"reason"`
+		SetBy   string    `json:"set_by"`
+		Expires time.Time `json:"expires"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MONITOR\tSTATE\tEXPIRES\tSET BY\tREASON")
+	for _, o := range list {
+		state := "unsafe"
+		if o.Safe {
+			state = "safe"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", o.Monitor, state, o.Expires.Local().Format(time.RFC3339), o.SetBy, o.Reason)
+	}
+	w.Flush()
+	return 0
+}
+
+func overrideDo(client *http.Client, req *http.Request) int {
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return overrideFailed(resp)
+	}
+	fmt.Println("OK")
+	return 0
+}
+
+func overrideFailed(resp *http.Response) int {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	fmt.Fprintf(os.Stderr, "%s: %s\n", resp.Status, strings.TrimSpace(string(msg)))
+	return 1
+}
+
+func currentUser() string {
+	name := "cli"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
@@ -0,0 +1,159 @@
+package action
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/thebuh/barn/internal/command"
+	"github.com/thebuh/barn/internal/dome"
+)
+
+// Triggers
+const (
+	TriggerUnsafe = "unsafe"
+	TriggerSafe   = "safe"
+)
+
+// Confirmation defaults
+const (
+	DefaultConfirmTimeout  = 2 * time.Minute
+	DefaultConfirmInterval = 5 * time.Second
+)
+
+var ErrNotConfirmed = errors.New("not confirmed before the timeout")
+
+// Confirmation checks that an action took effect
+type Confirmation interface {
+	Confirmed() (bool, error)
+}
+
+// Action runs a command when a monitor becomes unsafe or safe
+type Action struct {
+	Name string
+	// Monitors limits the action to the given monitor ids. Empty matches all monitors.
+	Monitors []string
+	// On is TriggerUnsafe or TriggerSafe
+	On      string
+	Command command.Command
+	// Confirm is polled every ConfirmInterval after the command succeeded.
+	// An attempt fails when it is not confirmed within ConfirmTimeout.
+	Confirm         Confirmation
+	ConfirmTimeout  time.Duration
+	ConfirmInterval time.Duration
+	// Attempts and Delay retry failed attempts with exponential backoff
+	Attempts int
+	Delay    time.Duration
+	// Escalate names the notification channels told when all attempts failed
+	Escalate []string
+}
+
+// Validate checks the action and fills in defaults
+func (a *Action) Validate() error {
+	if a.Name == "" {
+		return errors.New("name is required")
+	}
+	if a.On == "" {
+		a.On = TriggerUnsafe
+	}
+	if a.On != TriggerUnsafe && a.On != TriggerSafe {
+		return fmt.Errorf("unknown trigger %s", a.On)
+	}
+	if a.Command == nil {
+		return errors.New("command is required")
+	}
+	if a.ConfirmTimeout < 0 || a.ConfirmInterval < 0 || a.Delay < 0 {
+		return errors.New("durations must not be negative")
+	}
+	if a.ConfirmTimeout == 0 {
+		a.ConfirmTimeout = DefaultConfirmTimeout
+	}
+	if a.ConfirmInterval == 0 {
+		a.ConfirmInterval = DefaultConfirmInterval
+	}
+	if a.Attempts < 1 {
+		a.Attempts = 1
+	}
+	return nil
+}
+
+// StatusConfirmation matches a regular expression against a status
+type StatusConfirmation struct {
+	status  command.Status
+	pattern *regexp.Regexp
+}
+
+// NewStatusConfirmation confirms once the status matches pattern
+func NewStatusConfirmation(status command.Status, pattern string) (*StatusConfirmation, error) {
+	if status == nil {
+		return nil, errors.New("status is required")
+	}
+	if pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return &StatusConfirmation{status: status, pattern: re}, nil
+}
+
+func (c *StatusConfirmation) Confirmed() (bool, error) {
+	raw, err := c.status.Read()
+	if err != nil {
+		return false, err
+	}
+	return c.pattern.MatchString(strings.TrimSpace(raw)), nil
+}
+
+// DomeLookup returns a dome by id, or nil
+type DomeLookup func(id string) dome.Dome
+
+// DomeClose closes a dome served by barn and confirms it is closed. The dome
+// is looked up on every use so reloaded domes are found.
+type DomeClose struct {
+	id     string
+	lookup DomeLookup
+}
+
+// NewDomeClose creates a command closing the dome id
+func NewDomeClose(id string, lookup DomeLookup) (*DomeClose, error) {
+	if id == "" {
+		return nil, errors.New("dome is required")
+	}
+	if lookup == nil {
+		return nil, errors.New("dome lookup is required")
+	}
+	return &DomeClose{id: id, lookup: lookup}, nil
+}
+
+func (d *DomeClose) dome() (dome.Dome, error) {
+	found := d.lookup(d.id)
+	if found == nil {
+		return nil, fmt.Errorf("unknown dome %s", d.id)
+	}
+	return found, nil
+}
+
+// Run starts closing the dome
+func (d *DomeClose) Run() error {
+	found, err := d.dome()
+	if err != nil {
+		return err
+	}
+	return found.CloseShutter()
+}
+
+// Confirmed refreshes the dome and reports whether its shutter is closed
+func (d *DomeClose) Confirmed() (bool, error) {
+	found, err := d.dome()
+	if err != nil {
+		return false, err
+	}
+	if err := found.Refresh(); err != nil {
+		return false, err
+	}
+	return found.ShutterStatus() == dome.ShutterClosed, nil
+}
@@ -0,0 +1,77 @@
+package action
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/command"
+	"github.com/thebuh/barn/internal/dome"
+)
+
+func TestAction_Validate(t *testing.T) {
+	a := &Action{Name: "close", Command: &countingCommand{}}
+	assert.NoError(t, a.Validate(), "should work")
+	assert.Equal(t, TriggerUnsafe, a.On, "they should be equal")
+	assert.Equal(t, DefaultConfirmTimeout, a.ConfirmTimeout, "they should be equal")
+	assert.Equal(t, DefaultConfirmInterval, a.ConfirmInterval, "they should be equal")
+	assert.Equal(t, 1, a.Attempts, "they should be equal")
+}
+
+func TestStatusConfirmation(t *testing.T) {
+	status, _ := command.NewExec("echo", []string{"roof=closed"}, time.Second)
+	confirmation, err := NewStatusConfirmation(status, `closed$`)
+	assert.NoError(t, err, "should work")
+	confirmed, err := confirmation.Confirmed()
+	assert.NoError(t, err, "should work")
+	assert.True(t, confirmed, "trailing newlines should be ignored")
+
+	confirmation, _ = NewStatusConfirmation(status, `open`)
+	confirmed, _ = confirmation.Confirmed()
+	assert.False(t, confirmed, "should not be confirmed")
+
+	failing, _ := command.NewExec("false", nil, time.Second)
+	confirmation, _ = NewStatusConfirmation(failing, `closed`)
+	_, err = confirmation.Confirmed()
+	assert.Error(t, err, "should fail")
+
+	_, err = NewStatusConfirmation(status, "")
+	assert.Error(t, err, "should fail")
+	_, err = NewStatusConfirmation(status, "(")
+	assert.Error(t, err, "should fail")
+	_, err = NewStatusConfirmation(nil, "closed")
+	assert.Error(t, err, "should fail")
+}
+
+func TestDomeClose(t *testing.T) {
+	simulator, _ := dome.NewSimulator(20*time.Millisecond, true)
+	roof, err := dome.NewRoof("roof", "Roof", "", simulator, dome.Options{})
+	assert.NoError(t, err, "should work")
+	domes := map[string]dome.Dome{"roof": roof}
+	lookup := func(id string) dome.Dome {
+		if d, exists := domes[id]; exists {
+			return d
+		}
+		return nil
+	}
+
+	closeRoof, err := NewDomeClose("roof", lookup)
+	assert.NoError(t, err, "should work")
+	confirmed, err := closeRoof.Confirmed()
+	assert.NoError(t, err, "should work")
+	assert.False(t, confirmed, "open roofs should not be confirmed")
+	assert.NoError(t, closeRoof.Run(), "should work")
+	assert.Eventually(t, func() bool {
+		confirmed, err := closeRoof.Confirmed()
+		return err == nil && confirmed
+	}, time.Second, 5*time.Millisecond, "the roof should close")
+
+	missing, _ := NewDomeClose("shed", lookup)
+	assert.Error(t, missing.Run(), "should fail")
+	_, err = missing.Confirmed()
+	assert.Error(t, err, "should fail")
+	_, err = NewDomeClose("", lookup)
+	assert.Error(t, err, "should fail")
+	_, err = NewDomeClose("roof", nil)
+	assert.Error(t, err, "should fail")
+}
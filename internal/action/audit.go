@@ -0,0 +1,69 @@
+package action
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Audit steps
+const (
+	StepTriggered = "triggered"
+	StepSkipped   = "skipped"
+	StepAttempt   = "attempt"
+	StepFailed    = "failed"
+	StepSucceeded = "succeeded"
+	StepGaveUp    = "gave_up"
+	StepEscalated = "escalated"
+)
+
+// Entry is one step of an action execution. Entries of one execution share
+// its id.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Execution string    `json:"execution"`
+	Step      string    `json:"step"`
+	Monitor   string    `json:"monitor,omitempty"`
+	Trigger   string    `json:"trigger,omitempty"`
+	Attempt   int       `json:"attempt,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Audit is an append-only file of entries, one JSON object per line
+type Audit struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAudit opens the audit file at path, creating it if needed
+func OpenAudit(path string) (*Audit, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Audit{file: file}, nil
+}
+
+// Write appends an entry
+func (a *Audit) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+func (a *Audit) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
@@ -0,0 +1,67 @@
+package action
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/monitor"
+)
+
+func readAudit(t *testing.T, path string) []Entry {
+	f, err := os.Open(path)
+	assert.NoError(t, err, "should work")
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e), "should be valid json")
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "actions.jsonl")
+	audit, err := OpenAudit(path)
+	assert.NoError(t, err, "should work")
+	closeRoof := &countingCommand{fail: 1}
+	notifier := &recordingNotifier{}
+	r, err := New([]*Action{{
+		Name:     "close",
+		Command:  closeRoof,
+		Attempts: 2,
+		Delay:    time.Millisecond,
+		Escalate: []string{"pushover"},
+	}}, nil, notifier, audit)
+	assert.NoError(t, err, "should work")
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "", true)
+	refresh(r, rain, true)
+	refresh(r, rain, false)
+	assert.Eventually(t, func() bool { return closeRoof.count() == 2 }, time.Second, time.Millisecond, "should retry")
+	r.Close()
+
+	entries := readAudit(t, path)
+	var steps []string
+	for _, e := range entries {
+		steps = append(steps, e.Step)
+		assert.Equal(t, entries[0].Execution, e.Execution, "entries of one execution should share its id")
+		assert.Equal(t, "rain", e.Monitor, "they should be equal")
+		assert.Equal(t, TriggerUnsafe, e.Trigger, "they should be equal")
+	}
+	assert.Equal(t, []string{StepTriggered, StepAttempt, StepFailed, StepAttempt, StepSucceeded}, steps, "they should be equal")
+	assert.Equal(t, "controller offline", entries[2].Error, "they should be equal")
+	assert.Empty(t, notifier.sent(), "succeeded actions should not escalate")
+
+	// Reopening appends
+	audit, err = OpenAudit(path)
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, audit.Write(Entry{Action: "close", Step: StepSkipped}), "should work")
+	assert.NoError(t, audit.Close(), "should work")
+	assert.Len(t, readAudit(t, path), 6, "they should be equal")
+}
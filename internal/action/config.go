@@ -0,0 +1,146 @@
+package action
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/viper"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/command"
+	"github.com/thebuh/barn/internal/notify"
+)
+
+// LoadFromConfig builds a runner from the actions section. It returns nil
+// when no rules are configured. Monitors and domes are looked up in server;
+// escalations need notifier, which may be nil otherwise.
+func LoadFromConfig(v *viper.Viper, server app.Server, notifier *notify.Notifier) (*Runner, error) {
+	rulesConfig := v.GetStringMap("actions.rules")
+	if len(rulesConfig) == 0 {
+		return nil, nil
+	}
+	retry := notify.DefaultRetryPolicy
+	if v.IsSet("actions.retry.attempts") {
+		retry.Attempts = v.GetInt("actions.retry.attempts")
+	}
+	if v.IsSet("actions.retry.delay") {
+		retry.Delay = v.GetDuration("actions.retry.delay")
+	}
+
+	names := make([]string, 0, len(rulesConfig))
+	for name := range rulesConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var actions []*Action
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	for _, name := range names {
+		vt := v.Sub(fmt.Sprintf("actions.rules.%s", name))
+		a, connection, err := actionFromConfig(name, vt, server, notifier, retry)
+		if connection != nil {
+			closers = append(closers, connection)
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("action %s: %w", name, err)
+		}
+		actions = append(actions, a)
+	}
+
+	var audit *Audit
+	if path := v.GetString("actions.audit_log"); path != "" {
+		var err error
+		if audit, err = OpenAudit(path); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("audit log: %w", err)
+		}
+	}
+	// A nil notifier must not become a non-nil interface
+	var n Notifier
+	if notifier != nil {
+		n = notifier
+	}
+	r, err := New(actions, server.GetMonitor, n, audit)
+	if err != nil {
+		closeAll()
+		if audit != nil {
+			audit.Close()
+		}
+		return nil, err
+	}
+	r.closers = closers
+	return r, nil
+}
+
+// actionFromConfig reads monitors, on, retry, escalate and confirm, and either
+// a dome to close or a run command with its optional mqtt broker section. The
+// MQTT connection is returned even on errors so it can be closed.
+func actionFromConfig(name string, vt *viper.Viper, server app.Server, notifier *notify.Notifier,
+	retry notify.RetryPolicy) (*Action, *command.MQTTConnection, error) {
+	a := &Action{
+		Name:     name,
+		Monitors: vt.GetStringSlice("monitors"),
+		On:       vt.GetString("on"),
+		Attempts: retry.Attempts,
+		Delay:    retry.Delay,
+		Escalate: vt.GetStringSlice("escalate"),
+	}
+	if vt.IsSet("retry.attempts") {
+		a.Attempts = vt.GetInt("retry.attempts")
+	}
+	if vt.IsSet("retry.delay") {
+		a.Delay = vt.GetDuration("retry.delay")
+	}
+	for _, id := range a.Monitors {
+		if server.GetMonitor(id) == nil {
+			return nil, nil, fmt.Errorf("unknown monitor %s", id)
+		}
+	}
+	for _, channel := range a.Escalate {
+		if notifier == nil || !notifier.HasChannel(channel) {
+			return nil, nil, fmt.Errorf("unknown notification channel %s", channel)
+		}
+	}
+	a.ConfirmTimeout = vt.GetDuration("confirm.timeout")
+	a.ConfirmInterval = vt.GetDuration("confirm.interval")
+
+	if vt.IsSet("dome") == vt.IsSet("run") {
+		return nil, nil, errors.New("one of dome or run is required")
+	}
+	if vt.IsSet("dome") {
+		id := vt.GetString("dome")
+		if server.GetDome(id) == nil {
+			return nil, nil, fmt.Errorf("unknown dome %s", id)
+		}
+		closeDome, err := NewDomeClose(id, server.GetDome)
+		if err != nil {
+			return nil, nil, err
+		}
+		a.Command, a.Confirm = closeDome, closeDome
+		return a, nil, a.Validate()
+	}
+
+	connection, err := command.MQTTFromConfig(vt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.Command, err = command.FromConfig(vt.Sub("run"), connection); err != nil {
+		return nil, connection, fmt.Errorf("run: %w", err)
+	}
+	if vt.IsSet("confirm.pattern") {
+		status, err := command.StatusFromConfig(vt.Sub("confirm"), connection)
+		if err != nil {
+			return nil, connection, fmt.Errorf("confirm: %w", err)
+		}
+		if a.Confirm, err = NewStatusConfirmation(status, vt.GetString("confirm.pattern")); err != nil {
+			return nil, connection, fmt.Errorf("confirm: %w", err)
+		}
+	}
+	return a, connection, a.Validate()
+}
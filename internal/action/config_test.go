@@ -0,0 +1,128 @@
+package action
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/command/mqtttest"
+	"github.com/thebuh/barn/internal/dome"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/notify"
+)
+
+func readConfig(t *testing.T, content string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(bytes.NewBufferString(content)), "should work")
+	return v
+}
+
+func newTestServer(t *testing.T) app.Server {
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("rain", "Rain", "", true))
+	simulator, _ := dome.NewSimulator(time.Second, true)
+	roof, err := dome.NewRoof("roof", "Roof", "", simulator, dome.Options{})
+	assert.NoError(t, err, "should work")
+	barn.AddDome(roof)
+	return barn
+}
+
+func newTestNotifier(t *testing.T) *notify.Notifier {
+	n, err := notify.LoadFromConfig(readConfig(t, `
+notifications:
+  channels:
+    pushover:
+      type: command
+      command: "true"
+`))
+	assert.NoError(t, err, "should work")
+	t.Cleanup(n.Close)
+	return n
+}
+
+func TestLoadFromConfig(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	defer broker.Close()
+	path := filepath.Join(t.TempDir(), "actions.jsonl")
+	r, err := LoadFromConfig(readConfig(t, `
+actions:
+  audit_log: `+path+`
+  retry:
+    attempts: 5
+    delay: 30s
+  rules:
+    close_roof:
+      monitors: [rain]
+      dome: roof
+      confirm:
+        timeout: 3m
+      escalate: [pushover]
+    park_mount:
+      mqtt:
+        broker: `+broker.URL()+`
+      run:
+        mqtt: {topic: mount/set, payload: PARK}
+      confirm:
+        mqtt: {topic: mount/state}
+        pattern: parked
+        interval: 2s
+      retry:
+        attempts: 2
+    open_roof:
+      on: safe
+      run:
+        http: {url: "http://roof.local/open"}
+`), newTestServer(t), newTestNotifier(t))
+	assert.NoError(t, err, "should work")
+	defer r.Close()
+
+	assert.Len(t, r.actions, 3, "they should be equal")
+	closeRoof, openRoof, parkMount := r.actions[0], r.actions[1], r.actions[2]
+	assert.Equal(t, []string{"rain"}, closeRoof.Monitors, "they should be equal")
+	assert.Equal(t, TriggerUnsafe, closeRoof.On, "they should be equal")
+	assert.IsType(t, &DomeClose{}, closeRoof.Confirm, "domes should confirm they are closed")
+	assert.Equal(t, 3*time.Minute, closeRoof.ConfirmTimeout, "they should be equal")
+	assert.Equal(t, 5, closeRoof.Attempts, "they should be equal")
+	assert.Equal(t, 30*time.Second, closeRoof.Delay, "they should be equal")
+	assert.Equal(t, []string{"pushover"}, closeRoof.Escalate, "they should be equal")
+
+	assert.Equal(t, TriggerSafe, openRoof.On, "they should be equal")
+	assert.Nil(t, openRoof.Confirm, "confirmation should be optional")
+
+	assert.Equal(t, 2, parkMount.Attempts, "they should be equal")
+	assert.Equal(t, 2*time.Second, parkMount.ConfirmInterval, "they should be equal")
+	assert.NoError(t, parkMount.Command.Run(), "should work")
+	assert.Equal(t, []string{"mount/set=PARK"}, broker.Received(), "they should be equal")
+	assert.Len(t, r.closers, 1, "they should be equal")
+	assert.NotNil(t, r.audit, "should open the audit log")
+
+	r, err = LoadFromConfig(viper.New(), newTestServer(t), nil)
+	assert.NoError(t, err, "should work")
+	assert.Nil(t, r, "actions should be disabled without rules")
+}
+
+func TestLoadFromConfig_Errors(t *testing.T) {
+	for name, content := range map[string]string{
+		"no command":      "close: {monitors: [rain]}",
+		"dome and run":    "close: {dome: roof, run: {exec: {command: roof-close}}}",
+		"unknown dome":    "close: {dome: shed}",
+		"unknown monitor": "close: {dome: roof, monitors: [clouds]}",
+		"unknown channel": "close: {dome: roof, escalate: [sms]}",
+		"bad trigger":     "close: {dome: roof, on: rain}",
+		"bad run":         "close: {run: {exec: {args: [close]}}}",
+		"bad confirm":     "close: {run: {exec: {command: roof-close}}, confirm: {pattern: closed}}",
+		"bad pattern":     "close: {run: {exec: {command: roof-close}}, confirm: {exec: {command: roof-status}, pattern: \"(\"}}",
+		"no broker":       "close: {run: {mqtt: {topic: roof/set}}}",
+	} {
+		v := readConfig(t, "actions:\n  rules:\n    "+content+"\n")
+		_, err := LoadFromConfig(v, newTestServer(t), newTestNotifier(t))
+		assert.Error(t, err, name+" should fail")
+	}
+	_, err := LoadFromConfig(readConfig(t, "actions:\n  rules:\n    close: {dome: roof, escalate: [pushover]}\n"), newTestServer(t), nil)
+	assert.Error(t, err, "escalation should need notifications")
+}
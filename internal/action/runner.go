@@ -0,0 +1,253 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/notify"
+)
+
+// Notifier delivers escalations to notification channels
+type Notifier interface {
+	Send(channels []string, e notify.Event)
+}
+
+// MonitorLookup returns a monitor by id with overrides applied, or nil
+type MonitorLookup func(id string) monitor.SafetyMonitor
+
+// Runner executes actions when monitors change state. The state is taken
+// with overrides applied, so forcing a monitor unsafe runs its actions too.
+// The first state seen for a monitor only triggers when it is unsafe, so a
+// roof still closes when barn starts while it rains, but does not open just
+// because barn started. Actions run on their own goroutines; a trigger is
+// skipped while the action is still running.
+type Runner struct {
+	actions  []*Action
+	monitors MonitorLookup
+	notifier Notifier
+	audit    *Audit
+	// closers release connections shared by the commands
+	closers []io.Closer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	states  map[string]bool
+	running map[string]bool
+	now     func() time.Time
+}
+
+// New creates a runner. The notifier is only needed for escalations and the
+// audit is optional.
+func New(actions []*Action, monitors MonitorLookup, notifier Notifier, audit *Audit) (*Runner, error) {
+	names := make(map[string]bool)
+	for _, a := range actions {
+		if err := a.Validate(); err != nil {
+			return nil, fmt.Errorf("action %s: %w", a.Name, err)
+		}
+		if names[a.Name] {
+			return nil, fmt.Errorf("duplicate action %s", a.Name)
+		}
+		names[a.Name] = true
+		if len(a.Escalate) > 0 && notifier == nil {
+			return nil, fmt.Errorf("action %s: escalation needs notifications", a.Name)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Runner{
+		actions:  actions,
+		monitors: monitors,
+		notifier: notifier,
+		audit:    audit,
+		ctx:      ctx,
+		cancel:   cancel,
+		states:   make(map[string]bool),
+		running:  make(map[string]bool),
+		now:      time.Now,
+	}, nil
+}
+
+// Close stops running actions, waits for them and closes the audit and
+// connections
+func (r *Runner) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.cancel()
+	r.wg.Wait()
+	for _, c := range r.closers {
+		c.Close()
+	}
+	if r.audit != nil {
+		r.audit.Close()
+	}
+}
+
+// MonitorRefreshed implements app.Listener
+func (r *Runner) MonitorRefreshed(refresh app.MonitorRefresh) {
+	m := refresh.Monitor
+	safe := refresh.Safe
+	if r.monitors != nil {
+		if effective := r.monitors(m.GetId()); effective != nil {
+			safe = effective.IsSafe()
+		}
+	}
+	trigger := TriggerUnsafe
+	if safe {
+		trigger = TriggerSafe
+	}
+
+	r.mu.Lock()
+	was, seen := r.states[m.GetId()]
+	r.states[m.GetId()] = safe
+	if r.closed || (seen && was == safe) || (!seen && safe) {
+		r.mu.Unlock()
+		return
+	}
+	var started, skipped []*Action
+	for _, a := range r.actions {
+		if a.On != trigger || (len(a.Monitors) > 0 && !slices.Contains(a.Monitors, m.GetId())) {
+			continue
+		}
+		if r.running[a.Name] {
+			skipped = append(skipped, a)
+			continue
+		}
+		r.running[a.Name] = true
+		started = append(started, a)
+		r.wg.Add(1)
+	}
+	r.mu.Unlock()
+
+	for _, a := range skipped {
+		r.record(log.WarnLevel, Entry{Action: a.Name, Step: StepSkipped, Monitor: m.GetId(), Trigger: trigger},
+			fmt.Sprintf("Still running, skipping trigger by [%s]", m.GetId()))
+	}
+	for _, a := range started {
+		go r.execute(a, m, trigger)
+	}
+}
+
+// WeatherRefreshed implements app.Listener. Actions only follow monitors.
+func (r *Runner) WeatherRefreshed(refresh app.WeatherRefresh) {}
+
+// execute runs the attempts of one execution and escalates when all failed
+func (r *Runner) execute(a *Action, m monitor.SafetyMonitor, trigger string) {
+	defer r.wg.Done()
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, a.Name)
+		r.mu.Unlock()
+	}()
+
+	base := Entry{
+		Action:    a.Name,
+		Execution: fmt.Sprintf("%s-%d", a.Name, r.now().UnixNano()),
+		Monitor:   m.GetId(),
+		Trigger:   trigger,
+	}
+	r.record(log.InfoLevel, withStep(base, StepTriggered, 0, nil),
+		fmt.Sprintf("Triggered by [%s] becoming %s", m.GetId(), trigger))
+
+	delay := a.Delay
+	var err error
+	for attempt := 1; ; attempt++ {
+		r.record(log.InfoLevel, withStep(base, StepAttempt, attempt, nil), fmt.Sprintf("Attempt %d/%d", attempt, a.Attempts))
+		if err = r.attempt(a); err == nil {
+			r.record(log.InfoLevel, withStep(base, StepSucceeded, attempt, nil), fmt.Sprintf("Succeeded on attempt %d", attempt))
+			return
+		}
+		r.record(log.WarnLevel, withStep(base, StepFailed, attempt, err), fmt.Sprintf("Attempt %d/%d failed: %v", attempt, a.Attempts, err))
+		if attempt >= a.Attempts {
+			break
+		}
+		select {
+		case <-r.ctx.Done():
+			r.record(log.ErrorLevel, withStep(base, StepGaveUp, attempt, r.ctx.Err()), "Stopped before all attempts were made")
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	r.record(log.ErrorLevel, withStep(base, StepGaveUp, a.Attempts, err), fmt.Sprintf("Giving up after %d attempts", a.Attempts))
+	if len(a.Escalate) == 0 || r.ctx.Err() != nil {
+		return
+	}
+	r.notifier.Send(a.Escalate, notify.Event{
+		Type:       notify.EventActionFailed,
+		DeviceType: "safetymonitor",
+		DeviceId:   m.GetId(),
+		DeviceName: m.GetName(),
+		Safe:       trigger == TriggerSafe,
+		RawValue:   m.GetRawValue(),
+		Error:      err.Error(),
+		Time:       r.now(),
+		Message:    fmt.Sprintf("Action %s for %s failed after %d attempts: %v", a.Name, m.GetName(), a.Attempts, err),
+	})
+	r.record(log.InfoLevel, withStep(base, StepEscalated, a.Attempts, nil),
+		fmt.Sprintf("Escalated to [%s]", strings.Join(a.Escalate, ", ")))
+}
+
+// attempt runs the command and waits for the confirmation
+func (r *Runner) attempt(a *Action) error {
+	if err := a.Command.Run(); err != nil {
+		return err
+	}
+	if a.Confirm == nil {
+		return nil
+	}
+	deadline := time.Now().Add(a.ConfirmTimeout)
+	for {
+		confirmed, err := a.Confirm.Confirmed()
+		if err == nil && confirmed {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			if err != nil {
+				return errors.Join(ErrNotConfirmed, err)
+			}
+			return ErrNotConfirmed
+		}
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-time.After(a.ConfirmInterval):
+		}
+	}
+}
+
+// record logs a step and appends it to the audit
+func (r *Runner) record(level log.Level, e Entry, message string) {
+	if e.Time.IsZero() {
+		e.Time = r.now()
+	}
+	log.StandardLogger().Log(level, fmt.Sprintf("[BARN] Action [%s]. %s", e.Action, message))
+	if r.audit == nil {
+		return
+	}
+	if err := r.audit.Write(e); err != nil {
+		log.WithError(err).Error(fmt.Sprintf("[BARN] Action [%s]. Failed to write audit entry", e.Action))
+	}
+}
+
+func withStep(e Entry, step string, attempt int, err error) Entry {
+	e.Step = step
+	e.Attempt = attempt
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
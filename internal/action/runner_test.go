@@ -0,0 +1,233 @@
+package action
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/notify"
+)
+
+type countingCommand struct {
+	mu   sync.Mutex
+	runs int
+	fail int
+	// block delays runs until it is closed
+	block chan struct{}
+}
+
+func (c *countingCommand) Run() error {
+	if c.block != nil {
+		<-c.block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runs++
+	if c.fail > 0 {
+		c.fail--
+		return errors.New("controller offline")
+	}
+	return nil
+}
+
+func (c *countingCommand) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.runs
+}
+
+type fixedConfirmation struct {
+	confirmed bool
+}
+
+func (c *fixedConfirmation) Confirmed() (bool, error) {
+	return c.confirmed, nil
+}
+
+type recordingNotifier struct {
+	mu       sync.Mutex
+	channels []string
+	events   []notify.Event
+}
+
+func (n *recordingNotifier) Send(channels []string, e notify.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.channels = append(n.channels, channels...)
+	n.events = append(n.events, e)
+}
+
+func (n *recordingNotifier) sent() []notify.Event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]notify.Event(nil), n.events...)
+}
+
+func newTestRunner(t *testing.T, notifier Notifier, actions ...*Action) *Runner {
+	r, err := New(actions, nil, notifier, nil)
+	assert.NoError(t, err, "should work")
+	t.Cleanup(r.Close)
+	return r
+}
+
+func waitForIdle(t *testing.T, r *Runner) {
+	assert.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.running) == 0
+	}, time.Second, time.Millisecond, "actions should finish")
+}
+
+func refresh(r *Runner, m monitor.SafetyMonitor, safe bool) {
+	r.MonitorRefreshed(app.MonitorRefresh{Monitor: m, Safe: safe})
+}
+
+func TestRunner_Transitions(t *testing.T) {
+	closeRoof := &countingCommand{}
+	openRoof := &countingCommand{}
+	r := newTestRunner(t, nil,
+		&Action{Name: "close", Command: closeRoof},
+		&Action{Name: "open", On: TriggerSafe, Monitors: []string{"rain"}, Command: openRoof})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "", true)
+	clouds := monitor.NewSafetyMonitorDummy("clouds", "Clouds", "", true)
+
+	refresh(r, clouds, true) // first safe observation is not a transition
+	refresh(r, rain, true)
+	refresh(r, clouds, false)
+	waitForIdle(t, r)
+	assert.Equal(t, 1, closeRoof.count(), "should close")
+	refresh(r, clouds, true)
+	refresh(r, rain, false)
+	refresh(r, rain, true)
+
+	assert.Eventually(t, func() bool {
+		return closeRoof.count() == 2 && openRoof.count() == 1
+	}, time.Second, time.Millisecond, "each transition should run matching actions once")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, openRoof.count(), "actions should only follow their monitors")
+}
+
+func TestRunner_Overrides(t *testing.T) {
+	closeRoof := &countingCommand{}
+	forced := monitor.NewSafetyMonitorDummy("rain", "Rain", "", false)
+	r, err := New([]*Action{{Name: "close", Command: closeRoof}}, func(id string) monitor.SafetyMonitor {
+		return forced
+	}, nil, nil)
+	assert.NoError(t, err, "should work")
+	defer r.Close()
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "", true)
+
+	refresh(r, rain, true)
+	refresh(r, rain, true)
+	assert.Eventually(t, func() bool { return closeRoof.count() == 1 }, time.Second, time.Millisecond,
+		"monitors forced unsafe should trigger")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, closeRoof.count(), "the overridden state should not change")
+}
+
+func TestRunner_UnsafeAtStart(t *testing.T) {
+	closeRoof := &countingCommand{}
+	openRoof := &countingCommand{}
+	r := newTestRunner(t, nil,
+		&Action{Name: "close", Command: closeRoof},
+		&Action{Name: "open", On: TriggerSafe, Command: openRoof})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "", false)
+	clouds := monitor.NewSafetyMonitorDummy("clouds", "Clouds", "", true)
+
+	refresh(r, rain, false) // raining when barn starts
+	refresh(r, clouds, true)
+	refresh(r, rain, false)
+	assert.Eventually(t, func() bool { return closeRoof.count() == 1 }, time.Second, time.Millisecond,
+		"monitors unsafe when first seen should trigger")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, closeRoof.count(), "staying unsafe should not trigger again")
+	assert.Equal(t, 0, openRoof.count(), "monitors safe when first seen should not trigger")
+}
+
+func TestRunner_RetriesAndEscalates(t *testing.T) {
+	closeRoof := &countingCommand{fail: 5}
+	notifier := &recordingNotifier{}
+	r := newTestRunner(t, notifier, &Action{
+		Name:     "close",
+		Command:  closeRoof,
+		Attempts: 3,
+		Delay:    time.Millisecond,
+		Escalate: []string{"pushover"},
+	})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "", true)
+	refresh(r, rain, true)
+	refresh(r, rain, false)
+
+	assert.Eventually(t, func() bool { return len(notifier.sent()) == 1 }, time.Second, time.Millisecond, "should escalate")
+	assert.Equal(t, 3, closeRoof.count(), "they should be equal")
+	e := notifier.sent()[0]
+	assert.Equal(t, notify.EventActionFailed, e.Type, "they should be equal")
+	assert.Equal(t, "rain", e.DeviceId, "they should be equal")
+	assert.Equal(t, "Action close for Rain failed after 3 attempts: controller offline", e.Message, "they should be equal")
+	assert.Equal(t, []string{"pushover"}, notifier.channels, "they should be equal")
+}
+
+func TestRunner_Confirmation(t *testing.T) {
+	closeRoof := &countingCommand{fail: 1}
+	confirmation := &fixedConfirmation{confirmed: false}
+	notifier := &recordingNotifier{}
+	r := newTestRunner(t, notifier, &Action{
+		Name:            "close",
+		Command:         closeRoof,
+		Confirm:         confirmation,
+		ConfirmTimeout:  5 * time.Millisecond,
+		ConfirmInterval: time.Millisecond,
+		Attempts:        2,
+		Delay:           time.Millisecond,
+		Escalate:        []string{"pushover"},
+	})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "", true)
+	refresh(r, rain, true)
+	refresh(r, rain, false)
+
+	assert.Eventually(t, func() bool { return len(notifier.sent()) == 1 }, time.Second, time.Millisecond, "should escalate")
+	assert.Equal(t, ErrNotConfirmed.Error(), notifier.sent()[0].Error, "unconfirmed attempts should fail")
+
+	waitForIdle(t, r)
+	confirmation.confirmed = true
+	refresh(r, rain, true)
+	refresh(r, rain, false)
+	assert.Eventually(t, func() bool { return closeRoof.count() == 3 }, time.Second, time.Millisecond, "should run again")
+	assert.Never(t, func() bool { return len(notifier.sent()) > 1 }, 20*time.Millisecond, time.Millisecond,
+		"confirmed actions should not escalate")
+}
+
+func TestRunner_SkipsRunningActions(t *testing.T) {
+	closeRoof := &countingCommand{block: make(chan struct{})}
+	r := newTestRunner(t, nil, &Action{Name: "close", Command: closeRoof})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "", true)
+	clouds := monitor.NewSafetyMonitorDummy("clouds", "Clouds", "", true)
+	refresh(r, rain, true)
+	refresh(r, clouds, true)
+	refresh(r, rain, false)
+	refresh(r, clouds, false)
+	close(closeRoof.block)
+
+	assert.Eventually(t, func() bool { return closeRoof.count() == 1 }, time.Second, time.Millisecond, "should run")
+	assert.Never(t, func() bool { return closeRoof.count() > 1 }, 20*time.Millisecond, time.Millisecond,
+		"triggers should be skipped while running")
+}
+
+func TestNew_Errors(t *testing.T) {
+	command := &countingCommand{}
+	for name, actions := range map[string][]*Action{
+		"no name":     {{Command: command}},
+		"no command":  {{Name: "close"}},
+		"bad trigger": {{Name: "close", On: "rain", Command: command}},
+		"negative":    {{Name: "close", Delay: -time.Second, Command: command}},
+		"duplicate":   {{Name: "close", Command: command}, {Name: "close", Command: command}},
+		"no notifier": {{Name: "close", Command: command, Escalate: []string{"pushover"}}},
+	} {
+		_, err := New(actions, nil, nil, nil)
+		assert.Error(t, err, name+" should fail")
+	}
+}
@@ -2,13 +2,23 @@ package api
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/auth"
+	"github.com/thebuh/barn/internal/history"
+	"github.com/thebuh/barn/internal/metrics"
+	"github.com/thebuh/barn/internal/override"
 )
 
 type ClientId string
@@ -29,18 +39,37 @@ type WeatherClientState struct {
 type ApiServer struct {
 	ApiPort             uint32
 	Barn                app.Server
-	ServerTransactionID uint32
-	Devices             map[string]map[int]*Device
+	ServerTransactionID atomic.Uint32
+	// Devices is populated once by NewApiServer and only read afterwards
+	Devices map[string]map[int]*Device
+
+	metrics      *metrics.Metrics
+	serveMetrics bool
+	history      *history.Store
+	dashboard    *dashboardFeed
+	events       *eventHub
+	setup        app.Configurator
+	overrides    *override.Manager
+	auth         *auth.Authenticator
+	tls          *certReloader
 }
 
+// Device tracks the Alpaca clients connected to one barn device. mu guards
+// ConnectedClients and the client state it points to, as every request
+// handler goroutine may connect, disconnect or query clients.
 type Device struct {
 	Id               string
 	Type             string
 	Index            int
 	ConnectedClients map[ClientId]*ConnectedClient
+	mu               sync.RWMutex
+	// clientChanged is called after a client connects or disconnects
+	clientChanged func(d *Device, id ClientId, connected bool)
 }
 
 func (d *Device) IsConnected(id ClientId) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	for clientId, client := range d.ConnectedClients {
 		if clientId == id {
 			return client.Connected
@@ -48,16 +77,55 @@ func (d *Device) IsConnected(id ClientId) bool {
 	}
 	return false
 }
+
+// ConnectedClientIds returns the ids of the currently connected clients in order
+func (d *Device) ConnectedClientIds() []ClientId {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	ids := []ClientId{}
+	for id, client := range d.ConnectedClients {
+		if client.Connected {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// ConnectedClientCount returns the number of currently connected clients
+func (d *Device) ConnectedClientCount() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	count := 0
+	for _, client := range d.ConnectedClients {
+		if client.Connected {
+			count++
+		}
+	}
+	return count
+}
+
 func (d *Device) ConnectClient(id ClientId) {
+	if d.connectClient(id) && d.clientChanged != nil {
+		d.clientChanged(d, id, true)
+	}
+}
+
+// connectClient reports whether the client was not connected before
+func (d *Device) connectClient(id ClientId) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	for clientId, client := range d.ConnectedClients {
 		if clientId != id {
 			continue
 		}
 		if client.Connected == false {
 			client.Connected = true
-			return
+			return true
 		}
-		return
+		return false
 	}
 	cc := &ConnectedClient{
 		ClientId:            id,
@@ -66,27 +134,42 @@ func (d *Device) ConnectClient(id ClientId) {
 		WeatherState:        &WeatherClientState{AveragePeriod: 0.0}, // Default average period
 	}
 	d.ConnectedClients[id] = cc
+	return true
 }
 
 func (d *Device) DisconnectClient(id ClientId) {
-	for clientId := range d.ConnectedClients {
-		if clientId != id {
-			continue
-		}
-		delete(d.ConnectedClients, id)
+	if d.disconnectClient(id) && d.clientChanged != nil {
+		d.clientChanged(d, id, false)
 	}
 }
 
-// GetWeatherClientState returns the weather state for a specific client
+// disconnectClient reports whether the client was connected before
+func (d *Device) disconnectClient(id ClientId) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	client, exists := d.ConnectedClients[id]
+	if !exists {
+		return false
+	}
+	delete(d.ConnectedClients, id)
+	return client.Connected
+}
+
+// GetWeatherClientState returns a copy of the weather state for a specific client
 func (d *Device) GetWeatherClientState(id ClientId) *WeatherClientState {
-	if client, exists := d.ConnectedClients[id]; exists && client.Connected {
-		return client.WeatherState
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if client, exists := d.ConnectedClients[id]; exists && client.Connected && client.WeatherState != nil {
+		state := *client.WeatherState
+		return &state
 	}
 	return nil
 }
 
 // SetWeatherAveragePeriod sets the average period for a specific client
 func (d *Device) SetWeatherAveragePeriod(id ClientId, averagePeriod float64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if client, exists := d.ConnectedClients[id]; exists && client.Connected {
 		if client.WeatherState == nil {
 			client.WeatherState = &WeatherClientState{}
@@ -99,6 +182,8 @@ func (d *Device) SetWeatherAveragePeriod(id ClientId, averagePeriod float64) boo
 
 // GetWeatherAveragePeriod gets the average period for a specific client
 func (d *Device) GetWeatherAveragePeriod(id ClientId) (float64, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	if client, exists := d.ConnectedClients[id]; exists && client.Connected {
 		if client.WeatherState != nil {
 			return client.WeatherState.AveragePeriod, true
@@ -108,25 +193,19 @@ func (d *Device) GetWeatherAveragePeriod(id ClientId) (float64, bool) {
 }
 
 func NewApiServer(barn app.Server, apiPort uint32) *ApiServer {
-	return &ApiServer{
+	srv := &ApiServer{
 		ApiPort: apiPort,
 		Barn:    barn,
 		Devices: make(map[string]map[int]*Device),
+
+		dashboard: newDashboardFeed(),
+		events:    newEventHub(),
 	}
+	srv.initDevices()
+	return srv
 }
 
-func (srv *ApiServer) Start() {
-	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
-	// Add global middleware to inject API server into context
-	router.Use(func(c *gin.Context) {
-		c.Set("apiServer", srv)
-		c.Next()
-	})
-	router.GET("/", func(c *gin.Context) {
-		c.String(http.StatusOK, "Alpaca Barn server")
-	})
-
+func (srv *ApiServer) initDevices() {
 	// Initialize safety monitor devices
 	srv.Devices["safetymonitor"] = make(map[int]*Device)
 	ids := srv.Barn.GetMonitorIds()
@@ -151,8 +230,68 @@ func (srv *ApiServer) Start() {
 		}
 	}
 
+	// Initialize dome devices
+	srv.Devices["dome"] = make(map[int]*Device)
+	domeIds := srv.Barn.GetDomeIds()
+	for i, id := range domeIds {
+		srv.Devices["dome"][i] = &Device{
+			Id:               id,
+			Type:             "dome",
+			Index:            i,
+			ConnectedClients: make(map[ClientId]*ConnectedClient),
+		}
+	}
+
+	// A single switch device covers every monitor and station
+	srv.Devices["switch"] = map[int]*Device{
+		0: {
+			Id:               switchDeviceId,
+			Type:             "switch",
+			ConnectedClients: make(map[ClientId]*ConnectedClient),
+		},
+	}
+
+	for _, devices := range srv.Devices {
+		for _, device := range devices {
+			device.clientChanged = srv.clientChanged
+		}
+	}
+}
+
+// Router builds the gin engine serving the management and device APIs
+func (srv *ApiServer) Router() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.Default()
+	if srv.metrics != nil {
+		router.Use(metricsMiddleware(srv.metrics))
+	}
+	if srv.auth != nil {
+		// Only trusted proxies may set the address checked against allowlists
+		_ = router.SetTrustedProxies(srv.auth.TrustedProxies)
+		router.Use(authMiddleware(srv.auth))
+	}
+	if srv.metrics != nil && srv.serveMetrics {
+		router.GET("/metrics", gin.WrapH(srv.metrics.Handler()))
+	}
+	// Add global middleware to inject API server into context
+	router.Use(func(c *gin.Context) {
+		c.Set("apiServer", srv)
+		c.Next()
+	})
 	srv.configureManagementAPI(router)
 
+	dashboardAPI := NewDashboardAPI(srv)
+	dashboardAPI.ConfigureRoutes(router)
+
+	eventsAPI := NewEventsAPI(srv)
+	eventsAPI.ConfigureRoutes(router)
+
+	restAPI := NewRestAPI(srv)
+	restAPI.ConfigureRoutes(router)
+
+	setupAPI := NewSetupAPI(srv)
+	setupAPI.ConfigureRoutes(router)
+
 	// Configure separate API handlers
 	safetyMonitorAPI := NewSafetyMonitorAPI(srv)
 	safetyMonitorAPI.ConfigureRoutes(router)
@@ -160,9 +299,51 @@ func (srv *ApiServer) Start() {
 	weatherAPI := NewWeatherAPI(srv)
 	weatherAPI.ConfigureRoutes(router)
 
-	err := router.Run(fmt.Sprintf("0.0.0.0:%d", srv.ApiPort))
+	domeAPI := NewDomeAPI(srv)
+	domeAPI.ConfigureRoutes(router)
+
+	switchAPI := NewSwitchAPI(srv)
+	switchAPI.ConfigureRoutes(router)
+
+	if srv.overrides != nil {
+		overrideAPI := NewOverrideAPI(srv)
+		overrideAPI.ConfigureRoutes(router)
+	}
+
+	dummyAPI := NewDummyAPI(srv)
+	dummyAPI.ConfigureRoutes(router)
+
+	ingestAPI := NewIngestAPI(srv)
+	ingestAPI.ConfigureRoutes(router)
+
+	if srv.history != nil {
+		historyAPI := NewHistoryAPI(srv)
+		historyAPI.ConfigureRoutes(router)
+	}
+
+	return router
+}
+
+func (srv *ApiServer) Start() {
+	router := srv.Router()
+	if srv.tls != nil && srv.tls.config.HTTPPort != 0 {
+		go srv.listen(srv.tls.config.HTTPPort, func(ln net.Listener) error {
+			server := &http.Server{Handler: srv.legacyHandler(router), ReadHeaderTimeout: 10 * time.Second}
+			return server.Serve(ln)
+		})
+	}
+	srv.listen(srv.ApiPort, func(ln net.Listener) error {
+		return srv.serve(ln, router)
+	})
+}
+
+func (srv *ApiServer) listen(port uint32, serve func(net.Listener) error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err == nil {
+		err = serve(ln)
+	}
 	if err != nil {
-		return
+		log.WithError(err).Error(fmt.Sprintf("[BARN] API. Failed to serve on port %d", port))
 	}
 }
 
@@ -200,29 +381,7 @@ func (srv *ApiServer) configureManagementAPI(router *gin.Engine) {
 		c.IndentedJSON(http.StatusOK, resp)
 	})
 	router.GET("/management/v1/configureddevices", func(c *gin.Context) {
-		var val []DeviceConfiguration
-
-		// Add safety monitor devices
-		ids := srv.Barn.GetMonitorIds()
-		for i, id := range ids {
-			val = append(val, DeviceConfiguration{
-				DeviceName:   srv.Barn.GetMonitor(id).GetName(),
-				DeviceType:   "safetymonitor",
-				DeviceNumber: i,
-				UniqueID:     id,
-			})
-		}
-
-		// Add weather devices
-		weatherIds := srv.Barn.GetWeatherIds()
-		for i, id := range weatherIds {
-			val = append(val, DeviceConfiguration{
-				DeviceName:   srv.Barn.GetWeather(id).GetName(),
-				DeviceType:   "observingconditions",
-				DeviceNumber: i,
-				UniqueID:     id,
-			})
-		}
+		val := srv.configuredDevices()
 
 		resp := managementDevicesListResponse{
 			Value: val,
@@ -239,14 +398,60 @@ func (srv *ApiServer) configureManagementAPI(router *gin.Engine) {
 
 }
 
+// configuredDevices lists the devices in Alpaca device number order
+func (srv *ApiServer) configuredDevices() []DeviceConfiguration {
+	var val []DeviceConfiguration
+
+	// Add safety monitor devices
+	ids := srv.Barn.GetMonitorIds()
+	for i, id := range ids {
+		val = append(val, DeviceConfiguration{
+			DeviceName:   srv.Barn.GetMonitor(id).GetName(),
+			DeviceType:   "safetymonitor",
+			DeviceNumber: i,
+			UniqueID:     id,
+		})
+	}
+
+	// Add weather devices
+	weatherIds := srv.Barn.GetWeatherIds()
+	for i, id := range weatherIds {
+		val = append(val, DeviceConfiguration{
+			DeviceName:   srv.Barn.GetWeather(id).GetName(),
+			DeviceType:   "observingconditions",
+			DeviceNumber: i,
+			UniqueID:     id,
+		})
+	}
+
+	// Add dome devices
+	domeIds := srv.Barn.GetDomeIds()
+	for i, id := range domeIds {
+		val = append(val, DeviceConfiguration{
+			DeviceName:   srv.Barn.GetDome(id).GetName(),
+			DeviceType:   "dome",
+			DeviceNumber: i,
+			UniqueID:     id,
+		})
+	}
+
+	val = append(val, DeviceConfiguration{
+		DeviceName:   switchDeviceName,
+		DeviceType:   "switch",
+		DeviceNumber: 0,
+		UniqueID:     switchDeviceId,
+	})
+	return val
+}
+
 func (srv *ApiServer) prepareAlpacaResponse(c *gin.Context, resp *alpacaResponse) {
 	ctid := getClientTransactionId(c)
 	if ctid < 0 {
 		ctid = 0
 	}
-	srv.ServerTransactionID += 1
 	resp.ClientTransactionID = uint32(ctid)
-	resp.ServerTransactionID = srv.ServerTransactionID
+	resp.ServerTransactionID = srv.ServerTransactionID.Add(1)
+	c.Set("alpacaResponse", resp)
 
 }
 
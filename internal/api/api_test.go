@@ -1,8 +1,21 @@
 package api
 
 import (
-	"github.com/stretchr/testify/assert"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/metrics"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
 )
 
 func TestApiServer_ConnectClient(t *testing.T) {
@@ -31,3 +44,96 @@ func TestApiServer_DisconnectClient(t *testing.T) {
 	d.DisconnectClient(id)
 	assert.Equal(t, false, d.IsConnected(id), "they should be equal")
 }
+
+func TestDevice_ConcurrentClients(t *testing.T) {
+	var d = &Device{
+		ConnectedClients: make(map[ClientId]*ConnectedClient),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id ClientId) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				d.ConnectClient(id)
+				d.IsConnected(id)
+				d.SetWeatherAveragePeriod(id, float64(j))
+				d.GetWeatherAveragePeriod(id)
+				d.GetWeatherClientState(id)
+				d.DisconnectClient(id)
+			}
+			d.ConnectClient(id)
+		}(ClientId(fmt.Sprintf("127.0.0.1-%d", i)))
+	}
+	wg.Wait()
+	assert.Len(t, d.ConnectedClients, 20, "every client should end up connected")
+}
+
+func newTestBarn() app.Server {
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("safe", "Safe", "Always safe", true))
+	barn.AddWeather(weather.NewObservingConditionsDummy("station", "Station", "Dummy station"))
+	return barn
+}
+
+func TestApiServer_ConcurrentRequests(t *testing.T) {
+	srv := NewApiServer(newTestBarn(), 0)
+	router := srv.Router()
+
+	const workers = 10
+	const requests = 20
+	ids := make(chan uint32, workers*requests)
+	var wg sync.WaitGroup
+	for i := 1; i <= workers; i++ {
+		wg.Add(1)
+		go func(clientId int) {
+			defer wg.Done()
+			for j := 1; j <= requests; j++ {
+				form := url.Values{}
+				form.Set("ClientID", strconv.Itoa(clientId))
+				form.Set("ClientTransactionID", strconv.Itoa(j))
+				connect := httptest.NewRequest(http.MethodPut, "/api/v1/safetymonitor/0/connect", strings.NewReader(form.Encode()))
+				connect.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				router.ServeHTTP(httptest.NewRecorder(), connect)
+
+				req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/safetymonitor/0/issafe?ClientID=%d&ClientTransactionID=%d", clientId, j), nil)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				var resp boolResponse
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), "should be valid json")
+				assert.Equal(t, true, resp.Value, "connected client should see the monitor state")
+				assert.Equal(t, uint32(j), resp.ClientTransactionID, "they should be equal")
+				ids <- resp.ServerTransactionID
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[uint32]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "server transaction ids should be unique")
+		seen[id] = true
+	}
+	assert.Len(t, seen, workers*requests, "every request should get a server transaction id")
+}
+
+func TestApiServer_Metrics(t *testing.T) {
+	srv := NewApiServer(newTestBarn(), 0)
+	srv.UseMetrics(metrics.New(srv.Barn), true)
+	router := srv.Router()
+
+	srv.Devices["safetymonitor"][0].ConnectClient(ClientId("192.0.2.1-1"))
+	srv.Devices["observingconditions"][0].ConnectClient(ClientId("192.0.2.1-1"))
+	// SkyQuality is not available, so the Alpaca response carries NotImplemented
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/observingconditions/0/skyquality?ClientID=1&ClientTransactionID=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, `barn_http_requests_total{endpoint="/api/v1/observingconditions/:device_id/skyquality",error_number="1024",method="GET",status="200"} 1`)
+	assert.Contains(t, body, `barn_alpaca_connected_clients{device_id="safe",device_number="0",device_type="safetymonitor"} 1`)
+	assert.Contains(t, body, `barn_monitor_safe{monitor="safe",name="Safe"} 1`)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/auth"
+)
+
+// UseAuth requires credentials and allowed networks for the API
+func (srv *ApiServer) UseAuth(a *auth.Authenticator) {
+	srv.auth = a
+}
+
+// routeGroup returns the auth route group of a request path
+func routeGroup(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/"), strings.HasPrefix(path, "/management/"):
+		return auth.GroupAlpaca
+	case strings.HasPrefix(path, "/admin/"):
+		return auth.GroupAdmin
+	case strings.HasPrefix(path, "/ingest/"):
+		return auth.GroupIngest
+	case path == "/setup", strings.HasPrefix(path, "/setup/"):
+		return auth.GroupSetup
+	case strings.HasPrefix(path, "/history/"):
+		return auth.GroupHistory
+	case path == "/metrics":
+		return auth.GroupMetrics
+	}
+	return auth.GroupDashboard
+}
+
+// authMiddleware rejects requests the authenticator does not allow. Rejections
+// are plain text HTTP errors, as the Alpaca spec requires for anything but a
+// 200 response.
+func authMiddleware(a *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		group := routeGroup(c.Request.URL.Path)
+		principal, err := a.Authenticate(c.Request)
+		if err == nil {
+			err = a.Authorize(group, c.Request.Method, net.ParseIP(c.ClientIP()), principal)
+		}
+		if err == nil {
+			c.Set("principal", principal)
+			c.Next()
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"group": group,
+			"ip":    c.ClientIP(),
+			"user":  principal.Name,
+		}).Warn(fmt.Sprintf("[BARN] Auth [%s]. Rejected %s %s: %s", c.ClientIP(), c.Request.Method, c.Request.URL.Path, err))
+		switch {
+		case errors.Is(err, auth.ErrUnauthenticated):
+			c.Header("WWW-Authenticate", `Basic realm="barn", charset="UTF-8"`)
+			c.String(http.StatusUnauthorized, "Authentication required")
+		case errors.Is(err, auth.ErrNetwork):
+			c.String(http.StatusForbidden, "Access from this network is not allowed")
+		default:
+			c.String(http.StatusForbidden, "The %s role is required", requiredRole(a, group, c.Request.Method))
+		}
+		c.Abort()
+	}
+}
+
+func requiredRole(a *auth.Authenticator, group string, method string) auth.Role {
+	policy := a.Policy(group)
+	if method == http.MethodGet || method == http.MethodHead {
+		return policy.Read
+	}
+	return policy.Write
+}
+
+// requestPrincipal returns the authenticated caller, if any
+func requestPrincipal(c *gin.Context) (auth.Principal, bool) {
+	if v, exists := c.Get("principal"); exists {
+		if principal, ok := v.(auth.Principal); ok && principal.Name != "" {
+			return principal, true
+		}
+	}
+	return auth.Principal{}, false
+}
+
+// canAdminister reports whether the caller may make admin changes through
+// another route group, such as the Alpaca Override action
+func (srv *ApiServer) canAdminister(c *gin.Context) bool {
+	if srv.auth == nil {
+		return true
+	}
+	principal, _ := requestPrincipal(c)
+	return srv.auth.Authorize(auth.GroupAdmin, c.Request.Method, net.ParseIP(c.ClientIP()), principal) == nil
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/auth"
+	"github.com/thebuh/barn/internal/metrics"
+)
+
+func newAuthTestServer(t *testing.T) (*ApiServer, http.Handler) {
+	srv, _ := newOverrideTestServer(t)
+	local, err := auth.ParseNetworks([]string{"192.0.2.0/24"})
+	assert.NoError(t, err, "should work")
+	srv.UseAuth(auth.New(
+		[]auth.User{{Name: "nina", Password: "secret", Role: auth.RoleRead}},
+		[]auth.Token{{Name: "ops", Token: "0123456789abcdef", Role: auth.RoleAdmin}},
+		map[string]auth.Policy{
+			auth.GroupAdmin: {Read: auth.RoleAdmin, Write: auth.RoleAdmin, Networks: local},
+		},
+	))
+	return srv, srv.Router()
+}
+
+func TestRouteGroup(t *testing.T) {
+	for path, group := range map[string]string{
+		"/api/v1/safetymonitor/0/issafe":   auth.GroupAlpaca,
+		"/management/v1/configureddevices": auth.GroupAlpaca,
+		"/admin/overrides":                 auth.GroupAdmin,
+		"/events":                          auth.GroupDashboard,
+		"/v1/monitors/rain":                auth.GroupDashboard,
+		"/ingest/monitor/rain":             auth.GroupIngest,
+		"/setup":                           auth.GroupSetup,
+		"/setup/v1/safetymonitor/0/setup":  auth.GroupSetup,
+		"/history/monitors/rain":           auth.GroupHistory,
+		"/metrics":                         auth.GroupMetrics,
+		"/":                                auth.GroupDashboard,
+		"/dashboard/state":                 auth.GroupDashboard,
+		"/setupx":                          auth.GroupDashboard,
+	} {
+		assert.Equal(t, group, routeGroup(path), path)
+	}
+}
+
+func TestAuth_Alpaca(t *testing.T) {
+	_, router := newAuthTestServer(t)
+
+	w := alpacaGet(router, "/api/v1/safetymonitor/0/name")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "they should be equal")
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic", "should ask for basic auth")
+	assert.Equal(t, "Authentication required", w.Body.String(), "Alpaca errors are plain text")
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/safetymonitor/0/name?ClientID=1&ClientTransactionID=1", nil)
+	req.SetBasicAuth("nina", "secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var resp stringResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), "should be valid json")
+	assert.Equal(t, "Safe", resp.Value, "they should be equal")
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/management/apiversions", nil)
+	req.SetBasicAuth("nina", "wrong")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "they should be equal")
+}
+
+func TestAuth_OverrideActionRequiresAdmin(t *testing.T) {
+	srv, router := newAuthTestServer(t)
+	put := func(user string, password string) *httptest.ResponseRecorder {
+		form := url.Values{"ClientID": {"1"}, "ClientTransactionID": {"1"}}
+		form.Set("Action", "Override")
+		form.Set("Parameters", `{"state": "unsafe", "duration": "1h"}`)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/safetymonitor/0/action", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(user, password)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	connect := url.Values{"ClientID": {"1"}, "ClientTransactionID": {"1"}, "Connected": {"true"}}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/safetymonitor/0/connected", strings.NewReader(connect.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("nina", "secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "readers may connect")
+
+	w = put("nina", "secret")
+	assert.Equal(t, http.StatusOK, w.Code, "Alpaca errors use ErrorNumber")
+	var resp stringResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), "should be valid json")
+	assert.Equal(t, int32(0x40B), int32(resp.ErrorNumber), "they should be equal")
+	assert.Equal(t, true, srv.Barn.GetMonitor("safe").IsSafe(), "override should not be set")
+}
+
+func TestAuth_AdminEndpoint(t *testing.T) {
+	srv, router := newAuthTestServer(t)
+	request := func(remote string, user string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/admin/overrides/safe", strings.NewReader(`{"state": "unsafe", "duration": "1h", "set_by": "someone else"}`))
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set("X-Forwarded-For", "192.0.2.50")
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request("198.51.100.1", "", "0123456789abcdef").Code, "other networks should be rejected, even when forwarded")
+	assert.Equal(t, http.StatusUnauthorized, request("192.0.2.1", "", "").Code, "they should be equal")
+	w := request("192.0.2.1", "nina", "")
+	assert.Equal(t, http.StatusForbidden, w.Code, "they should be equal")
+	assert.Equal(t, "The admin role is required", w.Body.String(), "they should be equal")
+
+	assert.Equal(t, http.StatusOK, request("192.0.2.1", "", "0123456789abcdef").Code, "they should be equal")
+	list := srv.overrides.List()
+	assert.Len(t, list, 1, "they should be equal")
+	assert.Equal(t, "ops", list[0].SetBy, "authenticated callers should be recorded as themselves")
+}
+
+func TestAuth_MetricsPort(t *testing.T) {
+	srv, _ := newAuthTestServer(t)
+	srv.UseMetrics(metrics.New(srv.Barn), false)
+	router := srv.MetricsRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "the metrics port should require auth")
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("nina", "secret")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+}
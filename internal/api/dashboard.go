@@ -0,0 +1,243 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// sparklineSamples is the number of recent readings kept per weather sensor
+const sparklineSamples = 120
+
+// dashboardFeed keeps what the dashboard needs but the devices do not store:
+// recent weather readings
+type dashboardFeed struct {
+	mu      sync.RWMutex
+	samples map[string]map[string][]float64 // keyed by station and sensor
+}
+
+func newDashboardFeed() *dashboardFeed {
+	return &dashboardFeed{
+		samples: make(map[string]map[string][]float64),
+	}
+}
+
+func (f *dashboardFeed) weatherRefreshed(refresh app.WeatherRefresh) {
+	if refresh.Err != nil {
+		return
+	}
+	id := refresh.Weather.GetId()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sensors, exists := f.samples[id]
+	if !exists {
+		sensors = make(map[string][]float64)
+		f.samples[id] = sensors
+	}
+	for sensor, value := range weather.StationSensors(refresh.Weather) {
+		values := append(sensors[sensor], value)
+		if len(values) > sparklineSamples {
+			values = values[len(values)-sparklineSamples:]
+		}
+		sensors[sensor] = values
+	}
+}
+
+// sensorSamples returns a copy of the recent readings of a sensor
+func (f *dashboardFeed) sensorSamples(id string, sensor string) []float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]float64{}, f.samples[id][sensor]...)
+}
+
+// monitorError returns the error of the last refresh of a monitor if it failed
+func (srv *ApiServer) monitorError(id string) string {
+	if status := srv.Barn.GetMonitorHealth(id); status.Failing() {
+		return status.LastError
+	}
+	return ""
+}
+
+// weatherError returns the error of the last refresh of a station if it failed
+func (srv *ApiServer) weatherError(id string) string {
+	if status := srv.Barn.GetWeatherHealth(id); status.Failing() {
+		return status.LastError
+	}
+	return ""
+}
+
+// MonitorRefreshed implements app.Listener
+func (srv *ApiServer) MonitorRefreshed(refresh app.MonitorRefresh) {
+	if event, ok := srv.monitorEvent(refresh.Monitor.GetId()); ok {
+		srv.events.publishMonitor(event)
+	}
+}
+
+// WeatherRefreshed implements app.Listener
+func (srv *ApiServer) WeatherRefreshed(refresh app.WeatherRefresh) {
+	srv.dashboard.weatherRefreshed(refresh)
+	srv.publishWeather(refresh.Weather.GetId())
+}
+
+type dashboardState struct {
+	Time     time.Time          `json:"time"`
+	Monitors []dashboardMonitor `json:"monitors"`
+	Weather  []dashboardWeather `json:"weather"`
+}
+
+type dashboardMonitor struct {
+	Id           string     `json:"id"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	DeviceNumber int        `json:"device_number"`
+	Safe         bool       `json:"safe"`
+	Raw          string     `json:"raw"`
+	LastRefresh  *time.Time `json:"last_refresh"`
+	Error        string     `json:"error"`
+	Clients      []ClientId `json:"clients"`
+	// Override is set while the monitor state is forced
+	Override *override.Override `json:"override"`
+}
+
+type dashboardWeather struct {
+	Id           string            `json:"id"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	DeviceNumber int               `json:"device_number"`
+	LastRefresh  *time.Time        `json:"last_refresh"`
+	Error        string            `json:"error"`
+	Clients      []ClientId        `json:"clients"`
+	Sensors      []dashboardSensor `json:"sensors"`
+}
+
+type dashboardSensor struct {
+	Name    string    `json:"name"`
+	Value   float64   `json:"value"`
+	Samples []float64 `json:"samples"`
+}
+
+// DashboardAPI serves the embedded status dashboard
+type DashboardAPI struct {
+	*ApiServer
+}
+
+// NewDashboardAPI creates a new dashboard handler
+func NewDashboardAPI(apiServer *ApiServer) *DashboardAPI {
+	return &DashboardAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up the dashboard page, its assets and state endpoint
+func (d *DashboardAPI) ConfigureRoutes(router *gin.Engine) {
+	assets, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	router.GET("/", d.handleIndex(assets))
+	router.StaticFS("/dashboard/assets", http.FS(assets))
+	router.GET("/dashboard/state", d.handleState)
+}
+
+func (d *DashboardAPI) handleIndex(assets fs.FS) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		index, err := fs.ReadFile(assets, "index.html")
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Dashboard not available")
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+	}
+}
+
+func (d *DashboardAPI) handleState(c *gin.Context) {
+	state := dashboardState{
+		Time:     time.Now(),
+		Monitors: []dashboardMonitor{},
+		Weather:  []dashboardWeather{},
+	}
+	for i, id := range d.Barn.GetMonitorIds() {
+		m := d.Barn.GetMonitor(id)
+		if m == nil {
+			continue
+		}
+		state.Monitors = append(state.Monitors, dashboardMonitor{
+			Id:           id,
+			Name:         m.GetName(),
+			Description:  m.GetDescription(),
+			DeviceNumber: i,
+			Safe:         m.IsSafe(),
+			Raw:          m.GetRawValue(),
+			LastRefresh:  optionalTime(m.GetTimeStamp()),
+			Error:        d.monitorError(id),
+			Clients:      d.deviceClients("safetymonitor", id),
+			Override:     activeOverride(m),
+		})
+	}
+	for i, id := range d.Barn.GetWeatherIds() {
+		w := d.Barn.GetWeather(id)
+		if w == nil {
+			continue
+		}
+		station := dashboardWeather{
+			Id:           id,
+			Name:         w.GetName(),
+			Description:  w.GetDescription(),
+			DeviceNumber: i,
+			LastRefresh:  optionalTime(w.GetTimeStamp()),
+			Error:        d.weatherError(id),
+			Clients:      d.deviceClients("observingconditions", id),
+			Sensors:      []dashboardSensor{},
+		}
+		for sensor, value := range weather.StationSensors(w) {
+			station.Sensors = append(station.Sensors, dashboardSensor{
+				Name:    sensor,
+				Value:   value,
+				Samples: d.dashboard.sensorSamples(id, sensor),
+			})
+		}
+		sort.Slice(station.Sensors, func(i, j int) bool {
+			return station.Sensors[i].Name < station.Sensors[j].Name
+		})
+		state.Weather = append(state.Weather, station)
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// deviceClients lists the clients connected to the Alpaca device backed by id
+func (d *DashboardAPI) deviceClients(deviceType string, id string) []ClientId {
+	for _, device := range d.Devices[deviceType] {
+		if device.Id == id {
+			return device.ConnectedClientIds()
+		}
+	}
+	return []ClientId{}
+}
+
+// activeOverride returns the override forcing the state of m, if any
+func activeOverride(m monitor.SafetyMonitor) *override.Override {
+	if om, ok := m.(*override.Monitor); ok {
+		return &om.Override
+	}
+	return nil
+}
+
+// optionalTime returns nil for devices that never refreshed
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
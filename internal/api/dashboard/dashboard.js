@@ -0,0 +1,175 @@
+(function () {
+  "use strict";
+
+  var refreshInterval = 5000;
+  // eventDelay gathers the events of one refresh into a single update
+  var eventDelay = 200;
+  var pending = null;
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (key) {
+      node.setAttribute(key, attrs[key]);
+    });
+    (children || []).forEach(function (child) {
+      node.appendChild(typeof child === "string" ? document.createTextNode(child) : child);
+    });
+    return node;
+  }
+
+  function formatTime(value) {
+    if (!value) {
+      return "never";
+    }
+    var seconds = Math.round((Date.now() - new Date(value).getTime()) / 1000);
+    return new Date(value).toLocaleTimeString() + " (" + Math.max(seconds, 0) + "s ago)";
+  }
+
+  function formatValue(value) {
+    return Number.isInteger(value) ? String(value) : value.toFixed(2);
+  }
+
+  function sparkline(samples) {
+    var ns = "http://www.w3.org/2000/svg";
+    var svg = document.createElementNS(ns, "svg");
+    svg.setAttribute("class", "sparkline");
+    svg.setAttribute("viewBox", "0 0 100 20");
+    svg.setAttribute("preserveAspectRatio", "none");
+    if (samples.length < 2) {
+      return svg;
+    }
+    var min = Math.min.apply(null, samples);
+    var max = Math.max.apply(null, samples);
+    var range = max - min || 1;
+    var points = samples.map(function (value, i) {
+      var x = (i / (samples.length - 1)) * 100;
+      var y = 19 - ((value - min) / range) * 18;
+      return x.toFixed(1) + "," + y.toFixed(1);
+    });
+    var line = document.createElementNS(ns, "polyline");
+    line.setAttribute("points", points.join(" "));
+    svg.appendChild(line);
+    return svg;
+  }
+
+  function details(rows) {
+    var list = el("dl");
+    rows.forEach(function (row) {
+      list.appendChild(el("dt", {}, [row[0]]));
+      list.appendChild(el("dd", row[2] ? { "class": row[2] } : {}, [row[1]]));
+    });
+    return list;
+  }
+
+  function clients(list) {
+    return list.length ? list.join(", ") : "none";
+  }
+
+  function monitorCard(m) {
+    var rows = [
+      ["Device", "safetymonitor/" + m.device_number],
+      ["Raw value", m.raw || "—"],
+      ["Last refresh", formatTime(m.last_refresh)],
+      ["Clients", clients(m.clients)]
+    ];
+    if (m.error) {
+      rows.push(["Error", m.error, "error-text"]);
+    }
+    var badge = m.safe ? "SAFE" : "UNSAFE";
+    if (m.override) {
+      badge = "FORCED " + badge;
+      rows.push(["Override", "by " + m.override.set_by + " until " + new Date(m.override.expires).toLocaleString() +
+        (m.override.reason ? " (" + m.override.reason + ")" : ""), "override-text"]);
+    }
+    return el("div", { "class": "card " + (m.safe ? "safe" : "unsafe") }, [
+      el("h3", {}, [m.name || m.id, el("span", { "class": "badge" }, [badge])]),
+      el("p", { "class": "description" }, [m.description || m.id]),
+      details(rows)
+    ]);
+  }
+
+  function weatherCard(w) {
+    var rows = [
+      ["Device", "observingconditions/" + w.device_number],
+      ["Last refresh", formatTime(w.last_refresh)],
+      ["Clients", clients(w.clients)]
+    ];
+    if (w.error) {
+      rows.push(["Error", w.error, "error-text"]);
+    }
+    var table = el("table");
+    w.sensors.forEach(function (s) {
+      var spark = el("td", { "class": "spark" });
+      spark.appendChild(sparkline(s.samples));
+      table.appendChild(el("tr", {}, [
+        el("td", {}, [s.name]),
+        el("td", { "class": "value" }, [formatValue(s.value)]),
+        spark
+      ]));
+    });
+    return el("div", { "class": "card" + (w.error ? " unsafe" : "") }, [
+      el("h3", {}, [w.name || w.id]),
+      el("p", { "class": "description" }, [w.description || w.id]),
+      details(rows),
+      table
+    ]);
+  }
+
+  function replace(container, cards) {
+    while (container.firstChild) {
+      container.removeChild(container.firstChild);
+    }
+    cards.forEach(function (card) {
+      container.appendChild(card);
+    });
+  }
+
+  function render(state) {
+    replace(document.getElementById("monitors"), state.monitors.map(monitorCard));
+    replace(document.getElementById("weather"), state.weather.map(weatherCard));
+  }
+
+  function setStatus(text, isError) {
+    var status = document.getElementById("status");
+    status.textContent = text;
+    status.className = "status" + (isError ? " error" : "");
+  }
+
+  function update() {
+    fetch("/dashboard/state", { cache: "no-store" })
+      .then(function (resp) {
+        if (!resp.ok) {
+          throw new Error("HTTP " + resp.status);
+        }
+        return resp.json();
+      })
+      .then(function (state) {
+        render(state);
+        setStatus("Updated " + new Date(state.time).toLocaleTimeString(), false);
+      })
+      .catch(function (err) {
+        setStatus("Update failed: " + err.message, true);
+      })
+      .then(function () {
+        schedule(refreshInterval);
+      });
+  }
+
+  function schedule(delay) {
+    clearTimeout(pending);
+    pending = setTimeout(update, delay);
+  }
+
+  // Changes streamed by the server update the page at once. Polling keeps the
+  // sparklines moving and covers browsers without EventSource.
+  if (window.EventSource) {
+    var events = new EventSource("/events");
+    ["monitor", "weather", "connection"].forEach(function (type) {
+      events.addEventListener(type, function () {
+        schedule(eventDelay);
+      });
+    });
+  }
+
+  update();
+})();
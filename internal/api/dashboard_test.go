@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+func TestDashboardAPI_Pages(t *testing.T) {
+	srv := NewApiServer(newTestBarn(), 0)
+	router := srv.Router()
+	for path, contentType := range map[string]string{
+		"/":                               "text/html",
+		"/dashboard/assets/dashboard.js":  "javascript",
+		"/dashboard/assets/dashboard.css": "text/css",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Header().Get("Content-Type"), contentType, path)
+		assert.NotContains(t, w.Body.String(), "://cdn", "dashboard must work offline")
+	}
+}
+
+// failingMonitor fails every refresh with err
+type failingMonitor struct {
+	*monitor.SafetyMonitorDummy
+	err error
+}
+
+func (m *failingMonitor) Refresh() error {
+	return m.err
+}
+
+func TestDashboardAPI_State(t *testing.T) {
+	barn := app.New()
+	barn.AddMonitor(&failingMonitor{monitor.NewSafetyMonitorDummy("safe", "Safe", "Always safe", true), errors.New("timeout")})
+	barn.AddWeather(weather.NewObservingConditionsDummy("station", "Station", "Dummy station"))
+	srv := NewApiServer(barn, 0)
+	srv.Devices["safetymonitor"][0].ConnectClient("127.0.0.1-2")
+	srv.Devices["safetymonitor"][0].ConnectClient("127.0.0.1-1")
+
+	station := barn.GetWeather("station")
+	for i := 0; i < sparklineSamples+5; i++ {
+		station.Refresh()
+		srv.WeatherRefreshed(app.WeatherRefresh{Weather: station, Started: time.Now()})
+	}
+	assert.Error(t, barn.RefreshMonitor("safe"), "should fail")
+
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard/state", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var state dashboardState
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state), "should be valid json")
+
+	assert.Len(t, state.Monitors, 1, "they should be equal")
+	monitor := state.Monitors[0]
+	assert.Equal(t, "safe", monitor.Id, "they should be equal")
+	assert.Equal(t, true, monitor.Safe, "they should be equal")
+	assert.Equal(t, "timeout", monitor.Error, "they should be equal")
+	assert.Equal(t, []ClientId{"127.0.0.1-1", "127.0.0.1-2"}, monitor.Clients, "they should be equal")
+
+	assert.Len(t, state.Weather, 1, "they should be equal")
+	weather := state.Weather[0]
+	assert.Equal(t, []ClientId{}, weather.Clients, "they should be equal")
+	assert.NotEmpty(t, weather.Sensors, "available sensors should be listed")
+	for _, sensor := range weather.Sensors {
+		assert.Len(t, sensor.Samples, sparklineSamples, sensor.Name)
+		assert.Equal(t, sensor.Value, sensor.Samples[len(sensor.Samples)-1], sensor.Name)
+	}
+	assert.True(t, strings.Compare(weather.Sensors[0].Name, weather.Sensors[1].Name) < 0, "sensors should be sorted")
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/dome"
+)
+
+// DomeAPI exposes roll-off roofs as Alpaca domes that can only open and
+// close their shutter
+type DomeAPI struct {
+	*ApiServer
+}
+
+// NewDomeAPI creates a new dome API handler
+func NewDomeAPI(apiServer *ApiServer) *DomeAPI {
+	return &DomeAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up all dome API routes
+func (d *DomeAPI) ConfigureRoutes(router *gin.Engine) {
+	group := router.Group("/api/v1/dome/:device_id")
+	group.Use(alpacaValidationMiddleware())
+	group.Use(deviceValidationMiddleware("dome"))
+	group.Use(alpacaResponseMiddleware())
+	{
+		group.PUT("/connected", d.handleConnectedPut)
+		group.PUT("/connect", d.handleConnect)
+		group.PUT("/disconnect", d.handleDisconnect)
+		group.PUT("/action", d.handleAction)
+		group.PUT("/commandblind", d.handleNotImplemented)
+		group.PUT("/commandbool", d.handleNotImplemented)
+		group.PUT("/commandstring", d.handleNotImplemented)
+		group.PUT("/openshutter", d.handleOpenShutter)
+		group.PUT("/closeshutter", d.handleCloseShutter)
+		group.PUT("/abortslew", d.handleAbortSlew)
+		group.PUT("/slaved", d.handleSlavedPut)
+		group.PUT("/findhome", d.handleNotImplemented)
+		group.PUT("/park", d.handleNotImplemented)
+		group.PUT("/setpark", d.handleNotImplemented)
+		group.PUT("/slewtoaltitude", d.handleNotImplemented)
+		group.PUT("/slewtoazimuth", d.handleNotImplemented)
+		group.PUT("/synctoazimuth", d.handleNotImplemented)
+
+		group.GET("/connected", d.handleConnected)
+		group.GET("/connecting", d.handleConnecting)
+		group.GET("/name", d.handleName)
+		group.GET("/description", d.handleDescription)
+		group.GET("/driverinfo", d.handleDriverInfo)
+		group.GET("/driverversion", d.handleDriverVersion)
+		group.GET("/supportedactions", d.handleSupportedActions)
+		group.GET("/interfaceversion", d.handleInterfaceVersion)
+		group.GET("/devicestate", d.handleDeviceState)
+		group.GET("/shutterstatus", d.handleShutterStatus)
+		group.GET("/slewing", d.handleSlewing)
+		group.GET("/slaved", d.handleFalse)
+		group.GET("/athome", d.handleFalse)
+		group.GET("/atpark", d.handleFalse)
+		group.GET("/cansetshutter", d.handleTrue)
+		group.GET("/canfindhome", d.handleFalse)
+		group.GET("/canpark", d.handleFalse)
+		group.GET("/cansetaltitude", d.handleFalse)
+		group.GET("/cansetazimuth", d.handleFalse)
+		group.GET("/cansetpark", d.handleFalse)
+		group.GET("/canslave", d.handleFalse)
+		group.GET("/cansyncazimuth", d.handleFalse)
+		group.GET("/altitude", d.handleNotImplemented)
+		group.GET("/azimuth", d.handleNotImplemented)
+	}
+}
+
+// isRequestConnected checks if the current request is from a connected client
+func (d *DomeAPI) isRequestConnected(c *gin.Context) bool {
+	device, _ := c.Get("device")
+	return device.(*Device).IsConnected(getFullClientId(c))
+}
+
+// requestDome returns the dome of the request. It answers the request
+// itself when the client is not connected.
+func (d *DomeAPI) requestDome(c *gin.Context) (dome.Dome, bool) {
+	if !d.isRequestConnected(c) {
+		c.String(400, "Not connected")
+		return nil, false
+	}
+	roof, err := d.Barn.GetDomeByIndex(GetValidationContext(c).DeviceID)
+	if err != nil {
+		c.String(400, "Device not found")
+		return nil, false
+	}
+	return roof, true
+}
+
+// alpacaError answers with an Alpaca error in a 200 response
+func (d *DomeAPI) alpacaError(c *gin.Context, number int32, message string) {
+	resp := alpacaResponse{
+		ErrorNumber:  number,
+		ErrorMessage: message,
+	}
+	d.prepareAlpacaResponse(c, &resp)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (d *DomeAPI) respond(c *gin.Context) {
+	resp := alpacaResponse{}
+	d.prepareAlpacaResponse(c, &resp)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (d *DomeAPI) respondBool(c *gin.Context, value bool) {
+	resp := boolResponse{
+		Value: value,
+	}
+	d.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (d *DomeAPI) respondString(c *gin.Context, value string) {
+	resp := stringResponse{
+		Value: value,
+	}
+	d.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleConnected handles GET requests for the connected property
+func (d *DomeAPI) handleConnected(c *gin.Context) {
+	d.respondBool(c, d.isRequestConnected(c))
+}
+
+// handleConnecting handles GET requests for the connecting property
+func (d *DomeAPI) handleConnecting(c *gin.Context) {
+	d.respondBool(c, false)
+}
+
+// handleConnectedPut handles PUT requests to connect or disconnect
+func (d *DomeAPI) handleConnectedPut(c *gin.Context) {
+	device, _ := c.Get("device")
+	dev := device.(*Device)
+	switch strings.ToLower(switchParam(c, "Connected")) {
+	case "true":
+		dev.ConnectClient(getFullClientId(c))
+	case "false":
+		dev.DisconnectClient(getFullClientId(c))
+	default:
+		c.String(400, "Invalid request")
+		return
+	}
+	d.respond(c)
+}
+
+// handleConnect handles PUT requests to connect, which completes at once
+func (d *DomeAPI) handleConnect(c *gin.Context) {
+	device, _ := c.Get("device")
+	device.(*Device).ConnectClient(getFullClientId(c))
+	d.respond(c)
+}
+
+// handleDisconnect handles PUT requests to disconnect, which completes at once
+func (d *DomeAPI) handleDisconnect(c *gin.Context) {
+	device, _ := c.Get("device")
+	device.(*Device).DisconnectClient(getFullClientId(c))
+	d.respond(c)
+}
+
+// handleName handles GET requests for the name property
+func (d *DomeAPI) handleName(c *gin.Context) {
+	roof, err := d.Barn.GetDomeByIndex(GetValidationContext(c).DeviceID)
+	if err != nil {
+		c.String(400, "Device not found")
+		return
+	}
+	d.respondString(c, roof.GetName())
+}
+
+// handleDescription handles GET requests for the description property
+func (d *DomeAPI) handleDescription(c *gin.Context) {
+	roof, err := d.Barn.GetDomeByIndex(GetValidationContext(c).DeviceID)
+	if err != nil {
+		c.String(400, "Device not found")
+		return
+	}
+	d.respondString(c, roof.GetDescription())
+}
+
+// handleDriverInfo handles GET requests for the driverinfo property
+func (d *DomeAPI) handleDriverInfo(c *gin.Context) {
+	d.respondString(c, "Alpaca Barn roof")
+}
+
+// handleDriverVersion handles GET requests for the driverversion property
+func (d *DomeAPI) handleDriverVersion(c *gin.Context) {
+	bi, _ := debug.ReadBuildInfo()
+	d.respondString(c, bi.Main.Version)
+}
+
+// handleSupportedActions handles GET requests for the supportedactions property
+func (d *DomeAPI) handleSupportedActions(c *gin.Context) {
+	resp := stringlistResponse{
+		Value: []string{},
+	}
+	d.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleInterfaceVersion handles GET requests for the interfaceversion property
+func (d *DomeAPI) handleInterfaceVersion(c *gin.Context) {
+	resp := int32Response{
+		Value: 3,
+	}
+	d.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleAction handles PUT requests for actions, of which there are none
+func (d *DomeAPI) handleAction(c *gin.Context) {
+	if !d.isRequestConnected(c) {
+		c.String(400, "Not connected")
+		return
+	}
+	d.alpacaError(c, 0x40C, fmt.Sprintf("Action %q is not supported", switchParam(c, "Action"))) // ActionNotImplemented
+}
+
+// handleNotImplemented answers the members of domes that rotate or park
+func (d *DomeAPI) handleNotImplemented(c *gin.Context) {
+	d.alpacaError(c, 0x400, "Method not implemented") // NotImplemented
+}
+
+// handleTrue answers capabilities the roof has
+func (d *DomeAPI) handleTrue(c *gin.Context) {
+	if _, ok := d.requestDome(c); ok {
+		d.respondBool(c, true)
+	}
+}
+
+// handleFalse answers capabilities and positions the roof does not have
+func (d *DomeAPI) handleFalse(c *gin.Context) {
+	if _, ok := d.requestDome(c); ok {
+		d.respondBool(c, false)
+	}
+}
+
+// handleSlavedPut accepts turning slaving off, which it always is
+func (d *DomeAPI) handleSlavedPut(c *gin.Context) {
+	if _, ok := d.requestDome(c); !ok {
+		return
+	}
+	switch strings.ToLower(switchParam(c, "Slaved")) {
+	case "false":
+		d.respond(c)
+	case "true":
+		d.alpacaError(c, 0x400, "Slaving is not supported") // NotImplemented
+	default:
+		c.String(400, "Invalid Slaved value")
+	}
+}
+
+// handleShutterStatus handles GET requests for the shutterstatus property
+func (d *DomeAPI) handleShutterStatus(c *gin.Context) {
+	roof, ok := d.requestDome(c)
+	if !ok {
+		return
+	}
+	resp := int32Response{
+		Value: int32(roof.ShutterStatus()),
+	}
+	d.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleSlewing handles GET requests for the slewing property
+func (d *DomeAPI) handleSlewing(c *gin.Context) {
+	if roof, ok := d.requestDome(c); ok {
+		d.respondBool(c, roof.Slewing())
+	}
+}
+
+// handleDeviceState handles GET requests for the devicestate property
+func (d *DomeAPI) handleDeviceState(c *gin.Context) {
+	roof, ok := d.requestDome(c)
+	if !ok {
+		return
+	}
+	state := roof.ShutterStatus()
+	resp := deviceStateResponse{
+		Value: []DeviceState{
+			{Name: "Altitude", Value: 0},
+			{Name: "AtHome", Value: false},
+			{Name: "AtPark", Value: false},
+			{Name: "Azimuth", Value: 0},
+			{Name: "ShutterStatus", Value: int32(state)},
+			{Name: "Slewing", Value: state == dome.ShutterOpening || state == dome.ShutterClosing},
+			{Name: "TimeStamp", Value: roof.GetTimeStamp()},
+		},
+	}
+	d.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleOpenShutter handles PUT requests to open the roof, which is refused
+// while its safety monitor is unsafe
+func (d *DomeAPI) handleOpenShutter(c *gin.Context) {
+	if roof, ok := d.requestDome(c); ok {
+		d.command(c, roof, "open", roof.OpenShutter)
+	}
+}
+
+// handleCloseShutter handles PUT requests to close the roof
+func (d *DomeAPI) handleCloseShutter(c *gin.Context) {
+	if roof, ok := d.requestDome(c); ok {
+		d.command(c, roof, "close", roof.CloseShutter)
+	}
+}
+
+// handleAbortSlew handles PUT requests to stop the roof
+func (d *DomeAPI) handleAbortSlew(c *gin.Context) {
+	if roof, ok := d.requestDome(c); ok {
+		d.command(c, roof, "abort", roof.AbortSlew)
+	}
+}
+
+// command runs a roof command and logs who sent it. Moving the roof needs the
+// admin role, like overrides set from switches.
+func (d *DomeAPI) command(c *gin.Context, roof dome.Dome, name string, run func() error) {
+	if !d.canAdminister(c) {
+		d.alpacaError(c, 0x40B, "Roof commands require the admin role") // InvalidOperation
+		return
+	}
+	by := "alpaca:" + string(getFullClientId(c))
+	if principal, ok := requestPrincipal(c); ok {
+		by = principal.Name + " (" + by + ")"
+	}
+	err := run()
+	switch {
+	case errors.Is(err, dome.ErrUnsafe):
+		log.WithFields(log.Fields{"dome": roof.GetName(), "by": by}).Warn(fmt.Sprintf("[BARN] Dome [%s]. Refused to %s while unsafe", roof.GetName(), name))
+		d.alpacaError(c, 0x40B, "The roof cannot open while its safety monitor is unsafe") // InvalidOperation
+	case errors.Is(err, dome.ErrNotSupported):
+		d.alpacaError(c, 0x400, fmt.Sprintf("The roof controller has no %s command", name)) // NotImplemented
+	case err != nil:
+		log.WithError(err).Error(fmt.Sprintf("[BARN] Dome [%s]. Failed to %s", roof.GetName(), name))
+		d.alpacaError(c, 0x500, err.Error()) // UnspecifiedError
+	default:
+		log.WithFields(log.Fields{"dome": roof.GetName(), "by": by}).Info(fmt.Sprintf("[BARN] Dome [%s]. Sent %s", roof.GetName(), name))
+		d.respond(c)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/auth"
+	"github.com/thebuh/barn/internal/dome"
+	"github.com/thebuh/barn/internal/monitor"
+)
+
+func newDomeTestServer(t *testing.T, safe bool, travel time.Duration) http.Handler {
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("weather", "Weather", "", safe))
+	simulator, err := dome.NewSimulator(travel, false)
+	assert.NoError(t, err, "should work")
+	roof, err := dome.NewRoof("roof", "Roll-off roof", "Observatory roof", simulator, dome.Options{SafetyMonitor: "weather"})
+	assert.NoError(t, err, "should work")
+	barn.AddDome(roof)
+	router := NewApiServer(barn, 0).Router()
+	alpacaPut(router, "/api/v1/dome/0/connected", url.Values{"Connected": {"true"}})
+	return router
+}
+
+func TestDomeAPI_Shutter(t *testing.T) {
+	router := newDomeTestServer(t, true, 50*time.Millisecond)
+
+	value, _ := decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/shutterstatus"))
+	assert.Equal(t, float64(dome.ShutterClosed), value, "they should be equal")
+	value, _ = decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/name"))
+	assert.Equal(t, "Roll-off roof", value, "they should be equal")
+
+	_, errorNumber := decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/openshutter", url.Values{}))
+	assert.Equal(t, int32(0), errorNumber, "they should be equal")
+	value, _ = decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/shutterstatus"))
+	assert.Equal(t, float64(dome.ShutterOpening), value, "they should be equal")
+	value, _ = decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/slewing"))
+	assert.Equal(t, true, value, "they should be equal")
+
+	assert.Eventually(t, func() bool {
+		value, _ := decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/shutterstatus"))
+		return value == float64(dome.ShutterOpen)
+	}, 3*time.Second, 20*time.Millisecond, "the roof should open")
+
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/closeshutter", url.Values{}))
+	assert.Equal(t, int32(0), errorNumber, "they should be equal")
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/abortslew", url.Values{}))
+	assert.Equal(t, int32(0), errorNumber, "they should be equal")
+	value, _ = decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/shutterstatus"))
+	assert.Equal(t, float64(dome.ShutterError), value, "aborted roofs should report an error")
+
+	var state deviceStateResponse
+	w := alpacaGet(router, "/api/v1/dome/0/devicestate")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state), "should be valid json")
+	values := make(map[string]interface{})
+	for _, s := range state.Value {
+		values[s.Name] = s.Value
+	}
+	assert.Equal(t, float64(dome.ShutterError), values["ShutterStatus"], "they should be equal")
+	assert.Equal(t, false, values["Slewing"], "they should be equal")
+	assert.Contains(t, values, "TimeStamp", "should contain a timestamp")
+}
+
+func TestDomeAPI_RefusesUnsafeOpen(t *testing.T) {
+	router := newDomeTestServer(t, false, time.Second)
+	_, errorNumber := decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/openshutter", url.Values{}))
+	assert.Equal(t, int32(0x40B), errorNumber, "they should be equal")
+	value, _ := decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/shutterstatus"))
+	assert.Equal(t, float64(dome.ShutterClosed), value, "they should be equal")
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/closeshutter", url.Values{}))
+	assert.Equal(t, int32(0), errorNumber, "closing should be allowed while unsafe")
+}
+
+func TestDomeAPI_Capabilities(t *testing.T) {
+	router := newDomeTestServer(t, true, time.Second)
+	for property, expected := range map[string]interface{}{
+		"cansetshutter":  true,
+		"canfindhome":    false,
+		"canpark":        false,
+		"cansetaltitude": false,
+		"cansetazimuth":  false,
+		"canslave":       false,
+		"slaved":         false,
+		"athome":         false,
+		"atpark":         false,
+	} {
+		value, errorNumber := decodeSwitchValue(t, alpacaGet(router, "/api/v1/dome/0/"+property))
+		assert.Equal(t, int32(0), errorNumber, property)
+		assert.Equal(t, expected, value, property)
+	}
+	for _, path := range []string{"/api/v1/dome/0/azimuth", "/api/v1/dome/0/altitude"} {
+		_, errorNumber := decodeSwitchValue(t, alpacaGet(router, path))
+		assert.Equal(t, int32(0x400), errorNumber, path)
+	}
+	_, errorNumber := decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/park", url.Values{}))
+	assert.Equal(t, int32(0x400), errorNumber, "they should be equal")
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/slaved", url.Values{"Slaved": {"true"}}))
+	assert.Equal(t, int32(0x400), errorNumber, "they should be equal")
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/dome/0/slaved", url.Values{"Slaved": {"false"}}))
+	assert.Equal(t, int32(0), errorNumber, "they should be equal")
+
+	alpacaPut(router, "/api/v1/dome/0/connected", url.Values{"Connected": {"false"}})
+	w := alpacaGet(router, "/api/v1/dome/0/shutterstatus")
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+	w = alpacaGet(router, "/api/v1/dome/1/shutterstatus")
+	assert.NotEqual(t, http.StatusOK, w.Code, "unknown domes should fail")
+
+	var devices managementDevicesListResponse
+	assert.NoError(t, json.Unmarshal(alpacaGet(router, "/management/v1/configureddevices").Body.Bytes(), &devices), "should be valid json")
+	var types []string
+	for _, device := range devices.Value {
+		types = append(types, device.DeviceType)
+	}
+	assert.Contains(t, types, "dome", "domes should be listed")
+}
+
+func TestDomeAPI_RequiresAdmin(t *testing.T) {
+	barn := app.New()
+	simulator, err := dome.NewSimulator(time.Second, false)
+	assert.NoError(t, err, "should work")
+	roof, err := dome.NewRoof("roof", "Roll-off roof", "", simulator, dome.Options{})
+	assert.NoError(t, err, "should work")
+	barn.AddDome(roof)
+	srv := NewApiServer(barn, 0)
+	srv.UseAuth(auth.New([]auth.User{{Name: "nina", Password: "secret", Role: auth.RoleRead}}, nil, nil))
+	router := srv.Router()
+	put := func(path string, form url.Values) *httptest.ResponseRecorder {
+		form.Set("ClientID", "1")
+		form.Set("ClientTransactionID", "1")
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("nina", "secret")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := put("/api/v1/dome/0/connected", url.Values{"Connected": {"true"}})
+	assert.Equal(t, http.StatusOK, w.Code, "readers may connect")
+
+	for _, command := range []string{"openshutter", "closeshutter", "abortslew"} {
+		_, errorNumber := decodeSwitchValue(t, put("/api/v1/dome/0/"+command, url.Values{}))
+		assert.Equal(t, int32(0x40B), errorNumber, command)
+	}
+	assert.Equal(t, dome.ShutterClosed, roof.ShutterStatus(), "the roof should not move")
+}
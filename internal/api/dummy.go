@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// dummyMonitorRequest is the body of the dummy monitor admin endpoint
+type dummyMonitorRequest struct {
+	Safe *bool `json:"safe"`
+}
+
+// DummyAPI serves the admin endpoints that change dummy devices at runtime,
+// so client integration tests can flip states on demand
+type DummyAPI struct {
+	*ApiServer
+}
+
+// NewDummyAPI creates a new dummy device API handler
+func NewDummyAPI(apiServer *ApiServer) *DummyAPI {
+	return &DummyAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up the dummy device admin routes
+func (d *DummyAPI) ConfigureRoutes(router *gin.Engine) {
+	group := router.Group("/admin/dummy")
+	{
+		group.PUT("/monitors/:id", d.handleSetMonitor)
+		group.PUT("/weather/:id", d.handleSetWeather)
+	}
+}
+
+func (d *DummyAPI) handleSetMonitor(c *gin.Context) {
+	dummy, ok := dummyMonitor(d.Barn.GetMonitor(c.Param("id")))
+	if !ok {
+		c.String(http.StatusNotFound, "Dummy monitor not found")
+		return
+	}
+	var req dummyMonitorRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Safe == nil {
+		c.String(http.StatusBadRequest, "Invalid request body, expected JSON such as {\"safe\": false}")
+		return
+	}
+	dummy.SetSafe(*req.Safe)
+	d.publishMonitors()
+	c.IndentedJSON(http.StatusOK, gin.H{"id": dummy.GetId(), "safe": dummy.IsSafe()})
+}
+
+func (d *DummyAPI) handleSetWeather(c *gin.Context) {
+	dummy, ok := d.Barn.GetWeather(c.Param("id")).(*weather.ObservingConditionsDummy)
+	if !ok {
+		c.String(http.StatusNotFound, "Dummy weather station not found")
+		return
+	}
+	var values map[string]float64
+	if err := c.ShouldBindJSON(&values); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body, expected JSON such as {\"RainRate\": 2.5}")
+		return
+	}
+	if err := dummy.SetValues(values); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	d.publishWeather(dummy.GetId())
+	c.IndentedJSON(http.StatusOK, dummy.GetCondition())
+}
+
+// dummyMonitor returns the dummy behind a monitor, also while it is
+// overridden
+func dummyMonitor(m monitor.SafetyMonitor) (*monitor.SafetyMonitorDummy, bool) {
+	dummy, ok := unwrapOverride(m).(*monitor.SafetyMonitorDummy)
+	return dummy, ok
+}
+
+// requireAdmin answers Alpaca actions that change devices with an error
+// unless the caller may administer them
+func (srv *ApiServer) requireAdmin(c *gin.Context, message string) bool {
+	if srv.canAdminister(c) {
+		return true
+	}
+	resp := alpacaResponse{
+		ErrorNumber:  0x40B, // InvalidOperation
+		ErrorMessage: message,
+	}
+	srv.prepareAlpacaResponse(c, &resp)
+	c.IndentedJSON(http.StatusOK, resp)
+	return false
+}
+
+// handleSetSafeAction runs the Alpaca SetSafe action of dummy monitors.
+// Parameters is true or false.
+func (sm *SafetyMonitorAPI) handleSetSafeAction(c *gin.Context, dummy *monitor.SafetyMonitorDummy) {
+	safe, err := strconv.ParseBool(c.PostForm("Parameters"))
+	if err != nil {
+		c.String(400, "Invalid SetSafe parameters, expected true or false")
+		return
+	}
+	if !sm.requireAdmin(c, "SetSafe requires the admin role") {
+		return
+	}
+	dummy.SetSafe(safe)
+	sm.publishMonitors()
+	resp := stringResponse{
+		Value: strconv.FormatBool(dummy.IsSafe()),
+	}
+	sm.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleSetValuesAction runs the Alpaca SetValues action of dummy weather
+// stations. Parameters is a JSON object of readings by sensor name.
+func (w *WeatherAPI) handleSetValuesAction(c *gin.Context, dummy *weather.ObservingConditionsDummy) {
+	var values map[string]float64
+	if err := json.Unmarshal([]byte(c.PostForm("Parameters")), &values); err != nil {
+		c.String(400, "Invalid SetValues parameters, expected JSON such as {\"RainRate\": 2.5}")
+		return
+	}
+	if !w.requireAdmin(c, "SetValues requires the admin role") {
+		return
+	}
+	if err := dummy.SetValues(values); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	w.publishWeather(dummy.GetId())
+	resp := stringResponse{
+		Value: dummy.GetState(),
+	}
+	w.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/auth"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+func newDummyTestServer(t *testing.T) *ApiServer {
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("rain", "Rain", "", true))
+	barn.AddMonitor(monitor.NewSafetyMonitorFile("roof", "Roof", "", "/nonexistent", monitor.NewSafetyMatchingRule(false, "")))
+	barn.AddWeather(weather.NewObservingConditionsDummy("station", "Station", ""))
+	return NewApiServer(barn, 0)
+}
+
+func TestDummyAPI_Admin(t *testing.T) {
+	srv := newDummyTestServer(t)
+	router := srv.Router()
+	put := func(path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := put("/admin/dummy/monitors/rain", `{"safe": false}`)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, false, srv.Barn.GetMonitor("rain").IsSafe(), "they should be equal")
+
+	w = put("/admin/dummy/weather/station", `{"RainRate": 2.5, "cloud_cover": 80}`)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var condition weather.WeatherCondition
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &condition), "should be valid json")
+	assert.Equal(t, 2.5, condition.RainRate, "they should be equal")
+	assert.Equal(t, 80.0, srv.Barn.GetWeather("station").GetCloudCover(), "they should be equal")
+
+	for _, req := range []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/admin/dummy/monitors/roof", `{"safe": true}`, http.StatusNotFound},
+		{"/admin/dummy/monitors/missing", `{"safe": true}`, http.StatusNotFound},
+		{"/admin/dummy/monitors/rain", `{}`, http.StatusBadRequest},
+		{"/admin/dummy/weather/missing", `{"RainRate": 1}`, http.StatusNotFound},
+		{"/admin/dummy/weather/station", `{"Visibility": 10}`, http.StatusBadRequest},
+		{"/admin/dummy/weather/station", `raining`, http.StatusBadRequest},
+	} {
+		assert.Equal(t, req.code, put(req.path, req.body).Code, req.path+" "+req.body)
+	}
+}
+
+func TestDummyAPI_AlpacaActions(t *testing.T) {
+	srv := newDummyTestServer(t)
+	router := srv.Router()
+	alpacaPut(router, "/api/v1/safetymonitor/0/connected", url.Values{"Connected": {"true"}})
+	alpacaPut(router, "/api/v1/safetymonitor/1/connected", url.Values{"Connected": {"true"}})
+	alpacaPut(router, "/api/v1/observingconditions/0/connected", url.Values{"Connected": {"true"}})
+
+	assert.Contains(t, alpacaGet(router, "/api/v1/safetymonitor/0/supportedactions").Body.String(), `"SetSafe"`)
+	assert.NotContains(t, alpacaGet(router, "/api/v1/safetymonitor/1/supportedactions").Body.String(), `"SetSafe"`)
+	assert.Contains(t, alpacaGet(router, "/api/v1/observingconditions/0/supportedactions").Body.String(), `"SetValues"`)
+
+	w := alpacaPut(router, "/api/v1/safetymonitor/0/action", url.Values{"Action": {"SetSafe"}, "Parameters": {"false"}})
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, alpacaGet(router, "/api/v1/safetymonitor/0/issafe").Body.String(), `"Value": false`)
+
+	w = alpacaPut(router, "/api/v1/observingconditions/0/action", url.Values{"Action": {"SetValues"}, "Parameters": {`{"SkyQuality": 21.2}`}})
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, alpacaGet(router, "/api/v1/observingconditions/0/skyquality").Body.String(), `"Value": 21.2`)
+
+	for _, req := range []struct {
+		path   string
+		action string
+		params string
+	}{
+		{"/api/v1/safetymonitor/0/action", "SetSafe", "maybe"},
+		{"/api/v1/safetymonitor/1/action", "SetSafe", "true"},
+		{"/api/v1/observingconditions/0/action", "SetValues", "RainRate=1"},
+		{"/api/v1/observingconditions/0/action", "SetValues", `{"Visibility": 10}`},
+	} {
+		w := alpacaPut(router, req.path, url.Values{"Action": {req.action}, "Parameters": {req.params}})
+		assert.Equal(t, http.StatusBadRequest, w.Code, req.path+" "+req.params)
+	}
+}
+
+func TestDummyAPI_RequiresAdmin(t *testing.T) {
+	srv := newDummyTestServer(t)
+	srv.UseAuth(auth.New([]auth.User{{Name: "nina", Password: "secret", Role: auth.RoleRead}}, nil, nil))
+	router := srv.Router()
+	put := func(path string, form url.Values) *httptest.ResponseRecorder {
+		form.Set("ClientID", "1")
+		form.Set("ClientTransactionID", "1")
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("nina", "secret")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	put("/api/v1/safetymonitor/0/connected", url.Values{"Connected": {"true"}})
+	w := put("/api/v1/safetymonitor/0/action", url.Values{"Action": {"SetSafe"}, "Parameters": {"false"}})
+	assert.Contains(t, w.Body.String(), "SetSafe requires the admin role")
+	assert.Equal(t, true, srv.Barn.GetMonitor("rain").IsSafe(), "the state should not change")
+
+	w = put("/admin/dummy/monitors/rain", url.Values{})
+	assert.Equal(t, http.StatusForbidden, w.Code, "they should be equal")
+}
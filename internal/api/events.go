@@ -0,0 +1,362 @@
+package api
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+const (
+	// eventBuffer is the number of events queued for each subscriber. Slower
+	// subscribers are dropped and reconnect to a fresh snapshot.
+	eventBuffer = 64
+	// eventKeepAlive is how often idle streams are kept open
+	eventKeepAlive = 30 * time.Second
+)
+
+// Event types
+const (
+	EventMonitor    = "monitor"
+	EventWeather    = "weather"
+	EventConnection = "connection"
+)
+
+// streamEvent is a change sent to subscribers of the live state stream
+type streamEvent struct {
+	Type   string      `json:"event"`
+	Device string      `json:"-"`
+	Data   interface{} `json:"data"`
+}
+
+type monitorEvent struct {
+	Id          string     `json:"id"`
+	Name        string     `json:"name"`
+	Safe        bool       `json:"safe"`
+	Raw         string     `json:"raw"`
+	Error       string     `json:"error"`
+	Overridden  bool       `json:"overridden"`
+	LastRefresh *time.Time `json:"last_refresh"`
+}
+
+type weatherEvent struct {
+	Id          string             `json:"id"`
+	Name        string             `json:"name"`
+	Sensors     map[string]float64 `json:"sensors"`
+	Error       string             `json:"error"`
+	LastRefresh *time.Time         `json:"last_refresh"`
+}
+
+type connectionEvent struct {
+	DeviceType   string   `json:"device_type"`
+	DeviceNumber int      `json:"device_number"`
+	Id           string   `json:"id"`
+	Client       ClientId `json:"client"`
+	Connected    bool     `json:"connected"`
+}
+
+// eventSubscriber receives the events of the devices in its filter, or of
+// every device without one
+type eventSubscriber struct {
+	devices map[string]bool
+	events  chan streamEvent
+}
+
+func (s *eventSubscriber) wants(device string) bool {
+	return len(s.devices) == 0 || s.devices[device]
+}
+
+// eventHub fans device changes out to stream subscribers. It remembers the
+// last state published for every device, so refreshes that change nothing
+// are not sent.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]bool
+	monitors    map[string]monitorEvent
+	weather     map[string]weatherEvent
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: make(map[*eventSubscriber]bool),
+		monitors:    make(map[string]monitorEvent),
+		weather:     make(map[string]weatherEvent),
+	}
+}
+
+func (h *eventHub) subscribe(devices []string) *eventSubscriber {
+	sub := &eventSubscriber{events: make(chan streamEvent, eventBuffer)}
+	if len(devices) > 0 {
+		sub.devices = make(map[string]bool, len(devices))
+		for _, device := range devices {
+			sub.devices[device] = true
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[sub] = true
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[sub] {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publish sends an event without waiting for subscribers
+func (h *eventHub) publish(event streamEvent) {
+	for sub := range h.subscribers {
+		if !sub.wants(event.Device) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (h *eventHub) publishMonitor(event monitorEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last, exists := h.monitors[event.Id]
+	h.monitors[event.Id] = event
+	last.LastRefresh = event.LastRefresh
+	if exists && last == event {
+		return
+	}
+	h.publish(streamEvent{Type: EventMonitor, Device: event.Id, Data: event})
+}
+
+func (h *eventHub) publishWeather(event weatherEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last, exists := h.weather[event.Id]
+	h.weather[event.Id] = event
+	if exists && last.Error == event.Error && last.Name == event.Name && maps.Equal(last.Sensors, event.Sensors) {
+		return
+	}
+	h.publish(streamEvent{Type: EventWeather, Device: event.Id, Data: event})
+}
+
+func (h *eventHub) publishConnection(event connectionEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.publish(streamEvent{Type: EventConnection, Device: event.Id, Data: event})
+}
+
+// monitorEvent describes the state of monitor id as clients see it
+func (srv *ApiServer) monitorEvent(id string) (monitorEvent, bool) {
+	m := srv.Barn.GetMonitor(id)
+	if m == nil {
+		return monitorEvent{}, false
+	}
+	_, overridden := m.(*override.Monitor)
+	return monitorEvent{
+		Id:          id,
+		Name:        m.GetName(),
+		Safe:        m.IsSafe(),
+		Raw:         m.GetRawValue(),
+		Error:       srv.monitorError(id),
+		Overridden:  overridden,
+		LastRefresh: optionalTime(m.GetTimeStamp()),
+	}, true
+}
+
+func (srv *ApiServer) weatherEvent(id string) (weatherEvent, bool) {
+	w := srv.Barn.GetWeather(id)
+	if w == nil {
+		return weatherEvent{}, false
+	}
+	return weatherEvent{
+		Id:          id,
+		Name:        w.GetName(),
+		Sensors:     weather.StationSensors(w),
+		Error:       srv.weatherError(id),
+		LastRefresh: optionalTime(w.GetTimeStamp()),
+	}, true
+}
+
+// publishMonitors sends the monitors that changed outside of refreshes, such
+// as by overrides or pushes
+func (srv *ApiServer) publishMonitors() {
+	for _, id := range srv.Barn.GetMonitorIds() {
+		if event, ok := srv.monitorEvent(id); ok {
+			srv.events.publishMonitor(event)
+		}
+	}
+}
+
+func (srv *ApiServer) publishWeather(id string) {
+	if event, ok := srv.weatherEvent(id); ok {
+		srv.events.publishWeather(event)
+	}
+}
+
+// clientChanged implements the connection hook of devices
+func (srv *ApiServer) clientChanged(d *Device, client ClientId, connected bool) {
+	srv.events.publishConnection(connectionEvent{
+		DeviceType:   d.Type,
+		DeviceNumber: d.Index,
+		Id:           d.Id,
+		Client:       client,
+		Connected:    connected,
+	})
+}
+
+// snapshot describes the current state of the devices a new subscriber
+// wants, so it does not wait for the next change
+func (srv *ApiServer) snapshot(sub *eventSubscriber) []streamEvent {
+	var events []streamEvent
+	for _, id := range srv.Barn.GetMonitorIds() {
+		if event, ok := srv.monitorEvent(id); ok && sub.wants(id) {
+			events = append(events, streamEvent{Type: EventMonitor, Device: id, Data: event})
+		}
+	}
+	for _, id := range srv.Barn.GetWeatherIds() {
+		if event, ok := srv.weatherEvent(id); ok && sub.wants(id) {
+			events = append(events, streamEvent{Type: EventWeather, Device: id, Data: event})
+		}
+	}
+	for _, deviceType := range []string{"safetymonitor", "observingconditions", "dome", "switch"} {
+		for i := 0; i < len(srv.Devices[deviceType]); i++ {
+			d := srv.Devices[deviceType][i]
+			if !sub.wants(d.Id) {
+				continue
+			}
+			for _, client := range d.ConnectedClientIds() {
+				events = append(events, streamEvent{Type: EventConnection, Device: d.Id, Data: connectionEvent{
+					DeviceType:   d.Type,
+					DeviceNumber: d.Index,
+					Id:           d.Id,
+					Client:       client,
+					Connected:    true,
+				}})
+			}
+		}
+	}
+	return events
+}
+
+// EventsAPI streams device changes as Server-Sent Events or over a WebSocket
+type EventsAPI struct {
+	*ApiServer
+	upgrader websocket.Upgrader
+}
+
+// NewEventsAPI creates a new event stream handler
+func NewEventsAPI(apiServer *ApiServer) *EventsAPI {
+	return &EventsAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up the event stream routes
+func (e *EventsAPI) ConfigureRoutes(router *gin.Engine) {
+	router.GET("/events", e.handleStream)
+	router.GET("/events/ws", e.handleWebSocket)
+}
+
+// eventFilter reads the device ids of the device query parameter, repeated
+// or separated by commas
+func eventFilter(c *gin.Context) []string {
+	var devices []string
+	for _, device := range strings.Split(getQuery(c, "device"), ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+func (e *EventsAPI) handleStream(c *gin.Context) {
+	sub := e.events.subscribe(eventFilter(c))
+	defer e.events.unsubscribe(sub)
+	c.Header("Cache-Control", "no-cache")
+	// Keeps reverse proxies such as nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	for _, event := range e.snapshot(sub) {
+		c.SSEvent(event.Type, event.Data)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, open := <-sub.events:
+			if !open {
+				return false
+			}
+			c.SSEvent(event.Type, event.Data)
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
+
+func (e *EventsAPI) handleWebSocket(c *gin.Context) {
+	conn, err := e.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has answered the request
+		return
+	}
+	defer conn.Close()
+	sub := e.events.subscribe(eventFilter(c))
+	defer e.events.unsubscribe(sub)
+
+	// Messages from the client are not used, but reading notices when it
+	// goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, event := range e.snapshot(sub) {
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+	}
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, open := <-sub.events:
+			if !open {
+				return
+			}
+			err = conn.WriteJSON(event)
+		case <-keepAlive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case <-closed:
+			return
+		}
+		if err != nil {
+			log.WithError(err).Debug("[BARN] Events. WebSocket closed")
+			return
+		}
+	}
+}
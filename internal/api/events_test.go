@@ -0,0 +1,146 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+func newEventsTestServer(t *testing.T) (*ApiServer, *httptest.Server) {
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("rain", "Rain", "", true))
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("roof", "Roof", "", true))
+	barn.AddWeather(weather.NewObservingConditionsDummy("station", "Station", ""))
+	srv := NewApiServer(barn, 0)
+	server := httptest.NewServer(srv.Router())
+	t.Cleanup(server.Close)
+	return srv, server
+}
+
+// sseReader reads the events of a stream as event type and data
+type sseReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *sseReader) next(t *testing.T) (string, map[string]interface{}) {
+	var event string
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			var data map[string]interface{}
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &data), "should be valid json")
+			return event, data
+		}
+	}
+	t.Fatal("stream ended")
+	return "", nil
+}
+
+func openStream(t *testing.T, server *httptest.Server, query string) *sseReader {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events"+query, nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "should work")
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/event-stream")
+	return &sseReader{scanner: bufio.NewScanner(resp.Body)}
+}
+
+func TestEventsAPI_Stream(t *testing.T) {
+	srv, server := newEventsTestServer(t)
+	stream := openStream(t, server, "?device=rain&device=station")
+
+	event, data := stream.next(t)
+	assert.Equal(t, EventMonitor, event, "streams should start with a snapshot")
+	assert.Equal(t, "rain", data["id"], "they should be equal")
+	assert.Equal(t, true, data["safe"], "they should be equal")
+	event, data = stream.next(t)
+	assert.Equal(t, EventWeather, event, "they should be equal")
+	assert.Equal(t, "station", data["id"], "they should be equal")
+
+	srv.Barn.GetMonitor("roof").(*monitor.SafetyMonitorDummy).SetSafe(false)
+	srv.Barn.GetMonitor("rain").(*monitor.SafetyMonitorDummy).SetSafe(false)
+	srv.publishMonitors()
+	event, data = stream.next(t)
+	assert.Equal(t, EventMonitor, event, "they should be equal")
+	assert.Equal(t, "rain", data["id"], "other devices should be filtered out")
+	assert.Equal(t, false, data["safe"], "they should be equal")
+
+	form := url.Values{"Connected": {"true"}, "ClientID": {"7"}, "ClientTransactionID": {"1"}}
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/observingconditions/0/connected", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err, "should work")
+	resp.Body.Close()
+	event, data = stream.next(t)
+	assert.Equal(t, EventConnection, event, "they should be equal")
+	assert.Equal(t, "observingconditions", data["device_type"], "they should be equal")
+	assert.Equal(t, true, data["connected"], "they should be equal")
+
+	assert.NoError(t, srv.Barn.GetWeather("station").(*weather.ObservingConditionsDummy).SetValues(map[string]float64{"RainRate": 1.5}), "should work")
+	srv.WeatherRefreshed(app.WeatherRefresh{Weather: srv.Barn.GetWeather("station")})
+	event, data = stream.next(t)
+	assert.Equal(t, EventWeather, event, "they should be equal")
+	assert.Equal(t, 1.5, data["sensors"].(map[string]interface{})["RainRate"], "they should be equal")
+}
+
+func TestEventsAPI_WebSocket(t *testing.T) {
+	srv, server := newEventsTestServer(t)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/events/ws?device=roof", nil)
+	assert.NoError(t, err, "should work")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var event struct {
+		Event string                 `json:"event"`
+		Data  map[string]interface{} `json:"data"`
+	}
+	assert.NoError(t, conn.ReadJSON(&event), "should work")
+	assert.Equal(t, EventMonitor, event.Event, "they should be equal")
+	assert.Equal(t, "roof", event.Data["id"], "they should be equal")
+
+	srv.Barn.GetMonitor("roof").(*monitor.SafetyMonitorDummy).SetSafe(false)
+	srv.publishMonitors()
+	assert.NoError(t, conn.ReadJSON(&event), "should work")
+	assert.Equal(t, false, event.Data["safe"], "they should be equal")
+}
+
+func TestEventHub(t *testing.T) {
+	hub := newEventHub()
+	all := hub.subscribe(nil)
+	rain := hub.subscribe([]string{"rain"})
+
+	hub.publishMonitor(monitorEvent{Id: "rain", Safe: true})
+	hub.publishMonitor(monitorEvent{Id: "rain", Safe: true, LastRefresh: optionalTime(time.Now())})
+	hub.publishWeather(weatherEvent{Id: "station", Sensors: map[string]float64{"Temperature": 4}})
+	hub.publishWeather(weatherEvent{Id: "station", Sensors: map[string]float64{"Temperature": 4}})
+	assert.Equal(t, 2, len(all.events), "unchanged states should not be sent again")
+	assert.Equal(t, 1, len(rain.events), "filtered subscribers should only get their devices")
+
+	for i := 0; i <= eventBuffer; i++ {
+		hub.publishConnection(connectionEvent{Id: "rain", Client: "client", Connected: i%2 == 0})
+	}
+	_, subscribed := hub.subscribers[rain]
+	assert.False(t, subscribed, "slow subscribers should be dropped")
+	for range rain.events {
+	}
+	hub.unsubscribe(rain)
+	hub.unsubscribe(all)
+	assert.Empty(t, hub.subscribers, "should be empty")
+}
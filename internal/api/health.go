@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/health"
+)
+
+// handleHealthAction answers the Health action with the refresh status of a
+// device as JSON
+func (srv *ApiServer) handleHealthAction(c *gin.Context, status health.Status) {
+	value, err := json.Marshal(status)
+	if err != nil {
+		c.String(500, "Failed to encode health")
+		return
+	}
+	resp := stringResponse{
+		Value: string(value),
+	}
+	srv.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// healthDeviceState lists the refresh status of a device as extra device
+// state
+func healthDeviceState(status health.Status) []DeviceState {
+	return []DeviceState{
+		{Name: "LastError", Value: status.LastError},
+		{Name: "LastErrorCause", Value: status.LastErrorCause},
+		{Name: "ErrorCount", Value: status.ErrorCount},
+		{Name: "ConsecutiveFailures", Value: status.ConsecutiveFailures},
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/history"
+)
+
+// defaultHistoryWindow is queried when no time range is given
+const defaultHistoryWindow = 24 * time.Hour
+
+// HistoryAPI serves recorded monitor transitions and weather samples
+type HistoryAPI struct {
+	*ApiServer
+}
+
+// NewHistoryAPI creates a new history API handler
+func NewHistoryAPI(apiServer *ApiServer) *HistoryAPI {
+	return &HistoryAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// UseHistory enables the history query API backed by store
+func (srv *ApiServer) UseHistory(store *history.Store) {
+	srv.history = store
+}
+
+// ConfigureRoutes sets up the history query routes
+func (h *HistoryAPI) ConfigureRoutes(router *gin.Engine) {
+	group := router.Group("/history")
+	{
+		group.GET("/monitors/:id", h.handleQuery(history.KindMonitor))
+		group.GET("/weather/:id", h.handleQuery(history.KindWeather))
+	}
+}
+
+// handleQuery returns the history of one device. Query parameters:
+// from and to (RFC3339 or unix seconds), interval (Go duration) and
+// format (json or csv).
+func (h *HistoryAPI) handleQuery(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if !h.deviceExists(kind, id) {
+			c.String(http.StatusNotFound, "Device not found")
+			return
+		}
+
+		to := time.Now()
+		if v := getQuery(c, "to"); v != "" {
+			t, err := parseHistoryTime(v)
+			if err != nil {
+				c.String(http.StatusBadRequest, "Invalid to parameter")
+				return
+			}
+			to = t
+		}
+		from := to.Add(-defaultHistoryWindow)
+		if v := getQuery(c, "from"); v != "" {
+			t, err := parseHistoryTime(v)
+			if err != nil {
+				c.String(http.StatusBadRequest, "Invalid from parameter")
+				return
+			}
+			from = t
+		}
+		var interval time.Duration
+		if v := getQuery(c, "interval"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				c.String(http.StatusBadRequest, "Invalid interval parameter")
+				return
+			}
+			interval = d
+		}
+
+		records, err := h.history.Query(kind, id, from, to, interval)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if records == nil {
+			records = []history.Record{}
+		}
+
+		switch getQuery(c, "format") {
+		case "", "json":
+			c.IndentedJSON(http.StatusOK, records)
+		case "csv":
+			writeHistoryCSV(c, kind, records)
+		default:
+			c.String(http.StatusBadRequest, "Invalid format parameter")
+		}
+	}
+}
+
+func (h *HistoryAPI) deviceExists(kind string, id string) bool {
+	if kind == history.KindMonitor {
+		return h.Barn.GetMonitor(id) != nil
+	}
+	return h.Barn.GetWeather(id) != nil
+}
+
+func parseHistoryTime(v string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func writeHistoryCSV(c *gin.Context, kind string, records []history.Record) {
+	c.Header("Content-Type", "text/csv")
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	defer w.Flush()
+
+	if kind == history.KindMonitor {
+		w.Write([]string{"time", "safe", "raw"})
+		for _, r := range records {
+			safe := ""
+			if r.Safe != nil {
+				safe = strconv.FormatBool(*r.Safe)
+			}
+			w.Write([]string{r.Time.Format(time.RFC3339), safe, r.Raw})
+		}
+		return
+	}
+
+	sensorSet := make(map[string]bool)
+	for _, r := range records {
+		for sensor := range r.Values {
+			sensorSet[sensor] = true
+		}
+	}
+	sensors := make([]string, 0, len(sensorSet))
+	for sensor := range sensorSet {
+		sensors = append(sensors, sensor)
+	}
+	sort.Strings(sensors)
+
+	w.Write(append([]string{"time"}, sensors...))
+	for _, r := range records {
+		row := []string{r.Time.Format(time.RFC3339)}
+		for _, sensor := range sensors {
+			value, exists := r.Values[sensor]
+			if !exists {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+		w.Write(row)
+	}
+}
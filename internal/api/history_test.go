@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/history"
+)
+
+func newHistoryTestServer(t *testing.T) (*ApiServer, *history.Store) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"), time.Hour)
+	assert.NoError(t, err, "should work")
+	t.Cleanup(func() { store.Close() })
+	srv := NewApiServer(newTestBarn(), 0)
+	srv.UseHistory(store)
+	return srv, store
+}
+
+func TestHistoryAPI_MonitorJSON(t *testing.T) {
+	srv, store := newHistoryTestServer(t)
+	safe := false
+	assert.NoError(t, store.Append(history.Record{Time: time.Now().Add(-time.Minute), Kind: history.KindMonitor, Device: "safe", Safe: &safe, Raw: "0"}))
+
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history/monitors/safe", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var records []history.Record
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &records), "should be valid json")
+	assert.Len(t, records, 1, "they should be equal")
+	assert.Equal(t, "0", records[0].Raw, "they should be equal")
+}
+
+func TestHistoryAPI_WeatherCSV(t *testing.T) {
+	srv, store := newHistoryTestServer(t)
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	assert.NoError(t, store.Append(history.Record{Time: at, Kind: history.KindWeather, Device: "station",
+		Values: map[string]float64{"Temperature": 12.5, "Humidity": 80}}))
+
+	w := httptest.NewRecorder()
+	srv.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/history/weather/station?format=csv&interval=1m", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, "time,Humidity,Temperature", lines[0], "they should be equal")
+	assert.Len(t, lines, 2, "they should be equal")
+	assert.True(t, strings.HasSuffix(lines[1], ",80,12.5"), "row should contain the averaged values")
+}
+
+func TestHistoryAPI_InvalidRequests(t *testing.T) {
+	srv, _ := newHistoryTestServer(t)
+	router := srv.Router()
+	for path, code := range map[string]int{
+		"/history/monitors/unknown":             http.StatusNotFound,
+		"/history/weather/safe":                 http.StatusNotFound,
+		"/history/monitors/safe?from=yesterday": http.StatusBadRequest,
+		"/history/monitors/safe?interval=-1m":   http.StatusBadRequest,
+		"/history/monitors/safe?format=xml":     http.StatusBadRequest,
+		"/history/monitors/safe?from=0":         http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, code, w.Code, path)
+	}
+}
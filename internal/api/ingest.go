@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// maxPushSize limits the payloads pushed to devices
+const maxPushSize = 64 * 1024
+
+// IngestAPI serves the endpoints that scripts push results to, for push
+// monitors and weather stations
+type IngestAPI struct {
+	*ApiServer
+}
+
+// NewIngestAPI creates a new ingest API handler
+func NewIngestAPI(apiServer *ApiServer) *IngestAPI {
+	return &IngestAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up the ingest routes
+func (i *IngestAPI) ConfigureRoutes(router *gin.Engine) {
+	group := router.Group("/ingest")
+	{
+		group.PUT("/monitor/:id", i.handlePushMonitor)
+		group.POST("/monitor/:id", i.handlePushMonitor)
+		group.PUT("/weather/:id", i.handlePushWeather)
+		group.POST("/weather/:id", i.handlePushWeather)
+	}
+}
+
+func (i *IngestAPI) handlePushMonitor(c *gin.Context) {
+	device, ok := unwrapOverride(i.Barn.GetMonitor(c.Param("id"))).(*monitor.SafetyMonitorPush)
+	if !ok {
+		c.String(http.StatusNotFound, "Push monitor not found")
+		return
+	}
+	payload, ok := readPush(c)
+	if !ok {
+		return
+	}
+	// Refresh through barn, so health, history, notifications and actions see
+	// the push at once
+	device.Receive(payload)
+	err := i.Barn.RefreshMonitor(device.GetId())
+	i.publishMonitors()
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"id": device.GetId(), "safe": device.IsSafe(), "value": device.GetRawValue()})
+}
+
+func (i *IngestAPI) handlePushWeather(c *gin.Context) {
+	device, ok := i.Barn.GetWeather(c.Param("id")).(*weather.ObservingConditionsPush)
+	if !ok {
+		c.String(http.StatusNotFound, "Push weather station not found")
+		return
+	}
+	payload, ok := readPush(c)
+	if !ok {
+		return
+	}
+	device.Receive(payload)
+	err := i.Barn.RefreshWeather(device.GetId())
+	i.publishWeather(device.GetId())
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.IndentedJSON(http.StatusOK, device.GetCondition())
+}
+
+// readPush reads the pushed payload, plain text or JSON, without surrounding
+// white space. It answers the request itself when the body is unusable.
+func readPush(c *gin.Context) (string, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPushSize))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.String(http.StatusRequestEntityTooLarge, "Payload too large")
+		return "", false
+	case err != nil:
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return "", false
+	}
+	payload := strings.TrimSpace(string(body))
+	if payload == "" {
+		c.String(http.StatusBadRequest, "Empty payload")
+		return "", false
+	}
+	return payload, true
+}
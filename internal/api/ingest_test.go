@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/auth"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+func newIngestTestServer(t *testing.T, listeners ...app.Listener) *ApiServer {
+	barn := app.New()
+	for _, l := range listeners {
+		barn.AddListener(l)
+	}
+	sm, err := monitor.NewSafetyMonitorPush("rain", "Rain", "", time.Minute, monitor.JSONValue("rain"), monitor.NewSafetyMatchingRule(false, "^false$"))
+	assert.NoError(t, err, "should work")
+	barn.AddMonitor(sm)
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("dummy", "Dummy", "", true))
+	parser, err := weather.NewValuesParser([]string{"Temperature"})
+	assert.NoError(t, err, "should work")
+	station, err := weather.NewObservingConditionsPush("station", "Station", "", time.Minute, parser)
+	assert.NoError(t, err, "should work")
+	barn.AddWeather(station)
+	return NewApiServer(barn, 0)
+}
+
+func TestIngestAPI(t *testing.T) {
+	srv := newIngestTestServer(t)
+	router := srv.Router()
+	push := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := push(http.MethodPut, "/ingest/monitor/rain", `{"rain": false}`)
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Contains(t, w.Body.String(), `"safe": true`)
+	assert.True(t, srv.Barn.GetMonitor("rain").IsSafe(), "pushes should update the monitor")
+
+	w = push(http.MethodPost, "/ingest/weather/station", "{\"Temperature\": 7.5}\n")
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var condition weather.WeatherCondition
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &condition), "should be valid json")
+	assert.Equal(t, 7.5, condition.Temperature, "they should be equal")
+
+	for _, req := range []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/ingest/monitor/dummy", `{"rain": false}`, http.StatusNotFound},
+		{"/ingest/monitor/missing", `{"rain": false}`, http.StatusNotFound},
+		{"/ingest/weather/missing", `{"Temperature": 1}`, http.StatusNotFound},
+		{"/ingest/monitor/rain", ``, http.StatusBadRequest},
+		{"/ingest/monitor/rain", `raining`, http.StatusBadRequest},
+		{"/ingest/weather/station", `raining`, http.StatusBadRequest},
+		{"/ingest/weather/station", strings.Repeat(" ", maxPushSize+1), http.StatusRequestEntityTooLarge},
+	} {
+		assert.Equal(t, req.code, push(http.MethodPut, req.path, req.body).Code, req.path+" "+req.body)
+	}
+	assert.False(t, srv.Barn.GetMonitor("rain").IsSafe(), "unusable pushes should make the monitor unsafe")
+	assert.True(t, srv.Barn.GetMonitorHealth("rain").Failing(), "failed pushes should count as failed refreshes")
+	assert.True(t, srv.Barn.GetWeatherHealth("station").Failing(), "failed pushes should count as failed refreshes")
+	assert.Equal(t, 1, srv.Barn.GetMonitorHealth("rain").ErrorCount, "successful pushes should not be errors")
+}
+
+// refreshCounter counts the refreshes listeners are notified of
+type refreshCounter struct {
+	mu       sync.Mutex
+	monitors int
+	weather  int
+}
+
+func (r *refreshCounter) MonitorRefreshed(refresh app.MonitorRefresh) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.monitors++
+}
+
+func (r *refreshCounter) WeatherRefreshed(refresh app.WeatherRefresh) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.weather++
+}
+
+func TestIngestAPI_NotifiesListeners(t *testing.T) {
+	counter := &refreshCounter{}
+	srv := newIngestTestServer(t, counter)
+	router := srv.Router()
+	for path, body := range map[string]string{
+		"/ingest/monitor/rain":    `{"rain": true}`,
+		"/ingest/weather/station": `{"Temperature": 3}`,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+	assert.Equal(t, 1, counter.monitors, "pushes should reach listeners at once")
+	assert.Equal(t, 1, counter.weather, "pushes should reach listeners at once")
+}
+
+func TestIngestAPI_RequiresAdmin(t *testing.T) {
+	srv := newIngestTestServer(t)
+	srv.UseAuth(auth.New(nil, []auth.Token{
+		{Name: "viewer", Token: "viewer-token-0123456789", Role: auth.RoleRead},
+		{Name: "script", Token: "script-token-0123456789", Role: auth.RoleAdmin},
+	}, nil))
+	router := srv.Router()
+	push := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/ingest/monitor/rain", strings.NewReader(`{"rain": false}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, push("viewer-token-0123456789"), "they should be equal")
+	assert.Equal(t, http.StatusOK, push("script-token-0123456789"), "they should be equal")
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/metrics"
+)
+
+// UseMetrics records request metrics for every route. When serve is set the
+// metrics are also exposed on /metrics of the API port.
+func (srv *ApiServer) UseMetrics(m *metrics.Metrics, serve bool) {
+	srv.metrics = m
+	srv.serveMetrics = serve
+	m.SetClientSource(srv.connectedClients)
+}
+
+// MetricsRouter builds the gin engine serving /metrics on a port of its
+// own, with the same authentication as the API port
+func (srv *ApiServer) MetricsRouter() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.Default()
+	if srv.auth != nil {
+		_ = router.SetTrustedProxies(srv.auth.TrustedProxies)
+		router.Use(authMiddleware(srv.auth))
+	}
+	router.GET("/metrics", gin.WrapH(srv.metrics.Handler()))
+	return router
+}
+
+// StartMetrics serves the metrics router, using TLS like the API port
+func (srv *ApiServer) StartMetrics(port uint32) {
+	router := srv.MetricsRouter()
+	srv.listen(port, func(ln net.Listener) error {
+		return srv.serve(ln, router)
+	})
+}
+
+// connectedClients counts the connected clients of every device
+func (srv *ApiServer) connectedClients() []metrics.DeviceClients {
+	var counts []metrics.DeviceClients
+	for deviceType, devices := range srv.Devices {
+		for number, device := range devices {
+			counts = append(counts, metrics.DeviceClients{
+				DeviceType:   deviceType,
+				DeviceNumber: number,
+				DeviceId:     device.Id,
+				Count:        device.ConnectedClientCount(),
+			})
+		}
+	}
+	return counts
+}
+
+// metricsMiddleware observes request latency by route. The Alpaca error
+// number is taken from the response prepared by the handler, if any.
+func metricsMiddleware(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		errorNumber := ""
+		if v, exists := c.Get("alpacaResponse"); exists {
+			if resp, ok := v.(*alpacaResponse); ok {
+				errorNumber = strconv.Itoa(int(resp.ErrorNumber))
+			}
+		}
+		m.ObserveRequest(c.Request.Method, endpoint, c.Writer.Status(), errorNumber, time.Since(start))
+	}
+}
@@ -137,9 +137,9 @@ func alpacaResponseMiddleware() gin.HandlerFunc {
 			// Get API server from context
 			if apiServer, exists := c.Get("apiServer"); exists {
 				if server, ok := apiServer.(*ApiServer); ok {
-					server.ServerTransactionID += 1
 					resp.ClientTransactionID = uint32(ctid)
-					resp.ServerTransactionID = server.ServerTransactionID
+					resp.ServerTransactionID = server.ServerTransactionID.Add(1)
+					c.Set("alpacaResponse", resp)
 				}
 			}
 		})
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// openAPISpec describes the REST routes in OpenAPI 3. Schemas are generated
+// from the response types, so the description follows the code.
+func openAPISpec(routes []restRoute, version string) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	errorSchema := schemaOf(reflect.TypeOf(restError{}), schemas)
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		responses := map[string]interface{}{
+			"200": jsonResponse("OK", schemaOf(reflect.TypeOf(route.response), schemas)),
+		}
+		operation := map[string]interface{}{
+			"operationId": route.operation,
+			"summary":     route.summary,
+			"responses":   responses,
+		}
+		var parameters []interface{}
+		var path []string
+		for _, segment := range strings.Split(route.path, "/") {
+			if name, isParam := strings.CutPrefix(segment, ":"); isParam {
+				parameters = append(parameters, map[string]interface{}{
+					"name":     name,
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+				segment = "{" + name + "}"
+			}
+			path = append(path, segment)
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+			responses["404"] = jsonResponse("Not found", errorSchema)
+		}
+		paths[strings.Join(path, "/")] = map[string]interface{}{"get": operation}
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Alpaca Barn REST API",
+			"description": "Read-only state of the safety monitors and weather stations",
+			"version":     version,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+func jsonResponse(description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of a type. Structs are added to schemas once
+// and referenced.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaOf(t.Elem(), schemas)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		name := schemaName(t)
+		if _, exists := schemas[name]; !exists {
+			// Set first, so types referring to themselves end
+			schemas[name] = nil
+			properties := make(map[string]interface{})
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				key := strings.Split(field.Tag.Get("json"), ",")[0]
+				if !field.IsExported() || key == "-" {
+					continue
+				}
+				if key == "" {
+					key = field.Name
+				}
+				properties[key] = schemaOf(field.Type, schemas)
+			}
+			schemas[name] = map[string]interface{}{"type": "object", "properties": properties}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+var apiPackage = reflect.TypeOf(restError{}).PkgPath()
+
+// schemaName names the schema of a struct after its type, without the rest
+// prefix, e.g. Monitor for restMonitor. Types of other packages are prefixed
+// with the package, unless their name starts with it, e.g. HealthStatus for
+// health.Status but Override for override.Override.
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "rest")
+	if t.PkgPath() != apiPackage {
+		if pkg := path.Base(t.PkgPath()); !strings.HasPrefix(strings.ToLower(name), pkg) {
+			name = pkg + name
+		}
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
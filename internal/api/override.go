@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/override"
+)
+
+// overrideRequest sets or clears an override. It is the body of the admin
+// endpoint and the parameters of the Alpaca Override action.
+type overrideRequest struct {
+	// State is safe, unsafe or clear
+	State string `json:"state"`
+	// Duration (e.g. 2h) or Expires (RFC3339) sets the expiry
+	Duration string `json:"duration"`
+	Expires  string `json:"expires"`
+	Reason   string `json:"reason"`
+	SetBy    string `json:"set_by"`
+}
+
+// apply sets or clears the override of monitor id. by identifies the caller
+// when the request does not name anyone.
+func (r overrideRequest) apply(overrides *override.Manager, id string, by string) (*override.Override, error) {
+	if r.SetBy != "" {
+		by = r.SetBy
+	}
+	state := strings.ToLower(r.State)
+	if state == "clear" {
+		return nil, overrides.Clear(id, by)
+	}
+	if state != "safe" && state != "unsafe" {
+		return nil, fmt.Errorf("state must be safe, unsafe or clear")
+	}
+
+	o := override.Override{
+		Monitor: id,
+		Safe:    state == "safe",
+		Reason:  r.Reason,
+		SetBy:   by,
+		SetAt:   time.Now(),
+	}
+	switch {
+	case r.Duration != "":
+		d, err := time.ParseDuration(r.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+		o.Expires = o.SetAt.Add(d)
+	case r.Expires != "":
+		t, err := time.Parse(time.RFC3339, r.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires: %w", err)
+		}
+		o.Expires = t
+	default:
+		return nil, override.ErrMissingExpiry
+	}
+	if err := overrides.Set(o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// UseOverrides enables the override admin endpoint and Alpaca action
+func (srv *ApiServer) UseOverrides(overrides *override.Manager) {
+	srv.overrides = overrides
+}
+
+// OverrideAPI serves the admin endpoint for manual safety monitor overrides
+type OverrideAPI struct {
+	*ApiServer
+}
+
+// NewOverrideAPI creates a new override API handler
+func NewOverrideAPI(apiServer *ApiServer) *OverrideAPI {
+	return &OverrideAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up the override admin routes
+func (o *OverrideAPI) ConfigureRoutes(router *gin.Engine) {
+	group := router.Group("/admin/overrides")
+	{
+		group.GET("", o.handleList)
+		group.PUT("/:id", o.handleSet)
+		group.DELETE("/:id", o.handleClear)
+	}
+}
+
+func (o *OverrideAPI) handleList(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, o.overrides.List())
+}
+
+func (o *OverrideAPI) handleSet(c *gin.Context) {
+	id := c.Param("id")
+	if o.Barn.GetMonitor(id) == nil {
+		c.String(http.StatusNotFound, "Monitor not found")
+		return
+	}
+	var req overrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if strings.EqualFold(req.State, "clear") {
+		c.String(http.StatusBadRequest, "Use DELETE to clear an override")
+		return
+	}
+	if _, ok := requestPrincipal(c); ok {
+		// Authenticated callers are recorded as themselves
+		req.SetBy = ""
+	}
+	set, err := req.apply(o.overrides, id, requestIdentity(c))
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	o.publishMonitors()
+	c.IndentedJSON(http.StatusOK, set)
+}
+
+func (o *OverrideAPI) handleClear(c *gin.Context) {
+	id := c.Param("id")
+	by := getQuery(c, "by")
+	if _, ok := requestPrincipal(c); ok || by == "" {
+		by = requestIdentity(c)
+	}
+	err := o.overrides.Clear(id, by)
+	if errors.Is(err, override.ErrNotFound) {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	o.publishMonitors()
+	c.Status(http.StatusNoContent)
+}
+
+// requestIdentity names the caller of an admin request
+func requestIdentity(c *gin.Context) string {
+	if principal, ok := requestPrincipal(c); ok {
+		return principal.Name
+	}
+	return c.ClientIP()
+}
+
+// handleOverrideAction runs the Alpaca Override action. Parameters is an
+// overrideRequest in JSON.
+func (sm *SafetyMonitorAPI) handleOverrideAction(c *gin.Context, device monitor.SafetyMonitor) {
+	var req overrideRequest
+	if err := json.Unmarshal([]byte(c.PostForm("Parameters")), &req); err != nil {
+		c.String(400, "Invalid Override parameters, expected JSON such as {\"state\": \"unsafe\", \"duration\": \"2h\", \"reason\": \"cleaning\"}")
+		return
+	}
+	if !sm.requireAdmin(c, "Overrides require the admin role") {
+		return
+	}
+	by := "alpaca:" + string(getFullClientId(c))
+	if principal, ok := requestPrincipal(c); ok {
+		by = principal.Name + " (" + by + ")"
+		req.SetBy = ""
+	}
+	set, err := req.apply(sm.overrides, device.GetId(), by)
+	if err != nil && !errors.Is(err, override.ErrNotFound) {
+		c.String(400, err.Error())
+		return
+	}
+	sm.publishMonitors()
+	value := "cleared"
+	if set != nil {
+		value = set.String()
+	}
+	resp := stringResponse{
+		Value: value,
+	}
+	sm.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// overrideDeviceState describes the override of a monitor in its devicestate
+func overrideDeviceState(device monitor.SafetyMonitor) []DeviceState {
+	om, active := device.(*override.Monitor)
+	if !active {
+		return []DeviceState{{Name: "Override", Value: false}}
+	}
+	return []DeviceState{
+		{Name: "Override", Value: true},
+		{Name: "OverrideSafe", Value: om.Override.Safe},
+		{Name: "OverrideReason", Value: om.Override.Reason},
+		{Name: "OverrideSetBy", Value: om.Override.SetBy},
+		{Name: "OverrideExpires", Value: om.Override.Expires},
+	}
+}
+
+// unwrapOverride returns the device behind a monitor while it is overridden
+func unwrapOverride(device monitor.SafetyMonitor) monitor.SafetyMonitor {
+	if om, overridden := device.(*override.Monitor); overridden {
+		return om.SafetyMonitor
+	}
+	return device
+}
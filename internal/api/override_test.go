@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/override"
+)
+
+func newOverrideTestServer(t *testing.T) (*ApiServer, http.Handler) {
+	overrides, err := override.Open("")
+	assert.NoError(t, err, "should work")
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("safe", "Safe", "Always safe", true))
+	barn.UseOverrides(overrides)
+	srv := NewApiServer(barn, 0)
+	srv.UseOverrides(overrides)
+	return srv, srv.Router()
+}
+
+func alpacaPut(router http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	form.Set("ClientID", "1")
+	form.Set("ClientTransactionID", "1")
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func alpacaGet(router http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?ClientID=1&ClientTransactionID=1", nil))
+	return w
+}
+
+func TestOverrideAPI_Admin(t *testing.T) {
+	srv, router := newOverrideTestServer(t)
+
+	w := httptest.NewRecorder()
+	body := `{"state": "unsafe", "duration": "2h", "reason": "cleaning", "set_by": "alice"}`
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/overrides/safe", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, false, srv.Barn.GetMonitor("safe").IsSafe(), "they should be equal")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/overrides", nil))
+	var list []override.Override
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list), "should be valid json")
+	assert.Len(t, list, 1, "they should be equal")
+	assert.Equal(t, "alice", list[0].SetBy, "they should be equal")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/overrides/safe", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code, "they should be equal")
+	assert.Equal(t, true, srv.Barn.GetMonitor("safe").IsSafe(), "they should be equal")
+
+	for _, req := range []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{http.MethodPut, "/admin/overrides/missing", `{"state": "safe", "duration": "1h"}`, http.StatusNotFound},
+		{http.MethodPut, "/admin/overrides/safe", `{"state": "safe"}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/overrides/safe", `{"state": "maybe", "duration": "1h"}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/overrides/safe", `{"state": "clear"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/overrides/safe", "", http.StatusNotFound},
+	} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+		assert.Equal(t, req.code, w.Code, req.method+" "+req.path+" "+req.body)
+	}
+}
+
+func TestOverrideAPI_AlpacaAction(t *testing.T) {
+	srv, router := newOverrideTestServer(t)
+	alpacaPut(router, "/api/v1/safetymonitor/0/connected", url.Values{"Connected": {"true"}})
+
+	w := alpacaGet(router, "/api/v1/safetymonitor/0/supportedactions")
+	assert.Contains(t, w.Body.String(), `"Override"`)
+
+	w = alpacaPut(router, "/api/v1/safetymonitor/0/action", url.Values{
+		"Action":     {"Override"},
+		"Parameters": {`{"State": "unsafe", "Duration": "30m", "Reason": "operator on site"}`},
+	})
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	var resp stringResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), "should be valid json")
+	assert.Contains(t, resp.Value, "forced unsafe by alpaca:192.0.2.1-1")
+
+	w = alpacaGet(router, "/api/v1/safetymonitor/0/issafe")
+	assert.Contains(t, w.Body.String(), `"Value": false`)
+
+	w = alpacaGet(router, "/api/v1/safetymonitor/0/devicestate")
+	assert.Contains(t, w.Body.String(), `"Name": "OverrideReason",`)
+	assert.Contains(t, w.Body.String(), `"Value": "operator on site"`)
+
+	w = alpacaPut(router, "/api/v1/safetymonitor/0/action", url.Values{"Action": {"RawValue"}})
+	assert.Contains(t, w.Body.String(), "OVERRIDE forced unsafe")
+
+	w = alpacaPut(router, "/api/v1/safetymonitor/0/action", url.Values{"Action": {"Override"}, "Parameters": {`{"State": "clear"}`}})
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.Equal(t, true, srv.Barn.GetMonitor("safe").IsSafe(), "they should be equal")
+
+	w = alpacaPut(router, "/api/v1/safetymonitor/0/action", url.Values{"Action": {"Override"}, "Parameters": {"unsafe"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/health"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+type restMonitor struct {
+	Id           string             `json:"id"`
+	Name         string             `json:"name"`
+	Description  string             `json:"description"`
+	DeviceNumber int                `json:"device_number"`
+	Safe         bool               `json:"safe"`
+	Raw          string             `json:"raw"`
+	LastRefresh  *time.Time         `json:"last_refresh"`
+	Error        string             `json:"error"`
+	Override     *override.Override `json:"override"`
+	Source       *restSource        `json:"source"`
+	Health       health.Status      `json:"health"`
+}
+
+type restWeather struct {
+	Id           string        `json:"id"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	DeviceNumber int           `json:"device_number"`
+	LastRefresh  *time.Time    `json:"last_refresh"`
+	Error        string        `json:"error"`
+	Sensors      []restSensor  `json:"sensors"`
+	Source       *restSource   `json:"source"`
+	Health       health.Status `json:"health"`
+}
+
+type restSensor struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Unit        string  `json:"unit"`
+	Value       float64 `json:"value"`
+	// Age is the number of seconds since the reading was taken
+	Age *float64 `json:"age"`
+}
+
+// restSource summarizes the configuration of a device. Devices not loaded
+// from the configuration have none.
+type restSource struct {
+	Type     string            `json:"type"`
+	Settings map[string]string `json:"settings"`
+}
+
+type restStatus struct {
+	// Status is ok, or degraded while any device fails to refresh
+	Status string `json:"status"`
+	// Safe is set while there are monitors and all of them are safe
+	Safe     bool              `json:"safe"`
+	Version  string            `json:"version"`
+	Time     time.Time         `json:"time"`
+	Monitors restMonitorCounts `json:"monitors"`
+	Weather  restWeatherCounts `json:"weather"`
+}
+
+type restMonitorCounts struct {
+	Total      int `json:"total"`
+	Unsafe     int `json:"unsafe"`
+	Failing    int `json:"failing"`
+	Overridden int `json:"overridden"`
+}
+
+type restWeatherCounts struct {
+	Total   int `json:"total"`
+	Failing int `json:"failing"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+// restRoute is a read-only REST endpoint. The routes and their response
+// types also make up the OpenAPI description.
+type restRoute struct {
+	path      string
+	operation string
+	summary   string
+	response  interface{}
+	handler   func(r *RestAPI, c *gin.Context)
+}
+
+var restRoutes = []restRoute{
+	{"/v1/status", "getStatus", "Overall health", restStatus{}, (*RestAPI).handleStatus},
+	{"/v1/monitors", "listMonitors", "All safety monitors", []restMonitor{}, (*RestAPI).handleMonitors},
+	{"/v1/monitors/:id", "getMonitor", "One safety monitor", restMonitor{}, (*RestAPI).handleMonitor},
+	{"/v1/weather", "listWeather", "All weather stations", []restWeather{}, (*RestAPI).handleStations},
+	{"/v1/weather/:id", "getWeather", "One weather station with its sensors", restWeather{}, (*RestAPI).handleStation},
+}
+
+// RestAPI serves a read-only JSON API for scripts and dashboards that do
+// not speak Alpaca
+type RestAPI struct {
+	*ApiServer
+}
+
+// NewRestAPI creates a new REST API handler
+func NewRestAPI(apiServer *ApiServer) *RestAPI {
+	return &RestAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up the REST routes and their OpenAPI description
+func (r *RestAPI) ConfigureRoutes(router *gin.Engine) {
+	for _, route := range restRoutes {
+		handler := route.handler
+		router.GET(route.path, func(c *gin.Context) {
+			handler(r, c)
+		})
+	}
+	spec := openAPISpec(restRoutes, buildVersion())
+	router.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+}
+
+func (r *RestAPI) handleStatus(c *gin.Context) {
+	status := restStatus{
+		Status:  "ok",
+		Version: buildVersion(),
+		Time:    time.Now(),
+	}
+	for _, id := range r.Barn.GetMonitorIds() {
+		m := r.Barn.GetMonitor(id)
+		if m == nil {
+			continue
+		}
+		status.Monitors.Total++
+		if !m.IsSafe() {
+			status.Monitors.Unsafe++
+		}
+		if r.monitorError(id) != "" {
+			status.Monitors.Failing++
+		}
+		if activeOverride(m) != nil {
+			status.Monitors.Overridden++
+		}
+	}
+	for _, id := range r.Barn.GetWeatherIds() {
+		if r.Barn.GetWeather(id) == nil {
+			continue
+		}
+		status.Weather.Total++
+		if r.weatherError(id) != "" {
+			status.Weather.Failing++
+		}
+	}
+	if status.Monitors.Failing > 0 || status.Weather.Failing > 0 {
+		status.Status = "degraded"
+	}
+	status.Safe = status.Monitors.Total > 0 && status.Monitors.Unsafe == 0
+	c.JSON(http.StatusOK, status)
+}
+
+func (r *RestAPI) handleMonitors(c *gin.Context) {
+	monitors := []restMonitor{}
+	for i, id := range r.Barn.GetMonitorIds() {
+		if m, ok := r.monitor(i, id); ok {
+			monitors = append(monitors, m)
+		}
+	}
+	c.JSON(http.StatusOK, monitors)
+}
+
+func (r *RestAPI) handleMonitor(c *gin.Context) {
+	for i, id := range r.Barn.GetMonitorIds() {
+		if id != c.Param("id") {
+			continue
+		}
+		if m, ok := r.monitor(i, id); ok {
+			c.JSON(http.StatusOK, m)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, restError{Error: "Monitor not found"})
+}
+
+func (r *RestAPI) handleStations(c *gin.Context) {
+	stations := []restWeather{}
+	for i, id := range r.Barn.GetWeatherIds() {
+		if w, ok := r.station(i, id); ok {
+			stations = append(stations, w)
+		}
+	}
+	c.JSON(http.StatusOK, stations)
+}
+
+func (r *RestAPI) handleStation(c *gin.Context) {
+	for i, id := range r.Barn.GetWeatherIds() {
+		if id != c.Param("id") {
+			continue
+		}
+		if w, ok := r.station(i, id); ok {
+			c.JSON(http.StatusOK, w)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, restError{Error: "Weather station not found"})
+}
+
+func (r *RestAPI) monitor(number int, id string) (restMonitor, bool) {
+	m := r.Barn.GetMonitor(id)
+	if m == nil {
+		return restMonitor{}, false
+	}
+	return restMonitor{
+		Id:           id,
+		Name:         m.GetName(),
+		Description:  m.GetDescription(),
+		DeviceNumber: number,
+		Safe:         m.IsSafe(),
+		Raw:          m.GetRawValue(),
+		LastRefresh:  optionalTime(m.GetTimeStamp()),
+		Error:        r.monitorError(id),
+		Override:     activeOverride(m),
+		Source:       r.source(app.SectionMonitors, id),
+		Health:       r.Barn.GetMonitorHealth(id),
+	}, true
+}
+
+func (r *RestAPI) station(number int, id string) (restWeather, bool) {
+	w := r.Barn.GetWeather(id)
+	if w == nil {
+		return restWeather{}, false
+	}
+	station := restWeather{
+		Id:           id,
+		Name:         w.GetName(),
+		Description:  w.GetDescription(),
+		DeviceNumber: number,
+		LastRefresh:  optionalTime(w.GetTimeStamp()),
+		Error:        r.weatherError(id),
+		Sensors:      []restSensor{},
+		Source:       r.source(app.SectionWeather, id),
+		Health:       r.Barn.GetWeatherHealth(id),
+	}
+	// Stations read all their sensors at once
+	var age *float64
+	if !w.GetTimeStamp().IsZero() {
+		seconds := time.Since(w.GetTimeStamp()).Seconds()
+		age = &seconds
+	}
+	for sensor, value := range weather.StationSensors(w) {
+		description, _ := weather.GetSensorDescription(sensor)
+		station.Sensors = append(station.Sensors, restSensor{
+			Name:        sensor,
+			Description: description,
+			Unit:        weather.SensorUnits[sensor],
+			Value:       value,
+			Age:         age,
+		})
+	}
+	sort.Slice(station.Sensors, func(i, j int) bool {
+		return station.Sensors[i].Name < station.Sensors[j].Name
+	})
+	return station, true
+}
+
+// source summarizes the settings shown on the setup page of a device,
+// without the name and description and with passwords in URLs hidden
+func (r *RestAPI) source(section string, id string) *restSource {
+	if r.setup == nil {
+		return nil
+	}
+	deviceType, err := r.setup.DeviceType(section, id)
+	if err != nil {
+		return nil
+	}
+	settings, err := r.setup.DeviceSettings(section, id)
+	if err != nil {
+		return nil
+	}
+	source := &restSource{Type: deviceType, Settings: make(map[string]string)}
+	for _, setting := range settings {
+		switch setting.Key {
+		case "name", "description":
+			continue
+		case "url":
+			setting.Value = redactURL(setting.Value)
+		}
+		source.Settings[setting.Key] = setting.Value
+	}
+	return source
+}
+
+// buildVersion returns the module version barn was built from
+func buildVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		return bi.Main.Version
+	}
+	return ""
+}
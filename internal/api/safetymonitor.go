@@ -175,8 +175,18 @@ func (sm *SafetyMonitorAPI) handleDriverVersion(c *gin.Context) {
 
 // handleSupportedActions handles GET requests for safety monitor supportedactions property
 func (sm *SafetyMonitorAPI) handleSupportedActions(c *gin.Context) {
+	actions := []string{"RawValue", "Health"}
+	if sm.overrides != nil {
+		actions = append(actions, "Override")
+	}
+	deviceId, _ := strconv.Atoi(c.Param("device_id"))
+	if device, err := sm.Barn.GetMonitorByIndex(deviceId); err == nil {
+		if _, ok := dummyMonitor(device); ok {
+			actions = append(actions, "SetSafe")
+		}
+	}
 	resp := stringlistResponse{
-		Value: []string{"RawValue"},
+		Value: actions,
 	}
 	sm.prepareAlpacaResponse(c, &resp.alpacaResponse)
 	c.IndentedJSON(http.StatusOK, resp)
@@ -216,6 +226,10 @@ func (sm *SafetyMonitorAPI) handleDeviceState(c *gin.Context) {
 			Value: device.GetTimeStamp(),
 		},
 	}
+	if sm.overrides != nil {
+		deviceStates = append(deviceStates, overrideDeviceState(device)...)
+	}
+	deviceStates = append(deviceStates, healthDeviceState(sm.Barn.GetMonitorHealth(device.GetId()))...)
 
 	resp := deviceStateResponse{
 		Value: deviceStates,
@@ -279,6 +293,12 @@ func (sm *SafetyMonitorAPI) handleSafetyMonitorAction(c *gin.Context) {
 		}
 		sm.prepareAlpacaResponse(c, &resp.alpacaResponse)
 		c.IndentedJSON(http.StatusOK, resp)
+	} else if action == "Health" {
+		sm.handleHealthAction(c, sm.Barn.GetMonitorHealth(device.GetId()))
+	} else if action == "Override" && sm.overrides != nil {
+		sm.handleOverrideAction(c, device)
+	} else if dummy, ok := dummyMonitor(device); ok && action == "SetSafe" {
+		sm.handleSetSafeAction(c, dummy)
 	} else {
 		c.String(400, "The device did not understand which operation was being requested or insufficient information was given to complete the operation.")
 		return
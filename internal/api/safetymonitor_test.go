@@ -1,9 +1,15 @@
 package api
 
 import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/health"
+	"github.com/thebuh/barn/internal/monitor"
 )
 
 func TestSafetyMonitorAPI_ConnectClient(t *testing.T) {
@@ -290,3 +296,30 @@ func TestSafetyMonitorAPI_Connect_Disconnect_InvalidClient(t *testing.T) {
 	device.DisconnectClient(clientId1)
 	assert.Equal(t, false, device.IsConnected(clientId1), "Client 1 should be disconnected")
 }
+
+func TestSafetyMonitorAPI_Health(t *testing.T) {
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorFile("roof", "Roof", "", filepath.Join(t.TempDir(), "roof"), monitor.NewSafetyMatchingRule(false, "open")))
+	router := NewApiServer(barn, 0).Router()
+	alpacaPut(router, "/api/v1/safetymonitor/0/connected", url.Values{"Connected": {"true"}})
+	assert.Error(t, barn.RefreshMonitor("roof"), "missing files should fail")
+
+	assert.Contains(t, alpacaGet(router, "/api/v1/safetymonitor/0/supportedactions").Body.String(), `"Health"`)
+	var action stringResponse
+	w := alpacaPut(router, "/api/v1/safetymonitor/0/action", url.Values{"Action": {"Health"}, "Parameters": {""}})
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &action), "should work")
+	var status health.Status
+	assert.NoError(t, json.Unmarshal([]byte(action.Value), &status), "should work")
+	assert.Equal(t, 1, status.ErrorCount, "they should be equal")
+	assert.Contains(t, status.LastError, "no such file", "the real cause should be kept")
+
+	var state deviceStateResponse
+	assert.NoError(t, json.Unmarshal(alpacaGet(router, "/api/v1/safetymonitor/0/devicestate").Body.Bytes(), &state), "should work")
+	extras := make(map[string]interface{})
+	for _, s := range state.Value {
+		extras[s.Name] = s.Value
+	}
+	assert.Equal(t, false, extras["IsSafe"], "they should be equal")
+	assert.Equal(t, float64(1), extras["ConsecutiveFailures"], "they should be equal")
+	assert.Equal(t, status.LastError, extras["LastError"], "they should be equal")
+}
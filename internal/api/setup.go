@@ -0,0 +1,250 @@
+package api
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/app"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var setupTemplates = map[string]*template.Template{
+	"setup.html":        template.Must(template.ParseFS(templateFiles, "templates/layout.html", "templates/setup.html")),
+	"device_setup.html": template.Must(template.ParseFS(templateFiles, "templates/layout.html", "templates/device_setup.html")),
+}
+
+// deviceSections maps Alpaca device types to their config section. The
+// switch device has no settings of its own.
+var deviceSections = map[string]string{
+	"safetymonitor":       app.SectionMonitors,
+	"observingconditions": app.SectionWeather,
+	"dome":                app.SectionDomes,
+	"switch":              "",
+}
+
+// SetupAPI serves the Alpaca setup pages opened by the Setup button of clients
+type SetupAPI struct {
+	*ApiServer
+}
+
+// NewSetupAPI creates a new setup page handler
+func NewSetupAPI(apiServer *ApiServer) *SetupAPI {
+	return &SetupAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// UseSetup allows device settings to be changed from the setup pages
+func (srv *ApiServer) UseSetup(configurator app.Configurator) {
+	srv.setup = configurator
+}
+
+// ConfigureRoutes sets up the server and device setup pages
+func (s *SetupAPI) ConfigureRoutes(router *gin.Engine) {
+	router.GET("/setup", s.handleServerSetup)
+	router.GET("/setup/v1/:device_type/:device_id/setup", s.handleDeviceSetup)
+	router.POST("/setup/v1/:device_type/:device_id/setup", s.handleDeviceSetup)
+}
+
+type serverSetupPage struct {
+	Title   string
+	Version string
+	ApiPort uint32
+	Devices []DeviceConfiguration
+}
+
+type deviceSetupPage struct {
+	Title        string
+	DeviceType   string
+	DeviceNumber int
+	Id           string
+	Settings     []app.Setting
+	Editable     bool
+	Saved        bool
+	Error        string
+}
+
+func (s *SetupAPI) handleServerSetup(c *gin.Context) {
+	version := ""
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		version = bi.Main.Version
+	}
+	renderSetup(c, http.StatusOK, "setup.html", serverSetupPage{
+		Title:   "Alpaca Barn setup",
+		Version: version,
+		ApiPort: s.ApiPort,
+		Devices: s.configuredDevices(),
+	})
+}
+
+func (s *SetupAPI) handleDeviceSetup(c *gin.Context) {
+	deviceType := c.Param("device_type")
+	section, known := deviceSections[deviceType]
+	number, err := strconv.Atoi(c.Param("device_id"))
+	if !known || err != nil {
+		c.String(http.StatusNotFound, "Device not found")
+		return
+	}
+	device, exists := s.Devices[deviceType][number]
+	if !exists {
+		c.String(http.StatusNotFound, "Device not found")
+		return
+	}
+
+	page := deviceSetupPage{
+		DeviceType:   deviceType,
+		DeviceNumber: number,
+		Id:           device.Id,
+	}
+	page.Settings, page.Editable = s.deviceSettings(section, deviceType, device.Id)
+	page.Title = settingValue(page.Settings, "name") + " setup"
+
+	status := http.StatusOK
+	if c.Request.Method == http.MethodPost && page.Editable {
+		values := submittedSettings(c, page.Settings)
+		keepPassword(values, page.Settings)
+		if err := s.setup.UpdateDeviceSettings(section, device.Id, values); err != nil {
+			log.WithError(err).Warn(fmt.Sprintf("[BARN] Setup [%s]. Rejected settings", device.Id))
+			status = http.StatusBadRequest
+			page.Error = err.Error()
+			// Show what was submitted so it can be corrected
+			for i := range page.Settings {
+				if value, exists := values[page.Settings[i].Key]; exists {
+					page.Settings[i].Value = value
+				}
+			}
+		} else {
+			log.WithFields(log.Fields{"device": device.Id}).Info(fmt.Sprintf("[BARN] Setup [%s]. Settings saved", device.Id))
+			page.Saved = true
+			page.Settings, _ = s.deviceSettings(section, deviceType, device.Id)
+		}
+	}
+	page.Settings = redactSettings(page.Settings)
+	renderSetup(c, status, "device_setup.html", page)
+}
+
+// deviceSettings returns the settings of a device and whether they can be
+// changed. Devices not loaded from the configuration only show their name.
+func (s *SetupAPI) deviceSettings(section string, deviceType string, id string) ([]app.Setting, bool) {
+	if s.setup != nil && section != "" {
+		settings, err := s.setup.DeviceSettings(section, id)
+		if err == nil {
+			return settings, true
+		}
+		if !errors.Is(err, app.ErrDeviceNotConfigured) {
+			log.WithError(err).Error(fmt.Sprintf("[BARN] Setup [%s]. Failed to read settings", id))
+		}
+	}
+	name, description := "", ""
+	switch deviceType {
+	case "switch":
+		name, description = switchDeviceName, switchDeviceDescription
+	case "safetymonitor":
+		if m := s.Barn.GetMonitor(id); m != nil {
+			name, description = m.GetName(), m.GetDescription()
+		}
+	case "dome":
+		if d := s.Barn.GetDome(id); d != nil {
+			name, description = d.GetName(), d.GetDescription()
+		}
+	default:
+		if w := s.Barn.GetWeather(id); w != nil {
+			name, description = w.GetName(), w.GetDescription()
+		}
+	}
+	return []app.Setting{
+		{Key: "name", Label: "Name", Type: app.SettingText, Value: name, ReadOnly: true},
+		{Key: "description", Label: "Description", Type: app.SettingText, Value: description, ReadOnly: true},
+	}, false
+}
+
+// submittedSettings reads the editable settings from the form. Unchecked
+// checkboxes are not submitted, so a missing boolean means false.
+func submittedSettings(c *gin.Context, settings []app.Setting) map[string]string {
+	values := make(map[string]string)
+	for _, setting := range settings {
+		if setting.ReadOnly {
+			continue
+		}
+		value, exists := c.GetPostForm(setting.Key)
+		if setting.Type == app.SettingBool {
+			values[setting.Key] = strconv.FormatBool(exists && value != "false")
+			continue
+		}
+		if exists {
+			values[setting.Key] = value
+		}
+	}
+	return values
+}
+
+// redactSettings returns a copy of settings with the password in URLs hidden
+func redactSettings(settings []app.Setting) []app.Setting {
+	redacted := append([]app.Setting(nil), settings...)
+	for i := range redacted {
+		if redacted[i].Key == "url" {
+			redacted[i].Value = redactURL(redacted[i].Value)
+		}
+	}
+	return redacted
+}
+
+// redactURL hides the password of a URL
+func redactURL(value string) string {
+	if u, err := url.Parse(value); err == nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// keepPassword puts the stored password back into a submitted URL that still
+// has the redacted one for the same user
+func keepPassword(values map[string]string, stored []app.Setting) {
+	submitted, exists := values["url"]
+	if !exists {
+		return
+	}
+	u, err := url.Parse(submitted)
+	if err != nil || u.User == nil {
+		return
+	}
+	// url.URL.Redacted replaces passwords with xxxxx
+	if password, set := u.User.Password(); !set || password != "xxxxx" {
+		return
+	}
+	previous, err := url.Parse(settingValue(stored, "url"))
+	if err != nil || previous.User == nil || previous.User.Username() != u.User.Username() {
+		return
+	}
+	if password, set := previous.User.Password(); set {
+		u.User = url.UserPassword(u.User.Username(), password)
+		values["url"] = u.String()
+	}
+}
+
+func settingValue(settings []app.Setting, key string) string {
+	for _, setting := range settings {
+		if setting.Key == key {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+func renderSetup(c *gin.Context, status int, name string, page interface{}) {
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := setupTemplates[name].ExecuteTemplate(c.Writer, name, page); err != nil {
+		log.WithError(err).Error("[BARN] Setup. Failed to render page")
+	}
+}
@@ -0,0 +1,618 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// The single Alpaca Switch device
+const (
+	switchDeviceId          = "switch"
+	switchDeviceName        = "Alpaca Barn switches"
+	switchDeviceDescription = "Safety monitors, overrides and weather sensors as switches"
+)
+
+// Overrides set from switches expire after switchOverrideDuration, as every
+// override must expire
+const switchOverrideDuration = 12 * time.Hour
+
+// Reasons recorded on overrides set from switches. Turning maintenance off
+// only clears overrides it set.
+const (
+	switchOverrideReason = "set from Alpaca switch"
+	maintenanceReason    = "maintenance"
+)
+
+// sensorRange is the range and resolution reported for a sensor switch
+type sensorRange struct {
+	min, max, step float64
+}
+
+var sensorRanges = map[string]sensorRange{
+	weather.SensorCloudCover:     {0, 100, 0.1},
+	weather.SensorDewPoint:       {-100, 100, 0.1},
+	weather.SensorHumidity:       {0, 100, 0.1},
+	weather.SensorPressure:       {0, 1200, 0.1},
+	weather.SensorRainRate:       {0, 1000, 0.01},
+	weather.SensorSkyBrightness:  {0, 200000, 0.01},
+	weather.SensorSkyQuality:     {0, 30, 0.01},
+	weather.SensorSkyTemperature: {-100, 100, 0.1},
+	weather.SensorStarFWHM:       {0, 60, 0.01},
+	weather.SensorTemperature:    {-100, 100, 0.1},
+	weather.SensorWindDirection:  {0, 360, 0.1},
+	weather.SensorWindGust:       {0, 200, 0.1},
+	weather.SensorWindSpeed:      {0, 200, 0.1},
+}
+
+// barnSwitch is one switch of the Switch device. Boolean switches range from
+// 0 to 1 in steps of 1.
+type barnSwitch struct {
+	name        string
+	description string
+	min         float64
+	max         float64
+	step        float64
+	value       float64
+	// set is nil for read-only switches
+	set func(on bool, by string) error
+}
+
+func (s barnSwitch) canWrite() bool {
+	return s.set != nil
+}
+
+// state is false at the minimum value and true above it
+func (s barnSwitch) state() bool {
+	return s.value > s.min
+}
+
+// SwitchAPI exposes monitors, overrides and weather sensors as an Alpaca
+// Switch device for clients that work better with switches
+type SwitchAPI struct {
+	*ApiServer
+}
+
+// NewSwitchAPI creates a new switch API handler
+func NewSwitchAPI(apiServer *ApiServer) *SwitchAPI {
+	return &SwitchAPI{
+		ApiServer: apiServer,
+	}
+}
+
+// ConfigureRoutes sets up all switch API routes
+func (sw *SwitchAPI) ConfigureRoutes(router *gin.Engine) {
+	group := router.Group("/api/v1/switch/:device_id")
+	group.Use(alpacaValidationMiddleware())
+	group.Use(deviceValidationMiddleware("switch"))
+	group.Use(alpacaResponseMiddleware())
+	{
+		group.PUT("/connected", sw.handleConnectedPut)
+		group.PUT("/connect", sw.handleConnect)
+		group.PUT("/disconnect", sw.handleDisconnect)
+		group.PUT("/action", sw.handleAction)
+		group.PUT("/commandblind", sw.handleNotImplemented)
+		group.PUT("/commandbool", sw.handleNotImplemented)
+		group.PUT("/commandstring", sw.handleNotImplemented)
+		group.PUT("/setswitch", sw.handleSetSwitch)
+		group.PUT("/setswitchvalue", sw.handleSetSwitchValue)
+		group.PUT("/setswitchname", sw.handleNotImplemented)
+		group.PUT("/setasync", sw.handleSetSwitch)
+		group.PUT("/setasyncvalue", sw.handleSetSwitchValue)
+		group.PUT("/cancelasync", sw.handleCancelAsync)
+
+		group.GET("/connected", sw.handleConnected)
+		group.GET("/connecting", sw.handleConnecting)
+		group.GET("/name", sw.handleName)
+		group.GET("/description", sw.handleDescription)
+		group.GET("/driverinfo", sw.handleDriverInfo)
+		group.GET("/driverversion", sw.handleDriverVersion)
+		group.GET("/supportedactions", sw.handleSupportedActions)
+		group.GET("/interfaceversion", sw.handleInterfaceVersion)
+		group.GET("/devicestate", sw.handleDeviceState)
+		group.GET("/maxswitch", sw.handleMaxSwitch)
+		group.GET("/canwrite", sw.handleCanWrite)
+		group.GET("/canasync", sw.handleCanWrite)
+		group.GET("/getswitch", sw.handleGetSwitch)
+		group.GET("/getswitchvalue", sw.handleGetSwitchValue)
+		group.GET("/getswitchname", sw.handleGetSwitchName)
+		group.GET("/getswitchdescription", sw.handleGetSwitchDescription)
+		group.GET("/minswitchvalue", sw.handleMinSwitchValue)
+		group.GET("/maxswitchvalue", sw.handleMaxSwitchValue)
+		group.GET("/switchstep", sw.handleSwitchStep)
+		group.GET("/statechangecomplete", sw.handleStateChangeComplete)
+	}
+}
+
+// switches lists the switches in order: monitor states, override toggles,
+// the maintenance toggle, then weather sensors. The list follows the devices
+// loaded at the time of the request.
+func (sw *SwitchAPI) switches() []barnSwitch {
+	var switches []barnSwitch
+	monitorIds := sw.Barn.GetMonitorIds()
+	for _, id := range monitorIds {
+		m := sw.Barn.GetMonitor(id)
+		if m == nil {
+			continue
+		}
+		s := barnSwitch{
+			name:        m.GetName() + " safe",
+			description: fmt.Sprintf("Whether %s reports safe", m.GetName()),
+			max:         1,
+			step:        1,
+		}
+		if m.IsSafe() {
+			s.value = 1
+		}
+		switches = append(switches, s)
+	}
+
+	if sw.overrides != nil {
+		for _, id := range monitorIds {
+			m := sw.Barn.GetMonitor(id)
+			if m == nil {
+				continue
+			}
+			s := barnSwitch{
+				name:        m.GetName() + " force unsafe",
+				description: fmt.Sprintf("Forces %s unsafe for up to %s", m.GetName(), switchOverrideDuration),
+				max:         1,
+				step:        1,
+				set:         sw.overrideSetter(id),
+			}
+			if o, active := sw.overrides.Get(id); active && !o.Safe {
+				s.value = 1
+			}
+			switches = append(switches, s)
+		}
+		s := barnSwitch{
+			name:        "Maintenance",
+			description: fmt.Sprintf("Forces every monitor unsafe for up to %s", switchOverrideDuration),
+			max:         1,
+			step:        1,
+			set:         sw.setMaintenance,
+		}
+		for _, o := range sw.overrides.List() {
+			if o.Reason == maintenanceReason {
+				s.value = 1
+			}
+		}
+		switches = append(switches, s)
+	}
+
+	for _, id := range sw.Barn.GetWeatherIds() {
+		station := sw.Barn.GetWeather(id)
+		if station == nil {
+			continue
+		}
+		values := weather.StationSensors(station)
+		sensors := make([]string, 0, len(values))
+		for sensor := range values {
+			sensors = append(sensors, sensor)
+		}
+		sort.Strings(sensors)
+		for _, sensor := range sensors {
+			r := sensorRanges[sensor]
+			description, _ := weather.GetSensorDescription(sensor)
+			switches = append(switches, barnSwitch{
+				name:        station.GetName() + " " + sensor,
+				description: description,
+				min:         r.min,
+				max:         r.max,
+				step:        r.step,
+				value:       values[sensor],
+			})
+		}
+	}
+	return switches
+}
+
+// overrideSetter forces monitor id unsafe, or clears an unsafe override
+func (sw *SwitchAPI) overrideSetter(id string) func(bool, string) error {
+	return func(on bool, by string) error {
+		if on {
+			return sw.overrides.Set(override.Override{
+				Monitor: id,
+				Reason:  switchOverrideReason,
+				SetBy:   by,
+				Expires: time.Now().Add(switchOverrideDuration),
+			})
+		}
+		if o, active := sw.overrides.Get(id); active && !o.Safe {
+			return sw.overrides.Clear(id, by)
+		}
+		return nil
+	}
+}
+
+// setMaintenance forces every monitor unsafe, or clears the overrides it set
+func (sw *SwitchAPI) setMaintenance(on bool, by string) error {
+	if !on {
+		for _, o := range sw.overrides.List() {
+			if o.Reason != maintenanceReason {
+				continue
+			}
+			if err := sw.overrides.Clear(o.Monitor, by); err != nil && !errors.Is(err, override.ErrNotFound) {
+				return err
+			}
+		}
+		return nil
+	}
+	expires := time.Now().Add(switchOverrideDuration)
+	for _, id := range sw.Barn.GetMonitorIds() {
+		err := sw.overrides.Set(override.Override{
+			Monitor: id,
+			Reason:  maintenanceReason,
+			SetBy:   by,
+			Expires: expires,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRequestConnected checks if the current request is from a connected client
+func (sw *SwitchAPI) isRequestConnected(c *gin.Context) bool {
+	device, _ := c.Get("device")
+	return device.(*Device).IsConnected(getFullClientId(c))
+}
+
+// switchParam reads a parameter from the query of GET requests or the form
+// of PUT requests, ignoring the case of its name
+func switchParam(c *gin.Context, name string) string {
+	if c.Request.Method == http.MethodGet {
+		return getQuery(c, name)
+	}
+	_ = c.Request.ParseForm()
+	for key, values := range c.Request.PostForm {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// requestSwitch returns the switch named by the Id parameter. It answers the
+// request itself when the client is not connected or the Id is invalid.
+func (sw *SwitchAPI) requestSwitch(c *gin.Context) (barnSwitch, int, bool) {
+	if !sw.isRequestConnected(c) {
+		c.String(400, "Not connected")
+		return barnSwitch{}, 0, false
+	}
+	switches := sw.switches()
+	id, err := strconv.Atoi(switchParam(c, "Id"))
+	if err != nil || id < 0 || id >= len(switches) {
+		sw.alpacaError(c, 0x401, fmt.Sprintf("Switch Id must be between 0 and %d", len(switches)-1)) // InvalidValue
+		return barnSwitch{}, 0, false
+	}
+	return switches[id], id, true
+}
+
+// alpacaError answers with an Alpaca error in a 200 response
+func (sw *SwitchAPI) alpacaError(c *gin.Context, number int32, message string) {
+	resp := alpacaResponse{
+		ErrorNumber:  number,
+		ErrorMessage: message,
+	}
+	sw.prepareAlpacaResponse(c, &resp)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (sw *SwitchAPI) respond(c *gin.Context) {
+	resp := alpacaResponse{}
+	sw.prepareAlpacaResponse(c, &resp)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (sw *SwitchAPI) respondBool(c *gin.Context, value bool) {
+	resp := boolResponse{
+		Value: value,
+	}
+	sw.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (sw *SwitchAPI) respondString(c *gin.Context, value string) {
+	resp := stringResponse{
+		Value: value,
+	}
+	sw.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+func (sw *SwitchAPI) respondFloat(c *gin.Context, value float64) {
+	resp := float64Response{
+		Value: value,
+	}
+	sw.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleConnected handles GET requests for the connected property
+func (sw *SwitchAPI) handleConnected(c *gin.Context) {
+	sw.respondBool(c, sw.isRequestConnected(c))
+}
+
+// handleConnecting handles GET requests for the connecting property
+func (sw *SwitchAPI) handleConnecting(c *gin.Context) {
+	sw.respondBool(c, false)
+}
+
+// handleConnectedPut handles PUT requests to connect or disconnect
+func (sw *SwitchAPI) handleConnectedPut(c *gin.Context) {
+	device, _ := c.Get("device")
+	dev := device.(*Device)
+	switch strings.ToLower(switchParam(c, "Connected")) {
+	case "true":
+		dev.ConnectClient(getFullClientId(c))
+	case "false":
+		dev.DisconnectClient(getFullClientId(c))
+	default:
+		c.String(400, "Invalid request")
+		return
+	}
+	sw.respond(c)
+}
+
+// handleConnect handles PUT requests to connect, which completes at once
+func (sw *SwitchAPI) handleConnect(c *gin.Context) {
+	device, _ := c.Get("device")
+	device.(*Device).ConnectClient(getFullClientId(c))
+	sw.respond(c)
+}
+
+// handleDisconnect handles PUT requests to disconnect, which completes at once
+func (sw *SwitchAPI) handleDisconnect(c *gin.Context) {
+	device, _ := c.Get("device")
+	device.(*Device).DisconnectClient(getFullClientId(c))
+	sw.respond(c)
+}
+
+// handleName handles GET requests for the name property
+func (sw *SwitchAPI) handleName(c *gin.Context) {
+	sw.respondString(c, switchDeviceName)
+}
+
+// handleDescription handles GET requests for the description property
+func (sw *SwitchAPI) handleDescription(c *gin.Context) {
+	sw.respondString(c, switchDeviceDescription)
+}
+
+// handleDriverInfo handles GET requests for the driverinfo property
+func (sw *SwitchAPI) handleDriverInfo(c *gin.Context) {
+	sw.respondString(c, "Alpaca Barn switch")
+}
+
+// handleDriverVersion handles GET requests for the driverversion property
+func (sw *SwitchAPI) handleDriverVersion(c *gin.Context) {
+	bi, _ := debug.ReadBuildInfo()
+	sw.respondString(c, bi.Main.Version)
+}
+
+// handleSupportedActions handles GET requests for the supportedactions property
+func (sw *SwitchAPI) handleSupportedActions(c *gin.Context) {
+	resp := stringlistResponse{
+		Value: []string{},
+	}
+	sw.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleInterfaceVersion handles GET requests for the interfaceversion property
+func (sw *SwitchAPI) handleInterfaceVersion(c *gin.Context) {
+	resp := int32Response{
+		Value: 3,
+	}
+	sw.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleAction handles PUT requests for actions, of which there are none
+func (sw *SwitchAPI) handleAction(c *gin.Context) {
+	if !sw.isRequestConnected(c) {
+		c.String(400, "Not connected")
+		return
+	}
+	sw.alpacaError(c, 0x40C, fmt.Sprintf("Action %q is not supported", switchParam(c, "Action"))) // ActionNotImplemented
+}
+
+// handleNotImplemented answers methods barn does not implement, such as
+// renaming switches, whose names follow the devices
+func (sw *SwitchAPI) handleNotImplemented(c *gin.Context) {
+	sw.alpacaError(c, 0x400, "Method not implemented") // NotImplemented
+}
+
+// handleDeviceState handles GET requests for the devicestate property
+func (sw *SwitchAPI) handleDeviceState(c *gin.Context) {
+	if !sw.isRequestConnected(c) {
+		c.String(400, "Not connected")
+		return
+	}
+	deviceStates := []DeviceState{}
+	for i, s := range sw.switches() {
+		deviceStates = append(deviceStates,
+			DeviceState{Name: fmt.Sprintf("GetSwitch%d", i), Value: s.state()},
+			DeviceState{Name: fmt.Sprintf("GetSwitchValue%d", i), Value: s.value},
+		)
+		if s.canWrite() {
+			deviceStates = append(deviceStates, DeviceState{Name: fmt.Sprintf("StateChangeComplete%d", i), Value: true})
+		}
+	}
+	deviceStates = append(deviceStates, DeviceState{Name: "TimeStamp", Value: time.Now()})
+
+	resp := deviceStateResponse{
+		Value: deviceStates,
+	}
+	sw.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleMaxSwitch handles GET requests for the number of switches
+func (sw *SwitchAPI) handleMaxSwitch(c *gin.Context) {
+	if !sw.isRequestConnected(c) {
+		c.String(400, "Not connected")
+		return
+	}
+	resp := int32Response{
+		Value: int32(len(sw.switches())),
+	}
+	sw.prepareAlpacaResponse(c, &resp.alpacaResponse)
+	c.IndentedJSON(http.StatusOK, resp)
+}
+
+// handleCanWrite answers CanWrite and CanAsync. Writable switches complete
+// at once, so they support the asynchronous methods too.
+func (sw *SwitchAPI) handleCanWrite(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondBool(c, s.canWrite())
+	}
+}
+
+// handleGetSwitch handles GET requests for the state of a switch
+func (sw *SwitchAPI) handleGetSwitch(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondBool(c, s.state())
+	}
+}
+
+// handleGetSwitchValue handles GET requests for the value of a switch
+func (sw *SwitchAPI) handleGetSwitchValue(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondFloat(c, s.value)
+	}
+}
+
+// handleGetSwitchName handles GET requests for the name of a switch
+func (sw *SwitchAPI) handleGetSwitchName(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondString(c, s.name)
+	}
+}
+
+// handleGetSwitchDescription handles GET requests for the description of a switch
+func (sw *SwitchAPI) handleGetSwitchDescription(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondString(c, s.description)
+	}
+}
+
+// handleMinSwitchValue handles GET requests for the minimum value of a switch
+func (sw *SwitchAPI) handleMinSwitchValue(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondFloat(c, s.min)
+	}
+}
+
+// handleMaxSwitchValue handles GET requests for the maximum value of a switch
+func (sw *SwitchAPI) handleMaxSwitchValue(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondFloat(c, s.max)
+	}
+}
+
+// handleSwitchStep handles GET requests for the step size of a switch
+func (sw *SwitchAPI) handleSwitchStep(c *gin.Context) {
+	if s, _, ok := sw.requestSwitch(c); ok {
+		sw.respondFloat(c, s.step)
+	}
+}
+
+// handleStateChangeComplete reports whether an asynchronous change finished,
+// which it always has
+func (sw *SwitchAPI) handleStateChangeComplete(c *gin.Context) {
+	s, _, ok := sw.requestSwitch(c)
+	if !ok {
+		return
+	}
+	if !s.canWrite() {
+		sw.alpacaError(c, 0x400, "Switch does not support asynchronous changes") // NotImplemented
+		return
+	}
+	sw.respondBool(c, true)
+}
+
+// handleCancelAsync handles PUT requests to cancel a change, of which none
+// is ever pending
+func (sw *SwitchAPI) handleCancelAsync(c *gin.Context) {
+	s, _, ok := sw.requestSwitch(c)
+	if !ok {
+		return
+	}
+	if !s.canWrite() {
+		sw.alpacaError(c, 0x400, "Switch does not support asynchronous changes") // NotImplemented
+		return
+	}
+	sw.respond(c)
+}
+
+// handleSetSwitch answers SetSwitch and SetAsync
+func (sw *SwitchAPI) handleSetSwitch(c *gin.Context) {
+	s, id, ok := sw.requestSwitch(c)
+	if !ok {
+		return
+	}
+	state, err := strconv.ParseBool(switchParam(c, "State"))
+	if err != nil {
+		c.String(400, "Invalid State value")
+		return
+	}
+	sw.setSwitch(c, s, id, state)
+}
+
+// handleSetSwitchValue answers SetSwitchValue and SetAsyncValue
+func (sw *SwitchAPI) handleSetSwitchValue(c *gin.Context) {
+	s, id, ok := sw.requestSwitch(c)
+	if !ok {
+		return
+	}
+	value, err := strconv.ParseFloat(switchParam(c, "Value"), 64)
+	if err != nil {
+		c.String(400, "Invalid Value value")
+		return
+	}
+	if math.IsNaN(value) || value < s.min || value > s.max {
+		sw.alpacaError(c, 0x401, fmt.Sprintf("Value must be between %g and %g", s.min, s.max)) // InvalidValue
+		return
+	}
+	sw.setSwitch(c, s, id, value > s.min)
+}
+
+func (sw *SwitchAPI) setSwitch(c *gin.Context, s barnSwitch, id int, on bool) {
+	if !s.canWrite() {
+		sw.alpacaError(c, 0x400, fmt.Sprintf("Switch %d is read-only", id)) // NotImplemented
+		return
+	}
+	if !sw.canAdminister(c) {
+		sw.alpacaError(c, 0x40B, "Overrides require the admin role") // InvalidOperation
+		return
+	}
+	by := "alpaca:" + string(getFullClientId(c))
+	if principal, ok := requestPrincipal(c); ok {
+		by = principal.Name + " (" + by + ")"
+	}
+	if err := s.set(on, by); err != nil {
+		log.WithError(err).Error(fmt.Sprintf("[BARN] Switch [%s]. Failed to set", s.name))
+		sw.alpacaError(c, 0x500, err.Error()) // UnspecifiedError
+		return
+	}
+	sw.publishMonitors()
+	log.WithFields(log.Fields{
+		"switch": id,
+		"state":  on,
+		"by":     by,
+	}).Info(fmt.Sprintf("[BARN] Switch [%s]. Set to [%t]", s.name, on))
+	sw.respond(c)
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/auth"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// stationWithSensors reports a fixed set of sensors
+type stationWithSensors struct {
+	*weather.ObservingConditionsDummy
+	sensors map[string]bool
+}
+
+func (s *stationWithSensors) SupportsSensor(sensorName string) bool {
+	return s.sensors[sensorName]
+}
+
+func newSwitchTestServer(t *testing.T) (*ApiServer, http.Handler) {
+	overrides, err := override.Open("")
+	assert.NoError(t, err, "should work")
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("roof", "Roof", "", true))
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("rain", "Rain", "", false))
+	barn.AddWeather(&stationWithSensors{
+		ObservingConditionsDummy: weather.NewObservingConditionsDummy("station", "Station", ""),
+		sensors:                  map[string]bool{weather.SensorTemperature: true, weather.SensorHumidity: true},
+	})
+	barn.UseOverrides(overrides)
+	srv := NewApiServer(barn, 0)
+	srv.UseOverrides(overrides)
+	router := srv.Router()
+	alpacaPut(router, "/api/v1/switch/0/connected", url.Values{"Connected": {"true"}})
+	return srv, router
+}
+
+// switchGet reads a property of switch id
+func switchGet(router http.Handler, property string, id int) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	path := fmt.Sprintf("/api/v1/switch/0/%s?ClientID=1&ClientTransactionID=1&Id=%d", property, id)
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func decodeSwitchValue(t *testing.T, w *httptest.ResponseRecorder) (interface{}, int32) {
+	var resp struct {
+		Value       interface{}
+		ErrorNumber int32
+	}
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), "should be valid json")
+	return resp.Value, resp.ErrorNumber
+}
+
+func TestSwitchAPI_Switches(t *testing.T) {
+	_, router := newSwitchTestServer(t)
+
+	value, _ := decodeSwitchValue(t, alpacaGet(router, "/api/v1/switch/0/maxswitch"))
+	// Two monitors, two override toggles, maintenance and two sensors
+	assert.Equal(t, 7.0, value, "they should be equal")
+
+	names := []string{"Rain safe", "Roof safe", "Rain force unsafe", "Roof force unsafe", "Maintenance", "Station Humidity", "Station Temperature"}
+	for id, name := range names {
+		value, _ := decodeSwitchValue(t, switchGet(router, "getswitchname", id))
+		assert.Equal(t, name, value, "they should be equal")
+	}
+
+	value, _ = decodeSwitchValue(t, switchGet(router, "getswitch", 0))
+	assert.Equal(t, false, value, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "getswitch", 1))
+	assert.Equal(t, true, value, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "canwrite", 0))
+	assert.Equal(t, false, value, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "canasync", 2))
+	assert.Equal(t, true, value, "they should be equal")
+
+	value, _ = decodeSwitchValue(t, switchGet(router, "minswitchvalue", 6))
+	assert.Equal(t, -100.0, value, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "maxswitchvalue", 6))
+	assert.Equal(t, 100.0, value, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "switchstep", 6))
+	assert.Equal(t, 0.1, value, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "getswitchdescription", 6))
+	assert.Contains(t, value, "temperature", "sensor descriptions should be used")
+
+	_, errorNumber := decodeSwitchValue(t, switchGet(router, "getswitchvalue", 7))
+	assert.Equal(t, int32(0x401), errorNumber, "they should be equal")
+	_, errorNumber = decodeSwitchValue(t, switchGet(router, "statechangecomplete", 0))
+	assert.Equal(t, int32(0x400), errorNumber, "they should be equal")
+}
+
+func TestSwitchAPI_SetOverride(t *testing.T) {
+	srv, router := newSwitchTestServer(t)
+
+	_, errorNumber := decodeSwitchValue(t, alpacaPut(router, "/api/v1/switch/0/setswitch", url.Values{"Id": {"0"}, "State": {"false"}}))
+	assert.Equal(t, int32(0x400), errorNumber, "read-only switches should not be set")
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/switch/0/setswitchvalue", url.Values{"Id": {"2"}, "Value": {"2"}}))
+	assert.Equal(t, int32(0x401), errorNumber, "they should be equal")
+
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/switch/0/setswitch", url.Values{"Id": {"3"}, "State": {"true"}}))
+	assert.Equal(t, int32(0), errorNumber, "they should be equal")
+	assert.Equal(t, false, srv.Barn.GetMonitor("roof").IsSafe(), "the roof should be forced unsafe")
+	value, _ := decodeSwitchValue(t, switchGet(router, "getswitchvalue", 3))
+	assert.Equal(t, 1.0, value, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "getswitch", 1))
+	assert.Equal(t, false, value, "monitor switches should follow overrides")
+
+	_, errorNumber = decodeSwitchValue(t, alpacaPut(router, "/api/v1/switch/0/setasyncvalue", url.Values{"Id": {"3"}, "Value": {"0"}}))
+	assert.Equal(t, int32(0), errorNumber, "they should be equal")
+	value, _ = decodeSwitchValue(t, switchGet(router, "statechangecomplete", 3))
+	assert.Equal(t, true, value, "they should be equal")
+	assert.Equal(t, true, srv.Barn.GetMonitor("roof").IsSafe(), "the override should be cleared")
+}
+
+func TestSwitchAPI_Maintenance(t *testing.T) {
+	srv, router := newSwitchTestServer(t)
+	srv.overrides.Set(override.Override{Monitor: "rain", Safe: true, Reason: "sensor broken", Expires: time.Now().Add(time.Hour)})
+
+	alpacaPut(router, "/api/v1/switch/0/setasync", url.Values{"Id": {"4"}, "State": {"true"}})
+	assert.Equal(t, false, srv.Barn.GetMonitor("roof").IsSafe(), "they should be equal")
+	assert.Equal(t, false, srv.Barn.GetMonitor("rain").IsSafe(), "they should be equal")
+	value, _ := decodeSwitchValue(t, switchGet(router, "getswitch", 4))
+	assert.Equal(t, true, value, "they should be equal")
+
+	alpacaPut(router, "/api/v1/switch/0/setswitch", url.Values{"Id": {"4"}, "State": {"false"}})
+	assert.Len(t, srv.overrides.List(), 0, "maintenance overrides should be cleared")
+	value, _ = decodeSwitchValue(t, switchGet(router, "getswitch", 4))
+	assert.Equal(t, false, value, "they should be equal")
+}
+
+func TestSwitchAPI_RequiresAdmin(t *testing.T) {
+	srv, _ := newSwitchTestServer(t)
+	srv.UseAuth(auth.New([]auth.User{{Name: "nina", Password: "secret", Role: auth.RoleRead}}, nil, nil))
+	router := srv.Router()
+	put := func(path string, form url.Values) *httptest.ResponseRecorder {
+		form.Set("ClientID", "1")
+		form.Set("ClientTransactionID", "1")
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("nina", "secret")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := put("/api/v1/switch/0/connected", url.Values{"Connected": {"true"}})
+	assert.Equal(t, http.StatusOK, w.Code, "readers may connect")
+
+	_, errorNumber := decodeSwitchValue(t, put("/api/v1/switch/0/setswitch", url.Values{"Id": {"2"}, "State": {"true"}}))
+	assert.Equal(t, int32(0x40B), errorNumber, "they should be equal")
+	assert.Equal(t, true, srv.Barn.GetMonitor("roof").IsSafe(), "override should not be set")
+}
+
+func TestSwitchAPI_NotConnected(t *testing.T) {
+	_, router := newSwitchTestServer(t)
+	alpacaPut(router, "/api/v1/switch/0/connected", url.Values{"Connected": {"false"}})
+	w := switchGet(router, "getswitch", 0)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "they should be equal")
+
+	value, _ := decodeSwitchValue(t, alpacaGet(router, "/api/v1/switch/0/interfaceversion"))
+	assert.Equal(t, 3.0, value, "they should be equal")
+	var devices managementDevicesListResponse
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/management/v1/configureddevices", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &devices), "should be valid json")
+	last := devices.Value[len(devices.Value)-1]
+	assert.Equal(t, "switch", last.DeviceType, "they should be equal")
+	assert.Equal(t, 0, last.DeviceNumber, "they should be equal")
+}
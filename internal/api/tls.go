@@ -0,0 +1,192 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TLSConfig enables HTTPS on the API port
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS with client certificates signed by it
+	ClientCAFile string
+	// ClientCertOptional accepts clients without a certificate when
+	// ClientCAFile is set; certificates that are given must still verify
+	ClientCertOptional bool
+	// HTTPPort also listens for plain HTTP, for legacy Alpaca clients
+	HTTPPort uint32
+	// Redirect sends plain HTTP requests to HTTPS instead of serving them
+	Redirect bool
+}
+
+// certReloader serves the certificate and client CA from files, loading
+// them again when the files change
+type certReloader struct {
+	config TLSConfig
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func newCertReloader(config TLSConfig) (*certReloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("tls cert and key are required")
+	}
+	r := &certReloader{config: config, modTimes: make(map[string]time.Time)}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// load reads the files. Callers must not hold mu.
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.config.ClientCAFile)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.modTimes = modTimes
+	return nil
+}
+
+// changed reports whether any file was modified since it was loaded
+func (r *certReloader) changed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the certificate and client CA, reloading changed files.
+// A failed reload keeps the previous files in use.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	if r.changed() {
+		if err := r.load(); err != nil {
+			log.WithError(err).Error(fmt.Sprintf("[BARN] TLS. Failed to reload [%s], keeping the previous certificate", r.config.CertFile))
+		} else {
+			log.Info(fmt.Sprintf("[BARN] TLS. Reloaded [%s]", r.config.CertFile))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.clientCA
+}
+
+// tlsConfig builds a server config that picks up reloaded files on every
+// handshake
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCA := r.current()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if clientCA != nil {
+				config.ClientCAs = clientCA
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				if r.config.ClientCertOptional {
+					config.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+			return config, nil
+		},
+	}
+}
+
+// UseTLS serves the API over HTTPS. The certificate and key are loaded now
+// so that errors show at startup.
+func (srv *ApiServer) UseTLS(config TLSConfig) error {
+	reloader, err := newCertReloader(config)
+	if err != nil {
+		return err
+	}
+	srv.tls = reloader
+	return nil
+}
+
+// DiscoveryPort returns the port Alpaca discovery should advertise. Discovery
+// has no way to tell clients to use HTTPS, so the plain HTTP port is
+// advertised while it serves the API.
+func (srv *ApiServer) DiscoveryPort() uint32 {
+	if srv.tls != nil && srv.tls.config.HTTPPort != 0 && !srv.tls.config.Redirect {
+		return srv.tls.config.HTTPPort
+	}
+	return srv.ApiPort
+}
+
+// serve answers requests on a listener, over HTTPS when TLS is enabled
+func (srv *ApiServer) serve(ln net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	if srv.tls == nil {
+		return server.Serve(ln)
+	}
+	server.TLSConfig = srv.tls.tlsConfig()
+	return server.ServeTLS(ln, "", "")
+}
+
+// legacyHandler serves plain HTTP next to HTTPS, either with the API itself
+// or with redirects to HTTPS
+func (srv *ApiServer) legacyHandler(router http.Handler) http.Handler {
+	if !srv.tls.config.Redirect {
+		return router
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		target := fmt.Sprintf("https://%s%s", net.JoinHostPort(host, fmt.Sprint(srv.ApiPort)), r.URL.RequestURI())
+		// 308 keeps the method and body of Alpaca PUT requests
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert issues a certificate for 127.0.0.1, signed by parent or
+// self-signed when parent is nil
+func newTestCert(t *testing.T, serial int64, isCA bool, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "should work")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "barn test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err, "should work")
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err, "should work")
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile string, keyFile string, modTime time.Time) {
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600), "should work")
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), "should work")
+	assert.NoError(t, os.Chtimes(certFile, modTime, modTime), "should work")
+	assert.NoError(t, os.Chtimes(keyFile, modTime, modTime), "should work")
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// startTLSTestServer serves the API over TLS on a random port
+func startTLSTestServer(t *testing.T, config TLSConfig) (*ApiServer, string) {
+	srv := NewApiServer(newTestBarn(), 0)
+	assert.NoError(t, srv.UseTLS(config), "should work")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "should work")
+	t.Cleanup(func() { ln.Close() })
+	go srv.serve(ln, srv.Router())
+	return srv, "https://" + ln.Addr().String()
+}
+
+func tlsGet(t *testing.T, url string, config *tls.Config) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	resp, err := client.Get(url + "/management/apiversions")
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestTLS_ServeAndReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := newTestCert(t, 1, false, nil)
+	first.write(t, certFile, keyFile, time.Now().Add(-time.Minute))
+	_, url := startTLSTestServer(t, TLSConfig{CertFile: certFile, KeyFile: keyFile})
+
+	insecure := &tls.Config{InsecureSkipVerify: true}
+	resp, err := tlsGet(t, url, insecure)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "they should be equal")
+	assert.Equal(t, int64(1), resp.TLS.PeerCertificates[0].SerialNumber.Int64(), "they should be equal")
+
+	second := newTestCert(t, 2, false, nil)
+	second.write(t, certFile, keyFile, time.Now())
+	resp, err = tlsGet(t, url, insecure)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, int64(2), resp.TLS.PeerCertificates[0].SerialNumber.Int64(), "changed files should be reloaded")
+
+	// A broken file keeps the previous certificate
+	assert.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0600), "should work")
+	resp, err = tlsGet(t, url, insecure)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, int64(2), resp.TLS.PeerCertificates[0].SerialNumber.Int64(), "they should be equal")
+}
+
+func TestTLS_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caFile, caKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	newTestCert(t, 1, false, nil).write(t, certFile, keyFile, time.Now())
+	ca := newTestCert(t, 10, true, nil)
+	ca.write(t, caFile, caKeyFile, time.Now())
+	client := newTestCert(t, 11, false, ca)
+	stranger := newTestCert(t, 12, false, nil)
+
+	_, url := startTLSTestServer(t, TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+
+	_, err := tlsGet(t, url, &tls.Config{InsecureSkipVerify: true})
+	assert.Error(t, err, "clients without a certificate should be rejected")
+	_, err = tlsGet(t, url, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{stranger.tlsCertificate()}})
+	assert.Error(t, err, "clients with an unknown certificate should be rejected")
+	resp, err := tlsGet(t, url, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{client.tlsCertificate()}})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "they should be equal")
+}
+
+func TestTLS_InvalidFiles(t *testing.T) {
+	srv := NewApiServer(newTestBarn(), 0)
+	assert.Error(t, srv.UseTLS(TLSConfig{}), "cert and key are required")
+	assert.Error(t, srv.UseTLS(TLSConfig{CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"}), "should fail")
+}
+
+func TestTLS_LegacyHTTP(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	newTestCert(t, 1, false, nil).write(t, certFile, keyFile, time.Now())
+
+	srv := NewApiServer(newTestBarn(), 8443)
+	assert.Equal(t, uint32(8443), srv.DiscoveryPort(), "they should be equal")
+
+	assert.NoError(t, srv.UseTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile, HTTPPort: 8080}), "should work")
+	assert.Equal(t, uint32(8080), srv.DiscoveryPort(), "discovery should advertise the plain HTTP port")
+	w := httptest.NewRecorder()
+	srv.legacyHandler(srv.Router()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/management/apiversions", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "legacy clients should be served")
+
+	assert.NoError(t, srv.UseTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile, HTTPPort: 8080, Redirect: true}), "should work")
+	assert.Equal(t, uint32(8443), srv.DiscoveryPort(), "they should be equal")
+	w = httptest.NewRecorder()
+	srv.legacyHandler(srv.Router()).ServeHTTP(w, httptest.NewRequest(http.MethodPut, "http://barn.local:8080/api/v1/safetymonitor/0/connected?x=1", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, w.Code, "they should be equal")
+	assert.Equal(t, "https://barn.local:8443/api/v1/safetymonitor/0/connected?x=1", w.Header().Get("Location"), "they should be equal")
+}
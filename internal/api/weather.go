@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/health"
 	"github.com/thebuh/barn/internal/weather"
 )
 
@@ -76,6 +77,40 @@ func (w *WeatherAPI) isRequestConnected(c *gin.Context) bool {
 	return device.IsConnected(getFullClientId(c))
 }
 
+// sensorSupported answers with NotImplemented when the device does not report
+// a sensor, and with an error while the device has no readings to report
+func (w *WeatherAPI) sensorSupported(c *gin.Context, device weather.ObservingConditions, sensorName string) bool {
+	if !weather.SupportsSensor(device, sensorName) {
+		resp := alpacaResponse{
+			ErrorNumber:  0x400, // NotImplemented
+			ErrorMessage: fmt.Sprintf("Sensor %s is not supported by this device", sensorName),
+		}
+		w.prepareAlpacaResponse(c, &resp)
+		c.IndentedJSON(http.StatusOK, resp)
+		return false
+	}
+	return w.hasReadings(c, device)
+}
+
+// hasReadings answers with an error while the device has not read its
+// sensors yet, instead of reporting readings it never took
+func (w *WeatherAPI) hasReadings(c *gin.Context, device weather.ObservingConditions) bool {
+	if !device.GetTimeStamp().IsZero() {
+		return true
+	}
+	message := fmt.Sprintf("No readings from %s yet", device.GetName())
+	if status := w.Barn.GetWeatherHealth(device.GetId()); status.Failing() {
+		message += fmt.Sprintf(", last refresh failed (%s): %s", status.LastErrorCause, status.LastError)
+	}
+	resp := alpacaResponse{
+		ErrorNumber:  0x500, // Driver error
+		ErrorMessage: message,
+	}
+	w.prepareAlpacaResponse(c, &resp)
+	c.IndentedJSON(http.StatusOK, resp)
+	return false
+}
+
 // handleConnectedGet handles GET requests for connected property
 func (w *WeatherAPI) handleConnectedGet(c *gin.Context) {
 	result := w.isRequestConnected(c)
@@ -148,8 +183,15 @@ func (w *WeatherAPI) handleDriverVersion(c *gin.Context) {
 
 // handleSupportedActions handles GET requests for supportedactions property
 func (w *WeatherAPI) handleSupportedActions(c *gin.Context) {
+	actions := []string{"Refresh", "Health"}
+	deviceId, _ := strconv.Atoi(c.Param("device_id"))
+	if device, err := w.Barn.GetWeatherByIndex(deviceId); err == nil {
+		if _, ok := device.(*weather.ObservingConditionsDummy); ok {
+			actions = append(actions, "SetValues")
+		}
+	}
 	resp := stringlistResponse{
-		Value: []string{"Refresh"},
+		Value: actions,
 	}
 	w.prepareAlpacaResponse(c, &resp.alpacaResponse)
 	c.IndentedJSON(http.StatusOK, resp)
@@ -209,6 +251,10 @@ func (w *WeatherAPI) handleCloudCover(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorCloudCover) {
+		return
+	}
+
 	resp := percentDoubleResponse{
 		Value: device.GetCloudCover(),
 	}
@@ -230,6 +276,10 @@ func (w *WeatherAPI) handleDewPoint(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorDewPoint) {
+		return
+	}
+
 	resp := float64Response{
 		Value: device.GetDewPoint(),
 	}
@@ -251,6 +301,10 @@ func (w *WeatherAPI) handleHumidity(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorHumidity) {
+		return
+	}
+
 	resp := float64Response{
 		Value: device.GetHumidity(),
 	}
@@ -272,6 +326,10 @@ func (w *WeatherAPI) handlePressure(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorPressure) {
+		return
+	}
+
 	resp := float64Response{
 		Value: device.GetPressure(),
 	}
@@ -293,6 +351,10 @@ func (w *WeatherAPI) handleRainRate(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorRainRate) {
+		return
+	}
+
 	resp := float64Response{
 		Value: device.GetRainRate(),
 	}
@@ -314,16 +376,7 @@ func (w *WeatherAPI) handleSkyBrightness(c *gin.Context) {
 		return
 	}
 
-	// Check if sensor is available
-	if !weather.IsSensorAvailable(weather.SensorSkyBrightness) {
-		resp := alpacaResponse{
-			ClientTransactionID: 0,
-			ServerTransactionID: 0,
-			ErrorNumber:         0x400, // NotImplemented
-			ErrorMessage:        "Sensor SkyBrightness is not supported by this device",
-		}
-		w.prepareAlpacaResponse(c, &resp)
-		c.IndentedJSON(http.StatusOK, resp)
+	if !w.sensorSupported(c, device, weather.SensorSkyBrightness) {
 		return
 	}
 
@@ -348,16 +401,7 @@ func (w *WeatherAPI) handleSkyQuality(c *gin.Context) {
 		return
 	}
 
-	// Check if sensor is available
-	if !weather.IsSensorAvailable(weather.SensorSkyQuality) {
-		resp := alpacaResponse{
-			ClientTransactionID: 0,
-			ServerTransactionID: 0,
-			ErrorNumber:         0x400, // NotImplemented
-			ErrorMessage:        "Sensor SkyQuality is not supported by this device",
-		}
-		w.prepareAlpacaResponse(c, &resp)
-		c.IndentedJSON(http.StatusOK, resp)
+	if !w.sensorSupported(c, device, weather.SensorSkyQuality) {
 		return
 	}
 
@@ -382,16 +426,7 @@ func (w *WeatherAPI) handleSkyTemperature(c *gin.Context) {
 		return
 	}
 
-	// Check if sensor is available
-	if !weather.IsSensorAvailable(weather.SensorSkyTemperature) {
-		resp := alpacaResponse{
-			ClientTransactionID: 0,
-			ServerTransactionID: 0,
-			ErrorNumber:         0x400, // NotImplemented
-			ErrorMessage:        "Sensor SkyTemperature is not supported by this device",
-		}
-		w.prepareAlpacaResponse(c, &resp)
-		c.IndentedJSON(http.StatusOK, resp)
+	if !w.sensorSupported(c, device, weather.SensorSkyTemperature) {
 		return
 	}
 
@@ -416,16 +451,7 @@ func (w *WeatherAPI) handleStarFWHM(c *gin.Context) {
 		return
 	}
 
-	// Check if sensor is available
-	if !weather.IsSensorAvailable(weather.SensorStarFWHM) {
-		resp := alpacaResponse{
-			ClientTransactionID: 0,
-			ServerTransactionID: 0,
-			ErrorNumber:         0x400, // NotImplemented
-			ErrorMessage:        "Sensor StarFWHM is not supported by this device",
-		}
-		w.prepareAlpacaResponse(c, &resp)
-		c.IndentedJSON(http.StatusOK, resp)
+	if !w.sensorSupported(c, device, weather.SensorStarFWHM) {
 		return
 	}
 
@@ -450,16 +476,7 @@ func (w *WeatherAPI) handleTemperature(c *gin.Context) {
 		return
 	}
 
-	// Check if sensor is available
-	if !weather.IsSensorAvailable(weather.SensorTemperature) {
-		resp := alpacaResponse{
-			ClientTransactionID: 0,
-			ServerTransactionID: 0,
-			ErrorNumber:         0x400, // NotImplemented
-			ErrorMessage:        "Sensor Temperature is not supported by this device",
-		}
-		w.prepareAlpacaResponse(c, &resp)
-		c.IndentedJSON(http.StatusOK, resp)
+	if !w.sensorSupported(c, device, weather.SensorTemperature) {
 		return
 	}
 
@@ -484,6 +501,10 @@ func (w *WeatherAPI) handleWindDirection(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorWindDirection) {
+		return
+	}
+
 	resp := float64Response{
 		Value: device.GetWindDirection(),
 	}
@@ -505,6 +526,10 @@ func (w *WeatherAPI) handleWindGust(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorWindGust) {
+		return
+	}
+
 	resp := float64Response{
 		Value: device.GetWindGust(),
 	}
@@ -526,6 +551,10 @@ func (w *WeatherAPI) handleWindSpeed(c *gin.Context) {
 		return
 	}
 
+	if !w.sensorSupported(c, device, weather.SensorWindSpeed) {
+		return
+	}
+
 	resp := float64Response{
 		Value: device.GetWindSpeed(),
 	}
@@ -551,7 +580,7 @@ func (w *WeatherAPI) handleTimeSinceLastUpdate(c *gin.Context) {
 	sensorName := getQuery(c, "SensorName")
 
 	// If sensor name is provided, check if it's available
-	if sensorName != "" && !weather.IsSensorAvailable(sensorName) {
+	if sensorName != "" && !weather.SupportsSensor(device, sensorName) {
 		resp := alpacaResponse{
 			ClientTransactionID: 0,
 			ServerTransactionID: 0,
@@ -562,6 +591,9 @@ func (w *WeatherAPI) handleTimeSinceLastUpdate(c *gin.Context) {
 		c.IndentedJSON(http.StatusOK, resp)
 		return
 	}
+	if !w.hasReadings(c, device) {
+		return
+	}
 
 	resp := float64Response{
 		Value: device.GetTimeSinceLastUpdate(),
@@ -573,7 +605,7 @@ func (w *WeatherAPI) handleTimeSinceLastUpdate(c *gin.Context) {
 // handleSensorDescription handles GET requests for sensordescription property
 func (w *WeatherAPI) handleSensorDescription(c *gin.Context) {
 	deviceId, _ := strconv.Atoi(c.Param("device_id"))
-	_, err := w.Barn.GetWeatherByIndex(deviceId)
+	device, err := w.Barn.GetWeatherByIndex(deviceId)
 	if err != nil {
 		c.String(400, "Device not found")
 		return
@@ -592,7 +624,7 @@ func (w *WeatherAPI) handleSensorDescription(c *gin.Context) {
 	}
 
 	// Check if the sensor is available (not just valid)
-	if !weather.IsSensorAvailable(sensorName) {
+	if !weather.SupportsSensor(device, sensorName) {
 		resp := alpacaResponse{
 			ClientTransactionID: 0,
 			ServerTransactionID: 0,
@@ -621,7 +653,7 @@ func (w *WeatherAPI) handleSensorDescription(c *gin.Context) {
 // handleDeviceState handles GET requests for devicestate property
 func (w *WeatherAPI) handleDeviceState(c *gin.Context) {
 	deviceId, _ := strconv.Atoi(c.Param("device_id"))
-	_, err := w.Barn.GetWeatherByIndex(deviceId)
+	device, err := w.Barn.GetWeatherByIndex(deviceId)
 	if err != nil {
 		c.String(400, "Device not found")
 		return
@@ -636,8 +668,8 @@ func (w *WeatherAPI) handleDeviceState(c *gin.Context) {
 	deviceStates := make([]DeviceState, 0)
 
 	// Add only available sensors
-	for sensorName, available := range weather.AvailableSensors {
-		if available {
+	for sensorName := range weather.AvailableSensors {
+		if weather.SupportsSensor(device, sensorName) {
 			deviceStates = append(deviceStates, DeviceState{
 				Name:  sensorName,
 				Value: true,
@@ -650,6 +682,7 @@ func (w *WeatherAPI) handleDeviceState(c *gin.Context) {
 		Name:  weather.SensorTimeStamp,
 		Value: true,
 	})
+	deviceStates = append(deviceStates, healthDeviceState(w.Barn.GetWeatherHealth(device.GetId()))...)
 
 	resp := deviceStateResponse{
 		Value: deviceStates,
@@ -703,9 +736,14 @@ func (w *WeatherAPI) handleAction(c *gin.Context) {
 		return
 	}
 
-	switch action {
-	case "Refresh":
+	dummy, isDummy := device.(*weather.ObservingConditionsDummy)
+	switch {
+	case action == "Refresh":
 		w.handleRefreshAction(deviceId, device, c)
+	case action == "Health":
+		w.handleHealthAction(c, w.Barn.GetWeatherHealth(device.GetId()))
+	case action == "SetValues" && isDummy:
+		w.handleSetValuesAction(c, dummy)
 	default:
 		c.String(400, "The device did not understand which operation was being requested or insufficient information was given to complete the operation.")
 		return
@@ -731,13 +769,15 @@ func (w *WeatherAPI) handleRefresh(c *gin.Context) {
 
 // handleRefreshAction is a shared method that handles the refresh action logic
 func (w *WeatherAPI) handleRefreshAction(deviceId int, device weather.ObservingConditions, c *gin.Context) {
-	err := device.Refresh()
+	err := w.Barn.RefreshWeather(device.GetId())
 	if err != nil {
+		cause := health.Cause(err)
 		log.WithFields(log.Fields{
 			"deviceid": deviceId,
 			"weather":  device.GetName(),
 			"error":    err,
-		}).Error(fmt.Sprintf("[BARN] Weather [%s]. Failed to refresh: %v", device.GetName(), err))
+			"cause":    cause,
+		}).Error(fmt.Sprintf("[BARN] Weather [%s]. Failed to refresh (%s): %v", device.GetName(), cause, err))
 		c.String(500, "Failed to refresh weather data")
 		return
 	}
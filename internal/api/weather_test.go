@@ -1,9 +1,17 @@
 package api
 
 import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/health"
+	"github.com/thebuh/barn/internal/weather"
 )
 
 func TestWeatherAPI_ConnectClient(t *testing.T) {
@@ -289,3 +297,101 @@ func TestWeatherAPI_SupportedActions_IncludesRefresh(t *testing.T) {
 	assert.Equal(t, "Refresh", resp.Value[0], "Supported action should be Refresh")
 	assert.Equal(t, uint32(1), resp.ClientTransactionID, "ClientTransactionID should match")
 }
+
+// skyStation reports only SkyQuality, like a sky quality meter
+type skyStation struct {
+	*weather.ObservingConditionsDummy
+}
+
+func (skyStation) SupportsSensor(sensorName string) bool {
+	return sensorName == weather.SensorSkyQuality
+}
+
+func TestWeatherAPI_StationSensors(t *testing.T) {
+	barn := app.New()
+	sqm, err := weather.NewObservingConditionsDummyWithValues("sqm", "SQM", "Sky quality meter", map[string]float64{"sky_quality": 21.3})
+	assert.NoError(t, err, "should work")
+	barn.AddWeather(skyStation{sqm})
+	srv := NewApiServer(barn, 0)
+	router := srv.Router()
+	srv.Devices["observingconditions"][0].ConnectClient(ClientId("192.0.2.1-1"))
+
+	errorNumber := func(property string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/observingconditions/0/"+property+"ClientID=1&ClientTransactionID=1", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp alpacaResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), "should work")
+		return int(resp.ErrorNumber)
+	}
+	assert.Equal(t, 0, errorNumber("skyquality?"), "they should be equal")
+	assert.Equal(t, 0x400, errorNumber("temperature?"), "they should be equal")
+	assert.Equal(t, 0x400, errorNumber("humidity?"), "they should be equal")
+	assert.Equal(t, 0, errorNumber("sensordescription?SensorName=SkyQuality&"), "they should be equal")
+}
+
+// failingStation fails to refresh while err is set and reads a temperature
+// otherwise
+type failingStation struct {
+	*weather.ObservingConditionsDummy
+	err error
+}
+
+func (s *failingStation) Refresh() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.SetValues(map[string]float64{"temperature": 12})
+}
+
+func TestWeatherAPI_Health(t *testing.T) {
+	barn := app.New()
+	station := &failingStation{weather.NewObservingConditionsDummy("station", "Station", ""), &net.DNSError{Err: "no such host", Name: "station.invalid", IsNotFound: true}}
+	barn.AddWeather(station)
+	router := NewApiServer(barn, 0).Router()
+	alpacaPut(router, "/api/v1/observingconditions/0/connected", url.Values{"Connected": {"true"}})
+
+	get := func(property string, v interface{}) {
+		w := alpacaGet(router, "/api/v1/observingconditions/0/"+property)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v), "should work")
+	}
+	var reading alpacaResponse
+	get("temperature", &reading)
+	assert.Equal(t, int32(0x500), reading.ErrorNumber, "stations without readings should not report zeroes")
+	assert.Equal(t, "No readings from Station yet", reading.ErrorMessage, "they should be equal")
+
+	assert.Error(t, barn.RefreshWeather("station"), "should fail")
+	get("temperature", &reading)
+	assert.Equal(t, int32(0x500), reading.ErrorNumber, "stations that never refreshed should not report zeroes")
+	assert.Contains(t, reading.ErrorMessage, "dns", "the cause should be reported")
+	get("timesincelastupdate", &reading)
+	assert.Equal(t, int32(0x500), reading.ErrorNumber, "they should be equal")
+
+	var actions stringlistResponse
+	get("supportedactions", &actions)
+	assert.Contains(t, actions.Value, "Health", "should be supported")
+	var action stringResponse
+	w := alpacaPut(router, "/api/v1/observingconditions/0/action", url.Values{"Action": {"Health"}, "Parameters": {""}})
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &action), "should work")
+	var status health.Status
+	assert.NoError(t, json.Unmarshal([]byte(action.Value), &status), "should work")
+	assert.Equal(t, health.CauseDNS, status.LastErrorCause, "they should be equal")
+	assert.Equal(t, 1, status.ConsecutiveFailures, "they should be equal")
+
+	station.err = nil
+	w = alpacaPut(router, "/api/v1/observingconditions/0/action", url.Values{"Action": {"Refresh"}, "Parameters": {""}})
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	get("temperature", &reading)
+	assert.Equal(t, int32(0), reading.ErrorNumber, "stations should answer once refreshed")
+
+	var state deviceStateResponse
+	get("devicestate", &state)
+	extras := make(map[string]interface{})
+	for _, s := range state.Value {
+		extras[s.Name] = s.Value
+	}
+	assert.Equal(t, float64(1), extras["ErrorCount"], "they should be equal")
+	assert.Equal(t, float64(0), extras["ConsecutiveFailures"], "they should be equal")
+	assert.Equal(t, health.CauseDNS, extras["LastErrorCause"], "they should be equal")
+}
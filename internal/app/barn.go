@@ -3,11 +3,18 @@ package app
 import (
 	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"sort"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"github.com/thebuh/barn/internal/dome"
+	"github.com/thebuh/barn/internal/health"
 	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/record"
 	"github.com/thebuh/barn/internal/weather"
 )
 
@@ -18,141 +25,374 @@ type Server interface {
 	GetWeatherIds() []string
 	GetWeather(Id string) weather.ObservingConditions
 	GetWeatherByIndex(index int) (weather.ObservingConditions, error)
+	GetDomeIds() []string
+	GetDome(Id string) dome.Dome
+	GetDomeByIndex(index int) (dome.Dome, error)
+	GetMonitorHealth(id string) health.Status
+	GetWeatherHealth(id string) health.Status
+	RefreshMonitor(id string) error
+	RefreshWeather(id string) error
 }
 
+// server keeps device ids sorted alphabetically, which is the order Alpaca
+// clients see them in. mu guards the maps and id slices so devices can be looked up
+// by API handlers while the refresh loop or config reload is running.
 type server struct {
-	monitors map[string]monitor.SafetyMonitor
-	weather  map[string]weather.ObservingConditions
+	mu         sync.RWMutex
+	monitors   map[string]monitor.SafetyMonitor
+	monitorIds []string
+	weather    map[string]weather.ObservingConditions
+	weatherIds []string
+	domes      map[string]dome.Dome
+	domeIds    []string
+	listeners  []Listener
+	overrides  *override.Manager
+	recorder   *record.Recorder
+
+	monitorHealth *health.Tracker
+	weatherHealth *health.Tracker
+
+	// configMu guards the configuration the devices were loaded from and
+	// serializes changes to it
+	configMu    sync.Mutex
+	config      *viper.Viper
+	deviceTypes map[string]string // keyed by section and device id
 }
 
 func New() *server {
 	var server = server{}
 	server.monitors = make(map[string]monitor.SafetyMonitor)
 	server.weather = make(map[string]weather.ObservingConditions)
+	server.domes = make(map[string]dome.Dome)
+	server.deviceTypes = make(map[string]string)
+	server.monitorHealth = health.NewTracker()
+	server.weatherHealth = health.NewTracker()
 	return &server
 }
 
-func (s *server) LoadMonitorsFromConfig(v *viper.Viper) {
-	http := v.GetStringMap("monitors.http")
-	if http != nil {
-		for id := range http {
-			vt := v.Sub(fmt.Sprintf("monitors.http.%s", id))
-			rule := monitor.NewSafetyMatchingRule(vt.GetBool("rule.invert"), vt.GetString("rule.pattern"))
-			sm := monitor.NewSafetyMonitorHttp(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("url"), rule)
-			s.AddMonitor(sm)
-		}
-	}
-	file := v.GetStringMap("monitors.file")
-	if file != nil {
-		for id := range file {
-			vt := v.Sub(fmt.Sprintf("monitors.file.%s", id))
-			rule := monitor.NewSafetyMatchingRule(vt.GetBool("rule.invert"), vt.GetString("rule.pattern"))
-			sm := monitor.NewSafetyMonitorFile(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("path"), rule)
-			s.AddMonitor(sm)
-		}
+// sortedKeys returns config section keys in a stable order so devices are
+// loaded and logged the same way on every start
+func sortedKeys(section map[string]interface{}) []string {
+	keys := make([]string, 0, len(section))
+	for key := range section {
+		keys = append(keys, key)
 	}
-	dummy := v.GetStringMap("monitors.dummy")
-	if dummy != nil {
-		for id := range dummy {
-			vt := v.Sub(fmt.Sprintf("monitors.dummy.%s", id))
-			sm := monitor.NewSafetyMonitorDummy(id, vt.GetString("name"), vt.GetString("description"), vt.GetBool("is_safe"))
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *server) LoadMonitorsFromConfig(v *viper.Viper) {
+	s.useConfig(v)
+	for _, monitorType := range monitorTypes {
+		section := v.GetStringMap(fmt.Sprintf("%s.%s", SectionMonitors, monitorType))
+		for _, id := range sortedKeys(section) {
+			vt := v.Sub(fmt.Sprintf("%s.%s.%s", SectionMonitors, monitorType, id))
+			sm, err := monitorBuilders[monitorType](id, vt)
+			if err != nil {
+				log.WithError(err).Error(fmt.Sprintf("[BARN] Monitor [%s]. Invalid configuration, skipping", id))
+				continue
+			}
+			s.setDeviceType(SectionMonitors, id, monitorType)
 			s.AddMonitor(sm)
 		}
 	}
 }
 
 func (s *server) LoadWeatherFromConfig(v *viper.Viper) {
-	weatherConfig := v.GetStringMap("weather.dummy")
-	if weatherConfig != nil {
-		for id := range weatherConfig {
-			vt := v.Sub(fmt.Sprintf("weather.dummy.%s", id))
-			wt := weather.NewObservingConditionsDummy(id, vt.GetString("name"), vt.GetString("description"))
+	s.useConfig(v)
+	for _, weatherType := range weatherTypes {
+		section := v.GetStringMap(fmt.Sprintf("%s.%s", SectionWeather, weatherType))
+		for _, id := range sortedKeys(section) {
+			vt := v.Sub(fmt.Sprintf("%s.%s.%s", SectionWeather, weatherType, id))
+			wt, err := weatherBuilders[weatherType](id, vt)
+			if err != nil {
+				log.WithError(err).Error(fmt.Sprintf("[BARN] Weather [%s]. Invalid configuration, skipping", id))
+				continue
+			}
+			s.setDeviceType(SectionWeather, id, weatherType)
 			s.AddWeather(wt)
 		}
 	}
-	weatherConfig = v.GetStringMap("weather.http")
-	if weatherConfig != nil {
-		for id := range weatherConfig {
-			vt := v.Sub(fmt.Sprintf("weather.http.%s", id))
-			wt, _ := weather.NewObservingConditionsHttp(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("url"))
-			s.AddWeather(wt)
+}
+
+// LoadDomesFromConfig loads domes, which are linked to monitors by id and
+// may be loaded before or after them
+func (s *server) LoadDomesFromConfig(v *viper.Viper) {
+	s.useConfig(v)
+	for _, domeType := range domeTypes {
+		section := v.GetStringMap(fmt.Sprintf("%s.%s", SectionDomes, domeType))
+		for _, id := range sortedKeys(section) {
+			vt := v.Sub(fmt.Sprintf("%s.%s.%s", SectionDomes, domeType, id))
+			d, err := domeBuilders[domeType](id, vt)
+			if err != nil {
+				log.WithError(err).Error(fmt.Sprintf("[BARN] Dome [%s]. Invalid configuration, skipping", id))
+				continue
+			}
+			s.setDeviceType(SectionDomes, id, domeType)
+			s.AddDome(d)
 		}
 	}
 }
 
+// useConfig remembers the configuration devices were loaded from, so their
+// settings can be changed later
+func (s *server) useConfig(v *viper.Viper) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.config = v
+}
+
+func (s *server) setDeviceType(section string, id string, deviceType string) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.deviceTypes[deviceKey(section, id)] = deviceType
+}
+
 func (s *server) AddWeather(weather weather.ObservingConditions) {
+	s.mu.Lock()
+	useRecorder(s.recorder, record.KindWeather, weather.GetId(), weather)
+	previous, exists := s.weather[weather.GetId()]
+	if !exists {
+		s.weatherIds = insertSorted(s.weatherIds, weather.GetId())
+	}
 	s.weather[weather.GetId()] = weather
+	s.mu.Unlock()
+	if exists && previous != weather {
+		s.weatherHealth.Forget(weather.GetId())
+		closeDevice(previous)
+	}
 }
 
 func (s *server) AddMonitor(mon monitor.SafetyMonitor) {
+	s.mu.Lock()
+	useRecorder(s.recorder, record.KindMonitor, mon.GetId(), mon)
+	previous, exists := s.monitors[mon.GetId()]
+	if !exists {
+		s.monitorIds = insertSorted(s.monitorIds, mon.GetId())
+	}
 	s.monitors[mon.GetId()] = mon
+	s.mu.Unlock()
+	if exists && previous != mon {
+		s.monitorHealth.Forget(mon.GetId())
+		closeDevice(previous)
+	}
+}
+
+// AddDome adds or replaces a dome. Its safety monitor is looked up through
+// GetMonitor, so overrides apply.
+func (s *server) AddDome(d dome.Dome) {
+	d.UseSafety(s.GetMonitor)
+	s.mu.Lock()
+	previous, exists := s.domes[d.GetId()]
+	if !exists {
+		s.domeIds = insertSorted(s.domeIds, d.GetId())
+	}
+	s.domes[d.GetId()] = d
+	s.mu.Unlock()
+	if exists && previous != d {
+		closeDevice(previous)
+	}
+}
+
+// insertSorted adds an id to ids kept in alphabetical order. Devices are
+// numbered alphabetically by id, so adding or removing a device can renumber
+// the others.
+func insertSorted(ids []string, id string) []string {
+	i, _ := slices.BinarySearch(ids, id)
+	return slices.Insert(ids, i, id)
 }
 
 func (s *server) RemoveMonitor(id string) {
+	s.mu.Lock()
+	previous, exists := s.monitors[id]
 	delete(s.monitors, id)
+	s.monitorIds = slices.DeleteFunc(s.monitorIds, func(key string) bool {
+		return key == id
+	})
+	s.mu.Unlock()
+	if exists {
+		s.monitorHealth.Forget(id)
+		closeDevice(previous)
+	}
 }
 
-func (s *server) GetMonitorIds() []string {
-	keys := make([]string, 0)
-	for key := range s.monitors {
-		keys = append(keys, key)
+// closeDevice releases connections held by a replaced or removed device
+func closeDevice(device interface{}) {
+	if closer, ok := device.(io.Closer); ok {
+		_ = closer.Close()
 	}
-	sort.Sort(sort.StringSlice(keys))
-	return keys
+}
+
+func (s *server) GetMonitorIds() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.monitorIds)
 }
 
 func (s *server) GetMonitor(id string) monitor.SafetyMonitor {
-	return s.monitors[id]
+	s.mu.RLock()
+	m, overrides := s.monitors[id], s.overrides
+	s.mu.RUnlock()
+	return withOverride(overrides, m)
 }
 
 func (s *server) GetMonitorByIndex(id int) (monitor.SafetyMonitor, error) {
-	keys := s.GetMonitorIds()
-	if id > len(keys)-1 || id < 0 {
+	s.mu.RLock()
+	if id > len(s.monitorIds)-1 || id < 0 {
+		s.mu.RUnlock()
 		return nil, errors.New("Index out of range")
 	}
-	return s.monitors[keys[id]], nil
+	m, overrides := s.monitors[s.monitorIds[id]], s.overrides
+	s.mu.RUnlock()
+	return withOverride(overrides, m), nil
 }
 
-func (s *server) GetWeatherIds() []string {
-	keys := make([]string, 0)
-	for key := range s.weather {
-		keys = append(keys, key)
+// UseOverrides makes monitors report the state forced by active overrides.
+// Refreshes and their listeners still see the measured state.
+func (s *server) UseOverrides(overrides *override.Manager) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides = overrides
+}
+
+// UseRecorder records the payloads fetched by monitors and weather stations,
+// including devices added later
+func (s *server) UseRecorder(recorder *record.Recorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recorder = recorder
+	for id, m := range s.monitors {
+		useRecorder(recorder, record.KindMonitor, id, m)
+	}
+	for id, w := range s.weather {
+		useRecorder(recorder, record.KindWeather, id, w)
+	}
+}
+
+// useRecorder hooks a device that fetches payloads to the recorder
+func useRecorder(recorder *record.Recorder, kind string, id string, device interface{}) {
+	if r, ok := device.(record.Recordable); ok && recorder != nil {
+		r.UseRecorder(recorder.Hook(kind, id))
 	}
-	sort.Sort(sort.StringSlice(keys))
-	return keys
+}
+
+// withOverride wraps a monitor with its active override
+func withOverride(overrides *override.Manager, m monitor.SafetyMonitor) monitor.SafetyMonitor {
+	if overrides == nil {
+		return m
+	}
+	return overrides.Wrap(m)
+}
+
+func (s *server) GetWeatherIds() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.weatherIds)
 }
 
 func (s *server) GetWeather(id string) weather.ObservingConditions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.weather[id]
 }
 
 func (s *server) GetWeatherByIndex(id int) (weather.ObservingConditions, error) {
-	keys := s.GetWeatherIds()
-	if id > len(keys)-1 || id < 0 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if id > len(s.weatherIds)-1 || id < 0 {
 		return nil, errors.New("Index out of range")
 	}
-	return s.weather[keys[id]], nil
+	return s.weather[s.weatherIds[id]], nil
+}
+
+func (s *server) GetDomeIds() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.domeIds)
+}
+
+func (s *server) GetDome(id string) dome.Dome {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.domes[id]
+}
+
+func (s *server) GetDomeByIndex(id int) (dome.Dome, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if id > len(s.domeIds)-1 || id < 0 {
+		return nil, errors.New("Index out of range")
+	}
+	return s.domes[s.domeIds[id]], nil
 }
 
 func (s *server) Refresh() {
-	for _, val := range s.monitors {
-		m := val
+	s.mu.RLock()
+	monitors := make([]monitor.SafetyMonitor, 0, len(s.monitors))
+	for _, m := range s.monitors {
+		monitors = append(monitors, m)
+	}
+	overrides := s.overrides
+	stations := make([]weather.ObservingConditions, 0, len(s.weather))
+	for _, w := range s.weather {
+		stations = append(stations, w)
+	}
+	domes := make([]dome.Dome, 0, len(s.domes))
+	for _, d := range s.domes {
+		domes = append(domes, d)
+	}
+	s.mu.RUnlock()
+
+	if overrides != nil {
+		overrides.Expire()
+	}
+	for _, m := range monitors {
 		go func() {
-			m.Refresh()
+			refresh := s.refreshMonitor(m)
+			if refresh.Err != nil {
+				logRefreshError(fmt.Sprintf("[BARN] Monitor [%s]", m.GetName()), refresh.Err, refresh.Health)
+			}
 			log.WithFields(log.Fields{
 				"monitor": m.GetName(),
-				"state":   m.IsSafe(),
-			}).Info(fmt.Sprintf("[BARN] Monitor [%s]. Refreshing state. Now: [%t]", m.GetName(), m.IsSafe()))
+				"state":   refresh.Safe,
+			}).Info(fmt.Sprintf("[BARN] Monitor [%s]. Refreshing state. Now: [%t]", m.GetName(), refresh.Safe))
 		}()
 	}
-	for _, val := range s.weather {
-		w := val
+	for _, w := range stations {
 		go func() {
-			w.Refresh()
+			refresh := s.refreshWeather(w)
+			if refresh.Err != nil {
+				logRefreshError(fmt.Sprintf("[BARN] Weather [%s]", w.GetName()), refresh.Err, refresh.Health)
+				return
+			}
 			log.WithFields(log.Fields{
 				"weather": w.GetName(),
 				"state":   w.GetState(),
 			}).Info(fmt.Sprintf("[BARN] Weather [%s]. Refreshing state.", w.GetName()))
 		}()
 	}
+	for _, d := range domes {
+		go func() {
+			if err := d.Refresh(); err != nil {
+				log.WithError(err).Error(fmt.Sprintf("[BARN] Dome [%s]. Failed to refresh", d.GetName()))
+				return
+			}
+			state := d.ShutterStatus()
+			log.WithFields(log.Fields{
+				"dome":  d.GetName(),
+				"state": state.String(),
+			}).Info(fmt.Sprintf("[BARN] Dome [%s]. Refreshing state. Now: [%s]", d.GetName(), state))
+		}()
+	}
+}
+
+// logRefreshError logs why a device failed to refresh, with the cause and
+// how long it has been failing
+func logRefreshError(device string, err error, status health.Status) {
+	log.WithFields(log.Fields{
+		"error":                err,
+		"cause":                status.LastErrorCause,
+		"consecutive_failures": status.ConsecutiveFailures,
+		"error_count":          status.ErrorCount,
+	}).Error(fmt.Sprintf("%s. Failed to refresh (%s, %d in a row): %v", device, status.LastErrorCause, status.ConsecutiveFailures, err))
 }
@@ -2,10 +2,21 @@ package app
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/dome"
+	"github.com/thebuh/barn/internal/health"
 	"github.com/thebuh/barn/internal/monitor"
-	"testing"
+	"github.com/thebuh/barn/internal/override"
+	"github.com/thebuh/barn/internal/record"
+	"github.com/thebuh/barn/internal/weather"
 )
 
 func LoadTestConfig() {
@@ -55,6 +66,31 @@ func TestBarnServer_RemoveMonitorTest(t *testing.T) {
 	barn.RemoveMonitor("dummy")
 	assert.Nil(t, barn.monitors["dummy"], "should be nil")
 }
+
+// closingMonitor counts how often it was closed
+type closingMonitor struct {
+	*monitor.SafetyMonitorDummy
+	closed *int
+}
+
+func (m closingMonitor) Close() error {
+	*m.closed++
+	return nil
+}
+
+func TestBarnServer_ClosesReplacedDevices(t *testing.T) {
+	var barn = New()
+	var closed int
+	first := closingMonitor{monitor.NewSafetyMonitorDummy("socket", "name", "description", true), &closed}
+	barn.AddMonitor(first)
+	barn.AddMonitor(first)
+	assert.Equal(t, 0, closed, "adding the same monitor again should not close it")
+	barn.AddMonitor(closingMonitor{monitor.NewSafetyMonitorDummy("socket", "name", "description", true), &closed})
+	assert.Equal(t, 1, closed, "replaced monitors should be closed")
+	barn.RemoveMonitor("socket")
+	assert.Equal(t, 2, closed, "removed monitors should be closed")
+}
+
 func TestBarnServer_GetMonitorByIndex(t *testing.T) {
 	var barn = New()
 	sm := monitor.NewSafetyMonitorDummy("dummy", "name", "description", false)
@@ -62,10 +98,10 @@ func TestBarnServer_GetMonitorByIndex(t *testing.T) {
 	sm2 := monitor.NewSafetyMonitorDummy("aummy2", "name", "description", true)
 	barn.AddMonitor(sm2)
 	smr, _ := barn.GetMonitorByIndex(0)
-	assert.Equal(t, "dummy", smr.GetId(), "should be equal")
-	assert.Equal(t, false, smr.IsSafe(), "should be equal")
+	assert.Equal(t, "aummy2", smr.GetId(), "monitors should be numbered alphabetically")
+	assert.Equal(t, true, smr.IsSafe(), "should be equal")
 	smr, _ = barn.GetMonitorByIndex(1)
-	assert.Equal(t, "aummy2", smr.GetId(), "should be equal")
+	assert.Equal(t, "dummy", smr.GetId(), "should be equal")
 	_, err := barn.GetMonitorByIndex(2)
 	assert.Error(t, err, "should be error")
 }
@@ -79,20 +115,161 @@ func TestBarnServer_LoadConfig(t *testing.T) {
 	assert.NotNil(t, barn.GetMonitor("remote2"), "shouldn't be nil")
 	switch v := barn.GetMonitor("remote").(type) {
 	case *monitor.SafetyMonitorHttp:
-		assert.Equal(t, "http://127.0.0.1/test", v.url, "should be equal")
+		assert.Equal(t, "http://127.0.0.1/test", v.GetUrl(), "should be equal")
 		assert.Equal(t, "Some remote url", v.GetName(), "should be equal")
 		assert.Equal(t, "Some remote url description", v.GetDescription(), "should be equal")
-		assert.Equal(t, "'^[A-Z]+\\.com$", v.rule.pattern, "should be equal")
-		assert.Equal(t, false, v.rule.invert, "should be equal")
+		assert.Equal(t, "'^[A-Z]+\\.com$", v.GetRule().GetPattern(), "should be equal")
+		assert.Equal(t, false, v.GetRule().IsInverted(), "should be equal")
 	default:
 		assert.Fail(t, "Wrong type")
 	}
 	assert.NotNil(t, barn.GetMonitor("local"), "shouldn't be nil")
 	switch v := barn.GetMonitor("local").(type) {
 	case *monitor.SafetyMonitorFile:
-		assert.Equal(t, v.path, "/tmp/test", "should be equal")
+		assert.Equal(t, v.GetPath(), "/tmp/test", "should be equal")
 	default:
 		assert.Fail(t, "Wrong type")
 	}
 	assert.NotNil(t, barn.GetMonitor("fake"), "shouldn't be nil")
 }
+
+func TestBarnServer_ConcurrentAccess(t *testing.T) {
+	var barn = New()
+	barn.AddWeather(weather.NewObservingConditionsDummy("station", "Station", "Dummy station"))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(id string) {
+			defer wg.Done()
+			barn.AddMonitor(monitor.NewSafetyMonitorDummy(id, "name", "description", true))
+			barn.Refresh()
+		}(fmt.Sprintf("dummy%d", i))
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				for _, id := range barn.GetMonitorIds() {
+					barn.GetMonitor(id)
+				}
+				barn.GetMonitorByIndex(0)
+				barn.GetWeatherByIndex(0)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, barn.GetMonitorIds(), 10, "all monitors should be registered")
+}
+
+// recordingListener keeps the refreshes it is notified of
+type recordingListener struct {
+	monitors []MonitorRefresh
+	weather  []WeatherRefresh
+}
+
+func (l *recordingListener) MonitorRefreshed(refresh MonitorRefresh) {
+	l.monitors = append(l.monitors, refresh)
+}
+
+func (l *recordingListener) WeatherRefreshed(refresh WeatherRefresh) {
+	l.weather = append(l.weather, refresh)
+}
+
+func TestBarnServer_Overrides(t *testing.T) {
+	var barn = New()
+	sm := monitor.NewSafetyMonitorDummy("dummy", "name", "description", true)
+	barn.AddMonitor(sm)
+	overrides, _ := override.Open("")
+	barn.UseOverrides(overrides)
+	assert.Same(t, sm, barn.GetMonitor("dummy"), "monitors without override should not be wrapped")
+
+	err := overrides.Set(override.Override{Monitor: "dummy", Safe: false, SetBy: "test", Expires: time.Now().Add(time.Hour)})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, false, barn.GetMonitor("dummy").IsSafe(), "they should be equal")
+	m, _ := barn.GetMonitorByIndex(0)
+	assert.Equal(t, false, m.IsSafe(), "they should be equal")
+	assert.Equal(t, true, barn.refreshMonitor(sm).Safe, "refreshes should report the measured state")
+
+	listener := &recordingListener{}
+	barn.AddListener(listener)
+	assert.NoError(t, barn.RefreshMonitor("dummy"), "should work")
+	assert.Equal(t, true, listener.monitors[0].Safe, "listeners should see the measured state")
+	assert.Same(t, sm, listener.monitors[0].Monitor, "listeners should get the monitor without override")
+}
+
+func TestBarnServer_Domes(t *testing.T) {
+	var barn = New()
+	sm := monitor.NewSafetyMonitorDummy("weather", "Weather", "", false)
+	barn.AddMonitor(sm)
+	simulator, _ := dome.NewSimulator(time.Millisecond, true)
+	roof, _ := dome.NewRoof("roof", "Roof", "", simulator, dome.Options{SafetyMonitor: "weather", AutoClose: true})
+	barn.AddDome(roof)
+	assert.Equal(t, []string{"roof"}, barn.GetDomeIds(), "they should be equal")
+	d, err := barn.GetDomeByIndex(0)
+	assert.NoError(t, err, "should work")
+	assert.Same(t, roof, d, "they should be the same")
+	_, err = barn.GetDomeByIndex(1)
+	assert.Error(t, err, "should be error")
+	assert.ErrorIs(t, barn.GetDome("roof").OpenShutter(), dome.ErrUnsafe, "domes should follow their monitor")
+
+	overrides, _ := override.Open("")
+	barn.UseOverrides(overrides)
+	overrides.Set(override.Override{Monitor: "weather", Safe: true, SetBy: "test", Expires: time.Now().Add(time.Hour)})
+	assert.NoError(t, roof.OpenShutter(), "domes should follow overrides")
+
+	overrides.Clear("weather", "test")
+	assert.NoError(t, roof.Refresh(), "should work")
+	assert.Eventually(t, func() bool {
+		return roof.ShutterStatus() == dome.ShutterClosed
+	}, 3*time.Second, 10*time.Millisecond, "unsafe domes should close on refresh")
+}
+
+func TestBarnServer_Recorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	roofPath := filepath.Join(t.TempDir(), "roof")
+	assert.NoError(t, os.WriteFile(roofPath, []byte("open"), 0644), "should work")
+	recorder, err := record.Open(path)
+	assert.NoError(t, err, "should work")
+
+	var barn = New()
+	barn.AddMonitor(monitor.NewSafetyMonitorFile("before", "Before", "", roofPath, monitor.NewSafetyMatchingRule(false, "open")))
+	barn.UseRecorder(recorder)
+	barn.AddMonitor(monitor.NewSafetyMonitorFile("after", "After", "", roofPath, monitor.NewSafetyMatchingRule(false, "open")))
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("fake", "Fake", "", true))
+	for _, id := range barn.GetMonitorIds() {
+		barn.GetMonitor(id).Refresh()
+	}
+	assert.NoError(t, recorder.Close(), "should work")
+
+	for _, id := range []string{"before", "after"} {
+		records, err := record.Load(path, record.KindMonitor, id)
+		assert.NoError(t, err, "should work")
+		assert.Len(t, records, 1, id+" should be recorded")
+		assert.Equal(t, "open", records[0].Payload, "they should be equal")
+	}
+}
+
+func TestBarnServer_Health(t *testing.T) {
+	roofPath := filepath.Join(t.TempDir(), "roof")
+	var barn = New()
+	barn.AddMonitor(monitor.NewSafetyMonitorFile("roof", "Roof", "", roofPath, monitor.NewSafetyMatchingRule(false, "open")))
+	barn.AddWeather(weather.NewObservingConditionsDummy("station", "Station", ""))
+
+	assert.Error(t, barn.RefreshMonitor("roof"), "missing files should fail")
+	assert.Error(t, barn.RefreshMonitor("roof"), "missing files should fail")
+	status := barn.GetMonitorHealth("roof")
+	assert.Equal(t, 2, status.ConsecutiveFailures, "they should be equal")
+	assert.Equal(t, health.CauseOther, status.LastErrorCause, "they should be equal")
+	assert.True(t, status.LastSuccess.IsZero(), "should not have succeeded")
+
+	assert.NoError(t, os.WriteFile(roofPath, []byte("open"), 0644), "should work")
+	assert.NoError(t, barn.RefreshMonitor("roof"), "should work")
+	status = barn.GetMonitorHealth("roof")
+	assert.Equal(t, 0, status.ConsecutiveFailures, "they should be equal")
+	assert.Equal(t, 2, status.ErrorCount, "they should be equal")
+
+	assert.NoError(t, barn.RefreshWeather("station"), "should work")
+	assert.False(t, barn.GetWeatherHealth("station").LastSuccess.IsZero(), "should have succeeded")
+	assert.Error(t, barn.RefreshWeather("missing"), "should fail")
+
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("roof", "Roof", "", true))
+	assert.Equal(t, health.Status{}, barn.GetMonitorHealth("roof"), "replaced devices should start over")
+}
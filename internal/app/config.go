@@ -0,0 +1,910 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/thebuh/barn/internal/dome"
+	"github.com/thebuh/barn/internal/fetch"
+	"github.com/thebuh/barn/internal/modbus"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/record"
+	"github.com/thebuh/barn/internal/serial"
+	"github.com/thebuh/barn/internal/socket"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// Config sections holding device definitions
+const (
+	SectionMonitors = "monitors"
+	SectionWeather  = "weather"
+	SectionDomes    = "domes"
+)
+
+// Setting value types
+const (
+	SettingText   = "text"
+	SettingBool   = "bool"
+	SettingNumber = "number"
+)
+
+var (
+	ErrDeviceNotConfigured = errors.New("device is not defined in the configuration")
+	ErrUnknownSetting      = errors.New("setting cannot be changed")
+)
+
+// Setting is one value of a device config section
+type Setting struct {
+	// Key is relative to the device section, e.g. rule.pattern
+	Key      string
+	Label    string
+	Type     string
+	Value    string
+	ReadOnly bool
+}
+
+// Configurator exposes the settings of devices loaded from the configuration
+type Configurator interface {
+	DeviceType(section string, id string) (string, error)
+	DeviceSettings(section string, id string) ([]Setting, error)
+	UpdateDeviceSettings(section string, id string, values map[string]string) error
+}
+
+type monitorBuilder func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error)
+
+type weatherBuilder func(id string, vt *viper.Viper) (weather.ObservingConditions, error)
+
+type domeBuilder func(id string, vt *viper.Viper) (dome.Dome, error)
+
+// monitorTypes lists the monitor types in load order
+var monitorTypes = []string{"http", "file", "dummy", "astro", "schedule", "socket", "serial", "modbus", "simulator", "replay", "push"}
+
+var monitorBuilders = map[string]monitorBuilder{
+	"http": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		if err := validateURL(vt.GetString("url")); err != nil {
+			return nil, err
+		}
+		rule, err := ruleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		options, err := fetch.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return monitor.NewSafetyMonitorHttpWithOptions(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("url"), rule, options)
+	},
+	"file": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		rule, err := ruleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return monitor.NewSafetyMonitorFile(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("path"), rule), nil
+	},
+	"dummy": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		return monitor.NewSafetyMonitorDummy(id, vt.GetString("name"), vt.GetString("description"), vt.GetBool("is_safe")), nil
+	},
+	"astro": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		config, err := astroFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return monitor.NewSafetyMonitorAstro(id, vt.GetString("name"), vt.GetString("description"), config), nil
+	},
+	"schedule": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		config, err := scheduleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return monitor.NewSafetyMonitorSchedule(id, vt.GetString("name"), vt.GetString("description"), config), nil
+	},
+	"socket": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		rule, err := ruleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		options, err := socket.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return monitor.NewSafetyMonitorSocket(id, vt.GetString("name"), vt.GetString("description"), options, socket.FieldFromConfig(vt), rule)
+	},
+	"serial": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		rule, err := ruleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		options, err := serial.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		value := monitor.LineValue(socket.FieldFromConfig(vt).Extract)
+		switch vt.GetString("parser") {
+		case "":
+		case "rg15":
+			value = monitor.RG15Value
+			if options.Request == "" {
+				options.Request = serial.RG15Request
+			}
+		default:
+			return nil, fmt.Errorf("unknown parser %q", vt.GetString("parser"))
+		}
+		return monitor.NewSafetyMonitorSerial(id, vt.GetString("name"), vt.GetString("description"), options, value, rule)
+	},
+	"modbus": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		rule, err := ruleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		options, err := modbus.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		table, address, err := modbus.BitFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return monitor.NewSafetyMonitorModbus(id, vt.GetString("name"), vt.GetString("description"), options, table, address, rule)
+	},
+	"simulator": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		simulator, err := simulatorFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		limits := make(map[string]float64)
+		for sensor, value := range vt.GetStringMap("limits") {
+			limit, err := cast.ToFloat64E(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid limit for %s: %w", sensor, err)
+			}
+			limits[sensor] = limit
+		}
+		return monitor.NewSafetyMonitorSimulator(id, vt.GetString("name"), vt.GetString("description"), simulator, limits)
+	},
+	"replay": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		rule, err := ruleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		value, err := lineValueFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		replay, _, err := replayFromConfig(record.KindMonitor, id, vt)
+		if err != nil {
+			return nil, err
+		}
+		return replayMonitor{monitor.NewSafetyMonitorLine(id, vt.GetString("name"), vt.GetString("description"), replay, value, rule)}, nil
+	},
+	"push": func(id string, vt *viper.Viper) (monitor.SafetyMonitor, error) {
+		rule, err := ruleFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		value, err := lineValueFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		expiry, err := expiryFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return monitor.NewSafetyMonitorPush(id, vt.GetString("name"), vt.GetString("description"), expiry, value, rule)
+	},
+}
+
+// weatherTypes lists the weather station types in load order
+var weatherTypes = []string{"dummy", "http", "socket", "serial", "modbus", "simulator", "replay", "push"}
+
+var weatherBuilders = map[string]weatherBuilder{
+	"dummy": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		values := make(map[string]float64)
+		for sensor, value := range vt.GetStringMap("values") {
+			v, err := cast.ToFloat64E(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", sensor, err)
+			}
+			values[sensor] = v
+		}
+		return weather.NewObservingConditionsDummyWithValues(id, vt.GetString("name"), vt.GetString("description"), values)
+	},
+	"http": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		if err := validateURL(vt.GetString("url")); err != nil {
+			return nil, err
+		}
+		options, err := fetch.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return weather.NewObservingConditionsHttpWithOptions(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("url"), options)
+	},
+	"socket": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		options, err := socket.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		if isSet(vt, "preset") {
+			return weather.NewObservingConditionsSocketPreset(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("preset"), options)
+		}
+		parser, err := fieldParserFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return weather.NewObservingConditionsSocket(id, vt.GetString("name"), vt.GetString("description"), options, parser)
+	},
+	"serial": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		options, err := serial.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		if isSet(vt, "preset") {
+			return weather.NewObservingConditionsSerialPreset(id, vt.GetString("name"), vt.GetString("description"), vt.GetString("preset"), options)
+		}
+		parser, err := fieldParserFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return weather.NewObservingConditionsSerial(id, vt.GetString("name"), vt.GetString("description"), options, parser)
+	},
+	"modbus": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		options, err := modbus.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := registersFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return weather.NewObservingConditionsModbus(id, vt.GetString("name"), vt.GetString("description"), options, fields)
+	},
+	"simulator": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		simulator, err := simulatorFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return weather.NewObservingConditionsSimulator(id, vt.GetString("name"), vt.GetString("description"), simulator), nil
+	},
+	"replay": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		replay, records, err := replayFromConfig(record.KindWeather, id, vt)
+		if err != nil {
+			return nil, err
+		}
+		parser, err := lineParserFromConfig(vt, recordedSensors(records))
+		if err != nil {
+			return nil, err
+		}
+		return replayStation{weather.NewObservingConditionsLine(id, vt.GetString("name"), vt.GetString("description"), replay, parser)}, nil
+	},
+	"push": func(id string, vt *viper.Viper) (weather.ObservingConditions, error) {
+		parser, err := lineParserFromConfig(vt, nil)
+		if err != nil {
+			return nil, err
+		}
+		expiry, err := expiryFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return weather.NewObservingConditionsPush(id, vt.GetString("name"), vt.GetString("description"), expiry, parser)
+	},
+}
+
+// domeTypes lists the dome types in load order
+var domeTypes = []string{"simulator", "commands"}
+
+var domeBuilders = map[string]domeBuilder{
+	"simulator": func(id string, vt *viper.Viper) (dome.Dome, error) {
+		options, err := dome.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		simulator, err := dome.SimulatorFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return dome.NewRoof(id, vt.GetString("name"), vt.GetString("description"), simulator, options)
+	},
+	"commands": func(id string, vt *viper.Viper) (dome.Dome, error) {
+		options, err := dome.OptionsFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		controller, err := dome.ControllerFromConfig(vt)
+		if err != nil {
+			return nil, err
+		}
+		return dome.NewRoof(id, vt.GetString("name"), vt.GetString("description"), controller, options)
+	},
+}
+
+// simulationStart is when scenario events count from. It is shared so
+// simulated monitors and stations agree, also after a reload.
+var simulationStart = time.Now()
+
+// scenarioEventConfig is one entry of the events list of a scenario
+type scenarioEventConfig struct {
+	At       time.Duration      `mapstructure:"at"`
+	Duration time.Duration      `mapstructure:"duration"`
+	Values   map[string]float64 `mapstructure:"values"`
+	Safe     *bool              `mapstructure:"safe"`
+}
+
+// simulatorFromConfig reads the seed and a scenario, either inline or from
+// the file named by scenario. Unset scenario values are taken from
+// weather.DefaultScenario.
+func simulatorFromConfig(vt *viper.Viper) (*weather.Simulator, error) {
+	section := vt.Sub("scenario")
+	if path := vt.GetString("scenario"); path != "" {
+		section = viper.New()
+		section.SetConfigFile(path)
+		if err := section.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("scenario: %w", err)
+		}
+	}
+	scenario := weather.DefaultScenario
+	if section != nil {
+		for key, target := range map[string]*float64{
+			"temperature":       &scenario.Temperature,
+			"temperature_swing": &scenario.TemperatureSwing,
+			"humidity":          &scenario.Humidity,
+			"pressure":          &scenario.Pressure,
+			"wind_speed":        &scenario.WindSpeed,
+			"gustiness":         &scenario.Gustiness,
+			"wind_direction":    &scenario.WindDirection,
+			"cloud_cover":       &scenario.CloudCover,
+			"cloud_passages":    &scenario.CloudPassages,
+			"rain_showers":      &scenario.RainShowers,
+			"sky_quality":       &scenario.SkyQuality,
+		} {
+			if !isSet(section, key) {
+				continue
+			}
+			value, err := cast.ToFloat64E(section.Get(key))
+			if err != nil {
+				return nil, fmt.Errorf("scenario: invalid %s: %w", key, err)
+			}
+			*target = value
+		}
+		var events []scenarioEventConfig
+		if err := section.UnmarshalKey("events", &events); err != nil {
+			return nil, fmt.Errorf("scenario: invalid events: %w", err)
+		}
+		for _, e := range events {
+			scenario.Events = append(scenario.Events, weather.ScenarioEvent{At: e.At, Duration: e.Duration, Values: e.Values, Safe: e.Safe})
+		}
+	}
+	simulator, err := weather.NewSimulator(scenario, vt.GetInt64("seed"), simulationStart)
+	if err != nil {
+		return nil, fmt.Errorf("scenario: %w", err)
+	}
+	return simulator, nil
+}
+
+// replayMonitor and replayStation hide the recorder hook of the devices
+// they wrap, so replays are not recorded again
+type replayMonitor struct {
+	monitor.SafetyMonitor
+}
+
+type replayStation struct {
+	weather.ObservingConditions
+}
+
+func (r replayStation) SupportsSensor(sensorName string) bool {
+	return weather.SupportsSensor(r.ObservingConditions, sensorName)
+}
+
+// replayFromConfig loads the records of the device named by device, by
+// default the replaying device itself, from the recording file and replays
+// them at speed, optionally in a loop
+func replayFromConfig(kind string, id string, vt *viper.Viper) (*record.Replay, []record.Record, error) {
+	path := vt.GetString("recording")
+	if path == "" {
+		return nil, nil, errors.New("recording is required")
+	}
+	device := id
+	if isSet(vt, "device") {
+		device = vt.GetString("device")
+	}
+	records, err := record.Load(path, kind, device)
+	if err != nil {
+		return nil, nil, fmt.Errorf("recording: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("recording has no %s records of %s", kind, device)
+	}
+	replay, err := record.NewReplay(records, vt.GetFloat64("speed"), vt.GetBool("loop"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return replay, records, nil
+}
+
+// recordedSensors lists the sensors in the readings recorded as JSON
+// objects, such as those of Modbus stations
+func recordedSensors(records []record.Record) []string {
+	seen := make(map[string]bool)
+	var sensors []string
+	for _, rec := range records {
+		var readings map[string]float64
+		if json.Unmarshal([]byte(rec.Payload), &readings) != nil {
+			continue
+		}
+		for sensor := range readings {
+			if !seen[sensor] {
+				seen[sensor] = true
+				sensors = append(sensors, sensor)
+			}
+		}
+	}
+	sort.Strings(sensors)
+	return sensors
+}
+
+// lineValueFromConfig picks the value matched by the rule of monitors fed
+// whole payloads: a JSON key, a parser or a field of the payload
+func lineValueFromConfig(vt *viper.Viper) (monitor.LineValue, error) {
+	if isSet(vt, "key") {
+		return monitor.JSONValue(vt.GetString("key")), nil
+	}
+	switch vt.GetString("parser") {
+	case "":
+		return socket.FieldFromConfig(vt).Extract, nil
+	case "rg15":
+		return monitor.RG15Value, nil
+	}
+	return nil, fmt.Errorf("unknown parser %q", vt.GetString("parser"))
+}
+
+// lineParserFromConfig picks the parser of stations fed whole payloads from
+// the preset or format. The values format reads the sensors listed in the
+// config file, by default those given.
+func lineParserFromConfig(vt *viper.Viper, sensors []string) (weather.LineParser, error) {
+	if isSet(vt, "preset") {
+		preset, exists := weather.LinePresets[vt.GetString("preset")]
+		if !exists {
+			return nil, fmt.Errorf("unknown preset %q", vt.GetString("preset"))
+		}
+		return preset.Parser, nil
+	}
+	switch format := vt.GetString("format"); format {
+	case "http":
+		return weather.HTTPParser{}, nil
+	case "values":
+		if listed := vt.GetStringSlice("sensors"); len(listed) > 0 {
+			sensors = listed
+		}
+		return weather.NewValuesParser(sensors)
+	case "", "fields":
+		return fieldParserFromConfig(vt)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// expiryFromConfig reads how long pushed payloads are kept, zero for ever
+func expiryFromConfig(vt *viper.Viper) (time.Duration, error) {
+	if !isSet(vt, "expiry") {
+		return 0, nil
+	}
+	expiry, err := cast.ToDurationE(vt.Get("expiry"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiry: %w", err)
+	}
+	return expiry, nil
+}
+
+// fieldParserFromConfig reads the separator and the fields map of sensors
+// to reply fields
+func fieldParserFromConfig(vt *viper.Viper) (*weather.FieldParser, error) {
+	fields := make(map[string]int)
+	for sensor, value := range vt.GetStringMap("fields") {
+		index, err := cast.ToIntE(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field for %s: %w", sensor, err)
+		}
+		fields[sensor] = index
+	}
+	return weather.NewFieldParser(vt.GetString("separator"), fields)
+}
+
+// registersFromConfig reads the fields map of sensors to register sections
+func registersFromConfig(vt *viper.Viper) (map[string]modbus.Register, error) {
+	fields := make(map[string]modbus.Register)
+	for sensor := range vt.GetStringMap("fields") {
+		section := vt.Sub("fields." + sensor)
+		if section == nil {
+			return nil, fmt.Errorf("invalid field for %s", sensor)
+		}
+		register, err := modbus.RegisterFromConfig(section)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field for %s: %w", sensor, err)
+		}
+		fields[sensor] = register
+	}
+	return fields, nil
+}
+
+var commonSettings = []Setting{
+	{Key: "name", Label: "Name", Type: SettingText},
+	{Key: "description", Label: "Description", Type: SettingText},
+}
+
+// httpSettings leaves headers, credentials and TLS files to the config file
+var httpSettings = []Setting{
+	{Key: "url", Label: "URL", Type: SettingText},
+	{Key: "method", Label: "Method", Type: SettingText},
+	{Key: "timeout", Label: "Timeout (e.g. 5s)", Type: SettingText},
+}
+
+var socketSettings = []Setting{
+	{Key: "address", Label: "Address (host:port)", Type: SettingText, ReadOnly: true},
+	{Key: "request", Label: "Request", Type: SettingText},
+	{Key: "timeout", Label: "Timeout (e.g. 5s)", Type: SettingText},
+}
+
+var serialSettings = []Setting{
+	{Key: "device", Label: "Device", Type: SettingText, ReadOnly: true},
+	{Key: "baud", Label: "Baud rate", Type: SettingNumber},
+	{Key: "request", Label: "Request", Type: SettingText},
+	{Key: "timeout", Label: "Timeout (e.g. 5s)", Type: SettingText},
+}
+
+var modbusSettings = []Setting{
+	{Key: "address", Label: "Address (host:port)", Type: SettingText, ReadOnly: true},
+	{Key: "unit_id", Label: "Unit ID", Type: SettingNumber},
+	{Key: "timeout", Label: "Timeout (e.g. 5s)", Type: SettingText},
+}
+
+// simulatorSettings leave the scenario to the config file
+var simulatorSettings = []Setting{
+	{Key: "seed", Label: "Seed", Type: SettingNumber},
+	{Key: "scenario", Label: "Scenario file", Type: SettingText, ReadOnly: true},
+}
+
+// replaySettings leave the parser to the config file
+var replaySettings = []Setting{
+	{Key: "recording", Label: "Recording", Type: SettingText, ReadOnly: true},
+	{Key: "device", Label: "Recorded device (id)", Type: SettingText},
+	{Key: "speed", Label: "Speed (1 for real time)", Type: SettingNumber},
+	{Key: "loop", Label: "Loop", Type: SettingBool},
+}
+
+// pushSettings leave the parser to the config file
+var pushSettings = []Setting{
+	{Key: "expiry", Label: "Expiry (e.g. 10m, empty to keep)", Type: SettingText},
+}
+
+// domeSettings leave the commands and status source to the config file
+var domeSettings = []Setting{
+	{Key: "safety_monitor", Label: "Safety monitor required to open (id)", Type: SettingText},
+	{Key: "auto_close", Label: "Close when unsafe", Type: SettingBool},
+	{Key: "open_timeout", Label: "Open timeout (e.g. 2m)", Type: SettingText},
+	{Key: "close_timeout", Label: "Close timeout (e.g. 2m)", Type: SettingText},
+}
+
+var ruleSettings = []Setting{
+	{Key: "rule.pattern", Label: "Safe pattern (regular expression)", Type: SettingText},
+	// Rules ignore invert, so it is only shown
+	{Key: "rule.invert", Label: "Invert pattern", Type: SettingBool, ReadOnly: true},
+}
+
+// settingDefinitions lists the settings shown for each device type, keyed by
+// section and type. Settings that could point the server at other resources
+// are read-only.
+var settingDefinitions = map[string]map[string][]Setting{
+	SectionMonitors: {
+		"http":  concatSettings(commonSettings, httpSettings, ruleSettings),
+		"file":  concatSettings(commonSettings, []Setting{{Key: "path", Label: "Path", Type: SettingText, ReadOnly: true}}, ruleSettings),
+		"dummy": concatSettings(commonSettings, []Setting{{Key: "is_safe", Label: "Safe", Type: SettingBool}}),
+		"astro": concatSettings(commonSettings, []Setting{
+			{Key: "latitude", Label: "Latitude (°)", Type: SettingNumber},
+			{Key: "longitude", Label: "Longitude (°, east positive)", Type: SettingNumber},
+			{Key: "elevation", Label: "Elevation (m)", Type: SettingNumber},
+			{Key: "sun_altitude", Label: "Highest safe sun altitude (°)", Type: SettingNumber},
+			{Key: "sunrise_offset", Label: "Unsafe before sunrise (e.g. 30m)", Type: SettingText},
+			{Key: "moon_altitude", Label: "Highest safe moon altitude (°, empty to ignore)", Type: SettingNumber},
+			{Key: "moon_illumination", Label: "Minimum moon illumination (0-1)", Type: SettingNumber},
+		}),
+		"schedule": concatSettings(commonSettings, []Setting{
+			{Key: "timezone", Label: "Time zone (e.g. Europe/London)", Type: SettingText},
+			{Key: "calendar", Label: "Blackout calendar", Type: SettingText, ReadOnly: true},
+		}),
+		"socket": concatSettings(commonSettings, socketSettings, []Setting{
+			{Key: "field", Label: "Field to match (0 for the whole reply)", Type: SettingNumber},
+		}, ruleSettings),
+		"serial": concatSettings(commonSettings, serialSettings, []Setting{
+			{Key: "field", Label: "Field to match (0 for the whole reply)", Type: SettingNumber},
+		}, ruleSettings),
+		"modbus": concatSettings(commonSettings, modbusSettings, []Setting{
+			{Key: "table", Label: "Table (coil or discrete_input)", Type: SettingText},
+			{Key: "register", Label: "Register", Type: SettingNumber},
+		}, ruleSettings),
+		"simulator": concatSettings(commonSettings, simulatorSettings),
+		"replay": concatSettings(commonSettings, replaySettings, []Setting{
+			{Key: "field", Label: "Field to match (0 for the whole reply)", Type: SettingNumber},
+		}, ruleSettings),
+		"push": concatSettings(commonSettings, pushSettings, []Setting{
+			{Key: "field", Label: "Field to match (0 for the whole reply)", Type: SettingNumber},
+		}, ruleSettings),
+	},
+	SectionWeather: {
+		"dummy":     commonSettings,
+		"http":      concatSettings(commonSettings, httpSettings),
+		"socket":    concatSettings(commonSettings, socketSettings),
+		"serial":    concatSettings(commonSettings, serialSettings),
+		"modbus":    concatSettings(commonSettings, modbusSettings),
+		"simulator": concatSettings(commonSettings, simulatorSettings),
+		"replay":    concatSettings(commonSettings, replaySettings),
+		"push":      concatSettings(commonSettings, pushSettings),
+	},
+	SectionDomes: {
+		"simulator": concatSettings(commonSettings, domeSettings, []Setting{
+			{Key: "travel", Label: "Travel time (e.g. 10s)", Type: SettingText},
+		}),
+		"commands": concatSettings(commonSettings, domeSettings),
+	},
+}
+
+func concatSettings(groups ...[]Setting) []Setting {
+	var settings []Setting
+	for _, group := range groups {
+		settings = append(settings, group...)
+	}
+	return settings
+}
+
+func ruleFromConfig(vt *viper.Viper) (*monitor.SafetyMatchingRule, error) {
+	pattern := vt.GetString("rule.pattern")
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("invalid rule pattern: %w", err)
+	}
+	return monitor.NewSafetyMatchingRule(vt.GetBool("rule.invert"), pattern), nil
+}
+
+func astroFromConfig(vt *viper.Viper) (monitor.AstroConfig, error) {
+	config := monitor.AstroConfig{SunAltitude: monitor.DefaultSunAltitude}
+	for key, target := range map[string]*float64{
+		"latitude":          &config.Latitude,
+		"longitude":         &config.Longitude,
+		"elevation":         &config.Elevation,
+		"sun_altitude":      &config.SunAltitude,
+		"moon_illumination": &config.MoonIllumination,
+	} {
+		if !isSet(vt, key) {
+			continue
+		}
+		value, err := cast.ToFloat64E(vt.Get(key))
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*target = value
+	}
+	if !isSet(vt, "latitude") || !isSet(vt, "longitude") {
+		return config, errors.New("latitude and longitude are required")
+	}
+	if isSet(vt, "moon_altitude") {
+		value, err := cast.ToFloat64E(vt.Get("moon_altitude"))
+		if err != nil {
+			return config, fmt.Errorf("invalid moon_altitude: %w", err)
+		}
+		config.MoonAltitude = &value
+	}
+	if isSet(vt, "sunrise_offset") {
+		offset, err := cast.ToDurationE(vt.Get("sunrise_offset"))
+		if err != nil {
+			return config, fmt.Errorf("invalid sunrise_offset: %w", err)
+		}
+		config.SunriseOffset = offset
+	}
+	return config, config.Validate()
+}
+
+// scheduleWindowConfig is one entry of the windows list of a schedule monitor
+type scheduleWindowConfig struct {
+	Days  []string `mapstructure:"days"`
+	Start string   `mapstructure:"start"`
+	End   string   `mapstructure:"end"`
+}
+
+func scheduleFromConfig(vt *viper.Viper) (monitor.ScheduleConfig, error) {
+	var config monitor.ScheduleConfig
+	zone := vt.GetString("timezone")
+	if zone == "" {
+		zone = "Local"
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return config, fmt.Errorf("invalid timezone: %w", err)
+	}
+	config.Location = location
+
+	var windows []scheduleWindowConfig
+	if err := vt.UnmarshalKey("windows", &windows); err != nil {
+		return config, fmt.Errorf("invalid windows: %w", err)
+	}
+	for i, w := range windows {
+		var window monitor.ScheduleWindow
+		if window.Days, err = monitor.ParseWeekdays(w.Days); err != nil {
+			return config, fmt.Errorf("window %d: %w", i+1, err)
+		}
+		if window.Start, err = monitor.ParseTimeOfDay(w.Start); err != nil {
+			return config, fmt.Errorf("window %d: start: %w", i+1, err)
+		}
+		if window.End, err = monitor.ParseTimeOfDay(w.End); err != nil {
+			return config, fmt.Errorf("window %d: end: %w", i+1, err)
+		}
+		config.Windows = append(config.Windows, window)
+	}
+	blackouts, err := cast.ToSliceE(vt.Get("blackouts"))
+	if err != nil {
+		return config, fmt.Errorf("invalid blackouts: %w", err)
+	}
+	for _, value := range blackouts {
+		// YAML reads unquoted dates as timestamps
+		date, isTime := value.(time.Time)
+		if isTime {
+			value = date.Format(time.DateOnly)
+		}
+		blackout, err := monitor.ParseDateRange(cast.ToString(value))
+		if err != nil {
+			return config, fmt.Errorf("blackouts: %w", err)
+		}
+		config.Blackouts = append(config.Blackouts, blackout)
+	}
+	config.Calendar = vt.GetString("calendar")
+	return config, config.Validate()
+}
+
+// isSet reports whether a key has a non-empty value
+func isSet(vt *viper.Viper, key string) bool {
+	return vt.IsSet(key) && vt.GetString(key) != ""
+}
+
+func validateURL(value string) error {
+	u, err := url.ParseRequestURI(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", value)
+	}
+	return nil
+}
+
+// deviceKey identifies a device across sections
+func deviceKey(section string, id string) string {
+	return section + "/" + id
+}
+
+// configPath returns the config path of a device section. Callers must hold configMu.
+func (s *server) configPath(section string, id string) (string, string, error) {
+	deviceType, exists := s.deviceTypes[deviceKey(section, id)]
+	if !exists || s.config == nil {
+		return "", "", ErrDeviceNotConfigured
+	}
+	return fmt.Sprintf("%s.%s.%s", section, deviceType, id), deviceType, nil
+}
+
+// DeviceType returns the config type of a device, such as http
+func (s *server) DeviceType(section string, id string) (string, error) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	_, deviceType, err := s.configPath(section, id)
+	return deviceType, err
+}
+
+// DeviceSettings returns the settings of a device loaded from the configuration
+func (s *server) DeviceSettings(section string, id string) ([]Setting, error) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	path, deviceType, err := s.configPath(section, id)
+	if err != nil {
+		return nil, err
+	}
+	settings := append([]Setting(nil), settingDefinitions[section][deviceType]...)
+	for i := range settings {
+		settings[i].Value = s.config.GetString(path + "." + settings[i].Key)
+		if settings[i].Type == SettingBool {
+			settings[i].Value = strconv.FormatBool(s.config.GetBool(path + "." + settings[i].Key))
+		}
+	}
+	return settings, nil
+}
+
+// UpdateDeviceSettings changes settings of a device. The device is rebuilt
+// from the new settings first, so invalid values are rejected before they
+// reach the running server or the config file. The rest of the config file
+// is left as it is.
+func (s *server) UpdateDeviceSettings(section string, id string, values map[string]string) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	path, deviceType, err := s.configPath(section, id)
+	if err != nil {
+		return err
+	}
+
+	updated := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		setting, exists := findSetting(settingDefinitions[section][deviceType], key)
+		if !exists || setting.ReadOnly {
+			return fmt.Errorf("%s: %w", key, ErrUnknownSetting)
+		}
+		switch setting.Type {
+		case SettingBool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: invalid boolean %q", key, value)
+			}
+			updated[key] = b
+		case SettingNumber:
+			if value == "" {
+				updated[key] = ""
+				continue
+			}
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s: invalid number %q", key, value)
+			}
+			updated[key] = f
+		default:
+			updated[key] = value
+		}
+	}
+
+	candidate := viper.New()
+	if sub := s.config.Sub(path); sub != nil {
+		for key, value := range sub.AllSettings() {
+			candidate.Set(key, value)
+		}
+	}
+	for key, value := range updated {
+		candidate.Set(key, value)
+	}
+
+	var replace func()
+	switch section {
+	case SectionMonitors:
+		m, err := monitorBuilders[deviceType](id, candidate)
+		if err != nil {
+			return err
+		}
+		replace = func() { s.AddMonitor(m) }
+	case SectionWeather:
+		w, err := weatherBuilders[deviceType](id, candidate)
+		if err != nil {
+			return err
+		}
+		replace = func() { s.AddWeather(w) }
+	case SectionDomes:
+		d, err := domeBuilders[deviceType](id, candidate)
+		if err != nil {
+			return err
+		}
+		replace = func() { s.AddDome(d) }
+	}
+
+	// Only the changed keys are written. Writing viper's settings would add
+	// every default to the file and drop its comments.
+	if err := patchConfigFile(s.config.ConfigFileUsed(), path, updated); err != nil {
+		return fmt.Errorf("saving configuration: %w", err)
+	}
+	for key, value := range updated {
+		s.config.Set(path+"."+key, value)
+	}
+	replace()
+	return nil
+}
+
+func findSetting(settings []Setting, key string) (Setting, bool) {
+	for _, setting := range settings {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+	return Setting{}, false
+}
@@ -0,0 +1,649 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/dome"
+	"github.com/thebuh/barn/internal/modbus/modbustest"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/record"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+const testSetupConfig = `
+monitors:
+  file:
+    roof:
+      name: Roof
+      description: Roof switch
+      path: /tmp/barn-roof-state # Written by the limit switch
+      rule:
+        pattern: open
+  dummy:
+    fake:
+      name: Fake
+      is_safe: true
+weather:
+  http:
+    station:
+      name: Station
+      url: http://127.0.0.1:1/weather
+domes:
+  simulator:
+    roof:
+      name: Roof
+      safety_monitor: fake
+      travel: 1s
+`
+
+func newConfiguredBarn(t *testing.T) (*server, string) {
+	path := filepath.Join(t.TempDir(), "barn.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(testSetupConfig), 0644), "should work")
+	v := viper.New()
+	v.SetConfigFile(path)
+	assert.NoError(t, v.ReadInConfig(), "should work")
+	barn := New()
+	barn.LoadMonitorsFromConfig(v)
+	barn.LoadWeatherFromConfig(v)
+	barn.LoadDomesFromConfig(v)
+	return barn, path
+}
+
+func TestBarnServer_DeviceSettings(t *testing.T) {
+	barn, _ := newConfiguredBarn(t)
+	settings, err := barn.DeviceSettings(SectionMonitors, "roof")
+	assert.NoError(t, err, "should work")
+	values := make(map[string]Setting)
+	for _, s := range settings {
+		values[s.Key] = s
+	}
+	assert.Equal(t, "Roof", values["name"].Value, "they should be equal")
+	assert.Equal(t, "open", values["rule.pattern"].Value, "they should be equal")
+	assert.Equal(t, "false", values["rule.invert"].Value, "they should be equal")
+	assert.Equal(t, true, values["path"].ReadOnly, "path should not be editable")
+
+	_, err = barn.DeviceSettings(SectionMonitors, "missing")
+	assert.ErrorIs(t, err, ErrDeviceNotConfigured)
+
+	deviceType, err := barn.DeviceType(SectionMonitors, "roof")
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "file", deviceType, "they should be equal")
+	_, err = barn.DeviceType(SectionWeather, "roof")
+	assert.ErrorIs(t, err, ErrDeviceNotConfigured)
+}
+
+func TestBarnServer_UpdateDeviceSettings(t *testing.T) {
+	barn, path := newConfiguredBarn(t)
+	before := barn.GetMonitor("roof")
+
+	err := barn.UpdateDeviceSettings(SectionMonitors, "roof", map[string]string{
+		"name":         "Roof state",
+		"rule.pattern": "closed",
+	})
+	assert.NoError(t, err, "should work")
+
+	after, ok := barn.GetMonitor("roof").(*monitor.SafetyMonitorFile)
+	assert.True(t, ok, "monitor type should be kept")
+	assert.NotSame(t, before, after, "monitor should be rebuilt")
+	assert.Equal(t, "Roof state", after.GetName(), "they should be equal")
+	assert.Equal(t, "closed", after.GetRule().GetPattern(), "they should be equal")
+	assert.Equal(t, []string{"fake", "roof"}, barn.GetMonitorIds(), "device order should be kept")
+
+	saved := viper.New()
+	saved.SetConfigFile(path)
+	assert.NoError(t, saved.ReadInConfig(), "should work")
+	assert.Equal(t, "Roof state", saved.GetString("monitors.file.roof.name"), "they should be equal")
+	assert.Equal(t, "closed", saved.GetString("monitors.file.roof.rule.pattern"), "they should be equal")
+	assert.Equal(t, "/tmp/barn-roof-state", saved.GetString("monitors.file.roof.path"), "they should be equal")
+	assert.Equal(t, "Station", saved.GetString("weather.http.station.name"), "they should be equal")
+}
+
+func TestBarnServer_UpdateDeviceSettingsKeepsFile(t *testing.T) {
+	barn, path := newConfiguredBarn(t)
+	barn.config.SetDefault("api.port", 8080)
+	assert.NoError(t, os.Chmod(path, 0600), "should work")
+
+	err := barn.UpdateDeviceSettings(SectionMonitors, "roof", map[string]string{"name": "Roof state"})
+	assert.NoError(t, err, "should work")
+	err = barn.UpdateDeviceSettings(SectionMonitors, "fake", map[string]string{"description": "Always safe"})
+	assert.NoError(t, err, "should work")
+
+	saved, err := os.ReadFile(path)
+	assert.NoError(t, err, "should work")
+	assert.Contains(t, string(saved), "path: /tmp/barn-roof-state # Written by the limit switch", "comments should be kept")
+	assert.Contains(t, string(saved), "name: Roof state", "they should be equal")
+	assert.Contains(t, string(saved), "description: Always safe", "missing keys should be added")
+	assert.NotContains(t, string(saved), "api", "defaults should not be written")
+	assert.Equal(t, 1, strings.Count(string(saved), "description: Roof switch"), "other settings should be kept")
+	info, err := os.Stat(path)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "the file mode should be kept")
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, entries, 1, "temporary files should be removed")
+}
+
+func TestBarnServer_UpdateDomeSettings(t *testing.T) {
+	barn, path := newConfiguredBarn(t)
+	settings, err := barn.DeviceSettings(SectionDomes, "roof")
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "Roof", settings[0].Value, "they should be equal")
+
+	err = barn.UpdateDeviceSettings(SectionDomes, "roof", map[string]string{"auto_close": "true", "close_timeout": "45s"})
+	assert.NoError(t, err, "should work")
+	roof := barn.GetDome("roof").(*dome.Roof)
+	assert.Equal(t, true, roof.Options().AutoClose, "they should be equal")
+	assert.Equal(t, 45*time.Second, roof.Options().CloseTimeout, "they should be equal")
+	assert.NoError(t, roof.OpenShutter(), "rebuilt domes should be linked to their monitor")
+
+	err = barn.UpdateDeviceSettings(SectionDomes, "roof", map[string]string{"safety_monitor": ""})
+	assert.Error(t, err, "auto close needs a monitor")
+	saved := viper.New()
+	saved.SetConfigFile(path)
+	assert.NoError(t, saved.ReadInConfig(), "should work")
+	assert.Equal(t, "fake", saved.GetString("domes.simulator.roof.safety_monitor"), "they should be equal")
+}
+
+func TestBarnServer_UpdateDeviceSettingsValidation(t *testing.T) {
+	barn, path := newConfiguredBarn(t)
+	original, _ := os.ReadFile(path)
+	for name, update := range map[string]struct {
+		section string
+		id      string
+		values  map[string]string
+	}{
+		"invalid pattern":   {SectionMonitors, "roof", map[string]string{"rule.pattern": "("}},
+		"read-only setting": {SectionMonitors, "roof", map[string]string{"path": "/etc/passwd"}},
+		"invert":            {SectionMonitors, "roof", map[string]string{"rule.invert": "true"}},
+		"unknown setting":   {SectionMonitors, "fake", map[string]string{"rule.pattern": "x"}},
+		"invalid boolean":   {SectionMonitors, "fake", map[string]string{"is_safe": "maybe"}},
+		"invalid url":       {SectionWeather, "station", map[string]string{"url": "ftp://example.com"}},
+		"unknown device":    {SectionWeather, "roof", map[string]string{"name": "x"}},
+	} {
+		err := barn.UpdateDeviceSettings(update.section, update.id, update.values)
+		assert.Error(t, err, name)
+	}
+	assert.Equal(t, "open", barn.GetMonitor("roof").(*monitor.SafetyMonitorFile).GetRule().GetPattern(), "they should be equal")
+	current, _ := os.ReadFile(path)
+	assert.Equal(t, string(original), string(current), "config file should not change")
+}
+
+func TestHttpBuildersOptions(t *testing.T) {
+	for name, values := range map[string]map[string]interface{}{
+		"invalid status": {"expected_status": "ok"},
+		"missing ca":     {"tls.ca": "/nonexistent/ca.pem"},
+		"exclusive auth": {"auth.username": "barn", "auth.token": "abc"},
+	} {
+		v := viper.New()
+		v.Set("url", "http://127.0.0.1:1/test")
+		for key, value := range values {
+			v.Set(key, value)
+		}
+		_, err := monitorBuilders["http"]("remote", v)
+		assert.Error(t, err, name)
+		_, err = weatherBuilders["http"]("station", v)
+		assert.Error(t, err, name)
+	}
+
+	v := viper.New()
+	v.Set("url", "http://127.0.0.1:1/test")
+	v.Set("method", "POST")
+	v.Set("timeout", "1s")
+	v.Set("expected_status", []int{200, 503})
+	_, err := monitorBuilders["http"]("remote", v)
+	assert.NoError(t, err, "should work")
+	_, err = weatherBuilders["http"]("station", v)
+	assert.NoError(t, err, "should work")
+}
+
+func TestSocketBuilders(t *testing.T) {
+	v := viper.New()
+	v.Set("address", "127.0.0.1:1")
+	v.Set("timeout", "100ms")
+	v.Set("preset", "sqm-le")
+	station, err := weatherBuilders["socket"]("sqm", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, true, weather.SupportsSensor(station, weather.SensorSkyQuality), "they should be equal")
+	v.Set("preset", "unknown")
+	_, err = weatherBuilders["socket"]("sqm", v)
+	assert.Error(t, err, "should fail")
+
+	v = viper.New()
+	v.Set("address", "127.0.0.1:1")
+	v.Set("timeout", "100ms")
+	v.Set("separator", ";")
+	v.Set("fields", map[string]interface{}{"temperature": "3", "humidity": 4})
+	station, err = weatherBuilders["socket"]("station", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, true, weather.SupportsSensor(station, weather.SensorHumidity), "they should be equal")
+	v.Set("fields", map[string]interface{}{"temperature": "third"})
+	_, err = weatherBuilders["socket"]("station", v)
+	assert.Error(t, err, "should fail")
+
+	v = viper.New()
+	v.Set("address", "127.0.0.1:1")
+	v.Set("timeout", "100ms")
+	_, err = monitorBuilders["socket"]("roof", v)
+	assert.NoError(t, err, "should work")
+	v.Set("address", "roof.local")
+	_, err = monitorBuilders["socket"]("roof", v)
+	assert.Error(t, err, "should fail")
+}
+
+func TestSerialBuilders(t *testing.T) {
+	v := viper.New()
+	v.Set("device", "/dev/nonexistent-tty")
+	v.Set("timeout", "100ms")
+	v.Set("parser", "rg15")
+	_, err := monitorBuilders["serial"]("rain", v)
+	assert.NoError(t, err, "missing devices should only fail refreshes")
+	v.Set("parser", "rg11")
+	_, err = monitorBuilders["serial"]("rain", v)
+	assert.Error(t, err, "should fail")
+
+	v = viper.New()
+	v.Set("device", "/dev/nonexistent-tty")
+	v.Set("timeout", "100ms")
+	v.Set("preset", "rg-15")
+	station, err := weatherBuilders["serial"]("rain", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, true, weather.SupportsSensor(station, weather.SensorRainRate), "they should be equal")
+	v.Set("preset", "")
+	_, err = weatherBuilders["serial"]("rain", v)
+	assert.Error(t, err, "fields are required without a preset")
+	v.Set("baud", 12345)
+	_, err = weatherBuilders["serial"]("rain", v)
+	assert.Error(t, err, "should fail")
+}
+
+func TestModbusBuilders(t *testing.T) {
+	server := modbustest.NewServer()
+	defer server.Close()
+	server.SetDiscreteInput(2, true)
+	server.SetInputRegisters(0, 0xFFE2)
+	server.SetHoldingRegisters(5, 0, 1013)
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+table: discrete_input
+register: 2
+timeout: 1s
+`)), "should work")
+	v.Set("address", server.Addr())
+	sm, err := monitorBuilders["modbus"]("roof", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, true, sm.IsSafe(), "they should be equal")
+	v.Set("table", "holding")
+	_, err = monitorBuilders["modbus"]("roof", v)
+	assert.Error(t, err, "should fail")
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+timeout: 1s
+fields:
+  Temperature:
+    table: input
+    register: 0
+    type: int16
+    scale: 0.5
+  pressure:
+    register: 5
+    type: uint32
+`)), "should work")
+	v.Set("address", server.Addr())
+	station, err := weatherBuilders["modbus"]("plc", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, -15.0, station.GetTemperature(), "they should be equal")
+	assert.Equal(t, 1013.0, station.GetPressure(), "they should be equal")
+	assert.Equal(t, false, weather.SupportsSensor(station, weather.SensorHumidity), "they should be equal")
+
+	v.Set("fields.pressure.type", "float64")
+	_, err = weatherBuilders["modbus"]("plc", v)
+	assert.Error(t, err, "should fail")
+	v.Set("fields", map[string]interface{}{"pressure": 5})
+	_, err = weatherBuilders["modbus"]("plc", v)
+	assert.Error(t, err, "fields should be sections")
+}
+
+func TestDomeBuilders(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+name: Roof
+safety_monitor: fake
+open_timeout: 90s
+travel: 1s
+open: true
+`)), "should work")
+	d, err := domeBuilders["simulator"]("roof", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, dome.ShutterOpen, d.ShutterStatus(), "they should be equal")
+	assert.Equal(t, 90*time.Second, d.(*dome.Roof).Options().OpenTimeout, "they should be equal")
+
+	v.Set("auto_close", true)
+	v.Set("safety_monitor", "")
+	_, err = domeBuilders["simulator"]("roof", v)
+	assert.Error(t, err, "auto close needs a monitor")
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+open:
+  exec: {command: roof, args: [open]}
+close:
+  exec: {command: roof, args: [close]}
+status:
+  http: {url: "http://roof.local/status"}
+`)), "should work")
+	_, err = domeBuilders["commands"]("roof", v)
+	assert.NoError(t, err, "should work")
+	v.Set("status", map[string]interface{}{})
+	_, err = domeBuilders["commands"]("roof", v)
+	assert.Error(t, err, "should fail")
+}
+
+func TestSimulatorBuilders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storm.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+temperature: 5
+rain_showers: 0
+events:
+  - at: 0s
+    duration: 1h
+    values: {RainRate: 4, WindGust: 20}
+  - at: 0s
+    safe: false
+`), 0644), "should work")
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+name: Storm
+seed: 42
+scenario: `+path+`
+limits:
+  RainRate: 0
+`)), "should work")
+	w, err := weatherBuilders["simulator"]("storm", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, 4.0, w.GetRainRate(), "they should be equal")
+	assert.Equal(t, 20.0, w.GetWindGust(), "they should be equal")
+	m, err := monitorBuilders["simulator"]("storm", v)
+	assert.NoError(t, err, "should work")
+	assert.False(t, m.IsSafe(), "scripted events should make monitors unsafe")
+	assert.Equal(t, "RainRate=4", m.GetRawValue(), "they should be equal")
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+seed: 1
+scenario:
+  temperature: -10
+  temperature_swing: 0
+  rain_showers: 0
+  cloud_passages: 0
+`)), "should work")
+	w, err = weatherBuilders["simulator"]("calm", v)
+	assert.NoError(t, err, "should work")
+	assert.InDelta(t, -10, w.GetTemperature(), 1, "inline scenarios should be read")
+	m, err = monitorBuilders["simulator"]("calm", v)
+	assert.NoError(t, err, "should work")
+	assert.True(t, m.IsSafe(), "monitors without limits or events should be safe")
+
+	for name, scenario := range map[string]string{
+		"missing file": "scenario: /nonexistent/storm.yaml",
+		"bad value":    "scenario: {humidity: wet}",
+		"bad events":   "scenario: {events: rain}",
+		"invalid":      "scenario: {humidity: 150}",
+		"bad limit":    "limits: {RainRate: heavy}",
+	} {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		assert.NoError(t, v.ReadConfig(strings.NewReader(scenario)), "should work")
+		_, err := monitorBuilders["simulator"]("bad", v)
+		assert.Error(t, err, name+" should fail")
+	}
+}
+
+func TestAstroFromConfig(t *testing.T) {
+	v := viper.New()
+	v.Set("latitude", 51.5)
+	v.Set("longitude", "-0.1")
+	v.Set("sunrise_offset", "30m")
+	v.Set("moon_altitude", 10)
+	config, err := astroFromConfig(v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, 51.5, config.Latitude, "they should be equal")
+	assert.Equal(t, -0.1, config.Longitude, "they should be equal")
+	assert.Equal(t, monitor.DefaultSunAltitude, config.SunAltitude, "they should be equal")
+	assert.Equal(t, 30*time.Minute, config.SunriseOffset, "they should be equal")
+	assert.Equal(t, 10.0, *config.MoonAltitude, "they should be equal")
+
+	v.Set("moon_altitude", "")
+	config, err = astroFromConfig(v)
+	assert.NoError(t, err, "should work")
+	assert.Nil(t, config.MoonAltitude, "empty moon altitude should disable the check")
+
+	for name, values := range map[string]map[string]interface{}{
+		"missing site":       {"latitude": 51.5},
+		"invalid number":     {"latitude": "north", "longitude": 0},
+		"latitude range":     {"latitude": 95, "longitude": 0},
+		"invalid offset":     {"latitude": 0, "longitude": 0, "sunrise_offset": "soon"},
+		"illumination range": {"latitude": 0, "longitude": 0, "moon_illumination": 2},
+	} {
+		v := viper.New()
+		for key, value := range values {
+			v.Set(key, value)
+		}
+		_, err := astroFromConfig(v)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestScheduleFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+timezone: Europe/Berlin
+windows:
+  - days: [mon-fri]
+    start: "21:00"
+    end: "05:00"
+  - start: "22:00"
+    end: "04:00"
+blackouts:
+  - 2024-12-24
+  - 2024-12-31/2025-01-01
+`)), "should work")
+	config, err := scheduleFromConfig(v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "Europe/Berlin", config.Location.String(), "they should be equal")
+	assert.Len(t, config.Windows, 2, "they should be equal")
+	assert.Len(t, config.Windows[0].Days, 5, "they should be equal")
+	assert.Empty(t, config.Windows[1].Days, "every day")
+	assert.Equal(t, "2024-12-24", config.Blackouts[0].String(), "unquoted dates should be read")
+	assert.Equal(t, "2024-12-31/2025-01-01", config.Blackouts[1].String(), "they should be equal")
+
+	for name, values := range map[string]map[string]interface{}{
+		"invalid timezone": {"timezone": "Mars/Olympus"},
+		"invalid day":      {"windows": []map[string]interface{}{{"days": []string{"someday"}, "start": "21:00", "end": "05:00"}}},
+		"invalid time":     {"windows": []map[string]interface{}{{"start": "9pm", "end": "05:00"}}},
+		"invalid blackout": {"blackouts": []string{"christmas"}},
+	} {
+		v := viper.New()
+		for key, value := range values {
+			v.Set(key, value)
+		}
+		_, err := scheduleFromConfig(v)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestReplayBuilders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	start := time.Now().Add(-2 * time.Hour)
+	for i, payload := range []string{"RAIN 0", "RAIN 3"} {
+		writeRecord(t, path, record.Record{Time: start.Add(time.Duration(i) * time.Hour), Kind: record.KindMonitor, Device: "rain", Payload: payload})
+	}
+	for i, payload := range []string{`{"temp": 4, "rainin": 0}`, `{"temp": 3, "rainin": 0.4}`} {
+		writeRecord(t, path, record.Record{Time: start.Add(time.Duration(i) * time.Hour), Kind: record.KindWeather, Device: "station", Payload: payload})
+	}
+	writeRecord(t, path, record.Record{Time: start, Kind: record.KindWeather, Device: "plc", Payload: `{"Temperature": 2, "Humidity": 90}`})
+	writeRecord(t, path, record.Record{Time: start.Add(time.Minute), Kind: record.KindWeather, Device: "plc", Error: "sensor Humidity: timeout"})
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+name: Rain replay
+recording: `+path+`
+device: rain
+speed: 1000000
+field: 2
+rule:
+  pattern: "^0$"
+`)), "should work")
+	m, err := monitorBuilders["replay"]("replay", v)
+	assert.NoError(t, err, "should work")
+	assert.True(t, m.IsSafe(), "the first record should be replayed through the field and rule")
+	assert.Equal(t, "RAIN 0", m.GetRawValue(), "they should be equal")
+	_, recordable := m.(record.Recordable)
+	assert.False(t, recordable, "replays should not be recorded again")
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, m.Refresh(), "should work")
+	assert.False(t, m.IsSafe(), "accelerated replays should reach later records")
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+recording: `+path+`
+format: http
+`)), "should work")
+	w, err := weatherBuilders["replay"]("station", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, 4.0, w.GetTemperature(), "replays should default to the records of their own id")
+	assert.True(t, weather.SupportsSensor(w, weather.SensorRainRate), "should support the parsed sensors")
+	assert.False(t, weather.SupportsSensor(w, weather.SensorSkyQuality), "should only support the parsed sensors")
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+recording: `+path+`
+format: values
+`)), "should work")
+	w, err = weatherBuilders["replay"]("plc", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, 90.0, w.GetHumidity(), "recorded readings should be replayed")
+	assert.True(t, weather.SupportsSensor(w, weather.SensorTemperature), "recorded sensors should be supported")
+
+	for name, config := range map[string]string{
+		"no recording":   "format: http",
+		"missing file":   "recording: /nonexistent/recording.jsonl",
+		"unknown device": "recording: " + path + "\ndevice: roof",
+		"bad format":     "recording: " + path + "\nformat: xml",
+		"bad preset":     "recording: " + path + "\npreset: davis",
+		"no fields":      "recording: " + path,
+		"bad speed":      "recording: " + path + "\nformat: http\nspeed: -1",
+	} {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		assert.NoError(t, v.ReadConfig(strings.NewReader(config)), "should work")
+		_, err := weatherBuilders["replay"]("station", v)
+		assert.Error(t, err, name+" should fail")
+	}
+}
+
+func TestPushBuilders(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+name: Rain script
+expiry: 10m
+key: rain
+rule:
+  pattern: "^false$"
+`)), "should work")
+	m, err := monitorBuilders["push"]("rain", v)
+	assert.NoError(t, err, "should work")
+	assert.False(t, m.IsSafe(), "push monitors should be unsafe until pushed to")
+	pm, ok := m.(*monitor.SafetyMonitorPush)
+	assert.True(t, ok, "should build push monitors")
+	assert.NoError(t, pm.Push(`{"rain": false}`), "should work")
+	assert.True(t, m.IsSafe(), "pushes should go through the key and rule")
+
+	v = viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+format: values
+sensors: [Temperature, CloudCover]
+`)), "should work")
+	w, err := weatherBuilders["push"]("station", v)
+	assert.NoError(t, err, "should work")
+	assert.True(t, weather.SupportsSensor(w, weather.SensorCloudCover), "listed sensors should be supported")
+
+	for name, config := range map[string]string{
+		"bad expiry":      "expiry: soon",
+		"negative expiry": "expiry: -1m",
+		"bad parser":      "parser: davis",
+	} {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		assert.NoError(t, v.ReadConfig(strings.NewReader(config)), "should work")
+		_, err := monitorBuilders["push"]("rain", v)
+		assert.Error(t, err, name+" should fail")
+	}
+	for name, config := range map[string]string{
+		"bad expiry": "format: http\nexpiry: soon",
+		"no sensors": "format: values",
+		"bad format": "format: xml",
+	} {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		assert.NoError(t, v.ReadConfig(strings.NewReader(config)), "should work")
+		_, err := weatherBuilders["push"]("station", v)
+		assert.Error(t, err, name+" should fail")
+	}
+}
+
+func writeRecord(t *testing.T, path string, rec record.Record) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err, "should work")
+	defer f.Close()
+	line, _ := json.Marshal(rec)
+	_, err = f.Write(append(line, '\n'))
+	assert.NoError(t, err, "should work")
+}
+
+func TestDummyBuilders(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+name: Dummy
+values:
+  Temperature: 8.5
+  cloud_cover: 30
+  SkyQuality: 21
+`)), "should work")
+	w, err := weatherBuilders["dummy"]("dummy", v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, 8.5, w.GetTemperature(), "they should be equal")
+	assert.Equal(t, 30.0, w.GetCloudCover(), "they should be equal")
+	assert.True(t, weather.SupportsSensor(w, weather.SensorSkyQuality), "configured sensors should be supported")
+
+	for name, config := range map[string]string{
+		"unknown sensor": "values: {Visibility: 10}",
+		"bad value":      "values: {Temperature: warm}",
+	} {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		assert.NoError(t, v.ReadConfig(strings.NewReader(config)), "should work")
+		_, err := weatherBuilders["dummy"]("dummy", v)
+		assert.Error(t, err, name+" should fail")
+	}
+}
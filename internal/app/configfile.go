@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// patchConfigFile sets keys below a dotted path in a YAML file and leaves the
+// rest of the document, including its comments, as it is. Missing mappings
+// are added. The file is replaced atomically.
+func patchConfigFile(file string, path string, values map[string]interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing %s: %w", file, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: expected a mapping", file)
+	}
+
+	for key, value := range values {
+		keys := strings.Split(path+"."+key, ".")
+		node, err := mappingValue(doc.Content[0], keys)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		var encoded yaml.Node
+		if err := encoded.Encode(value); err != nil {
+			return err
+		}
+		// Keep the comments around the old value
+		encoded.HeadComment, encoded.LineComment, encoded.FootComment = node.HeadComment, node.LineComment, node.FootComment
+		*node = encoded
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return writeFileAtomic(file, out.Bytes())
+}
+
+// mappingValue returns the value node of a key path, adding missing keys.
+// Keys are matched case-insensitively like viper does.
+func mappingValue(node *yaml.Node, keys []string) (*yaml.Node, error) {
+	for i, key := range keys {
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s is not a mapping", strings.Join(keys[:i], "."))
+		}
+		var next *yaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if strings.EqualFold(node.Content[j].Value, key) {
+				next = node.Content[j+1]
+				break
+			}
+		}
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, next)
+		} else if next.Kind == yaml.ScalarNode && next.Tag == "!!null" && i < len(keys)-1 {
+			// An empty section such as "rule:" without keys
+			*next = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", HeadComment: next.HeadComment, LineComment: next.LineComment}
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// writeFileAtomic replaces a file by renaming a temporary file over it, so
+// readers never see a partly written file. The mode of the file is kept.
+func writeFileAtomic(file string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thebuh/barn/internal/health"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// MonitorRefresh describes the outcome of a single safety monitor refresh
+type MonitorRefresh struct {
+	Monitor  monitor.SafetyMonitor
+	WasSafe  bool
+	Safe     bool
+	Err      error
+	Started  time.Time
+	Duration time.Duration
+	// Health is the status of the monitor including this refresh
+	Health health.Status
+}
+
+// Changed reports whether the refresh flipped the monitor state
+func (r MonitorRefresh) Changed() bool {
+	return r.WasSafe != r.Safe
+}
+
+// WeatherRefresh describes the outcome of a single weather station refresh
+type WeatherRefresh struct {
+	Weather  weather.ObservingConditions
+	Err      error
+	Started  time.Time
+	Duration time.Duration
+	// Health is the status of the station including this refresh
+	Health health.Status
+}
+
+// Listener is notified after every device refresh. Listeners are called from
+// the refresh goroutines and must not block.
+type Listener interface {
+	MonitorRefreshed(refresh MonitorRefresh)
+	WeatherRefreshed(refresh WeatherRefresh)
+}
+
+func (s *server) AddListener(l Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+func (s *server) getListeners() []Listener {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listeners
+}
+
+// refreshMonitor refreshes a single monitor and notifies listeners
+func (s *server) refreshMonitor(m monitor.SafetyMonitor) MonitorRefresh {
+	refresh := MonitorRefresh{Monitor: m, WasSafe: m.IsSafe(), Started: time.Now()}
+	refresh.Err = m.Refresh()
+	refresh.Duration = time.Since(refresh.Started)
+	refresh.Safe = m.IsSafe()
+	refresh.Health = s.monitorHealth.Observe(m.GetId(), refresh.Err)
+	for _, l := range s.getListeners() {
+		l.MonitorRefreshed(refresh)
+	}
+	return refresh
+}
+
+// refreshWeather refreshes a single weather station and notifies listeners
+func (s *server) refreshWeather(w weather.ObservingConditions) WeatherRefresh {
+	refresh := WeatherRefresh{Weather: w, Started: time.Now()}
+	refresh.Err = w.Refresh()
+	refresh.Duration = time.Since(refresh.Started)
+	refresh.Health = s.weatherHealth.Observe(w.GetId(), refresh.Err)
+	for _, l := range s.getListeners() {
+		l.WeatherRefreshed(refresh)
+	}
+	return refresh
+}
+
+// RefreshMonitor refreshes a monitor now, like the refresh loop does.
+// Listeners see the measured state, not the one forced by an override.
+func (s *server) RefreshMonitor(id string) error {
+	s.mu.RLock()
+	m := s.monitors[id]
+	s.mu.RUnlock()
+	if m == nil {
+		return fmt.Errorf("unknown monitor %s", id)
+	}
+	return s.refreshMonitor(m).Err
+}
+
+// RefreshWeather refreshes a weather station now, like the refresh loop does
+func (s *server) RefreshWeather(id string) error {
+	w := s.GetWeather(id)
+	if w == nil {
+		return fmt.Errorf("unknown weather station %s", id)
+	}
+	return s.refreshWeather(w).Err
+}
+
+// GetMonitorHealth returns how the refreshes of a monitor went
+func (s *server) GetMonitorHealth(id string) health.Status {
+	return s.monitorHealth.Get(id)
+}
+
+// GetWeatherHealth returns how the refreshes of a weather station went
+func (s *server) GetWeatherHealth(id string) health.Status {
+	return s.weatherHealth.Get(id)
+}
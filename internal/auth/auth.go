@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role is the access level of a caller. Higher roles include lower ones.
+type Role int
+
+const (
+	RoleAnonymous Role = iota
+	RoleRead
+	RoleAdmin
+)
+
+// Route groups
+const (
+	GroupAlpaca    = "alpaca"
+	GroupDashboard = "dashboard"
+	GroupSetup     = "setup"
+	GroupHistory   = "history"
+	GroupMetrics   = "metrics"
+	GroupAdmin     = "admin"
+	GroupIngest    = "ingest"
+)
+
+// AllGroups lists the route groups
+var AllGroups = []string{GroupAlpaca, GroupDashboard, GroupSetup, GroupHistory, GroupMetrics, GroupAdmin, GroupIngest}
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("insufficient role")
+	ErrNetwork         = errors.New("network not allowed")
+)
+
+// ParseRole reads anonymous, read or admin
+func ParseRole(value string) (Role, error) {
+	switch strings.ToLower(value) {
+	case "anonymous", "none":
+		return RoleAnonymous, nil
+	case "read":
+		return RoleRead, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return RoleAnonymous, fmt.Errorf("unknown role %q", value)
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleAdmin:
+		return "admin"
+	}
+	return "anonymous"
+}
+
+// Policy sets who may use a route group. Read applies to GET and HEAD
+// requests, Write to all others.
+type Policy struct {
+	Read     Role
+	Write    Role
+	Networks []*net.IPNet
+}
+
+// DefaultPolicies let anyone with a read role use the Alpaca API and pages,
+// and keep changes to settings and overrides, and pushes, to admins
+var DefaultPolicies = map[string]Policy{
+	GroupAlpaca:    {Read: RoleRead, Write: RoleRead},
+	GroupDashboard: {Read: RoleRead, Write: RoleRead},
+	GroupSetup:     {Read: RoleRead, Write: RoleAdmin},
+	GroupHistory:   {Read: RoleRead, Write: RoleRead},
+	GroupMetrics:   {Read: RoleRead, Write: RoleRead},
+	GroupAdmin:     {Read: RoleAdmin, Write: RoleAdmin},
+	GroupIngest:    {Read: RoleAdmin, Write: RoleAdmin},
+}
+
+// User is a basic auth account. Names are case-insensitive. Password is
+// either plain text or a bcrypt hash.
+type User struct {
+	Name     string
+	Password string
+	Role     Role
+}
+
+// Token is a bearer token
+type Token struct {
+	Name  string
+	Token string
+	Role  Role
+}
+
+// Principal is an authenticated caller
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// Authenticator checks credentials and network allowlists of HTTP requests
+type Authenticator struct {
+	users    map[string]User
+	tokens   []Token
+	policies map[string]Policy
+	// TrustedProxies may set the client address with X-Forwarded-For
+	TrustedProxies []string
+
+	mu sync.Mutex
+	// verified caches bcrypt checks, which are deliberately slow, by a hash
+	// of the credentials
+	verified map[[sha256.Size]byte]bool
+}
+
+// New creates an authenticator. Groups missing from policies use
+// DefaultPolicies.
+func New(users []User, tokens []Token, policies map[string]Policy) *Authenticator {
+	a := &Authenticator{
+		users:    make(map[string]User, len(users)),
+		tokens:   tokens,
+		policies: make(map[string]Policy, len(AllGroups)),
+		verified: make(map[[sha256.Size]byte]bool),
+	}
+	for _, u := range users {
+		a.users[strings.ToLower(u.Name)] = u
+	}
+	for _, group := range AllGroups {
+		a.policies[group] = DefaultPolicies[group]
+		if policy, exists := policies[group]; exists {
+			a.policies[group] = policy
+		}
+	}
+	return a
+}
+
+// Policy returns the policy of a route group
+func (a *Authenticator) Policy(group string) Policy {
+	return a.policies[group]
+}
+
+// Authenticate returns the caller of a request. Requests without credentials
+// are anonymous; invalid credentials are an error.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return Principal{Role: RoleAnonymous}, nil
+	}
+	if token, found := strings.CutPrefix(header, "Bearer "); found {
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(t.Token), []byte(strings.TrimSpace(token))) == 1 {
+				return Principal{Name: t.Name, Role: t.Role}, nil
+			}
+		}
+		return Principal{}, ErrUnauthenticated
+	}
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return Principal{}, ErrUnauthenticated
+	}
+	user, exists := a.users[strings.ToLower(name)]
+	if !exists || !a.checkPassword(user, password) {
+		return Principal{}, ErrUnauthenticated
+	}
+	return Principal{Name: user.Name, Role: user.Role}, nil
+}
+
+func (a *Authenticator) checkPassword(user User, password string) bool {
+	if !isBcrypt(user.Password) {
+		return subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1
+	}
+	key := sha256.Sum256([]byte(user.Name + "\x00" + user.Password + "\x00" + password))
+	a.mu.Lock()
+	verified := a.verified[key]
+	a.mu.Unlock()
+	if verified {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return false
+	}
+	a.mu.Lock()
+	a.verified[key] = true
+	a.mu.Unlock()
+	return true
+}
+
+func isBcrypt(password string) bool {
+	return strings.HasPrefix(password, "$2a$") || strings.HasPrefix(password, "$2b$") || strings.HasPrefix(password, "$2y$")
+}
+
+// Authorize checks that a caller at ip may send a request with method to a
+// route group
+func (a *Authenticator) Authorize(group string, method string, ip net.IP, principal Principal) error {
+	policy := a.policies[group]
+	if len(policy.Networks) > 0 && !contains(policy.Networks, ip) {
+		return ErrNetwork
+	}
+	required := policy.Write
+	if method == http.MethodGet || method == http.MethodHead {
+		required = policy.Read
+	}
+	if principal.Role >= required {
+		return nil
+	}
+	if principal.Name == "" {
+		return ErrUnauthenticated
+	}
+	return ErrForbidden
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNetworks reads CIDR networks. Plain addresses are single hosts.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
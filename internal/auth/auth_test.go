@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-secret"), bcrypt.MinCost)
+	assert.NoError(t, err, "should work")
+	local, err := ParseNetworks([]string{"127.0.0.1", "10.0.0.0/8"})
+	assert.NoError(t, err, "should work")
+	return New(
+		[]User{
+			{Name: "Nina", Password: "secret", Role: RoleRead},
+			{Name: "admin", Password: string(hash), Role: RoleAdmin},
+		},
+		[]Token{{Name: "cli", Token: "0123456789abcdef", Role: RoleAdmin}},
+		map[string]Policy{
+			GroupAdmin: {Read: RoleAdmin, Write: RoleAdmin, Networks: local},
+		},
+	)
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	a := newTestAuthenticator(t)
+	for name, tc := range map[string]struct {
+		setup func(r *http.Request)
+		want  Principal
+		err   error
+	}{
+		"anonymous":      {func(r *http.Request) {}, Principal{Role: RoleAnonymous}, nil},
+		"basic":          {func(r *http.Request) { r.SetBasicAuth("nina", "secret") }, Principal{Name: "Nina", Role: RoleRead}, nil},
+		"bcrypt":         {func(r *http.Request) { r.SetBasicAuth("admin", "hashed-secret") }, Principal{Name: "admin", Role: RoleAdmin}, nil},
+		"bearer":         {func(r *http.Request) { r.Header.Set("Authorization", "Bearer 0123456789abcdef") }, Principal{Name: "cli", Role: RoleAdmin}, nil},
+		"wrong password": {func(r *http.Request) { r.SetBasicAuth("nina", "guess") }, Principal{}, ErrUnauthenticated},
+		"wrong bcrypt":   {func(r *http.Request) { r.SetBasicAuth("admin", "guess") }, Principal{}, ErrUnauthenticated},
+		"unknown user":   {func(r *http.Request) { r.SetBasicAuth("eve", "secret") }, Principal{}, ErrUnauthenticated},
+		"wrong token":    {func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, Principal{}, ErrUnauthenticated},
+		"unknown scheme": {func(r *http.Request) { r.Header.Set("Authorization", "Digest x") }, Principal{}, ErrUnauthenticated},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		tc.setup(r)
+		principal, err := a.Authenticate(r)
+		assert.ErrorIs(t, err, tc.err, name)
+		assert.Equal(t, tc.want, principal, name)
+	}
+
+	// Verified bcrypt credentials are cached
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("admin", "hashed-secret")
+	_, err := a.Authenticate(r)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, a.verified, 1, "they should be equal")
+}
+
+func TestAuthenticator_Authorize(t *testing.T) {
+	a := newTestAuthenticator(t)
+	anonymous := Principal{}
+	reader := Principal{Name: "nina", Role: RoleRead}
+	admin := Principal{Name: "cli", Role: RoleAdmin}
+	remote := net.ParseIP("192.0.2.1")
+	local := net.ParseIP("10.1.2.3")
+
+	assert.ErrorIs(t, a.Authorize(GroupAlpaca, http.MethodGet, remote, anonymous), ErrUnauthenticated)
+	assert.NoError(t, a.Authorize(GroupAlpaca, http.MethodPut, remote, reader), "readers may connect")
+	assert.NoError(t, a.Authorize(GroupSetup, http.MethodGet, remote, reader), "readers may view setup")
+	assert.ErrorIs(t, a.Authorize(GroupSetup, http.MethodPost, remote, reader), ErrForbidden)
+	assert.NoError(t, a.Authorize(GroupSetup, http.MethodPost, remote, admin), "admins may change setup")
+	assert.ErrorIs(t, a.Authorize(GroupAdmin, http.MethodGet, remote, admin), ErrNetwork)
+	assert.NoError(t, a.Authorize(GroupAdmin, http.MethodGet, local, admin), "should work")
+	assert.ErrorIs(t, a.Authorize(GroupAdmin, http.MethodGet, local, reader), ErrForbidden)
+	assert.ErrorIs(t, a.Authorize(GroupAdmin, http.MethodGet, nil, admin), ErrNetwork)
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"192.168.1.0/24", "::1", "10.0.0.1"})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "192.168.1.0/24", networks[0].String(), "they should be equal")
+	assert.Equal(t, "::1/128", networks[1].String(), "they should be equal")
+	assert.Equal(t, "10.0.0.1/32", networks[2].String(), "they should be equal")
+	assert.True(t, contains(networks, net.ParseIP("::ffff:192.168.1.7")), "mapped addresses should match")
+
+	_, err = ParseNetworks([]string{"192.168.1.0/33"})
+	assert.Error(t, err, "should fail")
+	_, err = ParseNetworks([]string{"localhost"})
+	assert.Error(t, err, "should fail")
+}
+
+func TestParseRole(t *testing.T) {
+	for value, want := range map[string]Role{"anonymous": RoleAnonymous, "none": RoleAnonymous, "Read": RoleRead, "admin": RoleAdmin} {
+		role, err := ParseRole(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, want, role, value)
+	}
+	_, err := ParseRole("root")
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// LoadFromConfig builds an authenticator from the auth section. It returns
+// nil when the section is missing, leaving the API open.
+func LoadFromConfig(v *viper.Viper) (*Authenticator, error) {
+	if !v.IsSet("auth") {
+		return nil, nil
+	}
+
+	var users []User
+	for _, name := range sortedKeys(v.GetStringMap("auth.users")) {
+		vt := v.Sub("auth.users." + name)
+		role, err := roleOrDefault(vt.GetString("role"), RoleRead)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+		if vt.GetString("password") == "" {
+			return nil, fmt.Errorf("user %s: password is required", name)
+		}
+		users = append(users, User{Name: name, Password: vt.GetString("password"), Role: role})
+	}
+
+	var tokens []Token
+	for _, name := range sortedKeys(v.GetStringMap("auth.tokens")) {
+		vt := v.Sub("auth.tokens." + name)
+		role, err := roleOrDefault(vt.GetString("role"), RoleRead)
+		if err != nil {
+			return nil, fmt.Errorf("token %s: %w", name, err)
+		}
+		if len(vt.GetString("token")) < 16 {
+			return nil, fmt.Errorf("token %s: token must be at least 16 characters", name)
+		}
+		tokens = append(tokens, Token{Name: name, Token: vt.GetString("token"), Role: role})
+	}
+
+	networks, err := ParseNetworks(v.GetStringSlice("auth.networks"))
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]Policy, len(AllGroups))
+	for _, group := range AllGroups {
+		policy := DefaultPolicies[group]
+		policy.Networks = networks
+		if vt := v.Sub("auth.routes." + group); vt != nil {
+			if policy.Read, err = roleOrDefault(vt.GetString("read"), policy.Read); err != nil {
+				return nil, fmt.Errorf("routes %s: %w", group, err)
+			}
+			if policy.Write, err = roleOrDefault(vt.GetString("write"), policy.Write); err != nil {
+				return nil, fmt.Errorf("routes %s: %w", group, err)
+			}
+			if vt.IsSet("networks") {
+				if policy.Networks, err = ParseNetworks(vt.GetStringSlice("networks")); err != nil {
+					return nil, fmt.Errorf("routes %s: %w", group, err)
+				}
+			}
+		}
+		policies[group] = policy
+	}
+	for group := range v.GetStringMap("auth.routes") {
+		if !slices.Contains(AllGroups, group) {
+			return nil, fmt.Errorf("unknown route group %q", group)
+		}
+	}
+
+	a := New(users, tokens, policies)
+	a.TrustedProxies = v.GetStringSlice("auth.trusted_proxies")
+	return a, nil
+}
+
+func roleOrDefault(value string, role Role) (Role, error) {
+	if value == "" {
+		return role, nil
+	}
+	return ParseRole(value)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
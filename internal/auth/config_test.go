@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestConfig(t *testing.T, config string) (*Authenticator, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(config)), "should work")
+	return LoadFromConfig(v)
+}
+
+func TestLoadFromConfig(t *testing.T) {
+	a, err := loadTestConfig(t, `
+auth:
+  users:
+    nina:
+      password: secret
+  tokens:
+    cli:
+      token: 0123456789abcdef
+      role: admin
+  networks: [192.168.1.0/24]
+  trusted_proxies: [127.0.0.1]
+  routes:
+    alpaca:
+      read: anonymous
+    admin:
+      networks: [127.0.0.1]
+`)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, RoleRead, a.users["nina"].Role, "users should default to read")
+	assert.Equal(t, RoleAdmin, a.tokens[0].Role, "they should be equal")
+	assert.Equal(t, RoleAnonymous, a.Policy(GroupAlpaca).Read, "they should be equal")
+	assert.Equal(t, RoleRead, a.Policy(GroupAlpaca).Write, "defaults should be kept")
+	assert.Equal(t, "192.168.1.0/24", a.Policy(GroupDashboard).Networks[0].String(), "global networks should apply")
+	assert.Equal(t, "127.0.0.1/32", a.Policy(GroupAdmin).Networks[0].String(), "route networks should replace global ones")
+	assert.Equal(t, []string{"127.0.0.1"}, a.TrustedProxies, "they should be equal")
+}
+
+func TestLoadFromConfig_Disabled(t *testing.T) {
+	a, err := loadTestConfig(t, "api:\n  port: 8080\n")
+	assert.NoError(t, err, "should work")
+	assert.Nil(t, a, "auth should be disabled")
+}
+
+func TestLoadFromConfig_Invalid(t *testing.T) {
+	for name, config := range map[string]string{
+		"missing password": "auth:\n  users:\n    nina:\n      role: read\n",
+		"short token":      "auth:\n  tokens:\n    cli:\n      token: abc\n",
+		"unknown role":     "auth:\n  users:\n    nina:\n      password: x\n      role: root\n",
+		"invalid network":  "auth:\n  networks: [nowhere]\n",
+		"unknown group":    "auth:\n  routes:\n    everything:\n      read: anonymous\n",
+	} {
+		_, err := loadTestConfig(t, config)
+		assert.Error(t, err, name)
+	}
+}
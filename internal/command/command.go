@@ -0,0 +1,124 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/thebuh/barn/internal/fetch"
+)
+
+// Command triggers an action on another device
+type Command interface {
+	Run() error
+}
+
+// Status reads the raw state of another device
+type Status interface {
+	Read() (string, error)
+}
+
+// HTTP sends a request, by default a POST
+type HTTP struct {
+	client *fetch.Client
+}
+
+// NewHTTP creates a command requesting url
+func NewHTTP(url string, options fetch.Options) (*HTTP, error) {
+	if err := validateURL(url); err != nil {
+		return nil, err
+	}
+	if options.Method == "" {
+		options.Method = "POST"
+	}
+	client, err := fetch.New(url, options)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTP{client: client}, nil
+}
+
+func (c *HTTP) Run() error {
+	_, err := c.client.Fetch()
+	return err
+}
+
+// HTTPStatus reads the status from the body of a response
+type HTTPStatus struct {
+	client *fetch.Client
+}
+
+// NewHTTPStatus creates a status source requesting url
+func NewHTTPStatus(url string, options fetch.Options) (*HTTPStatus, error) {
+	if err := validateURL(url); err != nil {
+		return nil, err
+	}
+	client, err := fetch.New(url, options)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPStatus{client: client}, nil
+}
+
+func (s *HTTPStatus) Read() (string, error) {
+	body, err := s.client.Fetch()
+	return string(body), err
+}
+
+func validateURL(value string) error {
+	u, err := url.ParseRequestURI(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", value)
+	}
+	return nil
+}
+
+// Exec runs a program without a shell
+type Exec struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+// NewExec creates a command running a program with args. A zero timeout uses
+// fetch.DefaultTimeout.
+func NewExec(command string, args []string, timeout time.Duration) (*Exec, error) {
+	if command == "" {
+		return nil, errors.New("command is required")
+	}
+	if timeout < 0 {
+		return nil, errors.New("timeout must not be negative")
+	}
+	if timeout == 0 {
+		timeout = fetch.DefaultTimeout
+	}
+	return &Exec{command: command, args: args, timeout: timeout}, nil
+}
+
+// Run fails if the program exits with a non-zero status
+func (c *Exec) Run() error {
+	_, err := c.output()
+	return err
+}
+
+// Read returns the standard output of the program
+func (c *Exec) Read() (string, error) {
+	return c.output()
+}
+
+func (c *Exec) output() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.command, c.args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,63 @@
+package command
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/fetch"
+)
+
+func TestHTTP(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/park", "/close":
+		case "/status":
+			w.Write([]byte("closed"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	park, err := NewHTTP(server.URL+"/park", fetch.Options{})
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, park.Run(), "should work")
+	closeCommand, _ := NewHTTP(server.URL+"/close", fetch.Options{Method: "put"})
+	assert.NoError(t, closeCommand.Run(), "should work")
+	status, _ := NewHTTPStatus(server.URL+"/status", fetch.Options{})
+	raw, err := status.Read()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "closed", raw, "they should be equal")
+	assert.Equal(t, []string{"POST /park", "PUT /close", "GET /status"}, requests, "they should be equal")
+
+	missing, _ := NewHTTP(server.URL+"/missing", fetch.Options{})
+	assert.Error(t, missing.Run(), "unexpected statuses should fail")
+	_, err = NewHTTP("roof.local/open", fetch.Options{})
+	assert.Error(t, err, "should fail")
+	_, err = NewHTTPStatus("ftp://roof.local/status", fetch.Options{})
+	assert.Error(t, err, "should fail")
+}
+
+func TestExec(t *testing.T) {
+	status, err := NewExec("sh", []string{"-c", "echo OPEN"}, time.Second)
+	assert.NoError(t, err, "should work")
+	raw, err := status.Read()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "OPEN\n", raw, "they should be equal")
+
+	failing, _ := NewExec("sh", []string{"-c", "echo jammed >&2; exit 3"}, time.Second)
+	err = failing.Run()
+	assert.Error(t, err, "non-zero exit statuses should fail")
+	assert.Contains(t, err.Error(), "jammed", "errors should include the output")
+
+	slow, _ := NewExec("sleep", []string{"5"}, 50*time.Millisecond)
+	assert.Error(t, slow.Run(), "commands should time out")
+
+	_, err = NewExec("", nil, 0)
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,119 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"github.com/thebuh/barn/internal/fetch"
+)
+
+// MQTTFromConfig connects to the broker of an mqtt section with broker,
+// client_id, username, password and timeout. It returns nil without an mqtt
+// section.
+func MQTTFromConfig(vt *viper.Viper) (*MQTTConnection, error) {
+	if !vt.IsSet("mqtt") {
+		return nil, nil
+	}
+	options := MQTTOptions{
+		Broker:   vt.GetString("mqtt.broker"),
+		ClientID: vt.GetString("mqtt.client_id"),
+		Username: vt.GetString("mqtt.username"),
+		Password: vt.GetString("mqtt.password"),
+		Timeout:  vt.GetDuration("mqtt.timeout"),
+	}
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	connection, err := NewMQTTConnection(options)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	return connection, nil
+}
+
+// FromConfig reads a command with exactly one of an http section with url
+// and request options, an exec section with command, args and timeout, or an
+// mqtt section with topic and payload. MQTT commands need a connection.
+func FromConfig(vt *viper.Viper, connection *MQTTConnection) (Command, error) {
+	kind, section, err := kindFromConfig(vt)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "http":
+		options, err := fetch.OptionsFromConfig(section)
+		if err != nil {
+			return nil, err
+		}
+		return NewHTTP(section.GetString("url"), options)
+	case "exec":
+		return execFromConfig(section)
+	default:
+		if connection == nil {
+			return nil, errors.New("mqtt commands need a broker in the mqtt section")
+		}
+		return NewMQTTPublish(connection, section.GetString("topic"), section.GetString("payload"))
+	}
+}
+
+// StatusFromConfig reads a status like FromConfig. MQTT statuses only need a
+// topic.
+func StatusFromConfig(vt *viper.Viper, connection *MQTTConnection) (Status, error) {
+	kind, section, err := kindFromConfig(vt)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "http":
+		options, err := fetch.OptionsFromConfig(section)
+		if err != nil {
+			return nil, err
+		}
+		return NewHTTPStatus(section.GetString("url"), options)
+	case "exec":
+		return execFromConfig(section)
+	default:
+		if connection == nil {
+			return nil, errors.New("mqtt status needs a broker in the mqtt section")
+		}
+		return NewMQTTStatus(connection, section.GetString("topic"))
+	}
+}
+
+// kindFromConfig returns the only one of the http, exec and mqtt sections
+func kindFromConfig(vt *viper.Viper) (string, *viper.Viper, error) {
+	if vt == nil {
+		return "", nil, errors.New("missing section")
+	}
+	var kind string
+	for _, key := range []string{"http", "exec", "mqtt"} {
+		if !vt.IsSet(key) {
+			continue
+		}
+		if kind != "" {
+			return "", nil, fmt.Errorf("%s and %s are exclusive", kind, key)
+		}
+		kind = key
+	}
+	if kind == "" {
+		return "", nil, errors.New("one of http, exec or mqtt is required")
+	}
+	section := vt.Sub(kind)
+	if section == nil {
+		return "", nil, fmt.Errorf("invalid %s section", kind)
+	}
+	return kind, section, nil
+}
+
+func execFromConfig(vt *viper.Viper) (*Exec, error) {
+	var args []string
+	if vt.IsSet("args") {
+		var err error
+		if args, err = cast.ToStringSliceE(vt.Get("args")); err != nil {
+			return nil, fmt.Errorf("invalid args: %w", err)
+		}
+	}
+	return NewExec(vt.GetString("command"), args, vt.GetDuration("timeout"))
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/command/mqtttest"
+)
+
+func readConfig(t *testing.T, content string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(content)), "should work")
+	return v
+}
+
+func TestFromConfig(t *testing.T) {
+	v := readConfig(t, `
+park:
+  exec:
+    command: sh
+    args: ["-c", "echo parked"]
+    timeout: 2s
+close:
+  http:
+    url: http://roof.local/close
+    method: PUT
+`)
+	park, err := FromConfig(v.Sub("park"), nil)
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, park.Run(), "should work")
+	closeCommand, err := FromConfig(v.Sub("close"), nil)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "PUT", closeCommand.(*HTTP).client.Options().Method, "they should be equal")
+	status, err := StatusFromConfig(v.Sub("park"), nil)
+	assert.NoError(t, err, "should work")
+	raw, _ := status.Read()
+	assert.Equal(t, "parked\n", raw, "they should be equal")
+
+	connection, err := MQTTFromConfig(v)
+	assert.NoError(t, err, "should work")
+	assert.Nil(t, connection, "connections need an mqtt section")
+}
+
+func TestFromConfig_MQTT(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	defer broker.Close()
+	v := readConfig(t, `
+mqtt:
+  broker: `+broker.URL()+`
+park:
+  mqtt: {topic: mount/set, payload: PARK}
+`)
+	connection, err := MQTTFromConfig(v)
+	assert.NoError(t, err, "should work")
+	defer connection.Close()
+	park, err := FromConfig(v.Sub("park"), connection)
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, park.Run(), "should work")
+	assert.Equal(t, []string{"mount/set=PARK"}, broker.Received(), "they should be equal")
+	_, err = StatusFromConfig(v.Sub("park"), connection)
+	assert.NoError(t, err, "should work")
+}
+
+func TestFromConfig_Errors(t *testing.T) {
+	for name, content := range map[string]string{
+		"no kind":   "timeout: 2s\n",
+		"two kinds": "exec: {command: roof-open}\nhttp: {url: http://roof.local}\n",
+		"bad url":   "http: {url: roof.local/open}\n",
+		"no broker": "mqtt: {topic: roof/set}\n",
+		"no exec":   "exec: {args: [open]}\n",
+		"bad args":  "exec: {command: roof-open, args: {a: b}}\n",
+	} {
+		_, err := FromConfig(readConfig(t, content), nil)
+		assert.Error(t, err, name+" should fail")
+	}
+	_, err := FromConfig(nil, nil)
+	assert.Error(t, err, "missing sections should fail")
+	_, err = StatusFromConfig(readConfig(t, "mqtt: {topic: roof/state}\n"), nil)
+	assert.Error(t, err, "should fail")
+	_, err = MQTTFromConfig(readConfig(t, "mqtt: {client_id: barn}\n"))
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,177 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// mqttQoS is used for publishes and subscriptions so commands are not lost
+// while the broker is reachable
+const mqttQoS = 1
+
+const DefaultMQTTTimeout = 5 * time.Second
+
+// MQTTOptions connect to a broker such as tcp://broker:1883
+type MQTTOptions struct {
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// Timeout limits waiting for the broker to accept a command
+	Timeout time.Duration
+}
+
+// Validate checks the options
+func (o MQTTOptions) Validate() error {
+	if o.Broker == "" {
+		return errors.New("broker is required")
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// MQTTConnection is a broker connection shared by the commands and status
+// of a device. It reconnects on its own and subscribes again after
+// reconnecting.
+type MQTTConnection struct {
+	client  mqtt.Client
+	timeout time.Duration
+	// connected completes on the first connection
+	connected mqtt.Token
+
+	mu            sync.Mutex
+	subscriptions map[string]mqtt.MessageHandler
+}
+
+// NewMQTTConnection starts connecting to the broker in the background, so an
+// unreachable broker does not stop barn from starting
+func NewMQTTConnection(options MQTTOptions) (*MQTTConnection, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultMQTTTimeout
+	}
+	c := &MQTTConnection{
+		timeout:       options.Timeout,
+		subscriptions: make(map[string]mqtt.MessageHandler),
+	}
+	clientOptions := mqtt.NewClientOptions().
+		AddBroker(options.Broker).
+		SetClientID(options.ClientID).
+		SetUsername(options.Username).
+		SetPassword(options.Password).
+		SetConnectTimeout(options.Timeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(c.resubscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.WithError(err).Warn(fmt.Sprintf("[BARN] MQTT [%s]. Connection lost", options.Broker))
+		})
+	c.client = mqtt.NewClient(clientOptions)
+	c.connected = c.client.Connect()
+	return c, nil
+}
+
+// resubscribe restores subscriptions, which clean sessions lose on reconnect
+func (c *MQTTConnection) resubscribe(client mqtt.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, handler := range c.subscriptions {
+		client.Subscribe(topic, mqttQoS, handler)
+	}
+}
+
+// Publish sends payload to topic and waits for the broker to accept it.
+// Right after starting it waits for the first connection too.
+func (c *MQTTConnection) Publish(topic string, payload string) error {
+	if !c.connected.WaitTimeout(c.timeout) || !c.client.IsConnectionOpen() {
+		return fmt.Errorf("publishing to %s: not connected to the broker", topic)
+	}
+	token := c.client.Publish(topic, mqttQoS, false, payload)
+	if !token.WaitTimeout(c.timeout) {
+		return fmt.Errorf("publishing to %s: timed out", topic)
+	}
+	return token.Error()
+}
+
+// Subscribe calls handler with the payload of messages on topic
+func (c *MQTTConnection) Subscribe(topic string, handler func(payload string)) {
+	messageHandler := func(_ mqtt.Client, message mqtt.Message) {
+		handler(string(message.Payload()))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscriptions[topic] = messageHandler
+	if c.client.IsConnectionOpen() {
+		c.client.Subscribe(topic, mqttQoS, messageHandler)
+	}
+}
+
+// Close disconnects from the broker
+func (c *MQTTConnection) Close() error {
+	c.client.Disconnect(250)
+	return nil
+}
+
+// MQTTPublish publishes a payload to a topic
+type MQTTPublish struct {
+	connection *MQTTConnection
+	topic      string
+	payload    string
+}
+
+// NewMQTTPublish creates a command publishing payload to topic
+func NewMQTTPublish(connection *MQTTConnection, topic string, payload string) (*MQTTPublish, error) {
+	if topic == "" {
+		return nil, errors.New("topic is required")
+	}
+	return &MQTTPublish{connection: connection, topic: topic, payload: payload}, nil
+}
+
+func (c *MQTTPublish) Run() error {
+	return c.connection.Publish(c.topic, c.payload)
+}
+
+// MQTTStatus keeps the last message on a topic. Controllers should publish
+// their state retained so it is known after connecting.
+type MQTTStatus struct {
+	topic string
+
+	mu       sync.Mutex
+	last     string
+	received bool
+}
+
+// NewMQTTStatus subscribes to topic
+func NewMQTTStatus(connection *MQTTConnection, topic string) (*MQTTStatus, error) {
+	if topic == "" {
+		return nil, errors.New("topic is required")
+	}
+	s := &MQTTStatus{topic: topic}
+	connection.Subscribe(topic, func(payload string) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.last = payload
+		s.received = true
+	})
+	return s, nil
+}
+
+func (s *MQTTStatus) Read() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.received {
+		return "", fmt.Errorf("no status received on %s", s.topic)
+	}
+	return s.last, nil
+}
@@ -0,0 +1,56 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/command/mqtttest"
+)
+
+func TestMQTT(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	defer broker.Close()
+	broker.Publish("roof/state", "closed")
+	connection, err := NewMQTTConnection(MQTTOptions{Broker: broker.URL(), ClientID: "barn-test", Timeout: time.Second})
+	assert.NoError(t, err, "should work")
+	defer connection.Close()
+
+	status, _ := NewMQTTStatus(connection, "roof/state")
+	open, _ := NewMQTTPublish(connection, "roof/set", "OPEN")
+
+	select {
+	case <-broker.Subscribed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a subscription")
+	}
+	assert.Eventually(t, func() bool {
+		raw, err := status.Read()
+		return err == nil && raw == "closed"
+	}, 5*time.Second, 10*time.Millisecond, "retained messages should be received")
+
+	assert.NoError(t, open.Run(), "should work")
+	assert.Equal(t, []string{"roof/set=OPEN"}, broker.Received(), "they should be equal")
+	broker.Publish("roof/state", "opening")
+	assert.Eventually(t, func() bool {
+		raw, _ := status.Read()
+		return raw == "opening"
+	}, 5*time.Second, 10*time.Millisecond, "new messages should be received")
+}
+
+func TestMQTT_Errors(t *testing.T) {
+	_, err := NewMQTTConnection(MQTTOptions{})
+	assert.Error(t, err, "should fail")
+
+	// Brokers that cannot be reached fail commands but not the connection
+	connection, err := NewMQTTConnection(MQTTOptions{Broker: "tcp://127.0.0.1:1", Timeout: 50 * time.Millisecond})
+	assert.NoError(t, err, "should work")
+	defer connection.Close()
+	status, _ := NewMQTTStatus(connection, "roof/state")
+	_, err = status.Read()
+	assert.Error(t, err, "statuses should fail until a message arrives")
+	publish, _ := NewMQTTPublish(connection, "roof/set", "OPEN")
+	assert.Error(t, publish.Run(), "should fail")
+	_, err = NewMQTTPublish(connection, "", "OPEN")
+	assert.Error(t, err, "should fail")
+}
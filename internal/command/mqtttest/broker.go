@@ -0,0 +1,173 @@
+// Package mqtttest provides an in-process MQTT 3.1.1 broker for tests
+package mqtttest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// Broker forwards publishes to subscribers of the same topic and keeps the
+// last message published with Publish as retained
+type Broker struct {
+	listener net.Listener
+
+	mu         sync.Mutex
+	messages   []string
+	clients    map[net.Conn][]string
+	retained   map[string]string
+	subscribed chan string
+}
+
+// NewBroker starts a broker on a local port
+func NewBroker() *Broker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("mqtttest: failed to listen: " + err.Error())
+	}
+	b := &Broker{
+		listener:   listener,
+		clients:    make(map[net.Conn][]string),
+		retained:   make(map[string]string),
+		subscribed: make(chan string, 16),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+// URL returns the tcp:// address of the broker
+func (b *Broker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Close stops the broker and drops its clients
+func (b *Broker) Close() {
+	b.listener.Close()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for conn := range b.clients {
+		conn.Close()
+	}
+}
+
+// Received lists publishes from clients as topic=payload
+func (b *Broker) Received() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.messages...)
+}
+
+// Subscribed receives the topics clients subscribe to
+func (b *Broker) Subscribed() <-chan string {
+	return b.subscribed
+}
+
+// Publish sends a retained message to subscribers
+func (b *Broker) Publish(topic string, payload string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retained[topic] = payload
+	for conn, topics := range b.clients {
+		for _, subscribed := range topics {
+			if subscribed == topic {
+				writePublish(conn, topic, payload)
+			}
+		}
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		length, multiplier := 0, 1
+		for {
+			digit, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length += int(digit&0x7F) * multiplier
+			multiplier *= 128
+			if digit&0x80 == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			b.mu.Lock()
+			b.clients[conn] = nil
+			b.mu.Unlock()
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			topicLength := int(binary.BigEndian.Uint16(body))
+			topic := string(body[2 : 2+topicLength])
+			rest := body[2+topicLength:]
+			if qos := (header >> 1) & 0x03; qos > 0 {
+				conn.Write([]byte{0x40, 0x02, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			b.mu.Lock()
+			b.messages = append(b.messages, topic+"="+string(rest))
+			b.mu.Unlock()
+		case 8: // SUBSCRIBE
+			var granted []byte
+			retained := make(map[string]string)
+			var topics []string
+			b.mu.Lock()
+			for rest := body[2:]; len(rest) > 2; {
+				topicLength := int(binary.BigEndian.Uint16(rest))
+				topic := string(rest[2 : 2+topicLength])
+				rest = rest[3+topicLength:]
+				b.clients[conn] = append(b.clients[conn], topic)
+				granted = append(granted, 0)
+				if payload, exists := b.retained[topic]; exists {
+					retained[topic] = payload
+				}
+				topics = append(topics, topic)
+			}
+			b.mu.Unlock()
+			conn.Write(append([]byte{0x90, byte(2 + len(granted)), body[0], body[1]}, granted...))
+			for topic, payload := range retained {
+				writePublish(conn, topic, payload)
+			}
+			for _, topic := range topics {
+				select {
+				case b.subscribed <- topic:
+				default:
+				}
+			}
+		case 12: // PINGREQ
+			conn.Write([]byte{0xD0, 0x00})
+		case 14: // DISCONNECT
+			b.mu.Lock()
+			delete(b.clients, conn)
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// writePublish sends a QoS 0 publish of less than 128 bytes
+func writePublish(conn net.Conn, topic string, payload string) {
+	packet := []byte{0x30, byte(2 + len(topic) + len(payload)), 0, byte(len(topic))}
+	packet = append(packet, topic...)
+	conn.Write(append(packet, payload...))
+}
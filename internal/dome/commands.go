@@ -0,0 +1,122 @@
+package dome
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/thebuh/barn/internal/command"
+)
+
+// defaultStatePatterns match the state names as words in any case
+var defaultStatePatterns = map[ShutterState]string{
+	ShutterOpen:    `(?i)\bopen\b`,
+	ShutterClosed:  `(?i)\bclosed\b`,
+	ShutterOpening: `(?i)\bopening\b`,
+	ShutterClosing: `(?i)\bclosing\b`,
+	ShutterError:   `(?i)\berror\b`,
+}
+
+// matchOrder checks faults and movement before the limits
+var matchOrder = []ShutterState{ShutterError, ShutterOpening, ShutterClosing, ShutterOpen, ShutterClosed}
+
+// StateMatcher maps the raw status to a state with regular expressions
+type StateMatcher struct {
+	patterns map[ShutterState]*regexp.Regexp
+}
+
+// NewStateMatcher compiles patterns for states, using the defaults for
+// states without one
+func NewStateMatcher(patterns map[ShutterState]string) (*StateMatcher, error) {
+	m := &StateMatcher{patterns: make(map[ShutterState]*regexp.Regexp, len(matchOrder))}
+	for _, state := range matchOrder {
+		pattern, exists := patterns[state]
+		if !exists || pattern == "" {
+			pattern = defaultStatePatterns[state]
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern: %w", state, err)
+		}
+		m.patterns[state] = re
+	}
+	return m, nil
+}
+
+// Match returns the first state whose pattern matches the status without
+// surrounding whitespace
+func (m *StateMatcher) Match(raw string) (ShutterState, error) {
+	raw = strings.TrimSpace(raw)
+	for _, state := range matchOrder {
+		if m.patterns[state].MatchString(raw) {
+			return state, nil
+		}
+	}
+	return ShutterError, fmt.Errorf("unknown status %q", raw)
+}
+
+// Commands are the actions of a CommandController. Abort is optional.
+type Commands struct {
+	Open  command.Command
+	Close command.Command
+	Abort command.Command
+}
+
+// CommandController drives a roof controller with commands and reads its
+// state from a status
+type CommandController struct {
+	commands Commands
+	status   command.Status
+	matcher  *StateMatcher
+	// closer releases a connection shared by the commands
+	closer io.Closer
+}
+
+// NewCommandController creates a controller. A nil matcher uses the default
+// patterns.
+func NewCommandController(commands Commands, status command.Status, matcher *StateMatcher) (*CommandController, error) {
+	if commands.Open == nil || commands.Close == nil {
+		return nil, errors.New("open and close commands are required")
+	}
+	if status == nil {
+		return nil, errors.New("status is required")
+	}
+	if matcher == nil {
+		matcher, _ = NewStateMatcher(nil)
+	}
+	return &CommandController{commands: commands, status: status, matcher: matcher}, nil
+}
+
+func (c *CommandController) OpenShutter() error {
+	return c.commands.Open.Run()
+}
+
+func (c *CommandController) CloseShutter() error {
+	return c.commands.Close.Run()
+}
+
+// AbortSlew fails with ErrNotSupported without an abort command
+func (c *CommandController) AbortSlew() error {
+	if c.commands.Abort == nil {
+		return ErrNotSupported
+	}
+	return c.commands.Abort.Run()
+}
+
+func (c *CommandController) ShutterStatus() (ShutterState, error) {
+	raw, err := c.status.Read()
+	if err != nil {
+		return ShutterError, err
+	}
+	return c.matcher.Match(raw)
+}
+
+// Close releases the MQTT connection, if any
+func (c *CommandController) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
@@ -0,0 +1,80 @@
+package dome
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/command"
+	"github.com/thebuh/barn/internal/fetch"
+)
+
+func TestStateMatcher(t *testing.T) {
+	matcher, err := NewStateMatcher(nil)
+	assert.NoError(t, err, "should work")
+	for raw, expected := range map[string]ShutterState{
+		"OPEN":               ShutterOpen,
+		"roof=closed\n":      ShutterClosed,
+		"Opening":            ShutterOpening,
+		"closing":            ShutterClosing,
+		"error: motor fault": ShutterError,
+	} {
+		state, err := matcher.Match(raw)
+		assert.NoError(t, err, "should work")
+		assert.Equal(t, expected, state, "they should be equal")
+	}
+	state, err := matcher.Match("halfway")
+	assert.Error(t, err, "unknown states should fail")
+	assert.Equal(t, ShutterError, state, "they should be equal")
+
+	matcher, err = NewStateMatcher(map[ShutterState]string{ShutterOpen: `^1$`, ShutterClosed: `^0$`})
+	assert.NoError(t, err, "should work")
+	state, _ = matcher.Match("1")
+	assert.Equal(t, ShutterOpen, state, "they should be equal")
+	state, _ = matcher.Match("closing")
+	assert.Equal(t, ShutterClosing, state, "states without a pattern should use the default")
+
+	_, err = NewStateMatcher(map[ShutterState]string{ShutterOpen: `(`})
+	assert.Error(t, err, "should fail")
+}
+
+func TestCommandController_HTTP(t *testing.T) {
+	var mu sync.Mutex
+	state := "closed"
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/open":
+			state = "opening"
+		case "/close":
+			state = "closing"
+		case "/status":
+			w.Write([]byte(`{"roof": "` + state + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	open, _ := command.NewHTTP(server.URL+"/open", fetch.Options{})
+	closeCommand, _ := command.NewHTTP(server.URL+"/close", fetch.Options{Method: "put"})
+	status, _ := command.NewHTTPStatus(server.URL+"/status", fetch.Options{})
+	controller, err := NewCommandController(Commands{Open: open, Close: closeCommand}, status, nil)
+	assert.NoError(t, err, "should work")
+
+	assert.NoError(t, controller.OpenShutter(), "should work")
+	current, err := controller.ShutterStatus()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, ShutterOpening, current, "they should be equal")
+	assert.NoError(t, controller.CloseShutter(), "should work")
+	assert.ErrorIs(t, controller.AbortSlew(), ErrNotSupported, "they should be equal")
+	assert.Equal(t, []string{"POST /open", "GET /status", "PUT /close"}, requests, "they should be equal")
+
+	_, err = NewCommandController(Commands{Open: open}, status, nil)
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,76 @@
+package dome
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/thebuh/barn/internal/command"
+)
+
+// OptionsFromConfig reads open_timeout, close_timeout, safety_monitor and
+// auto_close from a dome section
+func OptionsFromConfig(vt *viper.Viper) (Options, error) {
+	options := Options{
+		OpenTimeout:   vt.GetDuration("open_timeout"),
+		CloseTimeout:  vt.GetDuration("close_timeout"),
+		SafetyMonitor: vt.GetString("safety_monitor"),
+		AutoClose:     vt.GetBool("auto_close"),
+	}
+	return options, options.Validate()
+}
+
+// SimulatorFromConfig reads travel and open
+func SimulatorFromConfig(vt *viper.Viper) (*Simulator, error) {
+	return NewSimulator(vt.GetDuration("travel"), vt.GetBool("open"))
+}
+
+// ControllerFromConfig reads the open, close and optional abort commands and
+// the status. Each has one of an http, exec or mqtt section; mqtt sections
+// need the broker connection of the mqtt section.
+func ControllerFromConfig(vt *viper.Viper) (*CommandController, error) {
+	connection, err := command.MQTTFromConfig(vt)
+	if err != nil {
+		return nil, err
+	}
+	controller, err := commandControllerFromConfig(vt, connection)
+	if err != nil {
+		if connection != nil {
+			connection.Close()
+		}
+		return nil, err
+	}
+	if connection != nil {
+		controller.closer = connection
+	}
+	return controller, nil
+}
+
+func commandControllerFromConfig(vt *viper.Viper, connection *command.MQTTConnection) (*CommandController, error) {
+	var commands Commands
+	for _, c := range []struct {
+		key    string
+		target *command.Command
+	}{{"open", &commands.Open}, {"close", &commands.Close}, {"abort", &commands.Abort}} {
+		if c.key == "abort" && !vt.IsSet(c.key) {
+			continue
+		}
+		cmd, err := command.FromConfig(vt.Sub(c.key), connection)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.key, err)
+		}
+		*c.target = cmd
+	}
+	status, err := command.StatusFromConfig(vt.Sub("status"), connection)
+	if err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+	patterns := make(map[ShutterState]string)
+	for _, state := range matchOrder {
+		patterns[state] = vt.GetString("status.states." + state.String())
+	}
+	matcher, err := NewStateMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("status: %w", err)
+	}
+	return NewCommandController(commands, status, matcher)
+}
@@ -0,0 +1,120 @@
+package dome
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/command"
+	"github.com/thebuh/barn/internal/command/mqtttest"
+)
+
+func readConfig(t *testing.T, content string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(content)), "should work")
+	return v
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	options, err := OptionsFromConfig(readConfig(t, `
+open_timeout: 90s
+close_timeout: 1m
+safety_monitor: weather
+auto_close: true
+`))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, Options{
+		OpenTimeout:   90 * time.Second,
+		CloseTimeout:  time.Minute,
+		SafetyMonitor: "weather",
+		AutoClose:     true,
+	}, options, "they should be equal")
+
+	_, err = OptionsFromConfig(readConfig(t, `auto_close: true`))
+	assert.Error(t, err, "should fail")
+
+	sim, err := SimulatorFromConfig(readConfig(t, `travel: 3s`))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, 3*time.Second, sim.travel, "they should be equal")
+}
+
+func TestControllerFromConfig(t *testing.T) {
+	controller, err := ControllerFromConfig(readConfig(t, `
+open:
+  exec:
+    command: sh
+    args: ["-c", "exit 0"]
+close:
+  http:
+    url: http://roof.local/close
+    method: PUT
+    timeout: 2s
+status:
+  exec:
+    command: echo
+    args: ["1"]
+  states:
+    open: "^1$"
+    closed: "^0$"
+`))
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, controller.OpenShutter(), "should work")
+	assert.ErrorIs(t, controller.AbortSlew(), ErrNotSupported, "they should be equal")
+	state, err := controller.ShutterStatus()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, ShutterOpen, state, "they should be equal")
+	assert.IsType(t, &command.HTTP{}, controller.commands.Close, "they should be equal")
+	assert.NoError(t, controller.Close(), "should work")
+}
+
+func TestControllerFromConfig_MQTT(t *testing.T) {
+	broker := mqtttest.NewBroker()
+	defer broker.Close()
+	broker.Publish("roof/state", "open")
+	controller, err := ControllerFromConfig(readConfig(t, `
+mqtt:
+  broker: `+broker.URL()+`
+open:
+  mqtt: {topic: roof/set, payload: OPEN}
+close:
+  mqtt: {topic: roof/set, payload: CLOSE}
+abort:
+  mqtt: {topic: roof/set, payload: STOP}
+status:
+  mqtt: {topic: roof/state}
+`))
+	assert.NoError(t, err, "should work")
+	defer controller.Close()
+	assert.NoError(t, controller.AbortSlew(), "should work")
+	assert.Equal(t, []string{"roof/set=STOP"}, broker.Received(), "they should be equal")
+	assert.Eventually(t, func() bool {
+		state, err := controller.ShutterStatus()
+		return err == nil && state == ShutterOpen
+	}, 5*time.Second, 10*time.Millisecond, "they should be equal")
+}
+
+func TestControllerFromConfig_Errors(t *testing.T) {
+	valid := `
+close:
+  exec: {command: roof-close}
+status:
+  exec: {command: roof-status}
+`
+	for name, content := range map[string]string{
+		"missing open":   valid,
+		"no kind":        valid + "open: {timeout: 2s}\n",
+		"two kinds":      valid + "open:\n  exec: {command: roof-open}\n  http: {url: http://roof.local}\n",
+		"bad url":        valid + "open:\n  http: {url: roof.local/open}\n",
+		"no broker":      valid + "open:\n  mqtt: {topic: roof/set}\n",
+		"empty broker":   valid + "open:\n  exec: {command: roof-open}\nmqtt: {client_id: barn}\n",
+		"bad pattern":    "open:\n  exec: {command: roof-open}\nclose:\n  exec: {command: roof-close}\nstatus:\n  exec: {command: roof-status}\n  states: {open: \"(\"}\n",
+		"no exec":        valid + "open:\n  exec: {args: [open]}\n",
+		"invalid status": "open:\n  exec: {command: roof-open}\nclose:\n  exec: {command: roof-close}\nstatus: {}\n",
+	} {
+		_, err := ControllerFromConfig(readConfig(t, content))
+		assert.Error(t, err, name+" should fail")
+	}
+}
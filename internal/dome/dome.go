@@ -0,0 +1,369 @@
+package dome
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/monitor"
+)
+
+// ShutterState values are those of the Alpaca ShutterStatus property
+type ShutterState int
+
+const (
+	ShutterOpen ShutterState = iota
+	ShutterClosed
+	ShutterOpening
+	ShutterClosing
+	ShutterError
+)
+
+const DefaultTimeout = 2 * time.Minute
+
+// statusMaxAge limits how often clients polling ShutterStatus reach the
+// status source
+const statusMaxAge = time.Second
+
+var (
+	ErrUnsafe       = errors.New("safety monitor is unsafe")
+	ErrNotSupported = errors.New("not supported by the controller")
+)
+
+func (s ShutterState) String() string {
+	switch s {
+	case ShutterOpen:
+		return "open"
+	case ShutterClosed:
+		return "closed"
+	case ShutterOpening:
+		return "opening"
+	case ShutterClosing:
+		return "closing"
+	case ShutterError:
+		return "error"
+	}
+	return fmt.Sprintf("ShutterState(%d)", int(s))
+}
+
+// Controller moves a roof and reads its state
+type Controller interface {
+	OpenShutter() error
+	CloseShutter() error
+	AbortSlew() error
+	ShutterStatus() (ShutterState, error)
+}
+
+// SafetyLookup returns the monitor with an id, or nil if there is none
+type SafetyLookup func(id string) monitor.SafetyMonitor
+
+// Dome is a dome device with a shutter
+type Dome interface {
+	GetId() string
+	GetName() string
+	GetDescription() string
+	OpenShutter() error
+	CloseShutter() error
+	AbortSlew() error
+	ShutterStatus() ShutterState
+	Slewing() bool
+	GetTimeStamp() time.Time
+	UseSafety(lookup SafetyLookup)
+	Refresh() error
+}
+
+// Options of a roof. Zero timeouts use DefaultTimeout.
+type Options struct {
+	OpenTimeout  time.Duration
+	CloseTimeout time.Duration
+	// SafetyMonitor is the id of the monitor that must be safe to open
+	SafetyMonitor string
+	// AutoClose closes the roof on refresh while the monitor is unsafe
+	AutoClose bool
+}
+
+// Validate checks the options
+func (o Options) Validate() error {
+	if o.OpenTimeout < 0 || o.CloseTimeout < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+	if o.AutoClose && o.SafetyMonitor == "" {
+		return errors.New("auto close requires a safety monitor")
+	}
+	return nil
+}
+
+// Roof is a dome with only a shutter, such as a roll-off roof. It tracks
+// the movement it started until the controller reports the target state
+// and reports an error when that takes longer than the timeout. Controller
+// calls run without holding mu, so a hung command does not block aborting
+// or reading the state.
+type Roof struct {
+	id          string
+	name        string
+	description string
+	controller  Controller
+	options     Options
+
+	mu       sync.Mutex
+	safety   SafetyLookup
+	reported ShutterState
+	polled   time.Time
+	pollErr  error
+	polling  bool
+	// commands counts the commands sent, so results of commands and status
+	// reads overtaken by a later command are dropped
+	commands uint64
+	// moving is set from a command until the target is reported or the
+	// deadline passes
+	moving   bool
+	target   ShutterState
+	deadline time.Time
+	timedOut bool
+}
+
+// NewRoof creates a roof moved by a controller
+func NewRoof(id string, name string, description string, controller Controller, options Options) (*Roof, error) {
+	if controller == nil {
+		return nil, errors.New("controller is required")
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.OpenTimeout == 0 {
+		options.OpenTimeout = DefaultTimeout
+	}
+	if options.CloseTimeout == 0 {
+		options.CloseTimeout = DefaultTimeout
+	}
+	return &Roof{
+		id:          id,
+		name:        name,
+		description: description,
+		controller:  controller,
+		options:     options,
+		reported:    ShutterError,
+	}, nil
+}
+
+func (r *Roof) GetId() string {
+	return r.id
+}
+
+func (r *Roof) GetName() string {
+	return r.name
+}
+
+func (r *Roof) GetDescription() string {
+	return r.description
+}
+
+// GetSafetyMonitor returns the id of the linked monitor, if any
+func (r *Roof) GetSafetyMonitor() string {
+	return r.options.SafetyMonitor
+}
+
+// Options returns the options with defaults applied
+func (r *Roof) Options() Options {
+	return r.options
+}
+
+// UseSafety sets how the linked monitor is found
+func (r *Roof) UseSafety(lookup SafetyLookup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.safety = lookup
+}
+
+// isSafe reports whether the roof may open. A linked monitor that cannot be
+// found is unsafe.
+func (r *Roof) isSafe() bool {
+	if r.options.SafetyMonitor == "" {
+		return true
+	}
+	r.mu.Lock()
+	lookup := r.safety
+	r.mu.Unlock()
+	if lookup == nil {
+		return false
+	}
+	m := lookup(r.options.SafetyMonitor)
+	return m != nil && m.IsSafe()
+}
+
+// OpenShutter starts opening the roof unless the linked monitor is unsafe
+func (r *Roof) OpenShutter() error {
+	if !r.isSafe() {
+		return ErrUnsafe
+	}
+	return r.move(ShutterOpen, r.controller.OpenShutter, r.options.OpenTimeout)
+}
+
+// CloseShutter starts closing the roof, which is always allowed
+func (r *Roof) CloseShutter() error {
+	return r.move(ShutterClosed, r.controller.CloseShutter, r.options.CloseTimeout)
+}
+
+func (r *Roof) move(target ShutterState, command func() error, timeout time.Duration) error {
+	sent := r.startCommand()
+	if err := command(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sent != r.commands {
+		// Overtaken, e.g. by an abort sent while this command ran
+		return nil
+	}
+	r.moving = true
+	r.target = target
+	r.deadline = time.Now().Add(timeout)
+	r.timedOut = false
+	// The next status must come from the controller, not from before the
+	// command
+	r.polled = time.Time{}
+	return nil
+}
+
+// startCommand counts a command about to be sent and returns its number
+func (r *Roof) startCommand() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands++
+	return r.commands
+}
+
+// AbortSlew stops the roof
+func (r *Roof) AbortSlew() error {
+	sent := r.startCommand()
+	if err := r.controller.AbortSlew(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sent != r.commands {
+		return nil
+	}
+	r.moving = false
+	r.timedOut = false
+	r.polled = time.Time{}
+	return nil
+}
+
+// ShutterStatus returns the state of the roof. Failures to read the status
+// source report ShutterError. While another caller reads the status source,
+// the last state read is returned instead of waiting.
+func (r *Roof) ShutterStatus() ShutterState {
+	r.mu.Lock()
+	stale := time.Since(r.polled) >= statusMaxAge && !r.polling
+	if stale {
+		r.polling = true
+	}
+	r.mu.Unlock()
+	if stale {
+		r.poll()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stale {
+		r.polling = false
+	}
+	return r.state()
+}
+
+// Slewing is true while the roof opens or closes
+func (r *Roof) Slewing() bool {
+	state := r.ShutterStatus()
+	return state == ShutterOpening || state == ShutterClosing
+}
+
+// GetTimeStamp returns when the status source was last read
+func (r *Roof) GetTimeStamp() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.polled
+}
+
+// Refresh reads the status source and closes the roof when auto close is
+// enabled and the linked monitor is unsafe
+func (r *Roof) Refresh() error {
+	r.poll()
+	r.mu.Lock()
+	state, err := r.state(), r.pollErr
+	r.mu.Unlock()
+
+	if r.options.AutoClose && state != ShutterClosed && state != ShutterClosing && !r.isSafe() {
+		log.WithFields(log.Fields{
+			"dome":    r.name,
+			"state":   state.String(),
+			"monitor": r.options.SafetyMonitor,
+		}).Warn(fmt.Sprintf("[BARN] Dome [%s]. Safety monitor [%s] is unsafe, closing", r.name, r.options.SafetyMonitor))
+		if err := r.CloseShutter(); err != nil {
+			return fmt.Errorf("auto close: %w", err)
+		}
+	}
+	return err
+}
+
+// poll reads the status source. A status read while a command was sent is
+// dropped, as it may be from before the command. Callers must not hold mu.
+func (r *Roof) poll() {
+	r.mu.Lock()
+	sent := r.commands
+	r.mu.Unlock()
+
+	reported, err := r.controller.ShutterStatus()
+	if err != nil {
+		reported = ShutterError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sent != r.commands {
+		return
+	}
+	r.reported, r.pollErr = reported, err
+	r.polled = time.Now()
+}
+
+// state combines the reported state with the movement in progress. Callers
+// must hold mu.
+func (r *Roof) state() ShutterState {
+	if r.moving {
+		switch {
+		case r.reported == r.target:
+			r.moving = false
+		case r.reported == ShutterError && r.pollErr == nil:
+			// The controller itself reports a fault
+			r.moving = false
+		case time.Now().After(r.deadline):
+			r.moving = false
+			r.timedOut = true
+			log.WithFields(log.Fields{
+				"dome":     r.name,
+				"target":   r.target.String(),
+				"reported": r.reported.String(),
+			}).Error(fmt.Sprintf("[BARN] Dome [%s]. Roof did not reach [%s] in time", r.name, r.target))
+		case r.target == ShutterOpen:
+			return ShutterOpening
+		default:
+			return ShutterClosing
+		}
+	}
+	if r.timedOut {
+		return ShutterError
+	}
+	return r.reported
+}
+
+// Close releases connections held by the controller
+func (r *Roof) Close() error {
+	if closer, ok := r.controller.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package dome
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/monitor"
+)
+
+// fakeController reports whatever state the test sets
+type fakeController struct {
+	mu       sync.Mutex
+	state    ShutterState
+	err      error
+	commands []string
+}
+
+func (f *fakeController) record(command string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, command)
+	return nil
+}
+
+func (f *fakeController) OpenShutter() error  { return f.record("open") }
+func (f *fakeController) CloseShutter() error { return f.record("close") }
+func (f *fakeController) AbortSlew() error    { return f.record("abort") }
+
+func (f *fakeController) ShutterStatus() (ShutterState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state, f.err
+}
+
+func (f *fakeController) set(state ShutterState, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state, f.err = state, err
+}
+
+func (f *fakeController) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func monitors(ms ...monitor.SafetyMonitor) SafetyLookup {
+	return func(id string) monitor.SafetyMonitor {
+		for _, m := range ms {
+			if m.GetId() == id {
+				return m
+			}
+		}
+		return nil
+	}
+}
+
+func TestRoof_OpenAndClose(t *testing.T) {
+	controller := &fakeController{state: ShutterClosed}
+	roof, err := NewRoof("roof", "Roof", "", controller, Options{})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, DefaultTimeout, roof.Options().OpenTimeout, "they should be equal")
+	assert.Equal(t, ShutterClosed, roof.ShutterStatus(), "they should be equal")
+
+	assert.NoError(t, roof.OpenShutter(), "should work")
+	assert.Equal(t, ShutterOpening, roof.ShutterStatus(), "the roof should open until the controller reports open")
+	assert.Equal(t, true, roof.Slewing(), "they should be equal")
+
+	controller.set(ShutterOpen, nil)
+	assert.NoError(t, roof.Refresh(), "should work")
+	assert.Equal(t, ShutterOpen, roof.ShutterStatus(), "they should be equal")
+	assert.Equal(t, false, roof.Slewing(), "they should be equal")
+
+	assert.NoError(t, roof.CloseShutter(), "should work")
+	assert.Equal(t, ShutterClosing, roof.ShutterStatus(), "they should be equal")
+	assert.NoError(t, roof.AbortSlew(), "should work")
+	assert.Equal(t, ShutterOpen, roof.ShutterStatus(), "aborting should report the controller state")
+	assert.Equal(t, []string{"open", "close", "abort"}, controller.sent(), "they should be equal")
+}
+
+func TestRoof_Timeout(t *testing.T) {
+	controller := &fakeController{state: ShutterClosed}
+	roof, _ := NewRoof("roof", "Roof", "", controller, Options{OpenTimeout: 20 * time.Millisecond})
+	assert.NoError(t, roof.OpenShutter(), "should work")
+	time.Sleep(30 * time.Millisecond)
+	roof.Refresh()
+	assert.Equal(t, ShutterError, roof.ShutterStatus(), "roofs that do not open in time should report an error")
+
+	// Reaching the limit later does not clear the error, a new command does
+	controller.set(ShutterOpen, nil)
+	roof.Refresh()
+	assert.Equal(t, ShutterError, roof.ShutterStatus(), "they should be equal")
+	assert.NoError(t, roof.CloseShutter(), "should work")
+	controller.set(ShutterClosed, nil)
+	roof.Refresh()
+	assert.Equal(t, ShutterClosed, roof.ShutterStatus(), "they should be equal")
+}
+
+func TestRoof_StatusErrors(t *testing.T) {
+	controller := &fakeController{state: ShutterClosed}
+	roof, _ := NewRoof("roof", "Roof", "", controller, Options{})
+	controller.set(ShutterClosed, errors.New("unreachable"))
+	assert.Error(t, roof.Refresh(), "should fail")
+	assert.Equal(t, ShutterError, roof.ShutterStatus(), "they should be equal")
+
+	// Faults reported by the controller end a movement
+	controller.set(ShutterClosed, nil)
+	roof.OpenShutter()
+	controller.set(ShutterError, nil)
+	roof.Refresh()
+	assert.Equal(t, ShutterError, roof.ShutterStatus(), "they should be equal")
+	assert.Equal(t, false, roof.Slewing(), "they should be equal")
+}
+
+func TestRoof_Safety(t *testing.T) {
+	controller := &fakeController{state: ShutterClosed}
+	weather := monitor.NewSafetyMonitorDummy("weather", "Weather", "", false)
+	roof, _ := NewRoof("roof", "Roof", "", controller, Options{SafetyMonitor: "weather"})
+	assert.ErrorIs(t, roof.OpenShutter(), ErrUnsafe, "roofs without a lookup should not open")
+
+	roof.UseSafety(monitors(weather))
+	assert.ErrorIs(t, roof.OpenShutter(), ErrUnsafe, "they should be equal")
+	assert.NoError(t, roof.CloseShutter(), "closing should always be allowed")
+
+	roof.UseSafety(monitors(monitor.NewSafetyMonitorDummy("weather", "Weather", "", true)))
+	assert.NoError(t, roof.OpenShutter(), "should work")
+	roof.UseSafety(monitors())
+	assert.ErrorIs(t, roof.OpenShutter(), ErrUnsafe, "missing monitors should be unsafe")
+	assert.Equal(t, []string{"close", "open"}, controller.sent(), "they should be equal")
+}
+
+func TestRoof_AutoClose(t *testing.T) {
+	controller := &fakeController{state: ShutterOpen}
+	safe := true
+	roof, _ := NewRoof("roof", "Roof", "", controller, Options{SafetyMonitor: "weather", AutoClose: true})
+	roof.UseSafety(func(id string) monitor.SafetyMonitor {
+		return monitor.NewSafetyMonitorDummy(id, "Weather", "", safe)
+	})
+	assert.NoError(t, roof.Refresh(), "should work")
+	assert.Empty(t, controller.sent(), "safe roofs should stay open")
+
+	safe = false
+	assert.NoError(t, roof.Refresh(), "should work")
+	assert.Equal(t, []string{"close"}, controller.sent(), "unsafe roofs should close")
+	assert.Equal(t, ShutterClosing, roof.ShutterStatus(), "they should be equal")
+	roof.Refresh()
+	assert.Equal(t, []string{"close"}, controller.sent(), "closing roofs should not be closed again")
+
+	_, err := NewRoof("roof", "Roof", "", controller, Options{AutoClose: true})
+	assert.Error(t, err, "auto close needs a monitor")
+	_, err = NewRoof("roof", "Roof", "", nil, Options{})
+	assert.Error(t, err, "should fail")
+}
+
+// hangingController blocks opening until release is closed
+type hangingController struct {
+	*fakeController
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *hangingController) OpenShutter() error {
+	close(h.started)
+	<-h.release
+	return h.record("open")
+}
+
+func TestRoof_HungCommand(t *testing.T) {
+	controller := &hangingController{&fakeController{state: ShutterClosed}, make(chan struct{}), make(chan struct{})}
+	roof, err := NewRoof("roof", "Roof", "", controller, Options{})
+	assert.NoError(t, err, "should work")
+
+	opened := make(chan error)
+	go func() { opened <- roof.OpenShutter() }()
+	<-controller.started
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Equal(t, ShutterClosed, roof.ShutterStatus(), "status should be read while a command hangs")
+		assert.NoError(t, roof.AbortSlew(), "aborting should not wait for the command")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by the hung command")
+	}
+
+	close(controller.release)
+	assert.NoError(t, <-opened, "should work")
+	assert.Equal(t, false, roof.Slewing(), "the abort was sent last")
+	assert.Equal(t, []string{"abort", "open"}, controller.sent(), "they should be equal")
+}
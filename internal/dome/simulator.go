@@ -0,0 +1,88 @@
+package dome
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const DefaultTravel = 10 * time.Second
+
+// Simulator is a roof that takes travel to open or close. Aborting while it
+// moves leaves it between the limits, which it reports as an error.
+type Simulator struct {
+	travel time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	state   ShutterState
+	arrives time.Time
+}
+
+// NewSimulator creates a simulated roof, closed unless open is set. A zero
+// travel uses DefaultTravel.
+func NewSimulator(travel time.Duration, open bool) (*Simulator, error) {
+	if travel < 0 {
+		return nil, errors.New("travel must not be negative")
+	}
+	if travel == 0 {
+		travel = DefaultTravel
+	}
+	s := &Simulator{travel: travel, now: time.Now, state: ShutterClosed}
+	if open {
+		s.state = ShutterOpen
+	}
+	return s, nil
+}
+
+func (s *Simulator) OpenShutter() error {
+	return s.start(ShutterOpening, ShutterOpen)
+}
+
+func (s *Simulator) CloseShutter() error {
+	return s.start(ShutterClosing, ShutterClosed)
+}
+
+// start moves the roof the whole travel, unless it is at or moving to the
+// target already
+func (s *Simulator) start(moving ShutterState, target ShutterState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update()
+	if s.state == moving || s.state == target {
+		return nil
+	}
+	s.state = moving
+	s.arrives = s.now().Add(s.travel)
+	return nil
+}
+
+func (s *Simulator) AbortSlew() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update()
+	if s.state == ShutterOpening || s.state == ShutterClosing {
+		s.state = ShutterError
+	}
+	return nil
+}
+
+func (s *Simulator) ShutterStatus() (ShutterState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update()
+	return s.state, nil
+}
+
+// update ends the movement once the travel is over. Callers must hold mu.
+func (s *Simulator) update() {
+	if s.now().Before(s.arrives) {
+		return
+	}
+	switch s.state {
+	case ShutterOpening:
+		s.state = ShutterOpen
+	case ShutterClosing:
+		s.state = ShutterClosed
+	}
+}
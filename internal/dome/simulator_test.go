@@ -0,0 +1,50 @@
+package dome
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulator(t *testing.T) {
+	now := time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC)
+	sim, err := NewSimulator(time.Minute, false)
+	assert.NoError(t, err, "should work")
+	sim.now = func() time.Time { return now }
+
+	state, _ := sim.ShutterStatus()
+	assert.Equal(t, ShutterClosed, state, "they should be equal")
+	sim.OpenShutter()
+	now = now.Add(30 * time.Second)
+	state, _ = sim.ShutterStatus()
+	assert.Equal(t, ShutterOpening, state, "they should be equal")
+	sim.OpenShutter()
+	now = now.Add(30 * time.Second)
+	state, _ = sim.ShutterStatus()
+	assert.Equal(t, ShutterOpen, state, "opening again should not restart the travel")
+
+	sim.CloseShutter()
+	now = now.Add(10 * time.Second)
+	sim.AbortSlew()
+	now = now.Add(time.Minute)
+	state, _ = sim.ShutterStatus()
+	assert.Equal(t, ShutterError, state, "aborted roofs should stop between the limits")
+	sim.CloseShutter()
+	now = now.Add(time.Minute)
+	state, _ = sim.ShutterStatus()
+	assert.Equal(t, ShutterClosed, state, "they should be equal")
+}
+
+func TestSimulator_Defaults(t *testing.T) {
+	sim, _ := NewSimulator(0, true)
+	assert.Equal(t, DefaultTravel, sim.travel, "they should be equal")
+	state, _ := sim.ShutterStatus()
+	assert.Equal(t, ShutterOpen, state, "they should be equal")
+	assert.NoError(t, sim.AbortSlew(), "aborting a stopped roof should work")
+	state, _ = sim.ShutterStatus()
+	assert.Equal(t, ShutterOpen, state, "they should be equal")
+
+	_, err := NewSimulator(-time.Second, false)
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,34 @@
+package fetch
+
+import (
+	"fmt"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// OptionsFromConfig reads request options from a device section: method,
+// body, headers, auth.{username, password, token}, tls.{ca,
+// insecure_skip_verify}, timeout, max_body_size and expected_status
+func OptionsFromConfig(vt *viper.Viper) (Options, error) {
+	options := Options{
+		Method:             vt.GetString("method"),
+		Body:               vt.GetString("body"),
+		Headers:            vt.GetStringMapString("headers"),
+		Username:           vt.GetString("auth.username"),
+		Password:           vt.GetString("auth.password"),
+		Token:              vt.GetString("auth.token"),
+		CAFile:             vt.GetString("tls.ca"),
+		InsecureSkipVerify: vt.GetBool("tls.insecure_skip_verify"),
+		Timeout:            vt.GetDuration("timeout"),
+		MaxBodySize:        vt.GetInt64("max_body_size"),
+	}
+	if vt.IsSet("expected_status") {
+		status, err := cast.ToIntSliceE(vt.Get("expected_status"))
+		if err != nil {
+			return options, fmt.Errorf("invalid expected_status: %w", err)
+		}
+		options.ExpectedStatus = status
+	}
+	return options, options.Validate()
+}
@@ -0,0 +1,55 @@
+package fetch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func configFromYaml(t *testing.T, content string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(content)), "should work")
+	return v
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	options, err := OptionsFromConfig(configFromYaml(t, `
+method: POST
+body: '{"q": 1}'
+headers:
+  X-Api-Key: secret
+auth:
+  username: barn
+  password: pass
+tls:
+  ca: /etc/barn/ca.pem
+  insecure_skip_verify: true
+timeout: 10s
+max_body_size: 4096
+expected_status: [200, 503]
+`))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "POST", options.Method, "they should be equal")
+	assert.Equal(t, `{"q": 1}`, options.Body, "they should be equal")
+	assert.Equal(t, map[string]string{"x-api-key": "secret"}, options.Headers, "they should be equal")
+	assert.Equal(t, "barn", options.Username, "they should be equal")
+	assert.Equal(t, "pass", options.Password, "they should be equal")
+	assert.Equal(t, "/etc/barn/ca.pem", options.CAFile, "they should be equal")
+	assert.Equal(t, true, options.InsecureSkipVerify, "they should be equal")
+	assert.Equal(t, 10*time.Second, options.Timeout, "they should be equal")
+	assert.Equal(t, int64(4096), options.MaxBodySize, "they should be equal")
+	assert.Equal(t, []int{200, 503}, options.ExpectedStatus, "they should be equal")
+
+	options, err = OptionsFromConfig(configFromYaml(t, "url: http://localhost\n"))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, Options{Headers: map[string]string{}}, options, "missing keys should keep the defaults")
+
+	_, err = OptionsFromConfig(configFromYaml(t, "expected_status: [ok]\n"))
+	assert.Error(t, err, "should fail")
+	_, err = OptionsFromConfig(configFromYaml(t, "auth: {username: barn, token: abc}\n"))
+	assert.Error(t, err, "should fail")
+}
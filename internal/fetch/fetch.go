@@ -0,0 +1,161 @@
+package fetch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout     = 5 * time.Second
+	DefaultMaxBodySize = 64 * 1024
+)
+
+var (
+	ErrUnexpectedStatus = errors.New("unexpected status")
+	ErrBodyTooLarge     = errors.New("response body too large")
+)
+
+// Options customise the request a device sends to its URL. The zero value
+// is a GET with the default timeout and body limit that accepts any 2xx
+// status.
+type Options struct {
+	Method  string
+	Body    string
+	Headers map[string]string
+	// Username and Password send HTTP Basic credentials
+	Username string
+	Password string
+	// Token is sent as a bearer token
+	Token string
+	// CAFile verifies the server with these certificates instead of the
+	// system roots
+	CAFile             string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	MaxBodySize        int64
+	// ExpectedStatus lists the accepted status codes; empty accepts 2xx
+	ExpectedStatus []int
+}
+
+// Validate checks the options without loading files
+func (o Options) Validate() error {
+	if o.Username != "" && o.Token != "" {
+		return errors.New("basic auth and token are exclusive")
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if o.MaxBodySize < 0 {
+		return errors.New("max body size must not be negative")
+	}
+	for _, status := range o.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid status code %d", status)
+		}
+	}
+	return nil
+}
+
+// Client fetches one URL with its options
+type Client struct {
+	url     string
+	options Options
+	client  *http.Client
+}
+
+// New creates a client, loading the CA file if one is set
+func New(url string, options Options) (*Client, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Method == "" {
+		options.Method = http.MethodGet
+	}
+	options.Method = strings.ToUpper(options.Method)
+	if options.Timeout == 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.MaxBodySize == 0 {
+		options.MaxBodySize = DefaultMaxBodySize
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.CAFile != "" || options.InsecureSkipVerify {
+		config := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+		if options.CAFile != "" {
+			pem, err := os.ReadFile(options.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", options.CAFile)
+			}
+		}
+		transport.TLSClientConfig = config
+	}
+	return &Client{
+		url:     url,
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout, Transport: transport},
+	}, nil
+}
+
+// Options returns the options with defaults applied
+func (c *Client) Options() Options {
+	return c.options
+}
+
+// Fetch sends the request and reads the whole body. A status that is not
+// expected is an error wrapping ErrUnexpectedStatus.
+func (c *Client) Fetch() ([]byte, error) {
+	var body io.Reader
+	if c.options.Body != "" {
+		body = strings.NewReader(c.options.Body)
+	}
+	req, err := http.NewRequest(c.options.Method, c.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range c.options.Headers {
+		req.Header.Set(name, value)
+	}
+	if c.options.Username != "" {
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+	if c.options.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.options.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !c.expected(resp.StatusCode) {
+		return nil, fmt.Errorf("%w %d", ErrUnexpectedStatus, resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, c.options.MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > c.options.MaxBodySize {
+		return nil, fmt.Errorf("%w, limit is %d bytes", ErrBodyTooLarge, c.options.MaxBodySize)
+	}
+	return content, nil
+}
+
+func (c *Client) expected(status int) bool {
+	if len(c.options.ExpectedStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	return slices.Contains(c.options.ExpectedStatus, status)
+}
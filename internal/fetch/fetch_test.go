@@ -0,0 +1,146 @@
+package fetch
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetch_Defaults(t *testing.T) {
+	var method string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.Write([]byte(strings.Repeat("x", 5000)))
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL, Options{})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, DefaultTimeout, client.Options().Timeout, "they should be equal")
+	body, err := client.Fetch()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, http.MethodGet, method, "they should be equal")
+	assert.Equal(t, 5000, len(body), "the whole body should be read")
+}
+
+func TestFetch_Request(t *testing.T) {
+	var req *http.Request
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		req, body = r, string(content)
+	}))
+	defer server.Close()
+
+	client, err := New(server.URL, Options{
+		Method:   "post",
+		Body:     `{"query":"safe"}`,
+		Headers:  map[string]string{"x-api-key": "secret"},
+		Username: "barn",
+		Password: "pass",
+	})
+	assert.NoError(t, err, "should work")
+	_, err = client.Fetch()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, http.MethodPost, req.Method, "they should be equal")
+	assert.Equal(t, `{"query":"safe"}`, body, "they should be equal")
+	assert.Equal(t, "secret", req.Header.Get("X-Api-Key"), "they should be equal")
+	username, password, ok := req.BasicAuth()
+	assert.Equal(t, true, ok, "they should be equal")
+	assert.Equal(t, "barn", username, "they should be equal")
+	assert.Equal(t, "pass", password, "they should be equal")
+
+	client, _ = New(server.URL, Options{Token: "abc"})
+	_, err = client.Fetch()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"), "they should be equal")
+}
+
+func TestFetch_Status(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("1"))
+	}))
+	defer server.Close()
+
+	client, _ := New(server.URL, Options{})
+	_, err := client.Fetch()
+	assert.Equal(t, true, errors.Is(err, ErrUnexpectedStatus), "error pages should not be read")
+
+	client, _ = New(server.URL, Options{ExpectedStatus: []int{200, 503}})
+	body, err := client.Fetch()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "1", string(body), "they should be equal")
+}
+
+func TestFetch_MaxBodySize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	client, _ := New(server.URL, Options{MaxBodySize: 10})
+	_, err := client.Fetch()
+	assert.NoError(t, err, "a body at the limit should be read")
+
+	client, _ = New(server.URL, Options{MaxBodySize: 9})
+	_, err = client.Fetch()
+	assert.Equal(t, true, errors.Is(err, ErrBodyTooLarge), "they should be equal")
+}
+
+func TestFetch_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client, _ := New(server.URL, Options{Timeout: 50 * time.Millisecond})
+	_, err := client.Fetch()
+	assert.Error(t, err, "slow servers should time out")
+}
+
+func TestFetch_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1"))
+	}))
+	defer server.Close()
+
+	client, _ := New(server.URL, Options{})
+	_, err := client.Fetch()
+	assert.Error(t, err, "unknown certificates should be rejected")
+
+	client, _ = New(server.URL, Options{InsecureSkipVerify: true})
+	_, err = client.Fetch()
+	assert.NoError(t, err, "should work")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600), "should work")
+	client, err = New(server.URL, Options{CAFile: caFile})
+	assert.NoError(t, err, "should work")
+	_, err = client.Fetch()
+	assert.NoError(t, err, "the custom CA should be trusted")
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New("http://localhost", Options{CAFile: "/nonexistent/ca.pem"})
+	assert.Error(t, err, "should fail")
+	empty := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(empty, []byte("garbage"), 0600), "should work")
+	_, err = New("http://localhost", Options{CAFile: empty})
+	assert.Error(t, err, "should fail")
+	_, err = New("http://localhost", Options{Username: "barn", Token: "abc"})
+	assert.Error(t, err, "should fail")
+	_, err = New("http://localhost", Options{ExpectedStatus: []int{42}})
+	assert.Error(t, err, "should fail")
+	_, err = New("http://localhost", Options{Timeout: -time.Second})
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,154 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thebuh/barn/internal/fetch"
+)
+
+// Causes of refresh errors, from the most to the least specific
+const (
+	CauseDNS        = "dns"
+	CauseTimeout    = "timeout"
+	CauseTLS        = "tls"
+	CauseConnection = "connection"
+	CauseHTTPStatus = "http_status"
+	CauseParse      = "parse"
+	CauseOther      = "other"
+)
+
+// ParseError marks an error in reading a reply the device did send
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse marks err as a parse error. Nil stays nil.
+func Parse(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ParseError{Err: err}
+}
+
+// Cause names what made a refresh fail, or returns an empty string for nil
+func Cause(err error) string {
+	if err == nil {
+		return ""
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return CauseTimeout
+		}
+		return CauseDNS
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return CauseTimeout
+	}
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &certErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return CauseTLS
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return CauseConnection
+	}
+	if errors.Is(err, fetch.ErrUnexpectedStatus) {
+		return CauseHTTPStatus
+	}
+	var parseErr *ParseError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var numErr *strconv.NumError
+	if errors.As(err, &parseErr) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.As(err, &numErr) {
+		return CauseParse
+	}
+	return CauseOther
+}
+
+// Status sums up the refreshes of a device
+type Status struct {
+	LastError           string    `json:"last_error"`
+	LastErrorCause      string    `json:"last_error_cause"`
+	LastErrorTime       time.Time `json:"last_error_time"`
+	ErrorCount          int       `json:"error_count"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastSuccess         time.Time `json:"last_success"`
+}
+
+// Failing reports whether the last refresh failed
+func (s Status) Failing() bool {
+	return s.ConsecutiveFailures > 0
+}
+
+// Tracker keeps the status of devices by id
+type Tracker struct {
+	now func() time.Time
+
+	mu       sync.RWMutex
+	statuses map[string]Status
+}
+
+// NewTracker creates an empty tracker
+func NewTracker() *Tracker {
+	return &Tracker{now: time.Now, statuses: make(map[string]Status)}
+}
+
+// Observe records the outcome of a refresh and returns the new status. The
+// last error is kept after a success, so it can still be looked into.
+func (t *Tracker) Observe(id string, err error) Status {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.statuses[id]
+	if err == nil {
+		status.ConsecutiveFailures = 0
+		status.LastSuccess = now
+	} else {
+		status.LastError = err.Error()
+		status.LastErrorCause = Cause(err)
+		status.LastErrorTime = now
+		status.ErrorCount++
+		status.ConsecutiveFailures++
+	}
+	t.statuses[id] = status
+	return status
+}
+
+// Get returns the status of a device, which is empty before its first refresh
+func (t *Tracker) Get(id string) Status {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.statuses[id]
+}
+
+// Forget drops the status of a device
+func (t *Tracker) Forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.statuses, id)
+}
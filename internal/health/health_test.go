@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/fetch"
+)
+
+func fetchError(t *testing.T, url string, options fetch.Options) error {
+	client, err := fetch.New(url, options)
+	assert.NoError(t, err, "should work")
+	_, err = client.Fetch()
+	assert.Error(t, err, "should fail")
+	return err
+}
+
+func TestCause(t *testing.T) {
+	assert.Equal(t, "", Cause(nil), "they should be equal")
+	assert.Equal(t, CauseDNS, Cause(&net.DNSError{Err: "no such host", Name: "barn.invalid", IsNotFound: true}), "they should be equal")
+	assert.Equal(t, CauseTimeout, Cause(fmt.Errorf("reading: %w", context.DeadlineExceeded)), "they should be equal")
+	assert.Equal(t, CauseParse, Cause(Parse(errors.New("no value"))), "they should be equal")
+	assert.Equal(t, CauseParse, Cause(json.Unmarshal([]byte("{"), &struct{}{})), "they should be equal")
+	assert.Equal(t, CauseOther, Cause(errors.New("something else")), "they should be equal")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	assert.Equal(t, CauseHTTPStatus, Cause(fetchError(t, server.URL, fetch.Options{})), "they should be equal")
+	assert.Equal(t, CauseTimeout, Cause(fetchError(t, server.URL+"/slow", fetch.Options{Timeout: 50 * time.Millisecond})), "they should be equal")
+	server.Close()
+	assert.Equal(t, CauseConnection, Cause(fetchError(t, server.URL, fetch.Options{})), "they should be equal")
+
+	untrusted := httptest.NewTLSServer(http.NotFoundHandler())
+	defer untrusted.Close()
+	assert.Equal(t, CauseTLS, Cause(fetchError(t, untrusted.URL, fetch.Options{})), "they should be equal")
+}
+
+func TestParse(t *testing.T) {
+	inner := errors.New("no value")
+	err := Parse(inner)
+	assert.Equal(t, "no value", err.Error(), "the message should not change")
+	assert.True(t, errors.Is(err, inner), "should unwrap")
+	assert.Nil(t, Parse(nil), "should be nil")
+}
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now }
+
+	assert.Equal(t, Status{}, tracker.Get("rain"), "unknown devices should have no status")
+	status := tracker.Observe("rain", errors.New("connection refused"))
+	assert.True(t, status.LastSuccess.IsZero(), "should not have succeeded")
+	tracker.Observe("rain", Parse(errors.New("no value")))
+	status = tracker.Get("rain")
+	assert.Equal(t, Status{
+		LastError:           "no value",
+		LastErrorCause:      CauseParse,
+		LastErrorTime:       now,
+		ErrorCount:          2,
+		ConsecutiveFailures: 2,
+	}, status, "they should be equal")
+	assert.True(t, status.Failing(), "should be failing")
+
+	now = now.Add(time.Minute)
+	status = tracker.Observe("rain", nil)
+	assert.False(t, status.Failing(), "should recover")
+	assert.False(t, status.LastSuccess.IsZero(), "should have succeeded")
+	assert.Equal(t, 2, status.ErrorCount, "errors should still be counted")
+	assert.Equal(t, "no value", status.LastError, "the last error should be kept")
+	assert.Equal(t, now, status.LastSuccess, "they should be equal")
+
+	tracker.Forget("rain")
+	assert.Equal(t, Status{}, tracker.Get("rain"), "should be forgotten")
+}
@@ -0,0 +1,339 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// Record kinds
+const (
+	KindMonitor = "monitor"
+	KindWeather = "weather"
+)
+
+// DefaultRetention is used when no retention is configured
+const DefaultRetention = 30 * 24 * time.Hour
+
+// compactInterval is how often expired records are dropped from the file
+const compactInterval = time.Hour
+
+var ErrInvalidInterval = errors.New("interval must not be negative")
+
+// Record is a single history entry: a monitor state transition or a weather sample
+type Record struct {
+	Time   time.Time          `json:"time"`
+	Kind   string             `json:"kind"`
+	Device string             `json:"device"`
+	Safe   *bool              `json:"safe,omitempty"`
+	Raw    string             `json:"raw,omitempty"`
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+// Store is an append-only history file. Queries read the file, so only the
+// records they return are kept in memory. Expired records are dropped when the
+// file is compacted, which happens on open and then hourly in the background.
+type Store struct {
+	path      string
+	retention time.Duration
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	// mu guards writes to the file and replacing it
+	mu        sync.RWMutex
+	file      *os.File
+	lastState map[string]bool
+}
+
+// Open opens the history file at path, creating it if needed
+func Open(path string, retention time.Duration) (*Store, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Store{
+		path:      path,
+		retention: retention,
+		ctx:       ctx,
+		cancel:    cancel,
+		lastState: make(map[string]bool),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		cancel()
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.file = file
+	if err := s.compact(time.Now()); err != nil {
+		cancel()
+		s.file.Close()
+		return nil, err
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// run compacts the file every compactInterval until the store is closed
+func (s *Store) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.compact(now); err != nil {
+				log.WithError(err).Error(fmt.Sprintf("[BARN] History. Failed to compact [%s]", s.path))
+			}
+		}
+	}
+}
+
+// scan calls fn with every record read from r. Malformed lines are skipped
+// and counted; a partially written last line is expected after a crash.
+func scan(r io.Reader, fn func(r Record, line []byte) error) (int, error) {
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			skipped++
+			continue
+		}
+		if err := fn(record, scanner.Bytes()); err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, scanner.Err()
+}
+
+// snapshot opens the file for reading and returns how much of it holds
+// complete records
+func (s *Store) snapshot() (*os.File, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, err := s.file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// compact drops expired records by rewriting the file. The lock is only held
+// to copy the records appended meanwhile and to replace the file. Compactions
+// must not run concurrently.
+func (s *Store) compact(now time.Time) error {
+	cutoff := now.Add(-s.retention)
+	src, size, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(f)
+	skipped, err := scan(io.LimitReader(src, size), func(r Record, line []byte) error {
+		if r.Time.Before(cutoff) {
+			return nil
+		}
+		w.Write(line)
+		return w.WriteByte('\n')
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if skipped > 0 {
+		log.Warn(fmt.Sprintf("[BARN] History. Dropped %d malformed records from [%s]", skipped, s.path))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := src.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	return err
+}
+
+// Append writes a record to the history
+func (s *Store) Append(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close stops compacting and closes the history file
+func (s *Store) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// MonitorRefreshed implements app.Listener. Only the first observation of a
+// monitor and subsequent state transitions are recorded.
+func (s *Store) MonitorRefreshed(refresh app.MonitorRefresh) {
+	id := refresh.Monitor.GetId()
+	s.mu.Lock()
+	last, seen := s.lastState[id]
+	s.lastState[id] = refresh.Safe
+	s.mu.Unlock()
+	if seen && last == refresh.Safe {
+		return
+	}
+	safe := refresh.Safe
+	err := s.Append(Record{
+		Time:   refresh.Started,
+		Kind:   KindMonitor,
+		Device: id,
+		Safe:   &safe,
+		Raw:    refresh.Monitor.GetRawValue(),
+	})
+	if err != nil {
+		log.WithError(err).Error(fmt.Sprintf("[BARN] History. Failed to record monitor [%s]", id))
+	}
+}
+
+// WeatherRefreshed implements app.Listener. Failed refreshes are not sampled.
+func (s *Store) WeatherRefreshed(refresh app.WeatherRefresh) {
+	if refresh.Err != nil {
+		return
+	}
+	id := refresh.Weather.GetId()
+	err := s.Append(Record{
+		Time:   refresh.Started,
+		Kind:   KindWeather,
+		Device: id,
+		Values: weather.StationSensors(refresh.Weather),
+	})
+	if err != nil {
+		log.WithError(err).Error(fmt.Sprintf("[BARN] History. Failed to record weather [%s]", id))
+	}
+}
+
+// Query returns the records of a device in [from, to). With a positive
+// interval records are downsampled into buckets of that length: weather values
+// are averaged, and a monitor bucket is safe only if every record in it was.
+func (s *Store) Query(kind string, device string, from time.Time, to time.Time, interval time.Duration) ([]Record, error) {
+	if interval < 0 {
+		return nil, ErrInvalidInterval
+	}
+	f, size, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var matched []Record
+	_, err = scan(io.LimitReader(f, size), func(r Record, line []byte) error {
+		if r.Kind == kind && r.Device == device && !r.Time.Before(from) && r.Time.Before(to) {
+			matched = append(matched, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Refreshes run concurrently, so records are not quite in order in the file
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Time.Before(matched[j].Time)
+	})
+
+	if interval == 0 || len(matched) == 0 {
+		return matched, nil
+	}
+	return downsample(matched, from, interval), nil
+}
+
+func downsample(records []Record, from time.Time, interval time.Duration) []Record {
+	var buckets []Record
+	var sums map[string]float64
+	var counts map[string]int
+	flush := func() {
+		if len(buckets) == 0 || sums == nil {
+			return
+		}
+		b := &buckets[len(buckets)-1]
+		b.Values = make(map[string]float64, len(sums))
+		for k, v := range sums {
+			b.Values[k] = v / float64(counts[k])
+		}
+	}
+	for _, r := range records {
+		start := from.Add(r.Time.Sub(from).Truncate(interval))
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Time.Equal(start) {
+			flush()
+			buckets = append(buckets, Record{Time: start, Kind: r.Kind, Device: r.Device})
+			sums, counts = nil, nil
+		}
+		b := &buckets[len(buckets)-1]
+		if r.Safe != nil {
+			safe := *r.Safe && (b.Safe == nil || *b.Safe)
+			b.Safe = &safe
+			b.Raw = r.Raw
+		}
+		if r.Values != nil {
+			if sums == nil {
+				sums = make(map[string]float64)
+				counts = make(map[string]int)
+			}
+			for k, v := range r.Values {
+				sums[k] += v
+				counts[k]++
+			}
+		}
+	}
+	flush()
+	return buckets
+}
@@ -0,0 +1,145 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+func openTestStore(t *testing.T, retention time.Duration) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := Open(path, retention)
+	assert.NoError(t, err, "should work")
+	t.Cleanup(func() { store.Close() })
+	return store, path
+}
+
+func TestStore_MonitorTransitions(t *testing.T) {
+	store, _ := openTestStore(t, time.Hour)
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+	start := time.Now().Add(-time.Minute)
+
+	for i, safe := range []bool{true, true, false, false, true} {
+		store.MonitorRefreshed(app.MonitorRefresh{
+			Monitor: rain,
+			Safe:    safe,
+			Started: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	records, err := store.Query(KindMonitor, "rain", start, time.Now(), 0)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, records, 3, "only the first state and transitions should be recorded")
+	assert.Equal(t, true, *records[0].Safe, "they should be equal")
+	assert.Equal(t, false, *records[1].Safe, "they should be equal")
+	assert.Equal(t, true, *records[2].Safe, "they should be equal")
+	assert.Equal(t, start.Add(2*time.Second).Unix(), records[1].Time.Unix(), "they should be equal")
+}
+
+func TestStore_WeatherDownsample(t *testing.T) {
+	store, _ := openTestStore(t, time.Hour)
+	from := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	for i, temp := range []float64{10, 12, 20, 22} {
+		err := store.Append(Record{
+			Time:   from.Add(time.Duration(i) * 30 * time.Second),
+			Kind:   KindWeather,
+			Device: "station",
+			Values: map[string]float64{weather.SensorTemperature: temp},
+		})
+		assert.NoError(t, err, "should work")
+	}
+
+	records, err := store.Query(KindWeather, "station", from, time.Now(), time.Minute)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, records, 2, "samples should be grouped into minute buckets")
+	assert.Equal(t, 11.0, records[0].Values[weather.SensorTemperature], "they should be equal")
+	assert.Equal(t, 21.0, records[1].Values[weather.SensorTemperature], "they should be equal")
+	assert.Equal(t, from.Add(time.Minute), records[1].Time, "bucket should start at its interval")
+
+	_, err = store.Query(KindWeather, "station", from, time.Now(), -time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidInterval)
+}
+
+func TestStore_DownsampleMonitorIsConservative(t *testing.T) {
+	records := downsample([]Record{
+		{Time: time.Unix(0, 0), Kind: KindMonitor, Safe: boolPtr(true), Raw: "1"},
+		{Time: time.Unix(10, 0), Kind: KindMonitor, Safe: boolPtr(false), Raw: "0"},
+		{Time: time.Unix(20, 0), Kind: KindMonitor, Safe: boolPtr(true), Raw: "1"},
+	}, time.Unix(0, 0), time.Minute)
+	assert.Len(t, records, 1, "they should be equal")
+	assert.Equal(t, false, *records[0].Safe, "a bucket with an unsafe record should be unsafe")
+	assert.Equal(t, "1", records[0].Raw, "raw value should be the last one in the bucket")
+}
+
+func TestStore_ReopenAndRetention(t *testing.T) {
+	store, path := openTestStore(t, time.Hour)
+	now := time.Now()
+	assert.NoError(t, store.Append(Record{Time: now.Add(-2 * time.Hour), Kind: KindWeather, Device: "station"}))
+	assert.NoError(t, store.Append(Record{Time: now.Add(-time.Minute), Kind: KindWeather, Device: "station"}))
+	assert.NoError(t, store.Close())
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err, "should work")
+	f.WriteString(`{"time":"20`) // cut off by a crash
+	f.Close()
+
+	reopened, err := Open(path, time.Hour)
+	assert.NoError(t, err, "should work")
+	defer reopened.Close()
+	records, err := reopened.Query(KindWeather, "station", now.Add(-3*time.Hour), now, 0)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, records, 1, "expired records should be dropped")
+}
+
+func TestStore_CompactWhileAppending(t *testing.T) {
+	store, _ := openTestStore(t, time.Hour)
+	now := time.Now()
+	assert.NoError(t, store.Append(Record{Time: now.Add(-2 * time.Hour), Kind: KindWeather, Device: "station"}))
+
+	const appends = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < appends; i++ {
+			// Concurrent refreshes append slightly out of order
+			at := now.Add(-time.Duration(i%3) * time.Second)
+			assert.NoError(t, store.Append(Record{Time: at, Kind: KindWeather, Device: "station"}))
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, store.compact(now), "should work")
+	}
+	wg.Wait()
+
+	records, err := store.Query(KindWeather, "station", now.Add(-3*time.Hour), now.Add(time.Second), 0)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, records, appends, "records appended while compacting should be kept")
+	assert.True(t, sort.SliceIsSorted(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	}), "records should be sorted")
+}
+
+func TestStore_SkipsFailedWeatherRefresh(t *testing.T) {
+	store, _ := openTestStore(t, time.Hour)
+	station := weather.NewObservingConditionsDummy("station", "Station", "Dummy station")
+	store.WeatherRefreshed(app.WeatherRefresh{Weather: station, Started: time.Now(), Err: errors.New("timeout")})
+	store.WeatherRefreshed(app.WeatherRefresh{Weather: station, Started: time.Now()})
+
+	records, err := store.Query(KindWeather, "station", time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 0)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, records, 1, "they should be equal")
+	assert.Contains(t, records[0].Values, weather.SensorTemperature)
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
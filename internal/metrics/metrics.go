@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+const namespace = "barn"
+
+// DeviceClients is the number of Alpaca clients connected to one device
+type DeviceClients struct {
+	DeviceType   string
+	DeviceNumber int
+	DeviceId     string
+	Count        int
+}
+
+// Metrics exposes barn state in the Prometheus format. Device state gauges are
+// read from the barn server on every scrape, refresh timings are recorded as
+// an app.Listener and HTTP timings by the API middleware.
+type Metrics struct {
+	registry *prometheus.Registry
+	barn     app.Server
+
+	mu      sync.RWMutex
+	clients func() []DeviceClients
+
+	monitorRefreshDuration *prometheus.HistogramVec
+	monitorRefreshErrors   *prometheus.CounterVec
+	weatherRefreshDuration *prometheus.HistogramVec
+	weatherRefreshErrors   *prometheus.CounterVec
+	httpRequests           *prometheus.CounterVec
+	httpRequestDuration    *prometheus.HistogramVec
+
+	monitorSafe        *prometheus.Desc
+	monitorLastRefresh *prometheus.Desc
+	weatherLastRefresh *prometheus.Desc
+	weatherSensor      *prometheus.Desc
+	connectedClients   *prometheus.Desc
+}
+
+// New creates the metrics registry for the given barn server
+func New(barn app.Server) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		barn:     barn,
+		monitorRefreshDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "monitor_refresh_duration_seconds",
+			Help:      "Time taken to refresh a safety monitor.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"monitor"}),
+		monitorRefreshErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "monitor_refresh_errors_total",
+			Help:      "Number of failed safety monitor refreshes.",
+		}, []string{"monitor"}),
+		weatherRefreshDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "weather_refresh_duration_seconds",
+			Help:      "Time taken to refresh a weather station.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"station"}),
+		weatherRefreshErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "weather_refresh_errors_total",
+			Help:      "Number of failed weather station refreshes.",
+		}, []string{"station"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by endpoint, status and Alpaca error number.",
+		}, []string{"method", "endpoint", "status", "error_number"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by endpoint.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "endpoint"}),
+		monitorSafe: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "monitor", "safe"),
+			"Whether the safety monitor currently reports safe (1) or unsafe (0).",
+			[]string{"monitor", "name"}, nil),
+		monitorLastRefresh: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "monitor", "last_refresh_timestamp_seconds"),
+			"Unix time of the last successful safety monitor refresh.",
+			[]string{"monitor"}, nil),
+		weatherLastRefresh: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "weather", "last_refresh_timestamp_seconds"),
+			"Unix time of the last successful weather station refresh.",
+			[]string{"station"}, nil),
+		weatherSensor: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "weather", "sensor"),
+			"Current weather sensor reading.",
+			[]string{"station", "sensor"}, nil),
+		connectedClients: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "alpaca", "connected_clients"),
+			"Number of Alpaca clients connected to a device.",
+			[]string{"device_type", "device_number", "device_id"}, nil),
+	}
+	m.registry.MustRegister(
+		m,
+		m.monitorRefreshDuration,
+		m.monitorRefreshErrors,
+		m.weatherRefreshDuration,
+		m.weatherRefreshErrors,
+		m.httpRequests,
+		m.httpRequestDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler returns the HTTP handler serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// SetClientSource sets the function used to count connected Alpaca clients
+func (m *Metrics) SetClientSource(clients func() []DeviceClients) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients = clients
+}
+
+// MonitorRefreshed implements app.Listener
+func (m *Metrics) MonitorRefreshed(refresh app.MonitorRefresh) {
+	id := refresh.Monitor.GetId()
+	m.monitorRefreshDuration.WithLabelValues(id).Observe(refresh.Duration.Seconds())
+	if refresh.Err != nil {
+		m.monitorRefreshErrors.WithLabelValues(id).Inc()
+	}
+}
+
+// WeatherRefreshed implements app.Listener
+func (m *Metrics) WeatherRefreshed(refresh app.WeatherRefresh) {
+	id := refresh.Weather.GetId()
+	m.weatherRefreshDuration.WithLabelValues(id).Observe(refresh.Duration.Seconds())
+	if refresh.Err != nil {
+		m.weatherRefreshErrors.WithLabelValues(id).Inc()
+	}
+}
+
+// ObserveRequest records a served HTTP request. errorNumber is empty for
+// requests that did not produce an Alpaca response.
+func (m *Metrics) ObserveRequest(method string, endpoint string, status int, errorNumber string, duration time.Duration) {
+	m.httpRequests.WithLabelValues(method, endpoint, strconv.Itoa(status), errorNumber).Inc()
+	m.httpRequestDuration.WithLabelValues(method, endpoint).Observe(duration.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.monitorSafe
+	ch <- m.monitorLastRefresh
+	ch <- m.weatherLastRefresh
+	ch <- m.weatherSensor
+	ch <- m.connectedClients
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, id := range m.barn.GetMonitorIds() {
+		mon := m.barn.GetMonitor(id)
+		if mon == nil {
+			continue
+		}
+		safe := 0.0
+		if mon.IsSafe() {
+			safe = 1
+		}
+		ch <- prometheus.MustNewConstMetric(m.monitorSafe, prometheus.GaugeValue, safe, id, mon.GetName())
+		ch <- prometheus.MustNewConstMetric(m.monitorLastRefresh, prometheus.GaugeValue, timestamp(mon.GetTimeStamp()), id)
+	}
+	for _, id := range m.barn.GetWeatherIds() {
+		station := m.barn.GetWeather(id)
+		if station == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(m.weatherLastRefresh, prometheus.GaugeValue, timestamp(station.GetTimeStamp()), id)
+		for sensor, value := range weather.StationSensors(station) {
+			ch <- prometheus.MustNewConstMetric(m.weatherSensor, prometheus.GaugeValue, value, id, sensor)
+		}
+	}
+
+	m.mu.RLock()
+	clients := m.clients
+	m.mu.RUnlock()
+	if clients == nil {
+		return
+	}
+	for _, dc := range clients() {
+		ch <- prometheus.MustNewConstMetric(m.connectedClients, prometheus.GaugeValue, float64(dc.Count),
+			dc.DeviceType, strconv.Itoa(dc.DeviceNumber), dc.DeviceId)
+	}
+}
+
+// timestamp converts t to unix seconds, reporting 0 for devices that never refreshed
+func timestamp(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
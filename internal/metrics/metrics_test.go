@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "they should be equal")
+	return w.Body.String()
+}
+
+func TestMetrics_DeviceGauges(t *testing.T) {
+	barn := app.New()
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("roof", "Roof", "Roof state", true))
+	barn.AddMonitor(monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", false))
+	barn.AddWeather(weather.NewObservingConditionsDummy("station", "Station", "Dummy station"))
+	m := New(barn)
+	m.SetClientSource(func() []DeviceClients {
+		return []DeviceClients{{DeviceType: "safetymonitor", DeviceNumber: 0, DeviceId: "roof", Count: 2}}
+	})
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `barn_monitor_safe{monitor="roof",name="Roof"} 1`)
+	assert.Contains(t, body, `barn_monitor_safe{monitor="rain",name="Rain"} 0`)
+	assert.Contains(t, body, `barn_monitor_last_refresh_timestamp_seconds{monitor="roof"} 0`)
+	assert.Contains(t, body, `barn_weather_sensor{sensor="Temperature",station="station"} 0`)
+	assert.NotContains(t, body, `sensor="SkyQuality"`)
+	assert.Contains(t, body, `barn_alpaca_connected_clients{device_id="roof",device_number="0",device_type="safetymonitor"} 2`)
+}
+
+func TestMetrics_RefreshListener(t *testing.T) {
+	barn := app.New()
+	m := New(barn)
+	roof := monitor.NewSafetyMonitorDummy("roof", "Roof", "Roof state", true)
+	station := weather.NewObservingConditionsDummy("station", "Station", "Dummy station")
+
+	m.MonitorRefreshed(app.MonitorRefresh{Monitor: roof, Duration: 20 * time.Millisecond})
+	m.MonitorRefreshed(app.MonitorRefresh{Monitor: roof, Err: errors.New("timeout"), Duration: 5 * time.Second})
+	m.WeatherRefreshed(app.WeatherRefresh{Weather: station, Err: errors.New("bad json")})
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `barn_monitor_refresh_duration_seconds_count{monitor="roof"} 2`)
+	assert.Contains(t, body, `barn_monitor_refresh_errors_total{monitor="roof"} 1`)
+	assert.Contains(t, body, `barn_weather_refresh_errors_total{station="station"} 1`)
+}
+
+func TestMetrics_ObserveRequest(t *testing.T) {
+	m := New(app.New())
+	m.ObserveRequest("GET", "/api/v1/safetymonitor/:device_id/issafe", 200, "0", 3*time.Millisecond)
+	m.ObserveRequest("GET", "/api/v1/observingconditions/:device_id/skyquality", 200, "1024", time.Millisecond)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `barn_http_requests_total{endpoint="/api/v1/safetymonitor/:device_id/issafe",error_number="0",method="GET",status="200"} 1`)
+	assert.Contains(t, body, `barn_http_requests_total{endpoint="/api/v1/observingconditions/:device_id/skyquality",error_number="1024",method="GET",status="200"} 1`)
+	assert.Contains(t, body, `barn_http_request_duration_seconds_count{endpoint="/api/v1/safetymonitor/:device_id/issafe",method="GET"} 1`)
+}
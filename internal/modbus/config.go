@@ -0,0 +1,76 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// OptionsFromConfig reads connection options from a device section: address,
+// unit_id and timeout. The unit id defaults to 1.
+func OptionsFromConfig(vt *viper.Viper) (Options, error) {
+	options := Options{
+		Address: vt.GetString("address"),
+		UnitID:  DefaultUnitID,
+		Timeout: vt.GetDuration("timeout"),
+	}
+	if vt.IsSet("unit_id") {
+		unitID := vt.GetInt("unit_id")
+		if unitID < 0 || unitID > 255 {
+			return options, fmt.Errorf("invalid unit_id %d", unitID)
+		}
+		options.UnitID = byte(unitID)
+	}
+	return options, options.Validate()
+}
+
+// RegisterFromConfig reads a register value: table, register, type, order,
+// scale and offset. The table defaults to holding and the type to uint16.
+func RegisterFromConfig(vt *viper.Viper) (Register, error) {
+	address, err := addressFromConfig(vt)
+	if err != nil {
+		return Register{}, err
+	}
+	register := Register{
+		Table:   strings.ToLower(vt.GetString("table")),
+		Address: address,
+		Type:    strings.ToLower(vt.GetString("type")),
+		Order:   strings.ToLower(vt.GetString("order")),
+		Scale:   vt.GetFloat64("scale"),
+		Offset:  vt.GetFloat64("offset"),
+	}
+	if register.Table == "" {
+		register.Table = TableHolding
+	}
+	if register.Type == "" {
+		register.Type = TypeUint16
+	}
+	return register, register.Validate()
+}
+
+// BitFromConfig reads the table and register of a coil or discrete input.
+// The table defaults to coil.
+func BitFromConfig(vt *viper.Viper) (string, uint16, error) {
+	table := strings.ToLower(vt.GetString("table"))
+	if table == "" {
+		table = TableCoil
+	}
+	if table != TableCoil && table != TableDiscreteInput {
+		return "", 0, fmt.Errorf("bits are read from %s or %s tables, not %q", TableCoil, TableDiscreteInput, table)
+	}
+	address, err := addressFromConfig(vt)
+	return table, address, err
+}
+
+func addressFromConfig(vt *viper.Viper) (uint16, error) {
+	if !vt.IsSet("register") {
+		return 0, errors.New("register is required")
+	}
+	address := vt.GetInt("register")
+	if address < 0 || address > 0xFFFF {
+		return 0, fmt.Errorf("invalid register %d", address)
+	}
+	return uint16(address), nil
+}
@@ -0,0 +1,74 @@
+package modbus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func readConfig(t *testing.T, config string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(config)), "should work")
+	return v
+}
+
+func TestOptionsFromConfig(t *testing.T) {
+	options, err := OptionsFromConfig(readConfig(t, `
+address: plc.local:502
+unit_id: 17
+timeout: 2s
+`))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, Options{Address: "plc.local:502", UnitID: 17, Timeout: 2 * time.Second}, options, "they should be equal")
+
+	options, err = OptionsFromConfig(readConfig(t, `address: plc.local:502`))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, byte(DefaultUnitID), options.UnitID, "they should be equal")
+
+	_, err = OptionsFromConfig(readConfig(t, "address: plc.local:502\nunit_id: 300"))
+	assert.Error(t, err, "should fail")
+	_, err = OptionsFromConfig(readConfig(t, `address: plc.local`))
+	assert.Error(t, err, "should fail")
+}
+
+func TestRegisterFromConfig(t *testing.T) {
+	register, err := RegisterFromConfig(readConfig(t, `
+table: input
+register: 30
+type: float32
+order: CDAB
+scale: 0.5
+offset: -1
+`))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, Register{Table: TableInput, Address: 30, Type: TypeFloat32, Order: OrderCDAB, Scale: 0.5, Offset: -1}, register, "they should be equal")
+
+	register, err = RegisterFromConfig(readConfig(t, `register: 0`))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, Register{Table: TableHolding, Type: TypeUint16}, register, "they should be equal")
+
+	_, err = RegisterFromConfig(readConfig(t, `type: int16`))
+	assert.Error(t, err, "the register is required")
+	_, err = RegisterFromConfig(readConfig(t, "register: 70000"))
+	assert.Error(t, err, "should fail")
+	_, err = RegisterFromConfig(readConfig(t, "register: 1\ntable: coil"))
+	assert.Error(t, err, "should fail")
+}
+
+func TestBitFromConfig(t *testing.T) {
+	table, address, err := BitFromConfig(readConfig(t, "table: discrete_input\nregister: 4"))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, TableDiscreteInput, table, "they should be equal")
+	assert.Equal(t, uint16(4), address, "they should be equal")
+
+	table, _, err = BitFromConfig(readConfig(t, "register: 4"))
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, TableCoil, table, "they should be equal")
+
+	_, _, err = BitFromConfig(readConfig(t, "table: holding\nregister: 4"))
+	assert.Error(t, err, "should fail")
+}
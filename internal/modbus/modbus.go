@@ -0,0 +1,248 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const DefaultTimeout = 5 * time.Second
+
+// Function codes
+const (
+	FuncReadCoils            = 0x01
+	FuncReadDiscreteInputs   = 0x02
+	FuncReadHoldingRegisters = 0x03
+	FuncReadInputRegisters   = 0x04
+)
+
+// Limits of a single read, from the Modbus application protocol
+const (
+	MaxBits      = 2000
+	MaxRegisters = 125
+)
+
+// ExceptionError is an exception response from the server
+type ExceptionError struct {
+	Function byte
+	Code     byte
+}
+
+func (e *ExceptionError) Error() string {
+	names := map[byte]string{
+		0x01: "illegal function",
+		0x02: "illegal data address",
+		0x03: "illegal data value",
+		0x04: "server device failure",
+		0x06: "server device busy",
+		0x0A: "gateway path unavailable",
+		0x0B: "gateway target device failed to respond",
+	}
+	name, exists := names[e.Code]
+	if !exists {
+		name = "unknown exception"
+	}
+	return fmt.Sprintf("modbus function 0x%02x: %s (0x%02x)", e.Function, name, e.Code)
+}
+
+// DefaultUnitID is 1, the usual default slave id of devices. 0 is the
+// broadcast address, and 0xFF or 0 usually address a TCP gateway itself.
+const DefaultUnitID = 1
+
+// Options configure the connection to a Modbus TCP server
+type Options struct {
+	// Address is host:port, usually port 502
+	Address string
+	// UnitID selects the device behind a gateway
+	UnitID  byte
+	Timeout time.Duration
+}
+
+// Validate checks the options without connecting
+func (o Options) Validate() error {
+	if _, _, err := net.SplitHostPort(o.Address); err != nil {
+		return fmt.Errorf("invalid address %q", o.Address)
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// Client reads from a Modbus TCP server. The connection is kept open between
+// reads and dialled again after errors.
+type Client struct {
+	options Options
+
+	mu          sync.Mutex
+	conn        net.Conn
+	transaction uint16
+}
+
+// New creates a client. It connects on the first read.
+func New(options Options) (*Client, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Client{options: options}, nil
+}
+
+// Options returns the options with defaults applied
+func (c *Client) Options() Options {
+	return c.options
+}
+
+// ReadCoils reads count coils starting at address
+func (c *Client) ReadCoils(address uint16, count uint16) ([]bool, error) {
+	return c.readBits(FuncReadCoils, address, count)
+}
+
+// ReadDiscreteInputs reads count discrete inputs starting at address
+func (c *Client) ReadDiscreteInputs(address uint16, count uint16) ([]bool, error) {
+	return c.readBits(FuncReadDiscreteInputs, address, count)
+}
+
+// ReadHoldingRegisters reads count holding registers starting at address
+func (c *Client) ReadHoldingRegisters(address uint16, count uint16) ([]uint16, error) {
+	return c.readRegisters(FuncReadHoldingRegisters, address, count)
+}
+
+// ReadInputRegisters reads count input registers starting at address
+func (c *Client) ReadInputRegisters(address uint16, count uint16) ([]uint16, error) {
+	return c.readRegisters(FuncReadInputRegisters, address, count)
+}
+
+func (c *Client) readBits(function byte, address uint16, count uint16) ([]bool, error) {
+	if count < 1 || count > MaxBits {
+		return nil, fmt.Errorf("cannot read %d bits", count)
+	}
+	data, err := c.read(function, address, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != (int(count)+7)/8 {
+		return nil, fmt.Errorf("expected %d bytes of bits, got %d", (count+7)/8, len(data))
+	}
+	bits := make([]bool, count)
+	for i := range bits {
+		bits[i] = data[i/8]&(1<<(i%8)) != 0
+	}
+	return bits, nil
+}
+
+func (c *Client) readRegisters(function byte, address uint16, count uint16) ([]uint16, error) {
+	if count < 1 || count > MaxRegisters {
+		return nil, fmt.Errorf("cannot read %d registers", count)
+	}
+	data, err := c.read(function, address, count)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(count)*2 {
+		return nil, fmt.Errorf("expected %d bytes of registers, got %d", count*2, len(data))
+	}
+	registers := make([]uint16, count)
+	for i := range registers {
+		registers[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return registers, nil
+}
+
+// read sends a read request and returns the data bytes of the response
+func (c *Client) read(function byte, address uint16, count uint16) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reused := c.conn != nil
+	data, err := c.exchange(function, address, count)
+	var exception *ExceptionError
+	if err != nil && reused && !errors.As(err, &exception) {
+		// The server may have dropped an idle connection
+		data, err = c.exchange(function, address, count)
+	}
+	return data, err
+}
+
+func (c *Client) exchange(function byte, address uint16, count uint16) ([]byte, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.options.Address, c.options.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	data, err := c.roundTrip(function, address, count)
+	var exception *ExceptionError
+	if err != nil && !errors.As(err, &exception) {
+		c.close()
+	}
+	return data, err
+}
+
+func (c *Client) roundTrip(function byte, address uint16, count uint16) ([]byte, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.options.Timeout)); err != nil {
+		return nil, err
+	}
+	c.transaction++
+	request := make([]byte, 12)
+	binary.BigEndian.PutUint16(request[0:], c.transaction)
+	// Protocol identifier 0 is Modbus
+	binary.BigEndian.PutUint16(request[4:], 6)
+	request[6] = c.options.UnitID
+	request[7] = function
+	binary.BigEndian.PutUint16(request[8:], address)
+	binary.BigEndian.PutUint16(request[10:], count)
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 2 || length > 254 {
+			return nil, fmt.Errorf("invalid response length %d", length)
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(c.conn, pdu); err != nil {
+			return nil, err
+		}
+		if binary.BigEndian.Uint16(header[0:]) != c.transaction {
+			// A late response to a request that timed out
+			continue
+		}
+		if pdu[0] == function|0x80 {
+			if len(pdu) < 2 {
+				return nil, errors.New("short exception response")
+			}
+			return nil, &ExceptionError{Function: function, Code: pdu[1]}
+		}
+		if pdu[0] != function || len(pdu) < 2 || int(pdu[1]) != len(pdu)-2 {
+			return nil, fmt.Errorf("malformed response to function 0x%02x", function)
+		}
+		return pdu[2:], nil
+	}
+}
+
+// Close hangs up the connection, if any
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
+
+func (c *Client) close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.conn = nil
+}
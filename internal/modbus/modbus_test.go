@@ -0,0 +1,118 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/modbus/modbustest"
+)
+
+func newTestClient(t *testing.T) (*Client, *modbustest.Server) {
+	server := modbustest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := New(Options{Address: server.Addr(), UnitID: DefaultUnitID, Timeout: time.Second})
+	assert.NoError(t, err, "should work")
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+func TestClient_ReadBits(t *testing.T) {
+	client, server := newTestClient(t)
+	for i := uint16(0); i < 10; i++ {
+		server.SetCoil(i, i%3 == 0)
+	}
+	server.SetDiscreteInput(7, true)
+
+	coils, err := client.ReadCoils(0, 10)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, []bool{true, false, false, true, false, false, true, false, false, true}, coils, "they should be equal")
+	input, err := client.ReadBit(TableDiscreteInput, 7)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, true, input, "they should be equal")
+	coil, err := client.ReadBit(TableCoil, 1)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, false, coil, "they should be equal")
+	_, err = client.ReadBit(TableHolding, 1)
+	assert.Error(t, err, "should fail")
+}
+
+func TestClient_ReadRegisters(t *testing.T) {
+	client, server := newTestClient(t)
+	server.SetHoldingRegisters(100, 1, 2, 0xFFFF)
+	server.SetInputRegisters(0, 42)
+
+	registers, err := client.ReadHoldingRegisters(100, 3)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, []uint16{1, 2, 0xFFFF}, registers, "they should be equal")
+	registers, err = client.ReadInputRegisters(0, 1)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, []uint16{42}, registers, "they should be equal")
+
+	_, err = client.ReadHoldingRegisters(0, 0)
+	assert.Error(t, err, "should fail")
+	_, err = client.ReadHoldingRegisters(0, MaxRegisters+1)
+	assert.Error(t, err, "should fail")
+}
+
+func TestClient_Exception(t *testing.T) {
+	client, server := newTestClient(t)
+	server.SetHoldingRegisters(0, 1)
+
+	_, err := client.ReadHoldingRegisters(1, 1)
+	var exception *ExceptionError
+	assert.Equal(t, true, errors.As(err, &exception), "they should be equal")
+	assert.Equal(t, byte(0x02), exception.Code, "they should be equal")
+	assert.Contains(t, err.Error(), "illegal data address", "the exception should be named")
+	assert.Equal(t, 1, server.Requests(), "exceptions should not be retried")
+
+	// The connection survives exceptions
+	_, err = client.ReadHoldingRegisters(0, 1)
+	assert.NoError(t, err, "should work")
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	client, server := newTestClient(t)
+	server.SetInputRegisters(0, 7)
+	for i := 0; i < 3; i++ {
+		registers, err := client.ReadInputRegisters(0, 1)
+		assert.NoError(t, err, "dropped connections should be dialled again")
+		assert.Equal(t, []uint16{7}, registers, "they should be equal")
+		server.CloseConnections()
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "should work")
+	defer ln.Close()
+	go func() {
+		// Accept and never answer
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client, _ := New(Options{Address: ln.Addr().String(), Timeout: 50 * time.Millisecond})
+	defer client.Close()
+	start := time.Now()
+	_, err = client.ReadCoils(0, 1)
+	assert.Error(t, err, "should fail")
+	assert.Less(t, time.Since(start), time.Second, "reads should time out")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Options{Address: "plc.local"})
+	assert.Error(t, err, "addresses need a port")
+	_, err = New(Options{Address: "plc.local:502", Timeout: -time.Second})
+	assert.Error(t, err, "should fail")
+	client, err := New(Options{Address: "plc.local:502"})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, DefaultTimeout, client.Options().Timeout, "they should be equal")
+}
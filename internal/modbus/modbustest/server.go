@@ -0,0 +1,188 @@
+// Package modbustest provides an in-process Modbus TCP server for tests
+package modbustest
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// Server answers read requests from its tables. Reads of addresses that were
+// never set get an illegal data address exception.
+type Server struct {
+	listener net.Listener
+
+	mu             sync.Mutex
+	coils          map[uint16]bool
+	discreteInputs map[uint16]bool
+	holding        map[uint16]uint16
+	input          map[uint16]uint16
+	conns          map[net.Conn]bool
+	requests       int
+}
+
+// NewServer starts a server on a local port
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("modbustest: failed to listen: " + err.Error())
+	}
+	s := &Server{
+		listener:       listener,
+		coils:          map[uint16]bool{},
+		discreteInputs: map[uint16]bool{},
+		holding:        map[uint16]uint16{},
+		input:          map[uint16]uint16{},
+		conns:          map[net.Conn]bool{},
+	}
+	go s.serve()
+	return s
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// SetCoil sets a coil
+func (s *Server) SetCoil(address uint16, value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coils[address] = value
+}
+
+// SetDiscreteInput sets a discrete input
+func (s *Server) SetDiscreteInput(address uint16, value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discreteInputs[address] = value
+}
+
+// SetHoldingRegisters sets holding registers starting at address
+func (s *Server) SetHoldingRegisters(address uint16, values ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, value := range values {
+		s.holding[address+uint16(i)] = value
+	}
+}
+
+// SetInputRegisters sets input registers starting at address
+func (s *Server) SetInputRegisters(address uint16, values ...uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, value := range values {
+		s.input[address+uint16(i)] = value
+	}
+}
+
+// Requests returns the number of requests answered so far
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// CloseConnections drops open connections, as a server does with idle ones
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the server and drops open connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.CloseConnections()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		if length < 2 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		reply := s.answer(pdu)
+		binary.BigEndian.PutUint16(header[4:], uint16(len(reply)+1))
+		if _, err := conn.Write(append(header, reply...)); err != nil {
+			return
+		}
+	}
+}
+
+// answer builds the response PDU to a request PDU
+func (s *Server) answer(pdu []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	function := pdu[0]
+	if len(pdu) != 5 {
+		return []byte{function | 0x80, 0x03}
+	}
+	address := binary.BigEndian.Uint16(pdu[1:])
+	count := binary.BigEndian.Uint16(pdu[3:])
+
+	switch function {
+	case 0x01, 0x02:
+		table := s.coils
+		if function == 0x02 {
+			table = s.discreteInputs
+		}
+		data := make([]byte, (int(count)+7)/8)
+		for i := 0; i < int(count); i++ {
+			value, exists := table[address+uint16(i)]
+			if !exists {
+				return []byte{function | 0x80, 0x02}
+			}
+			if value {
+				data[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{function, byte(len(data))}, data...)
+	case 0x03, 0x04:
+		table := s.holding
+		if function == 0x04 {
+			table = s.input
+		}
+		data := make([]byte, 0, count*2)
+		for i := 0; i < int(count); i++ {
+			value, exists := table[address+uint16(i)]
+			if !exists {
+				return []byte{function | 0x80, 0x02}
+			}
+			data = binary.BigEndian.AppendUint16(data, value)
+		}
+		return append([]byte{function, byte(len(data))}, data...)
+	}
+	return []byte{function | 0x80, 0x01}
+}
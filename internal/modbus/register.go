@@ -0,0 +1,164 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Tables
+const (
+	TableCoil          = "coil"
+	TableDiscreteInput = "discrete_input"
+	TableHolding       = "holding"
+	TableInput         = "input"
+)
+
+// Data types of register values
+const (
+	TypeInt16   = "int16"
+	TypeUint16  = "uint16"
+	TypeInt32   = "int32"
+	TypeUint32  = "uint32"
+	TypeFloat32 = "float32"
+)
+
+// Byte orders of 32-bit values, naming the bytes of the value from most to
+// least significant as they arrive. ABCD is plain big-endian; CDAB swaps
+// the two registers, as many PLCs do.
+const (
+	OrderABCD = "abcd"
+	OrderCDAB = "cdab"
+	OrderBADC = "badc"
+	OrderDCBA = "dcba"
+)
+
+// Register locates a numeric value in the holding or input registers and
+// scales it: value = raw * Scale + Offset
+type Register struct {
+	Table   string
+	Address uint16
+	Type    string
+	Order   string
+	// Scale of 0 is treated as 1
+	Scale  float64
+	Offset float64
+}
+
+// Validate checks the table, type and byte order
+func (r Register) Validate() error {
+	switch r.Table {
+	case TableHolding, TableInput:
+	default:
+		return fmt.Errorf("registers are read from %s or %s tables, not %q", TableHolding, TableInput, r.Table)
+	}
+	switch r.Type {
+	case TypeInt16, TypeUint16, TypeInt32, TypeUint32, TypeFloat32:
+	default:
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+	switch strings.ToLower(r.Order) {
+	case "", OrderABCD, OrderCDAB, OrderBADC, OrderDCBA:
+	default:
+		return fmt.Errorf("unknown byte order %q", r.Order)
+	}
+	return nil
+}
+
+// Count returns the number of registers the value spans
+func (r Register) Count() uint16 {
+	if r.Type == TypeInt16 || r.Type == TypeUint16 {
+		return 1
+	}
+	return 2
+}
+
+// Decode converts the registers to a scaled value
+func (r Register) Decode(registers []uint16) (float64, error) {
+	if len(registers) != int(r.Count()) {
+		return 0, fmt.Errorf("%s needs %d registers, got %d", r.Type, r.Count(), len(registers))
+	}
+	raw := make([]byte, 0, 4)
+	for _, register := range registers {
+		raw = binary.BigEndian.AppendUint16(raw, register)
+	}
+	raw = reorder(raw, strings.ToLower(r.Order))
+
+	var value float64
+	switch r.Type {
+	case TypeInt16:
+		value = float64(int16(binary.BigEndian.Uint16(raw)))
+	case TypeUint16:
+		value = float64(binary.BigEndian.Uint16(raw))
+	case TypeInt32:
+		value = float64(int32(binary.BigEndian.Uint32(raw)))
+	case TypeUint32:
+		value = float64(binary.BigEndian.Uint32(raw))
+	case TypeFloat32:
+		value = float64(math.Float32frombits(binary.BigEndian.Uint32(raw)))
+	default:
+		return 0, fmt.Errorf("unknown type %q", r.Type)
+	}
+	scale := r.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return value*scale + r.Offset, nil
+}
+
+// reorder arranges bytes received in order into big-endian
+func reorder(raw []byte, order string) []byte {
+	if len(raw) == 2 {
+		// Byte swaps apply to single registers as well
+		if order == OrderBADC || order == OrderDCBA {
+			return []byte{raw[1], raw[0]}
+		}
+		return raw
+	}
+	switch order {
+	case OrderCDAB:
+		return []byte{raw[2], raw[3], raw[0], raw[1]}
+	case OrderBADC:
+		return []byte{raw[1], raw[0], raw[3], raw[2]}
+	case OrderDCBA:
+		return []byte{raw[3], raw[2], raw[1], raw[0]}
+	}
+	return raw
+}
+
+// ReadRegister reads and decodes a register value
+func (c *Client) ReadRegister(r Register) (float64, error) {
+	var registers []uint16
+	var err error
+	switch r.Table {
+	case TableHolding:
+		registers, err = c.ReadHoldingRegisters(r.Address, r.Count())
+	case TableInput:
+		registers, err = c.ReadInputRegisters(r.Address, r.Count())
+	default:
+		return 0, fmt.Errorf("cannot read registers from %q", r.Table)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return r.Decode(registers)
+}
+
+// ReadBit reads a single coil or discrete input
+func (c *Client) ReadBit(table string, address uint16) (bool, error) {
+	var bits []bool
+	var err error
+	switch table {
+	case TableCoil:
+		bits, err = c.ReadCoils(address, 1)
+	case TableDiscreteInput:
+		bits, err = c.ReadDiscreteInputs(address, 1)
+	default:
+		return false, fmt.Errorf("bits are read from %s or %s tables, not %q", TableCoil, TableDiscreteInput, table)
+	}
+	if err != nil {
+		return false, err
+	}
+	return bits[0], nil
+}
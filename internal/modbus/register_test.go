@@ -0,0 +1,66 @@
+package modbus
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister_Decode(t *testing.T) {
+	bits := math.Float32bits(12.5)
+	high, low := uint16(bits>>16), uint16(bits)
+	swap := func(v uint16) uint16 { return v<<8 | v>>8 }
+
+	tests := []struct {
+		register  Register
+		registers []uint16
+		want      float64
+	}{
+		{Register{Type: TypeInt16}, []uint16{0xFFFE}, -2},
+		{Register{Type: TypeUint16}, []uint16{0xFFFE}, 65534},
+		{Register{Type: TypeUint16, Order: OrderBADC}, []uint16{0x0100}, 1},
+		{Register{Type: TypeInt32}, []uint16{0xFFFF, 0xFFFD}, -3},
+		{Register{Type: TypeUint32}, []uint16{0x0001, 0x0000}, 65536},
+		{Register{Type: TypeUint32, Order: OrderCDAB}, []uint16{0x0000, 0x0001}, 65536},
+		{Register{Type: TypeFloat32}, []uint16{high, low}, 12.5},
+		{Register{Type: TypeFloat32, Order: "ABCD"}, []uint16{high, low}, 12.5},
+		{Register{Type: TypeFloat32, Order: OrderCDAB}, []uint16{low, high}, 12.5},
+		{Register{Type: TypeFloat32, Order: OrderBADC}, []uint16{swap(high), swap(low)}, 12.5},
+		{Register{Type: TypeFloat32, Order: OrderDCBA}, []uint16{swap(low), swap(high)}, 12.5},
+		// Temperature in tenths of a degree
+		{Register{Type: TypeInt16, Scale: 0.1}, []uint16{0xFF9C}, -10},
+		{Register{Type: TypeUint16, Scale: 0.01, Offset: -40}, []uint16{6000}, 20},
+	}
+	for _, test := range tests {
+		value, err := test.register.Decode(test.registers)
+		assert.NoError(t, err, "should work")
+		assert.InDelta(t, test.want, value, 1e-9, "they should be equal")
+	}
+
+	_, err := Register{Type: TypeFloat32}.Decode([]uint16{1})
+	assert.Error(t, err, "should fail")
+}
+
+func TestRegister_Validate(t *testing.T) {
+	assert.NoError(t, Register{Table: TableInput, Type: TypeInt32, Order: OrderCDAB}.Validate(), "should work")
+	assert.Error(t, Register{Table: TableCoil, Type: TypeInt16}.Validate(), "should fail")
+	assert.Error(t, Register{Table: TableHolding, Type: "float64"}.Validate(), "should fail")
+	assert.Error(t, Register{Table: TableHolding, Type: TypeInt16, Order: "bcda"}.Validate(), "should fail")
+}
+
+func TestClient_ReadRegister(t *testing.T) {
+	client, server := newTestClient(t)
+	bits := math.Float32bits(-3.25)
+	server.SetInputRegisters(10, uint16(bits), uint16(bits>>16))
+	server.SetHoldingRegisters(0, 215)
+
+	value, err := client.ReadRegister(Register{Table: TableInput, Address: 10, Type: TypeFloat32, Order: OrderCDAB})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, -3.25, value, "they should be equal")
+	value, err = client.ReadRegister(Register{Table: TableHolding, Address: 0, Type: TypeUint16, Scale: 0.1})
+	assert.NoError(t, err, "should work")
+	assert.InDelta(t, 21.5, value, 1e-9, "they should be equal")
+	_, err = client.ReadRegister(Register{Table: TableHolding, Address: 0, Type: TypeUint32})
+	assert.Error(t, err, "reads past set registers should fail")
+}
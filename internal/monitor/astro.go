@@ -0,0 +1,199 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultSunAltitude is the nautical twilight limit
+const DefaultSunAltitude = -12.0
+
+const (
+	// transitionStep is the resolution of the search for the next transition
+	transitionStep = 5 * time.Minute
+	// transitionHorizon bounds the search; near the poles there may be none
+	transitionHorizon = 48 * time.Hour
+)
+
+// AstroConfig configures a sun and moon altitude based monitor
+type AstroConfig struct {
+	Site
+	// SunAltitude is the highest sun altitude in degrees considered safe
+	SunAltitude float64
+	// SunriseOffset turns the monitor unsafe this long before the sun rises
+	// above SunAltitude
+	SunriseOffset time.Duration
+	// MoonAltitude, when set, is the highest moon altitude considered safe
+	MoonAltitude *float64
+	// MoonIllumination limits the moon check to a moon at least this
+	// illuminated (0 to 1)
+	MoonIllumination float64
+}
+
+// Validate checks the site and limits
+func (c AstroConfig) Validate() error {
+	if c.Latitude < -90 || c.Latitude > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if c.Longitude < -180 || c.Longitude > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	if c.SunAltitude < -90 || c.SunAltitude > 90 {
+		return errors.New("sun altitude must be between -90 and 90")
+	}
+	if c.SunriseOffset < 0 {
+		return errors.New("sunrise offset must not be negative")
+	}
+	if c.MoonAltitude != nil && (*c.MoonAltitude < -90 || *c.MoonAltitude > 90) {
+		return errors.New("moon altitude must be between -90 and 90")
+	}
+	if c.MoonIllumination < 0 || c.MoonIllumination > 1 {
+		return errors.New("moon illumination must be between 0 and 1")
+	}
+	return nil
+}
+
+// SafetyMonitorAstro is unsafe while the sun, or optionally the moon, is too
+// high. Positions are computed locally, so it works without network access.
+type SafetyMonitorAstro struct {
+	id          string
+	name        string
+	description string
+	config      AstroConfig
+	now         func() time.Time
+
+	mu              sync.RWMutex
+	safe            bool
+	lastRefreshTime time.Time
+	lastValue       string
+	nextTransition  time.Time
+}
+
+func NewSafetyMonitorAstro(id string, name string, description string, config AstroConfig) *SafetyMonitorAstro {
+	monitor := &SafetyMonitorAstro{id: id, name: name, description: description, config: config, now: time.Now}
+	monitor.Refresh()
+	return monitor
+}
+
+func (sm *SafetyMonitorAstro) GetId() string {
+	return sm.id
+}
+
+func (sm *SafetyMonitorAstro) GetName() string {
+	return sm.name
+}
+
+func (sm *SafetyMonitorAstro) GetDescription() string {
+	return sm.description
+}
+
+func (sm *SafetyMonitorAstro) GetConfig() AstroConfig {
+	return sm.config
+}
+
+func (sm *SafetyMonitorAstro) IsSafe() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.safe
+}
+
+// GetRawValue describes the current altitudes and the next transition
+func (sm *SafetyMonitorAstro) GetRawValue() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastValue
+}
+
+func (sm *SafetyMonitorAstro) GetTimeStamp() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastRefreshTime
+}
+
+// GetNextTransition returns when the state will next change, or the zero
+// time if it does not change within two days
+func (sm *SafetyMonitorAstro) GetNextTransition() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.nextTransition
+}
+
+func (sm *SafetyMonitorAstro) Refresh() error {
+	now := sm.now()
+	safe := sm.isSafeAt(now)
+
+	sm.mu.RLock()
+	next := sm.nextTransition
+	known := !sm.lastRefreshTime.IsZero() && sm.safe == safe && now.Before(next)
+	sm.mu.RUnlock()
+	if !known {
+		next = sm.findTransition(now, safe)
+	}
+
+	sun := SunAltitude(sm.config.Site, now)
+	value := fmt.Sprintf("sun %.2f°", sun)
+	if sm.config.MoonAltitude != nil {
+		value += fmt.Sprintf(", moon %.2f° (%.0f%% illuminated)", MoonAltitude(sm.config.Site, now), MoonIllumination(now)*100)
+	}
+	if next.IsZero() {
+		value += ", no transition within 48h"
+	} else {
+		value += fmt.Sprintf(", %s at %s", stateName(!safe), next.UTC().Format(time.RFC3339))
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = safe
+	sm.lastValue = value
+	sm.nextTransition = next
+	sm.lastRefreshTime = now
+	return nil
+}
+
+// isSafeAt applies the configured limits at time t
+func (sm *SafetyMonitorAstro) isSafeAt(t time.Time) bool {
+	site := sm.config.Site
+	if SunAltitude(site, t) > sm.config.SunAltitude {
+		return false
+	}
+	// Only a rising sun is closer to the limit after the offset
+	if sm.config.SunriseOffset > 0 && SunAltitude(site, t.Add(sm.config.SunriseOffset)) > sm.config.SunAltitude {
+		return false
+	}
+	if sm.config.MoonAltitude != nil && MoonIllumination(t) >= sm.config.MoonIllumination &&
+		MoonAltitude(site, t) > *sm.config.MoonAltitude {
+		return false
+	}
+	return true
+}
+
+// findTransition steps forward until the state differs from safe, then
+// bisects to the second
+func (sm *SafetyMonitorAstro) findTransition(from time.Time, safe bool) time.Time {
+	lo := from
+	for hi := from.Add(transitionStep); hi.Sub(from) <= transitionHorizon; hi = hi.Add(transitionStep) {
+		if sm.isSafeAt(hi) == safe {
+			lo = hi
+			continue
+		}
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if sm.isSafeAt(mid) == safe {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return hi
+	}
+	return time.Time{}
+}
+
+func stateName(safe bool) string {
+	if safe {
+		return "safe"
+	}
+	return "unsafe"
+}
@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var greenwich = Site{Latitude: 51.4769, Longitude: 0, Elevation: 46}
+
+func newTestAstro(config AstroConfig, now time.Time) *SafetyMonitorAstro {
+	sm := &SafetyMonitorAstro{id: "astro", name: "name", description: "description", config: config, now: func() time.Time { return now }}
+	sm.Refresh()
+	return sm
+}
+
+func TestSunAltitude(t *testing.T) {
+	noon := time.Date(2024, 6, 20, 12, 2, 0, 0, time.UTC)
+	assert.InDelta(t, 90-greenwich.Latitude+23.44, SunAltitude(greenwich, noon), 0.3, "solstice noon altitude")
+	midnight := time.Date(2024, 6, 20, 0, 2, 0, 0, time.UTC)
+	assert.InDelta(t, -(90 - greenwich.Latitude - 23.44), SunAltitude(greenwich, midnight), 0.3, "solstice midnight altitude")
+	// Published sunrise, the upper limb at the horizon with refraction
+	sunrise := time.Date(2024, 6, 20, 3, 43, 0, 0, time.UTC)
+	assert.InDelta(t, -0.27, SunAltitude(greenwich, sunrise), 0.3, "sunrise altitude")
+}
+
+func TestMoonIllumination(t *testing.T) {
+	assert.Greater(t, MoonIllumination(time.Date(2024, 4, 23, 23, 49, 0, 0, time.UTC)), 0.99, "full moon")
+	assert.Less(t, MoonIllumination(time.Date(2024, 4, 8, 18, 21, 0, 0, time.UTC)), 0.01, "new moon")
+	assert.InDelta(t, 0.5, MoonIllumination(time.Date(2024, 4, 15, 19, 13, 0, 0, time.UTC)), 0.03, "first quarter")
+}
+
+func TestMoonAltitude(t *testing.T) {
+	// The full moon culminates around local midnight, low in June
+	fullMoon := time.Date(2024, 6, 22, 0, 0, 0, 0, time.UTC)
+	alt := MoonAltitude(greenwich, fullMoon)
+	assert.Greater(t, alt, 5.0, "moon should be up")
+	assert.Less(t, alt, 20.0, "moon should be low")
+	assert.Less(t, MoonAltitude(greenwich, fullMoon.Add(12*time.Hour)), -5.0, "moon should be down at noon")
+}
+
+func TestAstroConfig_Validate(t *testing.T) {
+	moon := 100.0
+	for name, config := range map[string]AstroConfig{
+		"latitude":          {Site: Site{Latitude: 91}},
+		"longitude":         {Site: Site{Longitude: -181}},
+		"sun altitude":      {SunAltitude: -100},
+		"sunrise offset":    {SunriseOffset: -time.Minute},
+		"moon altitude":     {MoonAltitude: &moon},
+		"moon illumination": {MoonIllumination: 1.5},
+	} {
+		assert.Error(t, config.Validate(), name)
+	}
+	assert.NoError(t, AstroConfig{Site: greenwich, SunAltitude: DefaultSunAltitude}.Validate(), "should work")
+}
+
+func TestSafetyMonitorAstro_Sun(t *testing.T) {
+	config := AstroConfig{Site: greenwich, SunAltitude: DefaultSunAltitude}
+	night := newTestAstro(config, time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, true, night.IsSafe(), "they should be equal")
+	assert.Equal(t, "name", night.GetName(), "they should be equal")
+	assert.Equal(t, "description", night.GetDescription(), "they should be equal")
+
+	day := newTestAstro(config, time.Date(2024, 12, 21, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, false, day.IsSafe(), "they should be equal")
+
+	next := night.GetNextTransition()
+	assert.False(t, next.IsZero(), "dawn should be found")
+	assert.InDelta(t, DefaultSunAltitude, SunAltitude(greenwich, next), 0.01, "transition should be at the limit")
+	assert.True(t, next.After(time.Date(2024, 12, 21, 6, 0, 0, 0, time.UTC)), "nautical dawn is after 06:00")
+	assert.True(t, next.Before(time.Date(2024, 12, 21, 7, 0, 0, 0, time.UTC)), "nautical dawn is before 07:00")
+}
+
+func TestSafetyMonitorAstro_SunriseOffset(t *testing.T) {
+	midnight := time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC)
+	dawn := newTestAstro(AstroConfig{Site: greenwich, SunAltitude: DefaultSunAltitude}, midnight).GetNextTransition()
+	early := newTestAstro(AstroConfig{Site: greenwich, SunAltitude: DefaultSunAltitude, SunriseOffset: 30 * time.Minute}, midnight)
+	assert.InDelta(t, 0, early.GetNextTransition().Sub(dawn.Add(-30*time.Minute)).Seconds(), 2, "offset should move the transition")
+
+	// 20 minutes before dawn the offset already applies
+	early.now = func() time.Time { return dawn.Add(-20 * time.Minute) }
+	assert.NoError(t, early.Refresh(), "should work")
+	assert.Equal(t, false, early.IsSafe(), "they should be equal")
+}
+
+func TestSafetyMonitorAstro_Moon(t *testing.T) {
+	fullMoon := time.Date(2024, 6, 22, 0, 0, 0, 0, time.UTC)
+	// The sun is around -15° at midnight, well below this limit
+	config := AstroConfig{Site: greenwich, SunAltitude: -5, MoonAltitude: new(float64)}
+	assert.Equal(t, false, newTestAstro(config, fullMoon).IsSafe(), "moon above the horizon should be unsafe")
+
+	config.MoonIllumination = 1
+	assert.Equal(t, true, newTestAstro(config, fullMoon).IsSafe(), "a moon dimmer than the limit should be ignored")
+
+	high := 30.0
+	config = AstroConfig{Site: greenwich, SunAltitude: -5, MoonAltitude: &high}
+	assert.Equal(t, true, newTestAstro(config, fullMoon).IsSafe(), "moon below the limit should be safe")
+}
+
+func TestSafetyMonitorAstro_RawValue(t *testing.T) {
+	now := time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC)
+	sm := newTestAstro(AstroConfig{Site: greenwich, SunAltitude: DefaultSunAltitude}, now)
+	assert.Regexp(t, `^sun -\d+\.\d\d°, unsafe at 2024-12-21T06:\d\d:\d\dZ$`, sm.GetRawValue(), "they should match")
+	assert.Equal(t, now, sm.GetTimeStamp(), "they should be equal")
+
+	moon := 0.0
+	sm = newTestAstro(AstroConfig{Site: greenwich, SunAltitude: DefaultSunAltitude, MoonAltitude: &moon}, now)
+	assert.Regexp(t, `^sun -\d+\.\d\d°, moon -?\d+\.\d\d° \(\d+% illuminated\), (un)?safe at `, sm.GetRawValue(), "they should match")
+
+	// Midsummer at high latitude never gets dark enough
+	north := Site{Latitude: 60, Longitude: 10}
+	sm = newTestAstro(AstroConfig{Site: north, SunAltitude: DefaultSunAltitude}, time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+	assert.True(t, sm.GetNextTransition().IsZero(), "no transition expected")
+	assert.Regexp(t, `no transition within 48h$`, sm.GetRawValue(), "they should match")
+}
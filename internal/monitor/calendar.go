@@ -0,0 +1,353 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A minimal iCalendar (RFC 5545) reader for blackout calendars. It reads the
+// start, end and summary of events and expands simple recurrences, which
+// covers the holiday and event calendars exported by common calendar apps.
+
+// CalendarEvent is one VEVENT of a calendar
+type CalendarEvent struct {
+	Summary    string
+	Start      time.Time
+	End        time.Time
+	Recurrence *Recurrence
+	// Exceptions are the recurrence starts listed in EXDATE
+	Exceptions []time.Time
+}
+
+// Recurrence is the supported part of an RRULE
+type Recurrence struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// ParseCalendar reads the events of an iCalendar stream. Floating times and
+// all-day dates are placed in loc.
+func ParseCalendar(r io.Reader, loc *time.Location) ([]CalendarEvent, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		events    []CalendarEvent
+		event     *CalendarEvent
+		duration  string
+		rule      string
+		cancelled bool
+		depth     int
+	)
+	for n, line := range lines {
+		name, params, value, ok := splitProperty(line)
+		if !ok {
+			continue
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT") && event == nil:
+			event, duration, rule, cancelled, depth = &CalendarEvent{}, "", "", false, 0
+			continue
+		case event == nil:
+			continue
+		case name == "BEGIN":
+			depth++
+			continue
+		case name == "END" && depth > 0:
+			depth--
+			continue
+		case depth > 0:
+			// Properties of nested components such as VALARM
+			continue
+		}
+
+		var err error
+		switch name {
+		case "END":
+			if !cancelled {
+				err = event.finish(duration, rule, loc)
+			}
+			if err == nil && !cancelled && event.End.After(event.Start) {
+				events = append(events, *event)
+			}
+			event = nil
+		case "SUMMARY":
+			event.Summary = unescapeText(value)
+		case "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case "DTSTART":
+			event.Start, err = parseCalendarTime(value, params, loc)
+		case "DTEND":
+			event.End, err = parseCalendarTime(value, params, loc)
+		case "DURATION":
+			duration = value
+		case "RRULE":
+			rule = value
+		case "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				var exception time.Time
+				if exception, err = parseCalendarTime(v, params, loc); err != nil {
+					break
+				}
+				event.Exceptions = append(event.Exceptions, exception)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n+1, name, err)
+		}
+	}
+	return events, nil
+}
+
+// finish fills in the end of an event from its duration or start and reads
+// its recurrence rule
+func (e *CalendarEvent) finish(duration string, rule string, loc *time.Location) error {
+	if e.Start.IsZero() {
+		return errors.New("event without DTSTART")
+	}
+	if rule != "" {
+		recurrence, err := parseRecurrence(rule, e.Start, loc)
+		if errors.Is(err, errUnsupportedRule) {
+			log.WithFields(log.Fields{
+				"event": e.Summary,
+			}).Warn(fmt.Sprintf("[BARN] Calendar. Event [%s] only counted once: %s", e.Summary, err))
+		} else if err != nil {
+			return fmt.Errorf("RRULE: %w", err)
+		}
+		e.Recurrence = recurrence
+	}
+	if !e.End.IsZero() {
+		return nil
+	}
+	if duration != "" {
+		end, err := addCalendarDuration(e.Start, duration)
+		if err != nil {
+			return err
+		}
+		e.End = end
+		return nil
+	}
+	// An all-day event without an end lasts one day, a timed one is an instant
+	if isMidnight(e.Start) {
+		e.End = e.Start.AddDate(0, 0, 1)
+	}
+	return nil
+}
+
+func isMidnight(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0
+}
+
+// unfoldLines joins continuation lines, which start with a space or tab
+func unfoldLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// splitProperty splits NAME;PARAM=VALUE:value, ignoring colons in quoted
+// parameter values
+func splitProperty(line string) (string, map[string]string, string, bool) {
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", false
+	}
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string, len(parts)-1)
+	for _, p := range parts[1:] {
+		if key, value, found := strings.Cut(p, "="); found {
+			params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+func unescapeText(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// parseCalendarTime reads a DATE or DATE-TIME value
+func parseCalendarTime(value string, params map[string]string, loc *time.Location) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if tzid := params["TZID"]; tzid != "" {
+		zone, err := time.LoadLocation(tzid)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"tzid": tzid,
+			}).Warn(fmt.Sprintf("[BARN] Calendar. Unknown time zone [%s], using [%s]", tzid, loc))
+		} else {
+			loc = zone
+		}
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+// addCalendarDuration adds an RFC 5545 duration such as P1D or PT1H30M.
+// Weeks and days are calendar days, so they keep the wall clock across DST.
+func addCalendarDuration(t time.Time, value string) (time.Time, error) {
+	m := durationPattern.FindStringSubmatch(value)
+	if m == nil || value == "P" || value == "PT" {
+		return t, fmt.Errorf("invalid duration %q", value)
+	}
+	n := make([]int, 5)
+	for i := range n {
+		if m[i+2] != "" {
+			n[i], _ = strconv.Atoi(m[i+2])
+		}
+	}
+	sign := 1
+	if m[1] == "-" {
+		sign = -1
+	}
+	clock := time.Duration(n[2])*time.Hour + time.Duration(n[3])*time.Minute + time.Duration(n[4])*time.Second
+	return t.AddDate(0, 0, sign*(7*n[0]+n[1])).Add(time.Duration(sign) * clock), nil
+}
+
+var errUnsupportedRule = errors.New("unsupported recurrence")
+
+// parseRecurrence reads an RRULE. BY parts are only accepted where they
+// repeat the start, e.g. BYMONTH=12;BYMONTHDAY=25 of a yearly Christmas event.
+func parseRecurrence(value string, start time.Time, loc *time.Location) (*Recurrence, error) {
+	rule := &Recurrence{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, v, _ := strings.Cut(part, "=")
+		var err error
+		switch key = strings.ToUpper(key); key {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(v)
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(v)
+			if err == nil && rule.Interval < 1 {
+				err = errors.New("interval must be positive")
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(v)
+		case "UNTIL":
+			rule.Until, err = parseCalendarTime(v, nil, loc)
+		case "WKST":
+		case "BYMONTH":
+			if v != strconv.Itoa(int(start.Month())) {
+				return nil, fmt.Errorf("%w: %s", errUnsupportedRule, part)
+			}
+		case "BYMONTHDAY":
+			if v != strconv.Itoa(start.Day()) {
+				return nil, fmt.Errorf("%w: %s", errUnsupportedRule, part)
+			}
+		case "BYDAY":
+			if !strings.EqualFold(v, start.Weekday().String()[:2]) {
+				return nil, fmt.Errorf("%w: %s", errUnsupportedRule, part)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedRule, part)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+		return rule, nil
+	}
+	return nil, fmt.Errorf("%w: FREQ=%s", errUnsupportedRule, rule.Freq)
+}
+
+// step returns the calendar offset between occurrences and an upper bound of
+// its length, used to skip ahead without walking every occurrence
+func (r *Recurrence) step() (years int, months int, days int, longest time.Duration) {
+	switch r.Freq {
+	case "DAILY":
+		return 0, 0, r.Interval, time.Duration(r.Interval) * 25 * time.Hour
+	case "WEEKLY":
+		return 0, 0, 7 * r.Interval, time.Duration(r.Interval) * (7*24 + 1) * time.Hour
+	case "MONTHLY":
+		return 0, r.Interval, 0, time.Duration(r.Interval) * 31 * 24 * time.Hour
+	default:
+		return r.Interval, 0, 0, time.Duration(r.Interval) * 366 * 24 * time.Hour
+	}
+}
+
+// Occurrences calls fn with the start and end of every occurrence that
+// overlaps [from, to), in order, until fn returns false
+func (e CalendarEvent) Occurrences(from time.Time, to time.Time, fn func(start time.Time, end time.Time) bool) {
+	if e.Recurrence == nil {
+		if e.Start.Before(to) && e.End.After(from) {
+			fn(e.Start, e.End)
+		}
+		return
+	}
+	years, months, days, longest := e.Recurrence.step()
+	// Start a little before the first occurrence that can overlap from
+	k := 0
+	if skip := from.Sub(e.End); skip > 0 {
+		k = max(int(skip/longest)-1, 0)
+	}
+	for ; e.Recurrence.Count == 0 || k < e.Recurrence.Count; k++ {
+		start := e.Start.AddDate(k*years, k*months, k*days)
+		if !start.Before(to) || (!e.Recurrence.Until.IsZero() && start.After(e.Recurrence.Until)) {
+			return
+		}
+		// Months without the start day, e.g. the 31st, have no occurrence
+		if ((months > 0 || years > 0) && start.Day() != e.Start.Day()) || e.isException(start) {
+			continue
+		}
+		end := e.End.AddDate(k*years, k*months, k*days)
+		if end.After(from) && !fn(start, end) {
+			return
+		}
+	}
+}
+
+func (e CalendarEvent) isException(start time.Time) bool {
+	for _, exception := range e.Exceptions {
+		if exception.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAt reports whether an occurrence of the event covers t
+func (e CalendarEvent) ActiveAt(t time.Time) bool {
+	active := false
+	e.Occurrences(t, t.Add(time.Nanosecond), func(start time.Time, end time.Time) bool {
+		active = true
+		return false
+	})
+	return active
+}
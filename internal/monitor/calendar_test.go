@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Christmas Day\r\n" +
+	"DTSTART;VALUE=DATE:20201225\r\n" +
+	"DTEND;VALUE=DATE:20201226\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=12;BYMONTHDAY=25\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Star party\\, outreach\r\n" +
+	"DTSTART;TZID=\"Europe/London\":20240914T190000\r\n" +
+	"DURATION:PT4H\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT1H\r\n" +
+	"DESCRIPTION:Not an event\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Club night with a long \r\n" +
+	" folded name\r\n" +
+	"DTSTART:20240105T200000Z\r\n" +
+	"DTEND:20240105T230000Z\r\n" +
+	"RRULE:FREQ=WEEKLY;INTERVAL=2;COUNT=3\r\n" +
+	"EXDATE:20240119T200000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Cancelled\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"DTSTART;VALUE=DATE:20240101\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseCalendar(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	events, err := ParseCalendar(strings.NewReader(testCalendar), london)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, events, 3, "cancelled events should be skipped")
+
+	assert.Equal(t, "Christmas Day", events[0].Summary, "they should be equal")
+	assert.Equal(t, "YEARLY", events[0].Recurrence.Freq, "they should be equal")
+	assert.Equal(t, "Star party, outreach", events[1].Summary, "they should be equal")
+	assert.Equal(t, 4*time.Hour, events[1].End.Sub(events[1].Start), "they should be equal")
+	assert.Equal(t, time.Date(2024, 9, 14, 18, 0, 0, 0, time.UTC), events[1].Start.UTC(), "TZID should be applied")
+	assert.Equal(t, "Club night with a long folded name", events[2].Summary, "they should be equal")
+}
+
+func TestCalendarEvent_ActiveAt(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	events, err := ParseCalendar(strings.NewReader(testCalendar), london)
+	assert.NoError(t, err, "should work")
+	christmas, club := events[0], events[2]
+
+	assert.True(t, christmas.ActiveAt(time.Date(2031, 12, 25, 23, 0, 0, 0, london)), "yearly event should recur")
+	assert.False(t, christmas.ActiveAt(time.Date(2031, 12, 26, 0, 0, 0, 0, london)), "end should be excluded")
+	assert.False(t, christmas.ActiveAt(time.Date(2019, 12, 25, 12, 0, 0, 0, london)), "no occurrence before the start")
+
+	assert.True(t, club.ActiveAt(time.Date(2024, 1, 5, 21, 0, 0, 0, time.UTC)), "first occurrence")
+	assert.False(t, club.ActiveAt(time.Date(2024, 1, 12, 21, 0, 0, 0, time.UTC)), "interval should be applied")
+	assert.False(t, club.ActiveAt(time.Date(2024, 1, 19, 21, 0, 0, 0, time.UTC)), "EXDATE should be excluded")
+	assert.True(t, club.ActiveAt(time.Date(2024, 2, 2, 21, 0, 0, 0, time.UTC)), "third occurrence")
+	assert.False(t, club.ActiveAt(time.Date(2024, 2, 16, 21, 0, 0, 0, time.UTC)), "count should be applied")
+}
+
+func TestCalendarEvent_MonthlySkipsShortMonths(t *testing.T) {
+	event := CalendarEvent{
+		Start:      time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		End:        time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		Recurrence: &Recurrence{Freq: "MONTHLY", Interval: 1},
+	}
+	var starts []time.Time
+	event.Occurrences(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), func(start time.Time, end time.Time) bool {
+		starts = append(starts, start)
+		return true
+	})
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+	}, starts, "they should be equal")
+}
+
+func TestParseCalendar_UnsupportedRule(t *testing.T) {
+	calendar := "BEGIN:VEVENT\nSUMMARY:Thanksgiving\nDTSTART;VALUE=DATE:20241128\nRRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH\nEND:VEVENT\n"
+	events, err := ParseCalendar(strings.NewReader(calendar), time.UTC)
+	assert.NoError(t, err, "should work")
+	assert.Len(t, events, 1, "event should be kept")
+	assert.Nil(t, events[0].Recurrence, "unsupported rules should be dropped")
+	assert.Equal(t, 24*time.Hour, events[0].End.Sub(events[0].Start), "all-day event should last a day")
+
+	_, err = ParseCalendar(strings.NewReader("BEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\n"), time.UTC)
+	assert.Error(t, err, "invalid dates should fail")
+}
+
+func TestAddCalendarDuration(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	start := time.Date(2024, 3, 30, 22, 0, 0, 0, london)
+	end, err := addCalendarDuration(start, "P1DT1H30M")
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, time.Date(2024, 3, 31, 23, 30, 0, 0, london), end, "days should keep the wall clock across DST")
+	for _, invalid := range []string{"P", "PT", "1D", "P1H"} {
+		_, err := addCalendarDuration(start, invalid)
+		assert.Error(t, err, invalid)
+	}
+}
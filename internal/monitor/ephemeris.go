@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"math"
+	"time"
+)
+
+// Low precision sun and moon positions, good to a few hundredths of a degree
+// for the sun and a few tenths for the moon, which is plenty for twilight and
+// moon-up decisions. Formulas follow the Astronomical Almanac's low precision
+// methods as summarised in Meeus, Astronomical Algorithms.
+
+const (
+	deg = math.Pi / 180
+	rad = 180 / math.Pi
+	// j2000 is the Julian date of 2000-01-01 12:00 TT
+	j2000 = 2451545.0
+)
+
+// Site is an observing location
+type Site struct {
+	Latitude  float64 // degrees, north positive
+	Longitude float64 // degrees, east positive
+	Elevation float64 // metres above sea level
+}
+
+// daysSinceJ2000 returns the days since J2000.0
+func daysSinceJ2000(t time.Time) float64 {
+	return float64(t.UTC().UnixNano())/float64(24*time.Hour) + 2440587.5 - j2000
+}
+
+func sinDeg(x float64) float64 { return math.Sin(x * deg) }
+func cosDeg(x float64) float64 { return math.Cos(x * deg) }
+
+func normalize(x float64) float64 {
+	x = math.Mod(x, 360)
+	if x < 0 {
+		x += 360
+	}
+	return x
+}
+
+// sunEcliptic returns the apparent ecliptic longitude of the sun
+func sunEcliptic(d float64) float64 {
+	g := normalize(357.529 + 0.98560028*d)
+	q := normalize(280.459 + 0.98564736*d)
+	return normalize(q + 1.915*sinDeg(g) + 0.020*sinDeg(2*g))
+}
+
+func obliquity(d float64) float64 {
+	return 23.439 - 0.00000036*d
+}
+
+// moonEcliptic returns the ecliptic longitude, latitude and horizontal
+// parallax of the moon, all in degrees
+func moonEcliptic(d float64) (float64, float64, float64) {
+	t := d / 36525
+	lambda := 218.32 + 481267.881*t +
+		6.29*sinDeg(134.9+477198.85*t) - 1.27*sinDeg(259.2-413335.38*t) +
+		0.66*sinDeg(235.7+890534.23*t) + 0.21*sinDeg(269.9+954397.70*t) -
+		0.19*sinDeg(357.5+35999.05*t) - 0.11*sinDeg(186.6+966404.05*t)
+	beta := 5.13*sinDeg(93.3+483202.03*t) + 0.28*sinDeg(228.2+960400.87*t) -
+		0.28*sinDeg(318.3+6003.18*t) - 0.17*sinDeg(217.6-407332.20*t)
+	parallax := 0.9508 + 0.0518*cosDeg(134.9+477198.85*t) + 0.0095*cosDeg(259.2-413335.38*t) +
+		0.0078*cosDeg(235.7+890534.23*t) + 0.0028*cosDeg(269.9+954397.70*t)
+	return normalize(lambda), beta, parallax
+}
+
+// equatorial converts ecliptic coordinates to right ascension and declination
+func equatorial(lambda float64, beta float64, epsilon float64) (float64, float64) {
+	ra := math.Atan2(sinDeg(lambda)*cosDeg(epsilon)-math.Tan(beta*deg)*sinDeg(epsilon), cosDeg(lambda)) * rad
+	dec := math.Asin(sinDeg(beta)*cosDeg(epsilon)+cosDeg(beta)*sinDeg(epsilon)*sinDeg(lambda)) * rad
+	return normalize(ra), dec
+}
+
+// altitude returns the geometric altitude of an object seen from site
+func altitude(site Site, d float64, ra float64, dec float64) float64 {
+	gmst := normalize(280.46061837 + 360.98564736629*d)
+	hourAngle := gmst + site.Longitude - ra
+	sinAlt := sinDeg(site.Latitude)*sinDeg(dec) + cosDeg(site.Latitude)*cosDeg(dec)*cosDeg(hourAngle)
+	return math.Asin(math.Max(-1, math.Min(1, sinAlt))) * rad
+}
+
+// refraction returns the lift of an object near the horizon in degrees. The
+// standard sea level value is scaled by the air pressure at the site.
+func refraction(site Site, alt float64) float64 {
+	if alt < -1 {
+		return 0
+	}
+	pressure := math.Exp(-site.Elevation / 8434)
+	return pressure * 1.02 / math.Tan((alt+10.3/(alt+5.11))*deg) / 60
+}
+
+// SunAltitude returns the apparent altitude of the sun in degrees
+func SunAltitude(site Site, t time.Time) float64 {
+	d := daysSinceJ2000(t)
+	ra, dec := equatorial(sunEcliptic(d), 0, obliquity(d))
+	alt := altitude(site, d, ra, dec)
+	return alt + refraction(site, alt)
+}
+
+// MoonAltitude returns the apparent topocentric altitude of the moon in degrees
+func MoonAltitude(site Site, t time.Time) float64 {
+	d := daysSinceJ2000(t)
+	lambda, beta, parallax := moonEcliptic(d)
+	ra, dec := equatorial(lambda, beta, obliquity(d))
+	alt := altitude(site, d, ra, dec)
+	alt -= parallax * cosDeg(alt)
+	return alt + refraction(site, alt)
+}
+
+// MoonIllumination returns the illuminated fraction of the moon, from 0 at
+// new moon to 1 at full moon
+func MoonIllumination(t time.Time) float64 {
+	d := daysSinceJ2000(t)
+	lambda, beta, _ := moonEcliptic(d)
+	elongation := math.Acos(cosDeg(beta) * cosDeg(lambda-sunEcliptic(d)))
+	return (1 - math.Cos(elongation)) / 2
+}
@@ -0,0 +1,145 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/thebuh/barn/internal/health"
+	"github.com/thebuh/barn/internal/record"
+	"github.com/thebuh/barn/internal/serial"
+	"github.com/thebuh/barn/internal/socket"
+)
+
+// LineSource asks a device that speaks a line protocol for a reply
+type LineSource interface {
+	Query() (string, error)
+	Close() error
+}
+
+// LineValue takes the value matched against the rule from a reply
+type LineValue func(reply string) (string, error)
+
+// SafetyMonitorLine queries a device over a socket or serial port and
+// matches the reply, or a value taken from it, against its rule
+type SafetyMonitorLine struct {
+	id          string
+	name        string
+	description string
+	rule        *SafetyMatchingRule
+	value       LineValue
+	source      LineSource
+	record.Tap
+
+	mu              sync.RWMutex
+	safe            bool
+	lastRefreshTime time.Time
+	lastValue       string
+}
+
+func NewSafetyMonitorLine(id string, name string, description string, source LineSource, value LineValue, rule *SafetyMatchingRule) *SafetyMonitorLine {
+	monitor := &SafetyMonitorLine{id: id, name: name, description: description, rule: rule, value: value, source: source}
+	monitor.Refresh()
+	return monitor
+}
+
+// NewSafetyMonitorSocket creates a monitor for a TCP or UDP device
+func NewSafetyMonitorSocket(id string, name string, description string, options socket.Options, field socket.Field, rule *SafetyMatchingRule) (*SafetyMonitorLine, error) {
+	client, err := socket.New(options)
+	if err != nil {
+		return nil, err
+	}
+	return NewSafetyMonitorLine(id, name, description, client, field.Extract, rule), nil
+}
+
+// NewSafetyMonitorSerial creates a monitor for a serial device
+func NewSafetyMonitorSerial(id string, name string, description string, options serial.Options, value LineValue, rule *SafetyMatchingRule) (*SafetyMonitorLine, error) {
+	client, err := serial.New(options)
+	if err != nil {
+		return nil, err
+	}
+	return NewSafetyMonitorLine(id, name, description, client, value, rule), nil
+}
+
+// RG15Value reads a Hydreon RG-15 reply as true while it is dry, so the
+// default rule reports safe until rain is measured
+func RG15Value(reply string) (string, error) {
+	reading, err := serial.ParseRG15(reply)
+	if err != nil {
+		return "", err
+	}
+	if reading.Raining() {
+		return "false", nil
+	}
+	return "true", nil
+}
+
+func (sm *SafetyMonitorLine) GetId() string {
+	return sm.id
+}
+
+func (sm *SafetyMonitorLine) GetName() string {
+	return sm.name
+}
+
+func (sm *SafetyMonitorLine) GetDescription() string {
+	return sm.description
+}
+
+func (sm *SafetyMonitorLine) GetRule() *SafetyMatchingRule {
+	return sm.rule
+}
+
+func (sm *SafetyMonitorLine) IsSafe() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.safe
+}
+
+// GetRawValue returns the whole reply, even when a value is taken from it
+func (sm *SafetyMonitorLine) GetRawValue() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastValue
+}
+
+func (sm *SafetyMonitorLine) GetTimeStamp() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastRefreshTime
+}
+
+func (sm *SafetyMonitorLine) Refresh() error {
+	reply, err := sm.source.Query()
+	sm.Record(reply, err)
+	if err != nil {
+		sm.fail("")
+		return err
+	}
+	value := reply
+	if sm.value != nil {
+		if value, err = sm.value(reply); err != nil {
+			sm.fail(reply)
+			return health.Parse(err)
+		}
+	}
+	safe := sm.rule.isSafe(value)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.lastValue = reply
+	sm.safe = safe
+	sm.lastRefreshTime = time.Now()
+	return nil
+}
+
+func (sm *SafetyMonitorLine) fail(reply string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = false
+	sm.lastValue = reply
+}
+
+// Close hangs up the connection to the device
+func (sm *SafetyMonitorLine) Close() error {
+	return sm.source.Close()
+}
@@ -0,0 +1,122 @@
+package monitor
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/serial"
+	"github.com/thebuh/barn/internal/socket"
+)
+
+// startReplyServer answers each request with the current reply
+func startReplyServer(t *testing.T, reply *atomic.Value) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "should work")
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+					conn.Write([]byte(reply.Load().(string) + "\r\n"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSafetyMonitorLine_SocketRule(t *testing.T) {
+	var reply atomic.Value
+	reply.Store("ROOF OPEN")
+	address := startReplyServer(t, &reply)
+	sm, err := NewSafetyMonitorSocket("roof", "Roof", "Roof controller", socket.Options{Address: address, Request: "STATUS\r\n"},
+		socket.Field{}, NewSafetyMatchingRule(false, "open"))
+	assert.NoError(t, err, "should work")
+	defer sm.Close()
+	assert.Equal(t, true, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, "ROOF OPEN", sm.GetRawValue(), "they should be equal")
+
+	reply.Store("ROOF CLOSED")
+	assert.NoError(t, sm.Refresh(), "should work")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+}
+
+func TestSafetyMonitorLine_SocketField(t *testing.T) {
+	var reply atomic.Value
+	reply.Store("1,0,rain")
+	address := startReplyServer(t, &reply)
+	sm, _ := NewSafetyMonitorSocket("rain", "Rain", "", socket.Options{Address: address, Request: "?"},
+		socket.Field{Separator: ",", Index: 2}, NewSafetyMatchingRule(false, "^0$"))
+	defer sm.Close()
+	assert.Equal(t, true, sm.IsSafe(), "only the selected field should be matched")
+	assert.Equal(t, "1,0,rain", sm.GetRawValue(), "the raw value should be the whole reply")
+
+	reply.Store("1")
+	assert.Error(t, sm.Refresh(), "missing fields should fail")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+}
+
+func TestSafetyMonitorLine_SocketInvalid(t *testing.T) {
+	_, err := NewSafetyMonitorSocket("id", "name", "", socket.Options{Address: "no port"}, socket.Field{}, NewSafetyMatchingRule(false, ""))
+	assert.Error(t, err, "should fail")
+
+	sm, err := NewSafetyMonitorSocket("id", "name", "", socket.Options{Address: "127.0.0.1:1"}, socket.Field{}, NewSafetyMatchingRule(false, ""))
+	assert.NoError(t, err, "unreachable devices should only fail refreshes")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+}
+
+// replySource returns a fixed reply
+type replySource struct {
+	reply string
+}
+
+func (s *replySource) Query() (string, error) {
+	return s.reply, nil
+}
+
+func (s *replySource) Close() error {
+	return nil
+}
+
+func TestSafetyMonitorLine_RG15(t *testing.T) {
+	source := &replySource{reply: "Acc  0.00 mm, EventAcc  0.00 mm, TotalAcc  1.50 mm, RInt  0.00 mmph"}
+	sm := NewSafetyMonitorLine("rain", "Rain", "RG-15", source, RG15Value, NewSafetyMatchingRule(false, ""))
+	assert.Equal(t, true, sm.IsSafe(), "dry readings should be safe")
+
+	source.reply = "Acc  0.01 mm, EventAcc  0.01 mm, TotalAcc  1.51 mm, RInt  0.25 mmph"
+	assert.NoError(t, sm.Refresh(), "should work")
+	assert.Equal(t, false, sm.IsSafe(), "rain should be unsafe")
+	assert.Equal(t, source.reply, sm.GetRawValue(), "they should be equal")
+
+	source.reply = "p"
+	assert.Error(t, sm.Refresh(), "should fail")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+}
+
+func TestSafetyMonitorSerial_Invalid(t *testing.T) {
+	_, err := NewSafetyMonitorSerial("id", "name", "", serial.Options{}, nil, NewSafetyMatchingRule(false, ""))
+	assert.Error(t, err, "should fail")
+}
+
+func TestSafetyMonitorLine_Recorder(t *testing.T) {
+	source := &replySource{reply: "ROOF OPEN"}
+	sm := NewSafetyMonitorLine("roof", "Roof", "", source, nil, NewSafetyMatchingRule(false, "open"))
+	var payloads []string
+	sm.UseRecorder(func(payload string, err error) {
+		payloads = append(payloads, payload)
+	})
+	source.reply = "ROOF CLOSED"
+	assert.NoError(t, sm.Refresh(), "should work")
+	assert.Equal(t, []string{"ROOF CLOSED"}, payloads, "fetched replies should be recorded")
+}
@@ -0,0 +1,38 @@
+package monitor
+
+import (
+	"strconv"
+
+	"github.com/thebuh/barn/internal/modbus"
+)
+
+// modbusBit reads a coil or discrete input as "true" or "false"
+type modbusBit struct {
+	client  *modbus.Client
+	table   string
+	address uint16
+}
+
+func (b *modbusBit) Query() (string, error) {
+	value, err := b.client.ReadBit(b.table, b.address)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatBool(value), nil
+}
+
+func (b *modbusBit) Close() error {
+	return b.client.Close()
+}
+
+// NewSafetyMonitorModbus creates a monitor for a coil or discrete input of a
+// Modbus TCP device, such as a roof controller. The default rule reports
+// safe while the bit is set.
+func NewSafetyMonitorModbus(id string, name string, description string, options modbus.Options, table string, address uint16, rule *SafetyMatchingRule) (*SafetyMonitorLine, error) {
+	client, err := modbus.New(options)
+	if err != nil {
+		return nil, err
+	}
+	source := &modbusBit{client: client, table: table, address: address}
+	return NewSafetyMonitorLine(id, name, description, source, nil, rule), nil
+}
@@ -0,0 +1,62 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/modbus"
+	"github.com/thebuh/barn/internal/modbus/modbustest"
+)
+
+func TestSafetyMonitorModbus_Coil(t *testing.T) {
+	server := modbustest.NewServer()
+	defer server.Close()
+	server.SetCoil(3, true)
+
+	sm, err := NewSafetyMonitorModbus("roof", "Roof", "Roof PLC", modbus.Options{Address: server.Addr(), Timeout: time.Second},
+		modbus.TableCoil, 3, NewSafetyMatchingRule(false, ""))
+	assert.NoError(t, err, "should work")
+	defer sm.Close()
+	assert.Equal(t, true, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, "true", sm.GetRawValue(), "they should be equal")
+
+	server.SetCoil(3, false)
+	assert.NoError(t, sm.Refresh(), "should work")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, "false", sm.GetRawValue(), "they should be equal")
+}
+
+func TestSafetyMonitorModbus_DiscreteInput(t *testing.T) {
+	server := modbustest.NewServer()
+	defer server.Close()
+	// A rain sensor contact closes when wet
+	server.SetDiscreteInput(0, false)
+
+	sm, _ := NewSafetyMonitorModbus("rain", "Rain", "", modbus.Options{Address: server.Addr(), Timeout: time.Second},
+		modbus.TableDiscreteInput, 0, NewSafetyMatchingRule(false, "false"))
+	defer sm.Close()
+	assert.Equal(t, true, sm.IsSafe(), "they should be equal")
+
+	server.SetDiscreteInput(0, true)
+	sm.Refresh()
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+}
+
+func TestSafetyMonitorModbus_Errors(t *testing.T) {
+	server := modbustest.NewServer()
+	server.SetCoil(0, true)
+
+	sm, _ := NewSafetyMonitorModbus("roof", "Roof", "", modbus.Options{Address: server.Addr(), Timeout: time.Second},
+		modbus.TableCoil, 1, NewSafetyMatchingRule(false, ""))
+	defer sm.Close()
+	assert.Error(t, sm.Refresh(), "missing registers should fail")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+
+	server.Close()
+	assert.Error(t, sm.Refresh(), "unreachable servers should fail")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+
+	_, err := NewSafetyMonitorModbus("roof", "Roof", "", modbus.Options{Address: "plc.local"}, modbus.TableCoil, 0, nil)
+	assert.Error(t, err, "should fail")
+}
@@ -1,18 +1,20 @@
 package monitor
 
 import (
-	"errors"
-	"io"
-	"io/fs"
-	"net/http"
 	"os"
 	"regexp"
+	"sync"
 	"time"
+
+	"github.com/thebuh/barn/internal/fetch"
+	"github.com/thebuh/barn/internal/record"
 )
 
 type SafetyMonitor interface {
 	IsSafe() bool
-	Refresh()
+	// Refresh updates the monitor state. A failed refresh leaves the monitor
+	// unsafe and returns the cause.
+	Refresh() error
 	GetId() string
 	GetName() string
 	GetDescription() string
@@ -40,6 +42,14 @@ func NewSafetyMatchingRule(invert bool, pattern string) *SafetyMatchingRule {
 	return rule
 }
 
+func (rule *SafetyMatchingRule) GetPattern() string {
+	return rule.pattern
+}
+
+func (rule *SafetyMatchingRule) IsInverted() bool {
+	return rule.invert
+}
+
 func (rule *SafetyMatchingRule) isSafe(content string) bool {
 	if rule.regex == nil {
 		return false
@@ -51,25 +61,39 @@ func (rule *SafetyMatchingRule) isSafe(content string) bool {
 }
 
 type SafetyMonitorHttp struct {
-	id              string
-	name            string
-	description     string
+	id          string
+	name        string
+	description string
+	url         string
+	rule        *SafetyMatchingRule
+	client      *fetch.Client
+	record.Tap
+
+	// mu guards the refreshed state below. Refresh fetches without holding it
+	// and only swaps the new values in, so readers never wait on the network.
+	mu              sync.RWMutex
 	safe            bool
-	url             string
 	lastRefreshTime time.Time
 	lastValue       string
-	rule            *SafetyMatchingRule
-	client          *http.Client
 }
 
 func NewSafetyMonitorHttp(id string, name string, description string, url string, rule *SafetyMatchingRule) *SafetyMonitorHttp {
-	monitor := &SafetyMonitorHttp{id: id, name: name, description: description, url: url}
-	monitor.client = &http.Client{
-		Timeout: 5 * time.Second,
+	// Default options cannot fail
+	monitor, _ := NewSafetyMonitorHttpWithOptions(id, name, description, url, rule, fetch.Options{})
+	return monitor
+}
+
+// NewSafetyMonitorHttpWithOptions creates an HTTP monitor with a customised
+// request. Responses with an unexpected status are unsafe.
+func NewSafetyMonitorHttpWithOptions(id string, name string, description string, url string, rule *SafetyMatchingRule, options fetch.Options) (*SafetyMonitorHttp, error) {
+	client, err := fetch.New(url, options)
+	if err != nil {
+		return nil, err
 	}
+	monitor := &SafetyMonitorHttp{id: id, name: name, description: description, url: url, client: client}
 	monitor.rule = rule
 	monitor.Refresh()
-	return monitor
+	return monitor, nil
 }
 
 func (sm *SafetyMonitorHttp) GetId() string {
@@ -84,38 +108,55 @@ func (sm *SafetyMonitorHttp) GetDescription() string {
 	return sm.description
 }
 
+func (sm *SafetyMonitorHttp) GetUrl() string {
+	return sm.url
+}
+
+func (sm *SafetyMonitorHttp) GetRule() *SafetyMatchingRule {
+	return sm.rule
+}
+
 func (sm *SafetyMonitorHttp) IsSafe() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	return sm.safe
 }
 
 func (sm *SafetyMonitorHttp) GetRawValue() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	return sm.lastValue
 }
 
 func (sm *SafetyMonitorHttp) GetTimeStamp() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	return sm.lastRefreshTime
 }
 
-func (sm *SafetyMonitorHttp) Refresh() {
-	response, err := sm.client.Get(sm.url)
+func (sm *SafetyMonitorHttp) Refresh() error {
+	body, err := sm.client.Fetch()
+	sm.Record(string(body), err)
 	if err != nil {
-		sm.safe = false
-		sm.lastValue = ""
-		return
+		sm.fail()
+		return err
 	}
-	buf := make([]byte, 1024)
-	n, err := response.Body.Read(buf)
-	if err != nil && err != io.EOF {
-		sm.safe = false
-		sm.lastValue = ""
-		return
-	}
-	buf = buf[:n]
-	_ = response.Body.Close()
-	content := string(buf)
+	content := string(body)
+	safe := sm.rule.isSafe(content)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 	sm.lastValue = content
-	sm.safe = sm.rule.isSafe(content)
+	sm.safe = safe
 	sm.lastRefreshTime = time.Now()
+	return nil
+}
+
+func (sm *SafetyMonitorHttp) fail() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = false
+	sm.lastValue = ""
 }
 
 func NewSafetyMonitorDummyFromCfg(id string, cfg map[string]string) *SafetyMonitorDummy {
@@ -138,6 +179,7 @@ func NewSafetyMonitorDummy(id string, name string, description string, isSafe bo
 }
 
 type SafetyMonitorDummy struct {
+	mu          sync.RWMutex
 	safe        bool
 	id          string
 	name        string
@@ -145,10 +187,20 @@ type SafetyMonitorDummy struct {
 }
 
 func (sm *SafetyMonitorDummy) IsSafe() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	return sm.safe
 }
 
-func (sm *SafetyMonitorDummy) Refresh() {
+// SetSafe changes the state at runtime, such as from integration tests
+func (sm *SafetyMonitorDummy) SetSafe(safe bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = safe
+}
+
+func (sm *SafetyMonitorDummy) Refresh() error {
+	return nil
 }
 
 func (sm *SafetyMonitorDummy) GetId() string {
@@ -170,14 +222,17 @@ func (sm *SafetyMonitorDummy) GetTimeStamp() time.Time {
 }
 
 type SafetyMonitorFile struct {
-	id              string
-	name            string
-	description     string
+	id          string
+	name        string
+	description string
+	path        string
+	rule        *SafetyMatchingRule
+	record.Tap
+
+	mu              sync.RWMutex
 	safe            bool
-	path            string
 	lastRefreshTime time.Time
 	lastValue       string
-	rule            *SafetyMatchingRule
 }
 
 func (sm *SafetyMonitorFile) GetId() string {
@@ -191,38 +246,63 @@ func (sm *SafetyMonitorFile) GetDescription() string {
 	return sm.description
 }
 
+func (sm *SafetyMonitorFile) GetPath() string {
+	return sm.path
+}
+
+func (sm *SafetyMonitorFile) GetRule() *SafetyMatchingRule {
+	return sm.rule
+}
+
 func (sm *SafetyMonitorFile) GetRawValue() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	return sm.lastValue
 }
 
 func (sm *SafetyMonitorFile) GetTimeStamp() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	return sm.lastRefreshTime
 }
 
 func (sm *SafetyMonitorFile) IsSafe() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
 	return sm.safe
 }
 
-func (sm *SafetyMonitorFile) Refresh() {
+func (sm *SafetyMonitorFile) Refresh() error {
 	f, err := os.OpenFile(sm.path, os.O_RDONLY, 0444)
-	if errors.Is(err, fs.ErrNotExist) {
-		sm.safe = false
-		sm.lastValue = ""
-		return
+	if err != nil {
+		sm.Record("", err)
+		sm.fail()
+		return err
 	}
 	defer f.Close()
 	buf := make([]byte, 1024)
 	n, err := f.Read(buf)
+	sm.Record(string(buf[:n]), err)
 	if err != nil {
-		sm.safe = false
-		sm.lastValue = ""
-		return
+		sm.fail()
+		return err
 	}
-	buf = buf[:n]
-	content := string(buf)
+	content := string(buf[:n])
+	safe := sm.rule.isSafe(content)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 	sm.lastValue = content
-	sm.safe = sm.rule.isSafe(content)
+	sm.safe = safe
 	sm.lastRefreshTime = time.Now()
+	return nil
+}
+
+func (sm *SafetyMonitorFile) fail() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = false
+	sm.lastValue = ""
 }
 
 func NewSafetyMonitorFile(id string, name string, description string, path string, rule *SafetyMatchingRule) *SafetyMonitorFile {
@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/fetch"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -17,7 +22,8 @@ func TestSafetyMonitorDummy(t *testing.T) {
 	assert.Equal(t, false, dummy.IsSafe(), "they should be equal")
 	assert.Equal(t, "name", dummy.GetName(), "they should be equal")
 	assert.Equal(t, "description", dummy.GetDescription(), "they should be equal")
-
+	dummy.SetSafe(true)
+	assert.Equal(t, true, dummy.IsSafe(), "they should be equal")
 }
 
 func TestSafetyMonitorFile_IsSafeRefresh(t *testing.T) {
@@ -109,6 +115,41 @@ func TestSafetyMonitorHttp_InvalidUrl(t *testing.T) {
 	assert.Equal(t, false, file.IsSafe(), "they should be equal")
 }
 
+func TestSafetyMonitorHttp_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("error 1"))
+	}))
+	defer server.Close()
+	httpsm := NewSafetyMonitorHttp("id", "name", "description", server.URL, NewSafetyMatchingRule(false, ""))
+	assert.Equal(t, false, httpsm.IsSafe(), "error pages should be unsafe")
+	assert.Error(t, httpsm.Refresh(), "should fail")
+
+	httpsm, err := NewSafetyMonitorHttpWithOptions("id", "name", "description", server.URL, NewSafetyMatchingRule(false, ""),
+		fetch.Options{ExpectedStatus: []int{500}})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, true, httpsm.IsSafe(), "expected statuses should be matched")
+}
+
+func TestSafetyMonitorHttp_Options(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("true"))
+	}))
+	defer server.Close()
+	httpsm, err := NewSafetyMonitorHttpWithOptions("id", "name", "description", server.URL, NewSafetyMatchingRule(false, ""),
+		fetch.Options{Method: http.MethodPost, Token: "abc"})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, true, httpsm.IsSafe(), "they should be equal")
+
+	_, err = NewSafetyMonitorHttpWithOptions("id", "name", "description", server.URL, NewSafetyMatchingRule(false, ""),
+		fetch.Options{CAFile: "/nonexistent/ca.pem"})
+	assert.Error(t, err, "should fail")
+}
+
 func TestSafetyMatchingRule_Regex(t *testing.T) {
 	rule := NewSafetyMatchingRule(false, "[a-z]+")
 	assert.Equal(t, false, rule.isSafe("1"), "they should be equal")
@@ -130,3 +171,85 @@ func TestSafetyMatchingRule_InvalidRegex(t *testing.T) {
 	assert.Equal(t, false, rule.isSafe("0"), "they should be equal")
 	assert.Equal(t, false, rule.isSafe("abc"), "they should be equal")
 }
+
+func TestSafetyMonitorHttp_ConcurrentRefresh(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1)%2 == 0 {
+			w.Write([]byte("true"))
+			return
+		}
+		w.Write([]byte("false"))
+	}))
+	defer server.Close()
+	httpsm := NewSafetyMonitorHttp("id", "name", "description", server.URL, NewSafetyMatchingRule(false, ""))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				httpsm.Refresh()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				httpsm.IsSafe()
+				httpsm.GetRawValue()
+				httpsm.GetTimeStamp()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Contains(t, []string{"true", "false"}, httpsm.GetRawValue(), "should be one of the served values")
+}
+
+func TestSafetyMonitorFile_ConcurrentRefresh(t *testing.T) {
+	f, err := os.CreateTemp("", "SafetyMonitorFileTest")
+	assert.NoError(t, err, "should work")
+	defer os.Remove(f.Name())
+	_, err = f.Write([]byte("true"))
+	assert.NoError(t, err, "should work")
+	file := NewSafetyMonitorFile("file", "name", "description", f.Name(), NewSafetyMatchingRule(false, ""))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				file.Refresh()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				file.IsSafe()
+				file.GetRawValue()
+				file.GetTimeStamp()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, true, file.IsSafe(), "they should be equal")
+}
+
+func TestSafetyMonitorFile_Recorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roof")
+	assert.NoError(t, os.WriteFile(path, []byte("open"), 0644), "should work")
+	file := NewSafetyMonitorFile("roof", "Roof", "", path, NewSafetyMatchingRule(false, "open"))
+	var payloads, errs []string
+	file.UseRecorder(func(payload string, err error) {
+		payloads = append(payloads, payload)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	})
+	assert.NoError(t, file.Refresh(), "should work")
+	assert.NoError(t, os.Remove(path), "should work")
+	assert.Error(t, file.Refresh(), "should fail")
+	assert.Equal(t, []string{"open", ""}, payloads, "they should be equal")
+	assert.Len(t, errs, 1, "failed reads should be recorded")
+}
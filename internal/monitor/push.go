@@ -0,0 +1,71 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thebuh/barn/internal/push"
+)
+
+// SafetyMonitorPush is a line monitor fed by pushed payloads instead of
+// queries. It is unsafe until the first push and when no push arrives within
+// the expiry.
+type SafetyMonitorPush struct {
+	*SafetyMonitorLine
+	source *push.Source
+}
+
+// NewSafetyMonitorPush creates a monitor for payloads pushed by external
+// scripts. A zero expiry keeps the last payload forever.
+func NewSafetyMonitorPush(id string, name string, description string, expiry time.Duration, value LineValue, rule *SafetyMatchingRule) (*SafetyMonitorPush, error) {
+	source, err := push.NewSource(expiry)
+	if err != nil {
+		return nil, err
+	}
+	return &SafetyMonitorPush{
+		SafetyMonitorLine: NewSafetyMonitorLine(id, name, description, source, value, rule),
+		source:            source,
+	}, nil
+}
+
+// Receive takes a payload for the next refresh
+func (sm *SafetyMonitorPush) Receive(payload string) {
+	sm.source.Push(payload)
+}
+
+// Push takes a payload and refreshes the monitor from it
+func (sm *SafetyMonitorPush) Push(payload string) error {
+	sm.Receive(payload)
+	return sm.Refresh()
+}
+
+// JSONValue takes the value at key, with dots between nested keys, from a
+// JSON object. Strings are taken as they are, other values as JSON.
+func JSONValue(key string) LineValue {
+	return func(reply string) (string, error) {
+		var value interface{}
+		if err := json.Unmarshal([]byte(reply), &value); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		for _, name := range strings.Split(key, ".") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("key %s not found", key)
+			}
+			if value, ok = object[name]; !ok {
+				return "", fmt.Errorf("key %s not found", key)
+			}
+		}
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		encoded, _ := json.Marshal(value)
+		return string(encoded), nil
+	}
+}
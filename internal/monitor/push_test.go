@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/health"
+)
+
+func TestSafetyMonitorPush(t *testing.T) {
+	sm, err := NewSafetyMonitorPush("rain", "Rain", "", time.Minute, JSONValue("rain.state"), NewSafetyMatchingRule(false, "^dry$"))
+	assert.NoError(t, err, "should work")
+	assert.False(t, sm.IsSafe(), "push monitors should be unsafe before the first push")
+
+	assert.NoError(t, sm.Push(`{"rain": {"state": "dry"}}`), "should work")
+	assert.True(t, sm.IsSafe(), "pushed payloads should go through the value and rule")
+	assert.Equal(t, `{"rain": {"state": "dry"}}`, sm.GetRawValue(), "they should be equal")
+
+	err = sm.Push(`{"rain": {}}`)
+	assert.Error(t, err, "should fail")
+	assert.Equal(t, health.CauseParse, health.Cause(err), "unusable payloads should be parse errors")
+	assert.False(t, sm.IsSafe(), "unusable pushes should leave the monitor unsafe")
+
+	_, err = NewSafetyMonitorPush("rain", "Rain", "", -time.Minute, nil, NewSafetyMatchingRule(false, ""))
+	assert.Error(t, err, "negative expiries should fail")
+}
+
+func TestJSONValue(t *testing.T) {
+	for reply, want := range map[string]string{
+		`{"state": "safe"}`:          "safe",
+		`{"state": 1.5}`:             "1.5",
+		`{"state": true}`:            "true",
+		`{"state": {"rain": false}}`: `{"rain":false}`,
+	} {
+		value, err := JSONValue("state")(reply)
+		assert.NoError(t, err, reply)
+		assert.Equal(t, want, value, reply)
+	}
+	for _, reply := range []string{`dry`, `{"other": 1}`, `["state"]`} {
+		_, err := JSONValue("state")(reply)
+		assert.Error(t, err, reply)
+	}
+}
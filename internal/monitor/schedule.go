@@ -0,0 +1,400 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduleHorizon bounds the search for the next transition. It covers a
+// full week of windows.
+const scheduleHorizon = 8 * 24 * time.Hour
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// TimeOfDay is a wall clock time
+type TimeOfDay struct {
+	Hour   int
+	Minute int
+}
+
+// ParseTimeOfDay reads a time such as 21:00. 24:00 is accepted as the end of
+// the day.
+func ParseTimeOfDay(value string) (TimeOfDay, error) {
+	var t TimeOfDay
+	if _, err := fmt.Sscanf(value, "%d:%d", &t.Hour, &t.Minute); err != nil || len(value) != len("15:04") {
+		return t, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	if t.Hour < 0 || t.Hour > 24 || t.Minute < 0 || t.Minute > 59 || (t.Hour == 24 && t.Minute != 0) {
+		return t, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+}
+
+// on returns the time of day on the date of day, in its location
+func (t TimeOfDay) on(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour, t.Minute, 0, 0, day.Location())
+}
+
+// ScheduleWindow is a safe period starting on some weekdays. A window that
+// ends at or before its start runs past midnight into the next day.
+type ScheduleWindow struct {
+	// Days the window starts on; empty means every day
+	Days  []time.Weekday
+	Start TimeOfDay
+	End   TimeOfDay
+}
+
+// ParseWeekdays reads day names such as mon, Tuesday or ranges such as mon-fri
+func ParseWeekdays(values []string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, value := range values {
+		from, to, isRange := strings.Cut(value, "-")
+		if !isRange {
+			to = from
+		}
+		first, ok := parseWeekday(from)
+		last, ok2 := parseWeekday(to)
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid day %q", value)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for short, day := range weekdayNames {
+		if name == short || name == strings.ToLower(day.String()) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+func (w ScheduleWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// span returns the window starting on the date of day, if it starts that day
+func (w ScheduleWindow) span(day time.Time) (time.Time, time.Time, bool) {
+	if !w.startsOn(day.Weekday()) {
+		return time.Time{}, time.Time{}, false
+	}
+	start := w.Start.on(day)
+	end := w.End.on(day)
+	if !end.After(start) {
+		end = w.End.on(day.AddDate(0, 0, 1))
+	}
+	return start, end, true
+}
+
+func (w ScheduleWindow) String() string {
+	s := w.Start.String() + "-" + w.End.String()
+	if len(w.Days) == 0 {
+		return s
+	}
+	names := make([]string, len(w.Days))
+	for i, d := range w.Days {
+		names[i] = d.String()[:3]
+	}
+	return strings.Join(names, ",") + " " + s
+}
+
+// DateRange is a range of whole days, both ends included
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// ParseDateRange reads a date such as 2024-12-24 or a range such as
+// 2024-12-24/2024-12-26
+func ParseDateRange(value string) (DateRange, error) {
+	from, to, isRange := strings.Cut(value, "/")
+	if !isRange {
+		to = from
+	}
+	var r DateRange
+	var err error
+	if r.From, err = time.Parse(time.DateOnly, strings.TrimSpace(from)); err != nil {
+		return r, fmt.Errorf("invalid date %q", value)
+	}
+	if r.To, err = time.Parse(time.DateOnly, strings.TrimSpace(to)); err != nil {
+		return r, fmt.Errorf("invalid date %q", value)
+	}
+	if r.To.Before(r.From) {
+		return r, fmt.Errorf("invalid date range %q", value)
+	}
+	return r, nil
+}
+
+// span returns the range from the first midnight to the midnight after the
+// last day in loc
+func (r DateRange) span(loc *time.Location) (time.Time, time.Time) {
+	return time.Date(r.From.Year(), r.From.Month(), r.From.Day(), 0, 0, 0, 0, loc),
+		time.Date(r.To.Year(), r.To.Month(), r.To.Day()+1, 0, 0, 0, 0, loc)
+}
+
+func (r DateRange) String() string {
+	if r.From.Equal(r.To) {
+		return r.From.Format(time.DateOnly)
+	}
+	return r.From.Format(time.DateOnly) + "/" + r.To.Format(time.DateOnly)
+}
+
+// ScheduleConfig configures a time window based monitor
+type ScheduleConfig struct {
+	Location *time.Location
+	// Windows are the safe periods; without windows the monitor is safe
+	// whenever no blackout applies
+	Windows []ScheduleWindow
+	// Blackouts are unsafe days that override the windows
+	Blackouts []DateRange
+	// Calendar is an optional iCalendar file whose events are blackouts
+	Calendar string
+}
+
+// Validate checks the config
+func (c ScheduleConfig) Validate() error {
+	if c.Location == nil {
+		return errors.New("time zone is required")
+	}
+	return nil
+}
+
+// schedule evaluates a config and calendar at any time
+type schedule struct {
+	config ScheduleConfig
+	events []CalendarEvent
+}
+
+// stateAt returns whether t is safe and why
+func (s schedule) stateAt(t time.Time) (bool, string) {
+	t = t.In(s.config.Location)
+	for _, blackout := range s.config.Blackouts {
+		if start, end := blackout.span(s.config.Location); !t.Before(start) && t.Before(end) {
+			return false, "blackout " + blackout.String()
+		}
+	}
+	for _, event := range s.events {
+		if event.ActiveAt(t) {
+			return false, fmt.Sprintf("calendar event %q", event.Summary)
+		}
+	}
+	if len(s.config.Windows) == 0 {
+		return true, "no blackout"
+	}
+	for _, window := range s.config.Windows {
+		// A window from the previous day may still be open
+		for _, day := range []time.Time{t.AddDate(0, 0, -1), t} {
+			if start, end, ok := window.span(day); ok && !t.Before(start) && t.Before(end) {
+				return true, "window " + window.String()
+			}
+		}
+	}
+	return false, "outside windows"
+}
+
+// boundaries returns every instant in (from, to] where the state may change
+func (s schedule) boundaries(from time.Time, to time.Time) []time.Time {
+	loc := s.config.Location
+	var times []time.Time
+	add := func(ts ...time.Time) {
+		for _, t := range ts {
+			if t.After(from) && !t.After(to) {
+				times = append(times, t)
+			}
+		}
+	}
+	for _, blackout := range s.config.Blackouts {
+		add(blackout.span(loc))
+	}
+	for _, event := range s.events {
+		event.Occurrences(from, to, func(start time.Time, end time.Time) bool {
+			add(start, end)
+			return true
+		})
+	}
+	first := from.In(loc).AddDate(0, 0, -1)
+	for day := first; !day.After(to); day = day.AddDate(0, 0, 1) {
+		for _, window := range s.config.Windows {
+			if start, end, ok := window.span(day); ok {
+				add(start, end)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	return times
+}
+
+// nextTransition returns the first boundary after from with another state
+func (s schedule) nextTransition(from time.Time, safe bool) time.Time {
+	for _, t := range s.boundaries(from, from.Add(scheduleHorizon)) {
+		if next, _ := s.stateAt(t); next != safe {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// SafetyMonitorSchedule is safe within configured time windows, except on
+// blackout days and during calendar events
+type SafetyMonitorSchedule struct {
+	id          string
+	name        string
+	description string
+	config      ScheduleConfig
+	now         func() time.Time
+
+	mu              sync.RWMutex
+	safe            bool
+	lastRefreshTime time.Time
+	lastValue       string
+	nextTransition  time.Time
+	events          []CalendarEvent
+	calendarModTime time.Time
+}
+
+func NewSafetyMonitorSchedule(id string, name string, description string, config ScheduleConfig) *SafetyMonitorSchedule {
+	monitor := &SafetyMonitorSchedule{id: id, name: name, description: description, config: config, now: time.Now}
+	monitor.Refresh()
+	return monitor
+}
+
+func (sm *SafetyMonitorSchedule) GetId() string {
+	return sm.id
+}
+
+func (sm *SafetyMonitorSchedule) GetName() string {
+	return sm.name
+}
+
+func (sm *SafetyMonitorSchedule) GetDescription() string {
+	return sm.description
+}
+
+func (sm *SafetyMonitorSchedule) GetConfig() ScheduleConfig {
+	return sm.config
+}
+
+func (sm *SafetyMonitorSchedule) IsSafe() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.safe
+}
+
+// GetRawValue describes why the monitor is in its state and the next transition
+func (sm *SafetyMonitorSchedule) GetRawValue() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastValue
+}
+
+func (sm *SafetyMonitorSchedule) GetTimeStamp() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastRefreshTime
+}
+
+// GetNextTransition returns when the state will next change, or the zero
+// time if it does not change within eight days
+func (sm *SafetyMonitorSchedule) GetNextTransition() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.nextTransition
+}
+
+func (sm *SafetyMonitorSchedule) Refresh() error {
+	events, err := sm.loadCalendar()
+	if err != nil {
+		sm.fail()
+		return err
+	}
+	s := schedule{config: sm.config, events: events}
+	now := sm.now()
+	safe, reason := s.stateAt(now)
+	next := s.nextTransition(now, safe)
+
+	value := reason
+	if next.IsZero() {
+		value += ", no transition within 8 days"
+	} else {
+		value += fmt.Sprintf(", %s at %s", stateName(!safe), next.In(sm.config.Location).Format(time.RFC3339))
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = safe
+	sm.lastValue = value
+	sm.nextTransition = next
+	sm.lastRefreshTime = now
+	return nil
+}
+
+// loadCalendar returns the calendar events, reading the file again when it
+// has changed
+func (sm *SafetyMonitorSchedule) loadCalendar() ([]CalendarEvent, error) {
+	if sm.config.Calendar == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(sm.config.Calendar)
+	if err != nil {
+		return nil, err
+	}
+	sm.mu.RLock()
+	events, modTime := sm.events, sm.calendarModTime
+	sm.mu.RUnlock()
+	if info.ModTime().Equal(modTime) {
+		return events, nil
+	}
+
+	f, err := os.Open(sm.config.Calendar)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	events, err = ParseCalendar(f, sm.config.Location)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", sm.config.Calendar, err)
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.events = events
+	sm.calendarModTime = info.ModTime()
+	return events, nil
+}
+
+func (sm *SafetyMonitorSchedule) fail() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = false
+	sm.lastValue = ""
+	sm.nextTransition = time.Time{}
+}
@@ -0,0 +1,136 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSchedule(t *testing.T, config ScheduleConfig, now time.Time) *SafetyMonitorSchedule {
+	sm := &SafetyMonitorSchedule{id: "schedule", name: "name", description: "description", config: config, now: func() time.Time { return now }}
+	assert.NoError(t, sm.Refresh(), "should work")
+	return sm
+}
+
+func weeknights(t *testing.T) ScheduleConfig {
+	london, err := time.LoadLocation("Europe/London")
+	assert.NoError(t, err, "should work")
+	days, err := ParseWeekdays([]string{"mon-fri"})
+	assert.NoError(t, err, "should work")
+	return ScheduleConfig{
+		Location: london,
+		Windows:  []ScheduleWindow{{Days: days, Start: TimeOfDay{21, 0}, End: TimeOfDay{5, 0}}},
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tod, err := ParseTimeOfDay("21:30")
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, TimeOfDay{21, 30}, tod, "they should be equal")
+	_, err = ParseTimeOfDay("24:00")
+	assert.NoError(t, err, "should work")
+	for _, invalid := range []string{"9:00", "25:00", "21:60", "24:30", "night"} {
+		_, err := ParseTimeOfDay(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseWeekdays(t *testing.T) {
+	days, err := ParseWeekdays([]string{"fri-mon", "Wednesday"})
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday, time.Wednesday}, days, "they should be equal")
+	_, err = ParseWeekdays([]string{"mo"})
+	assert.Error(t, err, "should fail")
+}
+
+func TestParseDateRange(t *testing.T) {
+	r, err := ParseDateRange("2024-12-24/2024-12-26")
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "2024-12-24/2024-12-26", r.String(), "they should be equal")
+	r, err = ParseDateRange("2024-12-24")
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "2024-12-24", r.String(), "they should be equal")
+	_, err = ParseDateRange("2024-12-26/2024-12-24")
+	assert.Error(t, err, "should fail")
+}
+
+func TestSafetyMonitorSchedule_Windows(t *testing.T) {
+	config := weeknights(t)
+	loc := config.Location
+	for _, tc := range []struct {
+		at   time.Time
+		safe bool
+	}{
+		{time.Date(2024, 10, 14, 20, 59, 0, 0, loc), false}, // Monday evening
+		{time.Date(2024, 10, 14, 21, 0, 0, 0, loc), true},
+		{time.Date(2024, 10, 15, 4, 59, 0, 0, loc), true}, // Tuesday morning, Monday's window
+		{time.Date(2024, 10, 15, 5, 0, 0, 0, loc), false},
+		{time.Date(2024, 10, 19, 2, 0, 0, 0, loc), true},   // Saturday morning, Friday's window
+		{time.Date(2024, 10, 19, 22, 0, 0, 0, loc), false}, // Saturday night
+		{time.Date(2024, 10, 14, 2, 0, 0, 0, loc), false},  // Monday morning, no Sunday window
+	} {
+		sm := newTestSchedule(t, config, tc.at)
+		assert.Equal(t, tc.safe, sm.IsSafe(), tc.at.String())
+	}
+}
+
+func TestSafetyMonitorSchedule_DST(t *testing.T) {
+	config := weeknights(t)
+	config.Windows[0].Days = nil
+	// Clocks go forward at 01:00 UTC on 2024-03-31, so the night is an hour shorter
+	sm := newTestSchedule(t, config, time.Date(2024, 3, 30, 21, 30, 0, 0, time.UTC))
+	assert.Equal(t, true, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, time.Date(2024, 3, 31, 4, 0, 0, 0, time.UTC), sm.GetNextTransition().UTC(), "window should end at 05:00 BST")
+
+	// And back at 01:00 UTC on 2024-10-27, an hour longer
+	sm = newTestSchedule(t, config, time.Date(2024, 10, 26, 20, 30, 0, 0, time.UTC))
+	assert.Equal(t, true, sm.IsSafe(), "21:30 BST is inside the window")
+	sm = newTestSchedule(t, config, time.Date(2024, 10, 26, 19, 30, 0, 0, time.UTC))
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, time.Date(2024, 10, 26, 20, 0, 0, 0, time.UTC), sm.GetNextTransition().UTC(), "window should start at 21:00 BST")
+	sm = newTestSchedule(t, config, time.Date(2024, 10, 26, 20, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 10, 27, 5, 0, 0, 0, time.UTC), sm.GetNextTransition().UTC(), "window should end at 05:00 GMT")
+}
+
+func TestSafetyMonitorSchedule_Blackouts(t *testing.T) {
+	config := weeknights(t)
+	blackout, err := ParseDateRange("2024-12-24/2024-12-25")
+	assert.NoError(t, err, "should work")
+	config.Blackouts = []DateRange{blackout}
+	loc := config.Location
+
+	sm := newTestSchedule(t, config, time.Date(2024, 12, 23, 23, 0, 0, 0, loc))
+	assert.Equal(t, true, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, time.Date(2024, 12, 24, 0, 0, 0, 0, loc), sm.GetNextTransition(), "blackout should start at midnight")
+	assert.Equal(t, "window Mon,Tue,Wed,Thu,Fri 21:00-05:00, unsafe at 2024-12-24T00:00:00Z", sm.GetRawValue(), "they should be equal")
+
+	sm = newTestSchedule(t, config, time.Date(2024, 12, 24, 22, 0, 0, 0, loc))
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, "blackout 2024-12-24/2024-12-25, safe at 2024-12-26T00:00:00Z", sm.GetRawValue(), "they should be equal")
+}
+
+func TestSafetyMonitorSchedule_Calendar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.ics")
+	assert.NoError(t, os.WriteFile(path, []byte(testCalendar), 0644), "should work")
+	config := weeknights(t)
+	config.Windows = nil
+	config.Calendar = path
+
+	sm := newTestSchedule(t, config, time.Date(2024, 12, 20, 12, 0, 0, 0, config.Location))
+	assert.Equal(t, true, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, "no blackout, unsafe at 2024-12-25T00:00:00Z", sm.GetRawValue(), "they should be equal")
+
+	sm.now = func() time.Time { return time.Date(2024, 12, 25, 12, 0, 0, 0, config.Location) }
+	assert.NoError(t, sm.Refresh(), "should work")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+	assert.Equal(t, `calendar event "Christmas Day", safe at 2024-12-26T00:00:00Z`, sm.GetRawValue(), "they should be equal")
+
+	// A calendar that can't be read is unsafe
+	assert.NoError(t, os.Remove(path), "should work")
+	sm.now = func() time.Time { return time.Date(2024, 12, 20, 12, 0, 0, 0, config.Location) }
+	assert.Error(t, sm.Refresh(), "should fail")
+	assert.Equal(t, false, sm.IsSafe(), "they should be equal")
+}
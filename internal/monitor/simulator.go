@@ -0,0 +1,110 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thebuh/barn/internal/weather"
+)
+
+// SafetyMonitorSimulator is unsafe while a simulated reading is above its
+// limit, or while a scenario event says so
+type SafetyMonitorSimulator struct {
+	id          string
+	name        string
+	description string
+	simulator   *weather.Simulator
+	limits      map[string]float64
+	now         func() time.Time
+
+	mu              sync.RWMutex
+	safe            bool
+	lastRefreshTime time.Time
+	lastValue       string
+}
+
+// NewSafetyMonitorSimulator creates a monitor with upper limits by sensor
+// name. Without limits only scenario events make it unsafe.
+func NewSafetyMonitorSimulator(id string, name string, description string, simulator *weather.Simulator, limits map[string]float64) (*SafetyMonitorSimulator, error) {
+	if simulator == nil {
+		return nil, errors.New("simulator is required")
+	}
+	canonical := make(map[string]float64, len(limits))
+	for sensorName, limit := range limits {
+		sensor, valid := weather.CanonicalSensor(sensorName)
+		if !valid || sensor == weather.SensorAveragePeriod {
+			return nil, fmt.Errorf("unknown sensor %q", sensorName)
+		}
+		canonical[sensor] = limit
+	}
+	monitor := &SafetyMonitorSimulator{
+		id:          id,
+		name:        name,
+		description: description,
+		simulator:   simulator,
+		limits:      canonical,
+		now:         time.Now,
+	}
+	monitor.Refresh()
+	return monitor, nil
+}
+
+func (sm *SafetyMonitorSimulator) GetId() string {
+	return sm.id
+}
+
+func (sm *SafetyMonitorSimulator) GetName() string {
+	return sm.name
+}
+
+func (sm *SafetyMonitorSimulator) GetDescription() string {
+	return sm.description
+}
+
+func (sm *SafetyMonitorSimulator) IsSafe() bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.safe
+}
+
+// GetRawValue lists the limited readings, such as "RainRate=0 WindGust=7.4"
+func (sm *SafetyMonitorSimulator) GetRawValue() string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastValue
+}
+
+func (sm *SafetyMonitorSimulator) GetTimeStamp() time.Time {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.lastRefreshTime
+}
+
+func (sm *SafetyMonitorSimulator) Refresh() error {
+	now := sm.now()
+	values := sm.simulator.Values(now)
+	safe := true
+	var readings []string
+	for sensor, limit := range sm.limits {
+		value := values[sensor]
+		if value > limit {
+			safe = false
+		}
+		readings = append(readings, fmt.Sprintf("%s=%g", sensor, value))
+	}
+	sort.Strings(readings)
+	if scripted, isScripted := sm.simulator.Safe(now); isScripted {
+		safe = scripted
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.safe = safe
+	sm.lastValue = strings.Join(readings, " ")
+	sm.lastRefreshTime = now
+	return nil
+}
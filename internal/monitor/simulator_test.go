@@ -0,0 +1,48 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+func TestSafetyMonitorSimulator(t *testing.T) {
+	start := time.Date(2025, 1, 1, 20, 0, 0, 0, time.UTC)
+	safe := true
+	scenario := weather.DefaultScenario
+	scenario.RainShowers = 0
+	scenario.Events = []weather.ScenarioEvent{
+		{At: 10 * time.Minute, Duration: 20 * time.Minute, Values: map[string]float64{weather.SensorRainRate: 2.5}},
+		{At: 20 * time.Minute, Duration: 5 * time.Minute, Safe: &safe},
+	}
+	simulator, err := weather.NewSimulator(scenario, 42, start)
+	assert.NoError(t, err, "should work")
+	sm, err := NewSafetyMonitorSimulator("sim", "Simulator", "", simulator, map[string]float64{"rainrate": 0})
+	assert.NoError(t, err, "should work")
+
+	now := start
+	sm.now = func() time.Time { return now }
+	for _, step := range []struct {
+		at   time.Duration
+		safe bool
+	}{
+		{0, true},
+		{10 * time.Minute, false},
+		{20 * time.Minute, true},
+		{25 * time.Minute, false},
+		{30 * time.Minute, true},
+	} {
+		now = start.Add(step.at)
+		assert.NoError(t, sm.Refresh(), "should work")
+		assert.Equal(t, step.safe, sm.IsSafe(), "they should be equal at T+%v", step.at)
+	}
+	assert.Equal(t, "RainRate=0", sm.GetRawValue(), "they should be equal")
+	assert.Equal(t, now, sm.GetTimeStamp(), "they should be equal")
+
+	_, err = NewSafetyMonitorSimulator("sim", "Simulator", "", simulator, map[string]float64{"snow": 0})
+	assert.Error(t, err, "should fail")
+	_, err = NewSafetyMonitorSimulator("sim", "Simulator", "", nil, nil)
+	assert.Error(t, err, "should fail")
+}
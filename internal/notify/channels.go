@@ -0,0 +1,310 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Channel delivers a single event
+type Channel interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// DefaultWebhookBody is a generic JSON payload accepted by Gotify, Slack and
+// Discord compatible webhooks, which read the message, text and content field
+// respectively. ntfy publishes the raw body, so use "{{.Message}}" there.
+const DefaultWebhookBody = `{"title": "barn", "message": {{json .Message}}, "text": {{json .Message}}, "content": {{json .Message}}, "event": {{json .Type}}, "device": {{json .DeviceId}}, "safe": {{.Safe}}}`
+
+// DefaultEmailSubject is used when no subject template is configured
+const DefaultEmailSubject = `[barn] {{.DeviceName}}: {{.Type}}`
+
+// DefaultTimeout bounds a single delivery attempt
+const DefaultTimeout = 10 * time.Second
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func render(t *template.Template, e Event) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, e); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// WebhookChannel sends the event as a templated HTTP request
+type WebhookChannel struct {
+	url     string
+	method  string
+	headers map[string]string
+	body    *template.Template
+	client  *http.Client
+}
+
+// NewWebhookChannel creates a webhook channel. An empty body uses DefaultWebhookBody.
+func NewWebhookChannel(url string, method string, headers map[string]string, body string, timeout time.Duration) (*WebhookChannel, error) {
+	if url == "" {
+		return nil, fmt.Errorf("webhook url is required")
+	}
+	if method == "" {
+		method = http.MethodPost
+	}
+	if body == "" {
+		body = DefaultWebhookBody
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	t, err := parseTemplate("body", body)
+	if err != nil {
+		return nil, fmt.Errorf("webhook body: %w", err)
+	}
+	return &WebhookChannel{
+		url:     url,
+		method:  strings.ToUpper(method),
+		headers: headers,
+		body:    t,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (w *WebhookChannel) Send(ctx context.Context, e Event) error {
+	body, err := render(w.body, e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// EmailChannel sends the event by SMTP. STARTTLS is used when the server offers it.
+type EmailChannel struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+	subject  *template.Template
+	timeout  time.Duration
+}
+
+// NewEmailChannel creates an SMTP channel. An empty subject uses
+// DefaultEmailSubject and a zero timeout DefaultTimeout.
+func NewEmailChannel(host string, port int, username string, password string, from string, to []string, subject string, timeout time.Duration) (*EmailChannel, error) {
+	if host == "" || from == "" || len(to) == 0 {
+		return nil, fmt.Errorf("email host, from and to are required")
+	}
+	if port == 0 {
+		port = 25
+	}
+	if subject == "" {
+		subject = DefaultEmailSubject
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	t, err := parseTemplate("subject", subject)
+	if err != nil {
+		return nil, fmt.Errorf("email subject: %w", err)
+	}
+	return &EmailChannel{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+		to:       to,
+		subject:  t,
+		timeout:  timeout,
+	}, nil
+}
+
+func (m *EmailChannel) message(e Event) ([]byte, error) {
+	subject, err := render(m.subject, e)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", strings.ReplaceAll(subject, "\n", " "))
+	fmt.Fprintf(&buf, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&buf, "%s\r\n\r\nDevice: %s (%s)\r\nEvent: %s\r\nTime: %s\r\n",
+		e.Message, e.DeviceName, e.DeviceId, e.Type, e.Time.Format(time.RFC3339))
+	if e.Error != "" {
+		fmt.Fprintf(&buf, "Error: %s\r\n", e.Error)
+	}
+	return buf.Bytes(), nil
+}
+
+// Send delivers the message like smtp.SendMail, but gives up when the
+// timeout passes or ctx is done, also when the server stops responding
+func (m *EmailChannel) Send(ctx context.Context, e Event) error {
+	msg, err := m.message(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Unblock reads and writes when ctx is cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	err = m.deliver(conn, msg)
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %w", ctx.Err(), err)
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		// The connection deadline passed just before ctx noticed
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	return err
+}
+
+// deliver runs the SMTP conversation on an open connection
+func (m *EmailChannel) deliver(conn net.Conn, msg []byte) error {
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	for _, addr := range m.to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// CommandChannel runs a local command. Arguments are templates, and the event
+// is also passed in BARN_* environment variables.
+type CommandChannel struct {
+	command string
+	args    []*template.Template
+	timeout time.Duration
+}
+
+// NewCommandChannel creates a command channel
+func NewCommandChannel(command string, args []string, timeout time.Duration) (*CommandChannel, error) {
+	if command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c := &CommandChannel{command: command, timeout: timeout}
+	for i, arg := range args {
+		t, err := parseTemplate(fmt.Sprintf("arg%d", i), arg)
+		if err != nil {
+			return nil, fmt.Errorf("command argument %d: %w", i, err)
+		}
+		c.args = append(c.args, t)
+	}
+	return c, nil
+}
+
+func (c *CommandChannel) Send(ctx context.Context, e Event) error {
+	args := make([]string, 0, len(c.args))
+	for _, t := range c.args {
+		arg, err := render(t, e)
+		if err != nil {
+			return err
+		}
+		args = append(args, arg)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.command, args...)
+	cmd.Env = append(os.Environ(),
+		"BARN_EVENT="+e.Type,
+		"BARN_DEVICE_TYPE="+e.DeviceType,
+		"BARN_DEVICE_ID="+e.DeviceId,
+		"BARN_DEVICE_NAME="+e.DeviceName,
+		"BARN_SAFE="+strconv.FormatBool(e.Safe),
+		"BARN_RAW_VALUE="+e.RawValue,
+		"BARN_ERROR="+e.Error,
+		"BARN_MESSAGE="+e.Message,
+		"BARN_TIME="+e.Time.Format(time.RFC3339),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
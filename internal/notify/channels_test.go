@@ -0,0 +1,165 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEvent = Event{
+	Type:       EventUnsafe,
+	DeviceType: "safetymonitor",
+	DeviceId:   "rain",
+	DeviceName: "Rain \"sensor\"",
+	RawValue:   "1",
+	Time:       time.Date(2025, 1, 1, 22, 0, 0, 0, time.UTC),
+	Message:    "Rain \"sensor\" is now UNSAFE",
+}
+
+func TestWebhookChannel_DefaultBody(t *testing.T) {
+	var body map[string]interface{}
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Priority")
+		data, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(data, &body), "default body should be valid json")
+	}))
+	defer server.Close()
+
+	channel, err := NewWebhookChannel(server.URL, "", map[string]string{"X-Priority": "high"}, "", 0)
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, channel.Send(context.Background(), testEvent), "should work")
+	assert.Equal(t, testEvent.Message, body["content"], "they should be equal")
+	assert.Equal(t, testEvent.Message, body["text"], "they should be equal")
+	assert.Equal(t, "rain", body["device"], "they should be equal")
+	assert.Equal(t, false, body["safe"], "they should be equal")
+	assert.Equal(t, "high", header, "they should be equal")
+}
+
+func TestWebhookChannel_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	channel, err := NewWebhookChannel(server.URL, "PUT", nil, "{{.Message}}", time.Second)
+	assert.NoError(t, err, "should work")
+	assert.Error(t, channel.Send(context.Background(), testEvent), "should be error")
+
+	_, err = NewWebhookChannel(server.URL, "", nil, "{{.Missing", 0)
+	assert.Error(t, err, "invalid templates should be rejected")
+}
+
+func TestEmailChannel_Message(t *testing.T) {
+	channel, err := NewEmailChannel("smtp.example.com", 0, "", "", "barn@example.com", []string{"ops@example.com"}, "", 0)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, 25, channel.port, "they should be equal")
+	assert.Equal(t, DefaultTimeout, channel.timeout, "they should be equal")
+	msg, err := channel.message(testEvent)
+	assert.NoError(t, err, "should work")
+	assert.Contains(t, string(msg), "Subject: [barn] Rain \"sensor\": unsafe\r\n")
+	assert.Contains(t, string(msg), "To: ops@example.com\r\n")
+	assert.Contains(t, string(msg), "\r\n\r\nRain \"sensor\" is now UNSAFE\r\n")
+
+	_, err = NewEmailChannel("smtp.example.com", 25, "", "", "", nil, "", 0)
+	assert.Error(t, err, "should be error")
+}
+
+func TestEmailChannel_Send(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "should work")
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var data strings.Builder
+		fmt.Fprint(conn, "220 localhost\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				fmt.Fprint(conn, "250 localhost\r\n")
+			case strings.HasPrefix(line, "DATA"):
+				fmt.Fprint(conn, "354 go ahead\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				fmt.Fprint(conn, "250 queued\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 ok\r\n")
+			}
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	channel, err := NewEmailChannel("127.0.0.1", addr.Port, "", "", "barn@example.com", []string{"ops@example.com"}, "", time.Second)
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, channel.Send(context.Background(), testEvent), "should work")
+	assert.Contains(t, <-received, "Subject: [barn] Rain \"sensor\": unsafe\r\n")
+}
+
+func TestEmailChannel_Timeout(t *testing.T) {
+	// A server that accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "should work")
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	channel, err := NewEmailChannel("127.0.0.1", addr.Port, "", "", "barn@example.com", []string{"ops@example.com"}, "", 50*time.Millisecond)
+	assert.NoError(t, err, "should work")
+
+	start := time.Now()
+	err = channel.Send(context.Background(), testEvent)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "hung servers should time out")
+	assert.Less(t, time.Since(start), time.Second, "should give up after the timeout")
+}
+
+func TestCommandChannel_Send(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	channel, err := NewCommandChannel("/bin/sh", []string{"-c", `echo "$1 $BARN_EVENT $BARN_RAW_VALUE" > ` + out, "sh", "{{.DeviceId}}"}, time.Second)
+	assert.NoError(t, err, "should work")
+	assert.NoError(t, channel.Send(context.Background(), testEvent), "should work")
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "rain unsafe 1", strings.TrimSpace(string(data)), "they should be equal")
+
+	failing, err := NewCommandChannel("/bin/sh", []string{"-c", "echo broken >&2; exit 3"}, time.Second)
+	assert.NoError(t, err, "should work")
+	err = failing.Send(context.Background(), testEvent)
+	assert.ErrorContains(t, err, "broken")
+}
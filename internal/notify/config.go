@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// Channel types
+const (
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+	ChannelCommand = "command"
+)
+
+// LoadFromConfig builds a notifier from the notifications section. It returns
+// nil when neither rules nor channels are configured.
+func LoadFromConfig(v *viper.Viper) (*Notifier, error) {
+	rulesConfig := v.GetStringMap("notifications.rules")
+	if len(rulesConfig) == 0 && len(v.GetStringMap("notifications.channels")) == 0 {
+		return nil, nil
+	}
+
+	channels := make(map[string]Channel)
+	for id := range v.GetStringMap("notifications.channels") {
+		vt := v.Sub(fmt.Sprintf("notifications.channels.%s", id))
+		channel, err := channelFromConfig(vt)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", id, err)
+		}
+		channels[id] = channel
+	}
+
+	names := make([]string, 0, len(rulesConfig))
+	for name := range rulesConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var rules []*Rule
+	for _, name := range names {
+		vt := v.Sub(fmt.Sprintf("notifications.rules.%s", name))
+		rule := &Rule{
+			Name:     name,
+			Devices:  vt.GetStringSlice("devices"),
+			Events:   vt.GetStringSlice("events"),
+			Cooldown: vt.GetDuration("cooldown"),
+			Reminder: vt.GetDuration("reminder"),
+			Channels: vt.GetStringSlice("channels"),
+		}
+		for _, event := range rule.Events {
+			if !slices.Contains(AllEvents, event) {
+				return nil, fmt.Errorf("rule %s: unknown event %s", name, event)
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	retry := DefaultRetryPolicy
+	if v.IsSet("notifications.retry.attempts") {
+		retry.Attempts = v.GetInt("notifications.retry.attempts")
+	}
+	if v.IsSet("notifications.retry.delay") {
+		retry.Delay = v.GetDuration("notifications.retry.delay")
+	}
+	return New(rules, channels, retry)
+}
+
+func channelFromConfig(vt *viper.Viper) (Channel, error) {
+	switch vt.GetString("type") {
+	case ChannelWebhook:
+		return NewWebhookChannel(vt.GetString("url"), vt.GetString("method"), vt.GetStringMapString("headers"),
+			vt.GetString("body"), vt.GetDuration("timeout"))
+	case ChannelEmail:
+		return NewEmailChannel(vt.GetString("host"), vt.GetInt("port"), vt.GetString("username"), vt.GetString("password"),
+			vt.GetString("from"), vt.GetStringSlice("to"), vt.GetString("subject"), vt.GetDuration("timeout"))
+	case ChannelCommand:
+		return NewCommandChannel(vt.GetString("command"), vt.GetStringSlice("args"), vt.GetDuration("timeout"))
+	}
+	return nil, fmt.Errorf("unknown channel type %q", vt.GetString("type"))
+}
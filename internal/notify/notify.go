@@ -0,0 +1,399 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/app"
+)
+
+// Event types
+const (
+	EventUnsafe    = "unsafe"
+	EventSafe      = "safe"
+	EventReminder  = "reminder"
+	EventFailing   = "failing"
+	EventRecovered = "recovered"
+	// EventActionFailed is sent by safety actions to their escalation
+	// channels and is not routed by rules
+	EventActionFailed = "action_failed"
+)
+
+// AllEvents lists the event types a rule matches by default
+var AllEvents = []string{EventUnsafe, EventSafe, EventReminder, EventFailing, EventRecovered}
+
+// Event is a notification about a device. It is passed to channel templates.
+type Event struct {
+	Type       string
+	DeviceType string
+	DeviceId   string
+	DeviceName string
+	Safe       bool
+	RawValue   string
+	Error      string
+	Time       time.Time
+	Message    string
+}
+
+// Rule selects which events are delivered to which channels
+type Rule struct {
+	Name string
+	// Devices limits the rule to the given device ids. Empty matches all devices.
+	Devices []string
+	// Events limits the rule to the given event types. Empty matches all events.
+	Events []string
+	// Cooldown suppresses repeats of the same event for a device
+	Cooldown time.Duration
+	// Reminder repeats the unsafe notification while a monitor stays unsafe
+	Reminder time.Duration
+	Channels []string
+}
+
+func (r *Rule) matchesDevice(e Event) bool {
+	return len(r.Devices) == 0 || slices.Contains(r.Devices, e.DeviceId)
+}
+
+func (r *Rule) matches(e Event) bool {
+	if !r.matchesDevice(e) {
+		return false
+	}
+	events := r.Events
+	if len(events) == 0 {
+		events = AllEvents
+	}
+	return slices.Contains(events, e.Type)
+}
+
+// deviceState tracks what was last seen and sent for one device
+type deviceState struct {
+	seen       bool
+	safe       bool
+	failing    bool
+	lastSent   map[string]time.Time // keyed by rule name and event type
+	lastUnsafe map[string]time.Time // keyed by rule name
+}
+
+// Notifier turns refresh outcomes into events and delivers them according to
+// its rules. Delivery happens on per-channel queues so refreshes never wait
+// on a slow endpoint.
+type Notifier struct {
+	rules    []*Rule
+	channels map[string]*queue
+
+	mu      sync.Mutex
+	devices map[string]*deviceState
+	now     func() time.Time
+}
+
+// New creates a notifier delivering to the given channels
+func New(rules []*Rule, channels map[string]Channel, retry RetryPolicy) (*Notifier, error) {
+	n := &Notifier{
+		rules:    rules,
+		channels: make(map[string]*queue),
+		devices:  make(map[string]*deviceState),
+		now:      time.Now,
+	}
+	for _, rule := range rules {
+		for _, name := range rule.Channels {
+			if _, exists := channels[name]; !exists {
+				return nil, fmt.Errorf("rule %s: unknown channel %s", rule.Name, name)
+			}
+		}
+	}
+	for name, channel := range channels {
+		n.channels[name] = newQueue(name, channel, retry)
+	}
+	return n, nil
+}
+
+// Close stops the delivery queues, dropping undelivered events
+func (n *Notifier) Close() {
+	for _, q := range n.channels {
+		q.close()
+	}
+}
+
+// HasChannel reports whether a channel is configured
+func (n *Notifier) HasChannel(name string) bool {
+	_, exists := n.channels[name]
+	return exists
+}
+
+// Send delivers an event to the named channels regardless of rules
+func (n *Notifier) Send(channels []string, e Event) {
+	if e.Time.IsZero() {
+		e.Time = n.now()
+	}
+	if e.Message == "" {
+		e.Message = defaultMessage(e)
+	}
+	for _, name := range channels {
+		q, exists := n.channels[name]
+		if !exists {
+			log.Error(fmt.Sprintf("[BARN] Notify. Unknown channel [%s]", name))
+			continue
+		}
+		q.push(e)
+	}
+}
+
+// MonitorRefreshed implements app.Listener
+func (n *Notifier) MonitorRefreshed(refresh app.MonitorRefresh) {
+	m := refresh.Monitor
+	base := Event{
+		DeviceType: "safetymonitor",
+		DeviceId:   m.GetId(),
+		DeviceName: m.GetName(),
+		Safe:       refresh.Safe,
+		RawValue:   m.GetRawValue(),
+		Time:       n.now(),
+	}
+	if refresh.Err != nil {
+		base.Error = refresh.Err.Error()
+	}
+
+	n.mu.Lock()
+	state := n.state("safetymonitor/" + base.DeviceId)
+	var events []Event
+	if state.seen && state.safe != refresh.Safe {
+		events = append(events, withType(base, stateEvent(refresh.Safe)))
+	}
+	events = append(events, n.failureEvents(state, base, refresh.Err)...)
+	state.seen = true
+	state.safe = refresh.Safe
+	deliveries := n.route(state, events)
+	if refresh.Safe {
+		clear(state.lastUnsafe)
+	} else {
+		deliveries = append(deliveries, n.reminders(state, base)...)
+	}
+	n.mu.Unlock()
+
+	n.deliver(deliveries)
+}
+
+// WeatherRefreshed implements app.Listener. Only source failures and
+// recoveries are reported for weather stations.
+func (n *Notifier) WeatherRefreshed(refresh app.WeatherRefresh) {
+	w := refresh.Weather
+	base := Event{
+		DeviceType: "observingconditions",
+		DeviceId:   w.GetId(),
+		DeviceName: w.GetName(),
+		Safe:       refresh.Err == nil,
+		Time:       n.now(),
+	}
+	if refresh.Err != nil {
+		base.Error = refresh.Err.Error()
+	}
+
+	n.mu.Lock()
+	state := n.state("observingconditions/" + base.DeviceId)
+	deliveries := n.route(state, n.failureEvents(state, base, refresh.Err))
+	n.mu.Unlock()
+
+	n.deliver(deliveries)
+}
+
+func (n *Notifier) state(key string) *deviceState {
+	state, exists := n.devices[key]
+	if !exists {
+		state = &deviceState{
+			lastSent:   make(map[string]time.Time),
+			lastUnsafe: make(map[string]time.Time),
+		}
+		n.devices[key] = state
+	}
+	return state
+}
+
+// failureEvents reports a source that started failing or recovered
+func (n *Notifier) failureEvents(state *deviceState, base Event, err error) []Event {
+	var events []Event
+	if err != nil && !state.failing {
+		events = append(events, withType(base, EventFailing))
+	}
+	if err == nil && state.failing {
+		events = append(events, withType(base, EventRecovered))
+	}
+	state.failing = err != nil
+	return events
+}
+
+type delivery struct {
+	channels []string
+	event    Event
+}
+
+// route applies rule filters and cooldowns. Callers must hold mu.
+func (n *Notifier) route(state *deviceState, events []Event) []delivery {
+	var deliveries []delivery
+	for _, e := range events {
+		for _, rule := range n.rules {
+			if !rule.matches(e) {
+				continue
+			}
+			key := rule.Name + "/" + e.Type
+			if last, sent := state.lastSent[key]; sent && e.Time.Sub(last) < rule.Cooldown {
+				continue
+			}
+			state.lastSent[key] = e.Time
+			if e.Type == EventUnsafe {
+				state.lastUnsafe[rule.Name] = e.Time
+			}
+			deliveries = append(deliveries, delivery{channels: rule.Channels, event: e})
+		}
+	}
+	return deliveries
+}
+
+// reminders repeats the unsafe notification for rules with a reminder
+// interval, whether or not they list the reminder event. Callers must hold mu.
+func (n *Notifier) reminders(state *deviceState, base Event) []delivery {
+	var deliveries []delivery
+	e := withType(base, EventReminder)
+	for _, rule := range n.rules {
+		if rule.Reminder <= 0 || !rule.matchesDevice(e) {
+			continue
+		}
+		last, notified := state.lastUnsafe[rule.Name]
+		if !notified {
+			// Start the reminder clock on the first unsafe observation
+			state.lastUnsafe[rule.Name] = e.Time
+			continue
+		}
+		if e.Time.Sub(last) < rule.Reminder {
+			continue
+		}
+		state.lastUnsafe[rule.Name] = e.Time
+		deliveries = append(deliveries, delivery{channels: rule.Channels, event: e})
+	}
+	return deliveries
+}
+
+func (n *Notifier) deliver(deliveries []delivery) {
+	for _, d := range deliveries {
+		log.WithFields(log.Fields{
+			"device": d.event.DeviceId,
+			"event":  d.event.Type,
+		}).Info(fmt.Sprintf("[BARN] Notify. %s", d.event.Message))
+		n.Send(d.channels, d.event)
+	}
+}
+
+func stateEvent(safe bool) string {
+	if safe {
+		return EventSafe
+	}
+	return EventUnsafe
+}
+
+func withType(e Event, eventType string) Event {
+	e.Type = eventType
+	e.Message = defaultMessage(e)
+	return e
+}
+
+func defaultMessage(e Event) string {
+	switch e.Type {
+	case EventUnsafe:
+		return fmt.Sprintf("%s is now UNSAFE (raw value: %q)", e.DeviceName, e.RawValue)
+	case EventSafe:
+		return fmt.Sprintf("%s is now SAFE (raw value: %q)", e.DeviceName, e.RawValue)
+	case EventReminder:
+		return fmt.Sprintf("%s is still UNSAFE (raw value: %q)", e.DeviceName, e.RawValue)
+	case EventFailing:
+		return fmt.Sprintf("%s source is failing: %s", e.DeviceName, e.Error)
+	case EventRecovered:
+		return fmt.Sprintf("%s source recovered", e.DeviceName)
+	case EventActionFailed:
+		return fmt.Sprintf("Safety action for %s failed: %s", e.DeviceName, e.Error)
+	}
+	return fmt.Sprintf("%s: %s", e.DeviceName, e.Type)
+}
+
+// RetryPolicy controls redelivery of failed notifications
+type RetryPolicy struct {
+	Attempts int
+	Delay    time.Duration
+}
+
+// DefaultRetryPolicy is used when none is configured
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Delay: 10 * time.Second}
+
+// queueSize bounds the events waiting for a channel
+const queueSize = 100
+
+// queue delivers events to one channel in order, retrying failures with
+// exponential backoff
+type queue struct {
+	name    string
+	channel Channel
+	retry   RetryPolicy
+	events  chan Event
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newQueue(name string, channel Channel, retry RetryPolicy) *queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &queue{
+		name:    name,
+		channel: channel,
+		retry:   retry,
+		events:  make(chan Event, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go q.run()
+	return q
+}
+
+func (q *queue) push(e Event) {
+	select {
+	case q.events <- e:
+	default:
+		log.Error(fmt.Sprintf("[BARN] Notify. Channel [%s] queue is full, dropping %s event for [%s]", q.name, e.Type, e.DeviceId))
+	}
+}
+
+func (q *queue) close() {
+	q.cancel()
+}
+
+func (q *queue) run() {
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case e := <-q.events:
+			q.send(e)
+		}
+	}
+}
+
+func (q *queue) send(e Event) {
+	attempts := max(q.retry.Attempts, 1)
+	delay := q.retry.Delay
+	for attempt := 1; ; attempt++ {
+		err := q.channel.Send(q.ctx, e)
+		if err == nil {
+			return
+		}
+		log.WithError(err).Warn(fmt.Sprintf("[BARN] Notify. Channel [%s] attempt %d/%d failed", q.name, attempt, attempts))
+		if attempt >= attempts {
+			log.Error(fmt.Sprintf("[BARN] Notify. Giving up delivering %s event for [%s] to channel [%s]", e.Type, e.DeviceId, q.name))
+			return
+		}
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/app"
+	"github.com/thebuh/barn/internal/monitor"
+	"github.com/thebuh/barn/internal/weather"
+)
+
+type recordingChannel struct {
+	mu     sync.Mutex
+	events []Event
+	fail   int
+}
+
+func (r *recordingChannel) Send(ctx context.Context, e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail > 0 {
+		r.fail--
+		return errors.New("unavailable")
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recordingChannel) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, e := range r.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestNotifier(t *testing.T, rules ...*Rule) (*Notifier, *recordingChannel, *testClock) {
+	channel := &recordingChannel{}
+	n, err := New(rules, map[string]Channel{"test": channel}, RetryPolicy{Attempts: 3, Delay: time.Millisecond})
+	assert.NoError(t, err, "should work")
+	t.Cleanup(n.Close)
+	clock := &testClock{now: time.Date(2025, 1, 1, 22, 0, 0, 0, time.UTC)}
+	n.now = func() time.Time { return clock.now }
+	return n, channel, clock
+}
+
+func refresh(n *Notifier, m monitor.SafetyMonitor, safe bool, err error) {
+	n.MonitorRefreshed(app.MonitorRefresh{Monitor: m, Safe: safe, Err: err})
+}
+
+func waitForEvents(t *testing.T, channel *recordingChannel, expected ...string) {
+	assert.Eventually(t, func() bool {
+		return len(channel.types()) >= len(expected)
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, expected, channel.types(), "they should be equal")
+}
+
+func TestNotifier_StateTransitions(t *testing.T) {
+	n, channel, clock := newTestNotifier(t, &Rule{Name: "all", Channels: []string{"test"}})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+
+	refresh(n, rain, true, nil) // first observation is not a transition
+	clock.advance(time.Minute)
+	refresh(n, rain, false, nil)
+	clock.advance(time.Minute)
+	refresh(n, rain, false, nil)
+	clock.advance(time.Minute)
+	refresh(n, rain, true, nil)
+
+	waitForEvents(t, channel, EventUnsafe, EventSafe)
+	assert.Equal(t, "rain", channel.events[0].DeviceId, "they should be equal")
+	assert.Equal(t, "Rain is now UNSAFE (raw value: \"\")", channel.events[0].Message, "they should be equal")
+}
+
+func TestNotifier_DeviceAndEventFilters(t *testing.T) {
+	n, channel, clock := newTestNotifier(t, &Rule{
+		Name:     "rain",
+		Devices:  []string{"rain"},
+		Events:   []string{EventUnsafe},
+		Channels: []string{"test"},
+	})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+	roof := monitor.NewSafetyMonitorDummy("roof", "Roof", "Roof state", true)
+
+	for _, safe := range []bool{true, false, true} {
+		refresh(n, roof, safe, nil)
+		refresh(n, rain, safe, nil)
+		clock.advance(time.Minute)
+	}
+
+	waitForEvents(t, channel, EventUnsafe)
+	assert.Equal(t, "rain", channel.events[0].DeviceId, "they should be equal")
+}
+
+func TestNotifier_Cooldown(t *testing.T) {
+	n, channel, clock := newTestNotifier(t, &Rule{Name: "all", Cooldown: 10 * time.Minute, Channels: []string{"test"}})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+
+	refresh(n, rain, true, nil)
+	for i := 0; i < 3; i++ {
+		clock.advance(time.Minute)
+		refresh(n, rain, false, nil)
+		clock.advance(time.Minute)
+		refresh(n, rain, true, nil)
+	}
+	clock.advance(10 * time.Minute)
+	refresh(n, rain, false, nil)
+
+	waitForEvents(t, channel, EventUnsafe, EventSafe, EventUnsafe)
+}
+
+func TestNotifier_Reminder(t *testing.T) {
+	n, channel, clock := newTestNotifier(t, &Rule{Name: "all", Events: []string{EventUnsafe}, Reminder: 30 * time.Minute, Channels: []string{"test"}})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+
+	refresh(n, rain, true, nil)
+	refresh(n, rain, false, nil)
+	for i := 0; i < 7; i++ {
+		clock.advance(10 * time.Minute)
+		refresh(n, rain, false, nil)
+	}
+	refresh(n, rain, true, nil)
+	clock.advance(time.Hour)
+	refresh(n, rain, true, nil)
+
+	waitForEvents(t, channel, EventUnsafe, EventReminder, EventReminder)
+}
+
+func TestNotifier_SourceFailures(t *testing.T) {
+	n, channel, _ := newTestNotifier(t, &Rule{Name: "failures", Events: []string{EventFailing, EventRecovered}, Channels: []string{"test"}})
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+	station := weather.NewObservingConditionsDummy("station", "Station", "Dummy station")
+
+	refresh(n, rain, false, errors.New("connection refused"))
+	refresh(n, rain, false, errors.New("connection refused"))
+	refresh(n, rain, true, nil)
+	n.WeatherRefreshed(app.WeatherRefresh{Weather: station, Err: errors.New("bad json")})
+
+	waitForEvents(t, channel, EventFailing, EventRecovered, EventFailing)
+	assert.Equal(t, "connection refused", channel.events[0].Error, "they should be equal")
+	assert.Equal(t, "observingconditions", channel.events[2].DeviceType, "they should be equal")
+}
+
+func TestNotifier_RetriesWithoutBlocking(t *testing.T) {
+	n, channel, _ := newTestNotifier(t, &Rule{Name: "all", Channels: []string{"test"}})
+	channel.fail = 2
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+
+	refresh(n, rain, true, nil)
+	refresh(n, rain, false, nil)
+
+	waitForEvents(t, channel, EventUnsafe)
+}
+
+func TestNotifier_UnknownChannel(t *testing.T) {
+	_, err := New([]*Rule{{Name: "rule", Channels: []string{"missing"}}}, map[string]Channel{}, DefaultRetryPolicy)
+	assert.Error(t, err, "should be error")
+}
+
+func TestLoadFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+notifications:
+  retry:
+    attempts: 5
+  channels:
+    ntfy:
+      type: webhook
+      url: https://ntfy.example.com/barn
+      body: "{{.Message}}"
+    script:
+      type: command
+      command: /usr/local/bin/alert
+      args: ["{{.DeviceId}}"]
+  rules:
+    roof:
+      devices: [rain]
+      events: [unsafe, safe]
+      cooldown: 5m
+      reminder: 30m
+      channels: [ntfy, script]
+`))
+	assert.NoError(t, err, "should work")
+	n, err := LoadFromConfig(v)
+	assert.NoError(t, err, "should work")
+	defer n.Close()
+	assert.Len(t, n.rules, 1, "they should be equal")
+	assert.Equal(t, 5*time.Minute, n.rules[0].Cooldown, "they should be equal")
+	assert.Equal(t, 30*time.Minute, n.rules[0].Reminder, "they should be equal")
+	assert.Equal(t, 5, n.channels["ntfy"].retry.Attempts, "they should be equal")
+	assert.True(t, n.HasChannel("script"), "should have the channel")
+	assert.False(t, n.HasChannel("sms"), "should not have the channel")
+
+	v.Set("notifications.rules.roof.events", []string{"exploded"})
+	_, err = LoadFromConfig(v)
+	assert.Error(t, err, "unknown events should be rejected")
+
+	v.Set("notifications.rules", map[string]interface{}{})
+	n, err = LoadFromConfig(v)
+	assert.NoError(t, err, "should work")
+	defer n.Close()
+	assert.Empty(t, n.rules, "channels should be usable without rules")
+
+	n, err = LoadFromConfig(viper.New())
+	assert.NoError(t, err, "should work")
+	assert.Nil(t, n, "notifier should be disabled without rules or channels")
+}
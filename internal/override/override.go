@@ -0,0 +1,239 @@
+package override
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/thebuh/barn/internal/monitor"
+)
+
+var (
+	ErrMissingExpiry = errors.New("override must expire in the future")
+	ErrNotFound      = errors.New("no override set for monitor")
+)
+
+// Override forces a safety monitor safe or unsafe until it expires
+type Override struct {
+	Monitor string    `json:"monitor"`
+	Safe    bool      `json:"safe"`
+	Reason  string    `json:"reason"`
+	SetBy   string    `json:"set_by"`
+	SetAt   time.Time `json:"set_at"`
+	Expires time.Time `json:"expires"`
+}
+
+// State returns "safe" or "unsafe"
+func (o Override) State() string {
+	if o.Safe {
+		return "safe"
+	}
+	return "unsafe"
+}
+
+// String describes the override for logs and raw values
+func (o Override) String() string {
+	s := fmt.Sprintf("forced %s by %s until %s", o.State(), o.SetBy, o.Expires.Format(time.RFC3339))
+	if o.Reason != "" {
+		s += fmt.Sprintf(" (%s)", o.Reason)
+	}
+	return s
+}
+
+// Manager keeps the active overrides. They are saved to a JSON file on every
+// change so they survive restarts; an empty path keeps them in memory only.
+type Manager struct {
+	path string
+
+	mu        sync.Mutex
+	overrides map[string]Override
+	now       func() time.Time
+}
+
+// Open loads the overrides saved at path
+func Open(path string) (*Manager, error) {
+	m := &Manager{
+		path:      path,
+		overrides: make(map[string]Override),
+		now:       time.Now,
+	}
+	if path == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []Override
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, o := range saved {
+		m.overrides[o.Monitor] = o
+		log.WithFields(log.Fields{
+			"monitor": o.Monitor,
+		}).Info(fmt.Sprintf("[BARN] Override [%s]. Restored: %s", o.Monitor, o))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	return m, nil
+}
+
+// Set activates an override, replacing any existing one for the monitor
+func (m *Manager) Set(o Override) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if !o.Expires.After(now) {
+		return ErrMissingExpiry
+	}
+	if o.SetAt.IsZero() {
+		o.SetAt = now
+	}
+	m.overrides[o.Monitor] = o
+	log.WithFields(log.Fields{
+		"monitor": o.Monitor,
+		"safe":    o.Safe,
+		"by":      o.SetBy,
+	}).Warn(fmt.Sprintf("[BARN] Override [%s]. Set: %s", o.Monitor, o))
+	return m.save()
+}
+
+// Clear removes the override of a monitor
+func (m *Manager) Clear(id string, by string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.overrides[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.overrides, id)
+	log.WithFields(log.Fields{
+		"monitor": id,
+		"by":      by,
+	}).Warn(fmt.Sprintf("[BARN] Override [%s]. Cleared by %s", id, by))
+	return m.save()
+}
+
+// Get returns the active override of a monitor
+func (m *Manager) Get(id string) (Override, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	o, exists := m.overrides[id]
+	return o, exists
+}
+
+// List returns the active overrides ordered by monitor
+func (m *Manager) List() []Override {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+	list := make([]Override, 0, len(m.overrides))
+	for _, o := range m.overrides {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Monitor < list[j].Monitor
+	})
+	return list
+}
+
+// Expire drops overrides past their expiry. Overrides also expire whenever
+// they are read; calling this periodically makes the expiry show in the logs
+// on time.
+func (m *Manager) Expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire()
+}
+
+// expire drops expired overrides. Callers must hold mu.
+func (m *Manager) expire() {
+	now := m.now()
+	expired := false
+	for id, o := range m.overrides {
+		if now.Before(o.Expires) {
+			continue
+		}
+		delete(m.overrides, id)
+		expired = true
+		log.WithFields(log.Fields{
+			"monitor": id,
+		}).Warn(fmt.Sprintf("[BARN] Override [%s]. Expired: %s", id, o))
+	}
+	if !expired {
+		return
+	}
+	if err := m.save(); err != nil {
+		log.WithError(err).Error(fmt.Sprintf("[BARN] Override. Failed to save [%s]", m.path))
+	}
+}
+
+// save writes the overrides to the file. Callers must hold mu.
+func (m *Manager) save() error {
+	if m.path == "" {
+		return nil
+	}
+	list := make([]Override, 0, len(m.overrides))
+	for _, o := range m.overrides {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Monitor < list[j].Monitor
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Monitor reports the overridden state of a safety monitor. Everything else
+// is read from the wrapped monitor.
+type Monitor struct {
+	monitor.SafetyMonitor
+	Override Override
+}
+
+// Wrap returns the monitor as seen through its active override, if any
+func (m *Manager) Wrap(sm monitor.SafetyMonitor) monitor.SafetyMonitor {
+	if sm == nil {
+		return nil
+	}
+	o, active := m.Get(sm.GetId())
+	if !active {
+		return sm
+	}
+	return &Monitor{SafetyMonitor: sm, Override: o}
+}
+
+func (om *Monitor) IsSafe() bool {
+	return om.Override.Safe
+}
+
+// GetRawValue prefixes the measured value with the override
+func (om *Monitor) GetRawValue() string {
+	return fmt.Sprintf("OVERRIDE %s; raw: %s", om.Override, om.SafetyMonitor.GetRawValue())
+}
+
+// Unwrap returns the overridden monitor
+func (om *Monitor) Unwrap() monitor.SafetyMonitor {
+	return om.SafetyMonitor
+}
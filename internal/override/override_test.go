@@ -0,0 +1,69 @@
+package override
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thebuh/barn/internal/monitor"
+)
+
+func TestManager_SetRequiresExpiry(t *testing.T) {
+	m, _ := Open("")
+	err := m.Set(Override{Monitor: "rain", Safe: true, SetBy: "test"})
+	assert.ErrorIs(t, err, ErrMissingExpiry)
+	err = m.Set(Override{Monitor: "rain", Safe: true, SetBy: "test", Expires: time.Now().Add(-time.Minute)})
+	assert.ErrorIs(t, err, ErrMissingExpiry)
+	assert.Empty(t, m.List(), "should be empty")
+}
+
+func TestManager_Wrap(t *testing.T) {
+	m, _ := Open("")
+	rain := monitor.NewSafetyMonitorDummy("rain", "Rain", "Rain sensor", true)
+	assert.Same(t, rain, m.Wrap(rain), "monitors without override should not be wrapped")
+
+	err := m.Set(Override{Monitor: "rain", Safe: false, Reason: "cleaning", SetBy: "alice", Expires: time.Now().Add(time.Hour)})
+	assert.NoError(t, err, "should work")
+	wrapped := m.Wrap(rain)
+	assert.Equal(t, false, wrapped.IsSafe(), "they should be equal")
+	assert.Equal(t, "Rain", wrapped.GetName(), "they should be equal")
+	assert.Contains(t, wrapped.GetRawValue(), "OVERRIDE forced unsafe by alice until ")
+	assert.Contains(t, wrapped.GetRawValue(), "(cleaning); raw: ")
+	assert.Same(t, rain, wrapped.(*Monitor).Unwrap(), "they should be the same")
+
+	assert.NoError(t, m.Clear("rain", "alice"), "should work")
+	assert.ErrorIs(t, m.Clear("rain", "alice"), ErrNotFound)
+	assert.Equal(t, true, m.Wrap(rain).IsSafe(), "they should be equal")
+}
+
+func TestManager_Expiry(t *testing.T) {
+	m, _ := Open("")
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	assert.NoError(t, m.Set(Override{Monitor: "rain", Safe: true, SetBy: "test", Expires: now.Add(time.Minute)}))
+	_, active := m.Get("rain")
+	assert.True(t, active, "override should be active")
+
+	now = now.Add(time.Minute)
+	_, active = m.Get("rain")
+	assert.False(t, active, "override should expire")
+}
+
+func TestManager_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "overrides.json")
+	m, err := Open(path)
+	assert.NoError(t, err, "should work")
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	assert.NoError(t, m.Set(Override{Monitor: "rain", Safe: true, Reason: "sensor cleaning", SetBy: "bob", Expires: expires}))
+	assert.NoError(t, m.Set(Override{Monitor: "clouds", Safe: false, SetBy: "bob", Expires: expires}))
+	assert.NoError(t, m.Clear("clouds", "bob"))
+
+	restored, err := Open(path)
+	assert.NoError(t, err, "should work")
+	list := restored.List()
+	assert.Len(t, list, 1, "they should be equal")
+	assert.Equal(t, "rain", list[0].Monitor, "they should be equal")
+	assert.Equal(t, "sensor cleaning", list[0].Reason, "they should be equal")
+	assert.True(t, expires.Equal(list[0].Expires), "expiry should survive restarts")
+}
@@ -0,0 +1,68 @@
+package push
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoPush  = errors.New("nothing has been pushed yet")
+	ErrExpired = errors.New("push expired")
+)
+
+// Source holds the last payload pushed to a device. It answers queries like
+// the sources of line devices, so pushed payloads go through the same fields,
+// parsers and rules.
+type Source struct {
+	expiry time.Duration
+	now    func() time.Time
+
+	mu       sync.RWMutex
+	payload  string
+	received time.Time
+}
+
+// NewSource creates a source whose payloads expire after expiry. Zero keeps
+// the last payload forever.
+func NewSource(expiry time.Duration) (*Source, error) {
+	if expiry < 0 {
+		return nil, errors.New("expiry must not be negative")
+	}
+	return &Source{expiry: expiry, now: time.Now}, nil
+}
+
+// Push replaces the payload
+func (s *Source) Push(payload string) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payload = payload
+	s.received = now
+}
+
+// Query returns the last payload, failing when there is none or it expired
+func (s *Source) Query() (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.received.IsZero() {
+		return "", ErrNoPush
+	}
+	if age := s.now().Sub(s.received); s.expiry > 0 && age > s.expiry {
+		return "", fmt.Errorf("%w: last push %s ago", ErrExpired, age.Round(time.Second))
+	}
+	return s.payload, nil
+}
+
+// Received returns when the last payload was pushed
+func (s *Source) Received() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.received
+}
+
+// Close implements the line source interfaces
+func (s *Source) Close() error {
+	return nil
+}
@@ -0,0 +1,49 @@
+package push
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource(t *testing.T) {
+	source, err := NewSource(time.Minute)
+	assert.NoError(t, err, "should work")
+	now := time.Now()
+	source.now = func() time.Time { return now }
+
+	_, err = source.Query()
+	assert.True(t, errors.Is(err, ErrNoPush), "sources should fail before the first push")
+
+	source.Push("dry")
+	payload, err := source.Query()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "dry", payload, "they should be equal")
+	assert.Equal(t, now, source.Received(), "they should be equal")
+
+	now = now.Add(2 * time.Minute)
+	_, err = source.Query()
+	assert.True(t, errors.Is(err, ErrExpired), "old pushes should expire")
+	assert.ErrorContains(t, err, "last push 2m0s ago")
+
+	source.Push("wet")
+	payload, err = source.Query()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "wet", payload, "new pushes should renew the source")
+}
+
+func TestSource_NoExpiry(t *testing.T) {
+	source, err := NewSource(0)
+	assert.NoError(t, err, "should work")
+	now := time.Now()
+	source.now = func() time.Time { return now }
+	source.Push("dry")
+	now = now.Add(24 * time.Hour)
+	_, err = source.Query()
+	assert.NoError(t, err, "payloads without expiry should be kept")
+
+	_, err = NewSource(-time.Second)
+	assert.Error(t, err, "negative expiries should fail")
+}
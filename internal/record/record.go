@@ -0,0 +1,204 @@
+package record
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Record kinds
+const (
+	KindMonitor = "monitor"
+	KindWeather = "weather"
+)
+
+// Record is one payload a device fetched from its source
+type Record struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Device  string    `json:"device"`
+	Payload string    `json:"payload"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Hook receives every payload a device fetched, with the error of the fetch
+type Hook func(payload string, err error)
+
+// Recordable is implemented by devices that fetch payloads from a source
+type Recordable interface {
+	UseRecorder(hook Hook)
+}
+
+// Tap holds the hook of a device. Devices embed it to become Recordable;
+// the zero value records nothing.
+type Tap struct {
+	mu   sync.RWMutex
+	hook Hook
+}
+
+// UseRecorder implements Recordable
+func (t *Tap) UseRecorder(hook Hook) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hook = hook
+}
+
+// Record passes a fetched payload to the hook, if any
+func (t *Tap) Record(payload string, err error) {
+	t.mu.RLock()
+	hook := t.hook
+	t.mu.RUnlock()
+	if hook != nil {
+		hook(payload, err)
+	}
+}
+
+// Recorder appends records to a file, one JSON object per line
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	now  func() time.Time
+}
+
+// Open opens the recording at path for appending, creating it if needed
+func Open(path string) (*Recorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, now: time.Now}, nil
+}
+
+// Hook returns a hook recording the payloads of a device
+func (r *Recorder) Hook(kind string, device string) Hook {
+	return func(payload string, err error) {
+		rec := Record{Time: r.now(), Kind: kind, Device: device, Payload: payload}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		if err := r.write(rec); err != nil {
+			log.WithError(err).Error(fmt.Sprintf("[BARN] Record. Failed to record %s [%s]", kind, device))
+		}
+	}
+}
+
+func (r *Recorder) write(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Load reads the records of one device from a recording, ordered by time
+func Load(path string, kind string, device string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.Kind == kind && rec.Device == device {
+			records = append(records, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+// Replay answers queries with recorded payloads, as they were at the same
+// time into the recording. Time starts with the first query and passes speed
+// times faster than the clock. After the last record the replay stays there,
+// or starts over with loop.
+type Replay struct {
+	records []Record
+	speed   float64
+	loop    bool
+	now     func() time.Time
+
+	mu      sync.Mutex
+	started time.Time
+}
+
+// NewReplay creates a replay of records ordered by time. A zero speed replays
+// in real time.
+func NewReplay(records []Record, speed float64, loop bool) (*Replay, error) {
+	if len(records) == 0 {
+		return nil, errors.New("no records to replay")
+	}
+	if speed < 0 {
+		return nil, errors.New("speed must not be negative")
+	}
+	if speed == 0 {
+		speed = 1
+	}
+	return &Replay{records: records, speed: speed, loop: loop, now: time.Now}, nil
+}
+
+// Query returns the current payload, failing with the recorded error if
+// the fetch failed
+func (r *Replay) Query() (string, error) {
+	rec := r.current()
+	if rec.Error != "" {
+		return rec.Payload, errors.New(rec.Error)
+	}
+	return rec.Payload, nil
+}
+
+// Close implements the line source interfaces
+func (r *Replay) Close() error {
+	return nil
+}
+
+func (r *Replay) current() Record {
+	now := r.now()
+	r.mu.Lock()
+	if r.started.IsZero() {
+		r.started = now
+	}
+	offset := time.Duration(float64(now.Sub(r.started)) * r.speed)
+	r.mu.Unlock()
+
+	first := r.records[0].Time
+	span := r.records[len(r.records)-1].Time.Sub(first)
+	if r.loop && span > 0 {
+		offset %= span
+	}
+	target := first.Add(offset)
+	i := sort.Search(len(r.records), func(i int) bool {
+		return r.records[i].Time.After(target)
+	})
+	return r.records[i-1]
+}
@@ -0,0 +1,123 @@
+package record
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder_HookAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "recording.jsonl")
+	recorder, err := Open(path)
+	assert.NoError(t, err, "should work")
+	start := time.Date(2025, 3, 1, 22, 0, 0, 0, time.UTC)
+	now := start
+	recorder.now = func() time.Time { return now }
+
+	rain := recorder.Hook(KindMonitor, "rain")
+	station := recorder.Hook(KindWeather, "rain")
+	now = start.Add(2 * time.Second)
+	rain("wet", nil)
+	now = start.Add(time.Second)
+	rain("dry", nil)
+	station(`{"temp": 4}`, nil)
+	now = start.Add(3 * time.Second)
+	rain("", errors.New("timeout"))
+	assert.NoError(t, recorder.Close(), "should work")
+
+	records, err := Load(path, KindMonitor, "rain")
+	assert.NoError(t, err, "should work")
+	assert.Len(t, records, 3, "only the records of the device should be loaded")
+	assert.Equal(t, "dry", records[0].Payload, "records should be ordered by time")
+	assert.Equal(t, "wet", records[1].Payload, "records should be ordered by time")
+	assert.Equal(t, "timeout", records[2].Error, "they should be equal")
+
+	recorder, err = Open(path)
+	assert.NoError(t, err, "should work")
+	recorder.Hook(KindMonitor, "rain")("dry", nil)
+	recorder.Close()
+	records, err = Load(path, KindMonitor, "rain")
+	assert.NoError(t, err, "should work")
+	assert.Len(t, records, 4, "reopened recordings should be appended to")
+}
+
+func TestLoad_Errors(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "missing.jsonl"), KindMonitor, "rain")
+	assert.Error(t, err, "missing recordings should fail")
+
+	path := filepath.Join(t.TempDir(), "broken.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte("{\"kind\": \"monitor\"}\nnot json\n"), 0644), "should work")
+	_, err = Load(path, KindMonitor, "rain")
+	assert.ErrorContains(t, err, "line 2", "broken lines should fail")
+}
+
+func TestTap(t *testing.T) {
+	var tap Tap
+	tap.Record("ignored", nil)
+
+	var payloads []string
+	tap.UseRecorder(func(payload string, err error) {
+		payloads = append(payloads, payload)
+	})
+	tap.Record("dry", nil)
+	assert.Equal(t, []string{"dry"}, payloads, "they should be equal")
+}
+
+func testRecords(start time.Time) []Record {
+	return []Record{
+		{Time: start, Payload: "dry"},
+		{Time: start.Add(10 * time.Minute), Payload: "wet"},
+		{Time: start.Add(20 * time.Minute), Error: "timeout"},
+		{Time: start.Add(30 * time.Minute), Payload: "dry"},
+	}
+}
+
+func TestReplay_Speed(t *testing.T) {
+	start := time.Date(2025, 3, 1, 22, 0, 0, 0, time.UTC)
+	replay, err := NewReplay(testRecords(start), 60, false)
+	assert.NoError(t, err, "should work")
+	clock := time.Now()
+	replay.now = func() time.Time { return clock }
+
+	for _, step := range []struct {
+		after   time.Duration
+		payload string
+		err     bool
+	}{
+		{0, "dry", false},
+		{9 * time.Second, "dry", false},
+		{10 * time.Second, "wet", false},
+		{25 * time.Second, "", true},
+		{30 * time.Second, "dry", false},
+		{time.Hour, "dry", false},
+	} {
+		replay.now = func() time.Time { return clock.Add(step.after) }
+		payload, err := replay.Query()
+		assert.Equal(t, step.payload, payload, "they should be equal")
+		assert.Equal(t, step.err, err != nil, "recorded errors should be replayed")
+	}
+}
+
+func TestReplay_Loop(t *testing.T) {
+	start := time.Date(2025, 3, 1, 22, 0, 0, 0, time.UTC)
+	replay, err := NewReplay(testRecords(start), 0, true)
+	assert.NoError(t, err, "should work")
+	clock := time.Now()
+	replay.now = func() time.Time { return clock }
+	replay.Query()
+
+	replay.now = func() time.Time { return clock.Add(40 * time.Minute) }
+	payload, _ := replay.Query()
+	assert.Equal(t, "wet", payload, "real time replays should start over after the last record")
+}
+
+func TestNewReplay_Errors(t *testing.T) {
+	_, err := NewReplay(nil, 1, false)
+	assert.Error(t, err, "empty replays should fail")
+	_, err = NewReplay(testRecords(time.Now()), -1, false)
+	assert.Error(t, err, "negative speeds should fail")
+}
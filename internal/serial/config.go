@@ -0,0 +1,21 @@
+package serial
+
+import (
+	"github.com/spf13/viper"
+)
+
+// OptionsFromConfig reads port options from a device section: device, baud,
+// data_bits, parity, stop_bits, request, delimiter and timeout
+func OptionsFromConfig(vt *viper.Viper) (Options, error) {
+	options := Options{
+		Device:    vt.GetString("device"),
+		Baud:      vt.GetInt("baud"),
+		DataBits:  vt.GetInt("data_bits"),
+		Parity:    vt.GetString("parity"),
+		StopBits:  vt.GetInt("stop_bits"),
+		Request:   vt.GetString("request"),
+		Delimiter: vt.GetString("delimiter"),
+		Timeout:   vt.GetDuration("timeout"),
+	}
+	return options, options.Validate()
+}
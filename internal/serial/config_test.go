@@ -0,0 +1,43 @@
+package serial
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+device: /dev/ttyUSB0
+baud: 115200
+data_bits: 7
+parity: even
+stop_bits: 2
+request: "R\n"
+delimiter: "\r\n"
+timeout: 2s
+`)), "should work")
+	options, err := OptionsFromConfig(v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, Options{
+		Device:    "/dev/ttyUSB0",
+		Baud:      115200,
+		DataBits:  7,
+		Parity:    ParityEven,
+		StopBits:  2,
+		Request:   "R\n",
+		Delimiter: "\r\n",
+		Timeout:   2 * time.Second,
+	}, options, "they should be equal")
+
+	v = viper.New()
+	v.Set("device", "/dev/ttyUSB0")
+	v.Set("parity", "space")
+	_, err = OptionsFromConfig(v)
+	assert.Error(t, err, "should fail")
+}
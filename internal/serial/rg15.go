@@ -0,0 +1,66 @@
+package serial
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RG15Request polls a Hydreon RG-15 for a reading
+const RG15Request = "R\n"
+
+const mmPerInch = 25.4
+
+// RG15Reading is a Hydreon RG-15 reading, in millimetres whatever unit the
+// sensor is set to
+type RG15Reading struct {
+	Acc      float64
+	EventAcc float64
+	TotalAcc float64
+	// RainIntensity is in mm per hour
+	RainIntensity float64
+}
+
+var rg15Value = regexp.MustCompile(`^(\w+)\s+([-+]?\d*\.?\d+)\s*(\w*)$`)
+
+// ParseRG15 reads a reply such as
+// "Acc  0.01 mm, EventAcc  0.01 mm, TotalAcc  0.01 mm, RInt  0.10 mmph"
+// or its imperial form with in and iph
+func ParseRG15(reply string) (RG15Reading, error) {
+	var reading RG15Reading
+	found := false
+	for _, part := range strings.Split(reply, ",") {
+		match := rg15Value.FindStringSubmatch(strings.TrimSpace(part))
+		if match == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			return reading, err
+		}
+		if unit := strings.ToLower(match[3]); unit == "in" || unit == "iph" {
+			value *= mmPerInch
+		}
+		switch match[1] {
+		case "Acc":
+			reading.Acc = value
+		case "EventAcc":
+			reading.EventAcc = value
+		case "TotalAcc":
+			reading.TotalAcc = value
+		case "RInt":
+			reading.RainIntensity = value
+			found = true
+		}
+	}
+	if !found {
+		return reading, fmt.Errorf("no rain intensity in %q", reply)
+	}
+	return reading, nil
+}
+
+// Raining reports whether the sensor measures rain
+func (r RG15Reading) Raining() bool {
+	return r.RainIntensity > 0
+}
@@ -0,0 +1,26 @@
+package serial
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRG15(t *testing.T) {
+	reading, err := ParseRG15("Acc  0.01 mm, EventAcc  0.02 mm, TotalAcc  1.50 mm, RInt  0.10 mmph")
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, RG15Reading{Acc: 0.01, EventAcc: 0.02, TotalAcc: 1.5, RainIntensity: 0.1}, reading, "they should be equal")
+	assert.Equal(t, true, reading.Raining(), "they should be equal")
+
+	reading, err = ParseRG15("Acc 0.000 in, EventAcc 0.000 in, TotalAcc 0.100 in, RInt 0.000 iph")
+	assert.NoError(t, err, "should work")
+	assert.InDelta(t, 2.54, reading.TotalAcc, 1e-9, "inches should be converted to millimetres")
+	assert.Equal(t, false, reading.Raining(), "they should be equal")
+
+	reading, err = ParseRG15("Acc 0.10 mm, EventAcc 0.10 mm, TotalAcc 0.10 mm, RInt 1.00 mmph, XTBTips 3")
+	assert.NoError(t, err, "unknown values should be ignored")
+	assert.Equal(t, 1.0, reading.RainIntensity, "they should be equal")
+
+	_, err = ParseRG15("p")
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,181 @@
+package serial
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thebuh/barn/internal/socket"
+)
+
+const (
+	DefaultBaud      = 9600
+	DefaultTimeout   = 5 * time.Second
+	DefaultDelimiter = "\n"
+)
+
+// Parity settings
+const (
+	ParityNone = "none"
+	ParityEven = "even"
+	ParityOdd  = "odd"
+)
+
+var ErrUnsupported = errors.New("serial ports are not supported on this platform")
+
+// Options configure a device on a serial port. The port is opened in raw
+// mode without flow control.
+type Options struct {
+	Device   string
+	Baud     int
+	DataBits int
+	Parity   string
+	StopBits int
+	// Request is sent before each read. Without one the device is expected
+	// to stream lines on its own and the next complete line is read.
+	Request   string
+	Delimiter string
+	Timeout   time.Duration
+}
+
+// Validate checks the options without opening the port
+func (o Options) Validate() error {
+	if o.Device == "" {
+		return errors.New("device is required")
+	}
+	if o.Baud < 0 {
+		return fmt.Errorf("invalid baud rate %d", o.Baud)
+	}
+	if o.DataBits != 0 && (o.DataBits < 5 || o.DataBits > 8) {
+		return fmt.Errorf("invalid data bits %d", o.DataBits)
+	}
+	switch o.Parity {
+	case "", ParityNone, ParityEven, ParityOdd:
+	default:
+		return fmt.Errorf("unknown parity %q", o.Parity)
+	}
+	if o.StopBits != 0 && o.StopBits != 1 && o.StopBits != 2 {
+		return fmt.Errorf("invalid stop bits %d", o.StopBits)
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// port is an open serial device
+type port interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+	// flush discards input that has not been read yet
+	flush() error
+}
+
+// Client queries a device on a serial port. The port stays open between
+// queries, since opening it resets many boards, and is opened again after
+// the device was unplugged.
+type Client struct {
+	options Options
+
+	mu     sync.Mutex
+	port   port
+	reader *bufio.Reader
+}
+
+// New creates a client. It opens the port on the first query.
+func New(options Options) (*Client, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Baud == 0 {
+		options.Baud = DefaultBaud
+	}
+	if options.DataBits == 0 {
+		options.DataBits = 8
+	}
+	if options.Parity == "" {
+		options.Parity = ParityNone
+	}
+	if options.StopBits == 0 {
+		options.StopBits = 1
+	}
+	if options.Delimiter == "" {
+		options.Delimiter = DefaultDelimiter
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if _, err := baudRate(options.Baud); err != nil {
+		return nil, err
+	}
+	return &Client{options: options}, nil
+}
+
+// Options returns the options with defaults applied
+func (c *Client) Options() Options {
+	return c.options
+}
+
+// Query sends the request, or waits for the next streamed line, and returns
+// the reply without the delimiter and surrounding whitespace
+func (c *Client) Query() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reply, err := c.query()
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		// The device is gone or broken; open it again next time
+		c.close()
+	}
+	return reply, err
+}
+
+func (c *Client) query() (string, error) {
+	if c.port == nil {
+		p, err := open(c.options)
+		if err != nil {
+			return "", err
+		}
+		c.port, c.reader = p, bufio.NewReader(p)
+	}
+	// Drop stale input so the reply belongs to this query
+	if err := c.port.flush(); err != nil {
+		return "", err
+	}
+	c.reader.Reset(c.port)
+	if err := c.port.SetReadDeadline(time.Now().Add(c.options.Timeout)); err != nil {
+		return "", err
+	}
+	if c.options.Request != "" {
+		if _, err := c.port.Write([]byte(c.options.Request)); err != nil {
+			return "", err
+		}
+	} else if _, err := socket.ReadDelimited(c.reader, c.options.Delimiter); err != nil {
+		// The first streamed line may have been cut by the flush
+		return "", err
+	}
+	reply, err := socket.ReadDelimited(c.reader, c.options.Delimiter)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, c.options.Delimiter)), nil
+}
+
+// Close closes the port, if it is open
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
+
+func (c *Client) close() {
+	if c.port != nil {
+		_ = c.port.Close()
+	}
+	c.port, c.reader = nil, nil
+}
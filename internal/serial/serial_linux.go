@@ -0,0 +1,109 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+func baudRate(baud int) (uint32, error) {
+	rate, exists := baudRates[baud]
+	if !exists {
+		return 0, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	return rate, nil
+}
+
+// tty is a serial port opened non-blocking, so reads honour deadlines
+type tty struct {
+	*os.File
+}
+
+func open(options Options) (port, error) {
+	f, err := os.OpenFile(options.Device, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	p := &tty{File: f}
+	if err := p.configure(options); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", options.Device, err)
+	}
+	return p, nil
+}
+
+// control runs fn with the file descriptor. Fd() would switch the file back
+// to blocking mode.
+func (p *tty) control(fn func(fd int) error) error {
+	conn, err := p.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := conn.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+func (p *tty) configure(options Options) error {
+	rate, err := baudRate(options.Baud)
+	if err != nil {
+		return err
+	}
+	return p.control(func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+		// Raw mode, as cfmakeraw does
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CBAUD | unix.CRTSCTS
+		t.Cflag |= unix.CREAD | unix.CLOCAL | rate
+		switch options.DataBits {
+		case 5:
+			t.Cflag |= unix.CS5
+		case 6:
+			t.Cflag |= unix.CS6
+		case 7:
+			t.Cflag |= unix.CS7
+		default:
+			t.Cflag |= unix.CS8
+		}
+		switch options.Parity {
+		case ParityEven:
+			t.Cflag |= unix.PARENB
+		case ParityOdd:
+			t.Cflag |= unix.PARENB | unix.PARODD
+		}
+		if options.StopBits == 2 {
+			t.Cflag |= unix.CSTOPB
+		}
+		t.Ispeed, t.Ospeed = rate, rate
+		t.Cc[unix.VMIN], t.Cc[unix.VTIME] = 1, 0
+		return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+	})
+}
+
+func (p *tty) flush() error {
+	return p.control(func(fd int) error {
+		return unix.IoctlSetInt(fd, unix.TCFLSH, unix.TCIFLUSH)
+	})
+}
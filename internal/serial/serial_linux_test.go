@@ -0,0 +1,154 @@
+package serial
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens a pseudo-terminal pair and returns the master and the path
+// of the slave, which stands in for a serial device
+func openPTY(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals are not available: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	conn, err := master.SyscallConn()
+	assert.NoError(t, err, "should work")
+	var n uint32
+	var ioctlErr error
+	conn.Control(func(fd uintptr) {
+		if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr == nil {
+			n, ioctlErr = unix.IoctlGetUint32(int(fd), unix.TIOCGPTN)
+		}
+	})
+	if ioctlErr != nil {
+		t.Skipf("pseudo-terminals are not available: %v", ioctlErr)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+// answer replies to each line the device receives
+func answer(master *os.File, reply func(request string) string) {
+	go func() {
+		reader := bufio.NewReader(master)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			master.Write([]byte(reply(strings.TrimSpace(line))))
+		}
+	}()
+}
+
+func TestClient_Request(t *testing.T) {
+	master, device := openPTY(t)
+	answer(master, func(request string) string {
+		if request == "R" {
+			return "Acc  0.01 mm, EventAcc  0.02 mm, TotalAcc  1.50 mm, RInt  0.10 mmph\r\n"
+		}
+		return "?\r\n"
+	})
+
+	client, err := New(Options{Device: device, Baud: 19200, Parity: ParityEven, StopBits: 2, Request: RG15Request, Timeout: time.Second})
+	assert.NoError(t, err, "should work")
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		reply, err := client.Query()
+		assert.NoError(t, err, "should work")
+		assert.Equal(t, "Acc  0.01 mm, EventAcc  0.02 mm, TotalAcc  1.50 mm, RInt  0.10 mmph", reply, "they should be equal")
+	}
+
+	var termios *unix.Termios
+	client.port.(*tty).control(func(fd int) (err error) {
+		termios, err = unix.IoctlGetTermios(fd, unix.TCGETS)
+		return err
+	})
+	assert.Equal(t, uint32(unix.B19200), termios.Cflag&unix.CBAUD, "they should be equal")
+	// Pseudo-terminals always use 8 data bits without parity
+	assert.Equal(t, uint32(unix.CSTOPB), termios.Cflag&unix.CSTOPB, "they should be equal")
+	assert.Equal(t, uint32(0), termios.Lflag&(unix.ICANON|unix.ECHO), "the port should be in raw mode")
+}
+
+func TestClient_Streaming(t *testing.T) {
+	master, device := openPTY(t)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for n := 0; ; n++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				master.Write([]byte(fmt.Sprintf("sky %d, clear\n", n)))
+			}
+		}
+	}()
+
+	client, _ := New(Options{Device: device, Timeout: time.Second})
+	defer client.Close()
+	reply, err := client.Query()
+	assert.NoError(t, err, "should work")
+	assert.Regexp(t, `^sky \d+, clear$`, reply, "a whole line should be read")
+}
+
+func TestClient_Timeout(t *testing.T) {
+	master, device := openPTY(t)
+	answer(master, func(request string) string {
+		if request == "slow" {
+			return ""
+		}
+		return "ok\n"
+	})
+
+	client, _ := New(Options{Device: device, Request: "slow\n", Timeout: 100 * time.Millisecond})
+	defer client.Close()
+	_, err := client.Query()
+	assert.Equal(t, true, errors.Is(err, os.ErrDeadlineExceeded), "they should be equal")
+	assert.NotNil(t, client.port, "timeouts should keep the port open")
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	link := filepath.Join(t.TempDir(), "ttyUSB0")
+	first, device := openPTY(t)
+	assert.NoError(t, os.Symlink(device, link), "should work")
+	answer(first, func(string) string { return "first\n" })
+
+	client, _ := New(Options{Device: link, Request: "?\n", Timeout: time.Second})
+	defer client.Close()
+	reply, err := client.Query()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "first", reply, "they should be equal")
+
+	// Unplug the device and plug in another one under the same name
+	first.Close()
+	_, err = client.Query()
+	assert.Error(t, err, "unplugged devices should fail")
+	second, device := openPTY(t)
+	answer(second, func(string) string { return "second\n" })
+	assert.NoError(t, os.Remove(link), "should work")
+	assert.NoError(t, os.Symlink(device, link), "should work")
+
+	reply, err = client.Query()
+	assert.NoError(t, err, "the device should be opened again")
+	assert.Equal(t, "second", reply, "they should be equal")
+}
+
+func TestClient_MissingDevice(t *testing.T) {
+	client, err := New(Options{Device: "/dev/nonexistent-tty"})
+	assert.NoError(t, err, "ports are opened on the first query")
+	_, err = client.Query()
+	assert.Error(t, err, "should fail")
+	_, err = New(Options{Device: "/dev/ttyUSB0", Baud: 12345})
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package serial
+
+func baudRate(baud int) (uint32, error) {
+	return 0, ErrUnsupported
+}
+
+func open(options Options) (port, error) {
+	return nil, ErrUnsupported
+}
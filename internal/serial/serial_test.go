@@ -0,0 +1,23 @@
+package serial
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	assert.NoError(t, Options{Device: "/dev/ttyUSB0"}.Validate(), "should work")
+	assert.NoError(t, Options{Device: "/dev/ttyUSB0", Baud: 115200, DataBits: 7, Parity: ParityOdd, StopBits: 2}.Validate(), "should work")
+	for name, options := range map[string]Options{
+		"missing device": {},
+		"baud":           {Device: "/dev/ttyUSB0", Baud: -1},
+		"data bits":      {Device: "/dev/ttyUSB0", DataBits: 9},
+		"parity":         {Device: "/dev/ttyUSB0", Parity: "mark"},
+		"stop bits":      {Device: "/dev/ttyUSB0", StopBits: 3},
+		"timeout":        {Device: "/dev/ttyUSB0", Timeout: -time.Second},
+	} {
+		assert.Error(t, options.Validate(), name)
+	}
+}
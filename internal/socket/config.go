@@ -0,0 +1,24 @@
+package socket
+
+import (
+	"github.com/spf13/viper"
+)
+
+// OptionsFromConfig reads connection options from a device section: network,
+// address, request, delimiter, timeout and close_after_query
+func OptionsFromConfig(vt *viper.Viper) (Options, error) {
+	options := Options{
+		Network:         vt.GetString("network"),
+		Address:         vt.GetString("address"),
+		Request:         vt.GetString("request"),
+		Delimiter:       vt.GetString("delimiter"),
+		Timeout:         vt.GetDuration("timeout"),
+		CloseAfterQuery: vt.GetBool("close_after_query"),
+	}
+	return options, options.Validate()
+}
+
+// FieldFromConfig reads the separator and field keys
+func FieldFromConfig(vt *viper.Viper) Field {
+	return Field{Separator: vt.GetString("separator"), Index: vt.GetInt("field")}
+}
@@ -0,0 +1,41 @@
+package socket
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsFromConfig(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(strings.NewReader(`
+network: udp
+address: roof.local:4000
+request: "status\r\n"
+delimiter: "\r\n"
+timeout: 2s
+close_after_query: true
+separator: ";"
+field: 3
+`)), "should work")
+	options, err := OptionsFromConfig(v)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, Options{
+		Network:         "udp",
+		Address:         "roof.local:4000",
+		Request:         "status\r\n",
+		Delimiter:       "\r\n",
+		Timeout:         2 * time.Second,
+		CloseAfterQuery: true,
+	}, options, "they should be equal")
+	assert.Equal(t, Field{Separator: ";", Index: 3}, FieldFromConfig(v), "they should be equal")
+
+	v = viper.New()
+	v.Set("address", "roof.local")
+	_, err = OptionsFromConfig(v)
+	assert.Error(t, err, "should fail")
+}
@@ -0,0 +1,213 @@
+package socket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout   = 5 * time.Second
+	DefaultDelimiter = "\n"
+	// MaxReplySize caps replies from devices that never send the delimiter
+	MaxReplySize = 64 * 1024
+)
+
+var ErrReplyTooLarge = errors.New("reply too large")
+
+// Options configure a device that speaks a line protocol
+type Options struct {
+	// Network is tcp or udp
+	Network string
+	Address string
+	// Request is sent before each read. Devices that stream replies on
+	// their own need none.
+	Request string
+	// Delimiter ends a TCP reply. A UDP reply is one datagram.
+	Delimiter string
+	Timeout   time.Duration
+	// CloseAfterQuery hangs up after each reply, for devices that serve one
+	// client at a time
+	CloseAfterQuery bool
+}
+
+// Validate checks the options without connecting
+func (o Options) Validate() error {
+	switch o.Network {
+	case "", "tcp", "udp":
+	default:
+		return fmt.Errorf("unknown network %q", o.Network)
+	}
+	if _, _, err := net.SplitHostPort(o.Address); err != nil {
+		return fmt.Errorf("invalid address %q", o.Address)
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	return nil
+}
+
+// Client queries a device, keeping the connection open between queries and
+// dialling again after errors
+type Client struct {
+	options Options
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// New creates a client. It connects on the first query.
+func New(options Options) (*Client, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if options.Network == "" {
+		options.Network = "tcp"
+	}
+	if options.Delimiter == "" {
+		options.Delimiter = DefaultDelimiter
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Client{options: options}, nil
+}
+
+// Options returns the options with defaults applied
+func (c *Client) Options() Options {
+	return c.options
+}
+
+// Query sends the request and returns the reply without the delimiter and
+// surrounding whitespace
+func (c *Client) Query() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reused := c.conn != nil
+	reply, err := c.query()
+	if err != nil && reused {
+		// The device may have dropped an idle connection
+		reply, err = c.query()
+	}
+	if err != nil || c.options.CloseAfterQuery {
+		c.close()
+	}
+	return reply, err
+}
+
+func (c *Client) query() (string, error) {
+	if c.conn == nil {
+		conn, err := net.DialTimeout(c.options.Network, c.options.Address, c.options.Timeout)
+		if err != nil {
+			return "", err
+		}
+		c.conn, c.reader = conn, bufio.NewReader(conn)
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(c.options.Timeout)); err != nil {
+		c.close()
+		return "", err
+	}
+	if c.options.Request != "" {
+		if _, err := c.conn.Write([]byte(c.options.Request)); err != nil {
+			c.close()
+			return "", err
+		}
+	}
+	reply, err := c.read()
+	if err != nil {
+		c.close()
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, c.options.Delimiter)), nil
+}
+
+func (c *Client) read() (string, error) {
+	if c.options.Network == "udp" {
+		buf := make([]byte, MaxReplySize)
+		n, err := c.conn.Read(buf)
+		return string(buf[:n]), err
+	}
+	return ReadDelimited(c.reader, c.options.Delimiter)
+}
+
+// ReadDelimited reads up to and including the delimiter, failing with
+// ErrReplyTooLarge after MaxReplySize bytes
+func ReadDelimited(reader *bufio.Reader, delimiter string) (string, error) {
+	var reply []byte
+	last := delimiter[len(delimiter)-1]
+	for {
+		chunk, err := reader.ReadSlice(last)
+		reply = append(reply, chunk...)
+		if len(reply) > MaxReplySize {
+			return "", ErrReplyTooLarge
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if strings.HasSuffix(string(reply), delimiter) {
+			return string(reply), nil
+		}
+	}
+}
+
+// Close hangs up the connection, if any
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.close()
+	return nil
+}
+
+func (c *Client) close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.conn, c.reader = nil, nil
+}
+
+// Field selects one field of a reply
+type Field struct {
+	// Separator splits the reply; empty splits on whitespace
+	Separator string
+	// Index counts from 1; 0 selects the whole reply
+	Index int
+}
+
+// Extract returns the selected field without surrounding whitespace
+func (f Field) Extract(reply string) (string, error) {
+	if f.Index == 0 {
+		return reply, nil
+	}
+	var fields []string
+	if f.Separator == "" {
+		fields = strings.Fields(reply)
+	} else {
+		fields = strings.Split(reply, f.Separator)
+	}
+	if f.Index < 0 || f.Index > len(fields) {
+		return "", fmt.Errorf("field %d not found in %q", f.Index, reply)
+	}
+	return strings.TrimSpace(fields[f.Index-1]), nil
+}
+
+var numberPattern = regexp.MustCompile(`[-+]?(\d+\.?\d*|\.\d+)`)
+
+// ParseNumber reads the first number in a field, ignoring units such as the
+// m in the 06.70m of an SQM reading
+func ParseNumber(field string) (float64, error) {
+	match := numberPattern.FindString(field)
+	if match == "" {
+		return 0, fmt.Errorf("no number in %q", field)
+	}
+	return strconv.ParseFloat(match, 64)
+}
@@ -0,0 +1,167 @@
+package socket
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sqmReply = "r, 06.70m,0000022921Hz,0000000020c,0000000.000s, 039.4C\r\n"
+
+// startLineServer answers every line it reads with reply and counts the
+// connections it accepted. Connections are dropped after drop replies when
+// drop is positive.
+func startLineServer(t *testing.T, reply string, drop int) (string, *atomic.Int32) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "should work")
+	t.Cleanup(func() { ln.Close() })
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for n := 1; ; n++ {
+					buf := make([]byte, 16)
+					if _, err := reader.Read(buf); err != nil {
+						return
+					}
+					conn.Write([]byte(reply))
+					if n == drop {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), &connections
+}
+
+func TestClient_KeepAlive(t *testing.T) {
+	address, connections := startLineServer(t, sqmReply, 0)
+	client, err := New(Options{Address: address, Request: "rx"})
+	assert.NoError(t, err, "should work")
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		reply, err := client.Query()
+		assert.NoError(t, err, "should work")
+		assert.Equal(t, strings.TrimSpace(sqmReply), reply, "they should be equal")
+	}
+	assert.Equal(t, int32(1), connections.Load(), "the connection should be kept open")
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	address, connections := startLineServer(t, "open\n", 1)
+	client, _ := New(Options{Address: address, Request: "status\n"})
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		reply, err := client.Query()
+		assert.NoError(t, err, "dropped connections should be dialled again")
+		assert.Equal(t, "open", reply, "they should be equal")
+	}
+	assert.Equal(t, int32(3), connections.Load(), "they should be equal")
+}
+
+func TestClient_CloseAfterQuery(t *testing.T) {
+	address, connections := startLineServer(t, "1\n", 0)
+	client, _ := New(Options{Address: address, Request: "?", CloseAfterQuery: true})
+	client.Query()
+	client.Query()
+	assert.Equal(t, int32(2), connections.Load(), "they should be equal")
+}
+
+func TestClient_Delimiter(t *testing.T) {
+	address, _ := startLineServer(t, "a\nb#", 0)
+	client, _ := New(Options{Address: address, Request: "?", Delimiter: "#"})
+	defer client.Close()
+	reply, err := client.Query()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "a\nb", reply, "they should be equal")
+}
+
+func TestClient_Timeout(t *testing.T) {
+	address, _ := startLineServer(t, "no delimiter", 0)
+	client, _ := New(Options{Address: address, Request: "?", Timeout: 100 * time.Millisecond})
+	defer client.Close()
+	_, err := client.Query()
+	assert.Error(t, err, "replies without a delimiter should time out")
+
+	client, _ = New(Options{Address: "127.0.0.1:1", Timeout: 100 * time.Millisecond})
+	_, err = client.Query()
+	assert.Error(t, err, "should fail")
+}
+
+func TestClient_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "should work")
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte("closed\n"), addr)
+		}
+	}()
+
+	client, err := New(Options{Network: "udp", Address: conn.LocalAddr().String(), Request: "status"})
+	assert.NoError(t, err, "should work")
+	defer client.Close()
+	reply, err := client.Query()
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "closed", reply, "they should be equal")
+}
+
+func TestOptions_Validate(t *testing.T) {
+	assert.NoError(t, Options{Address: "sqm.local:10001"}.Validate(), "should work")
+	assert.Error(t, Options{Address: "sqm.local"}.Validate(), "a port is required")
+	assert.Error(t, Options{Network: "serial", Address: "sqm.local:10001"}.Validate(), "should fail")
+	assert.Error(t, Options{Address: "sqm.local:10001", Timeout: -time.Second}.Validate(), "should fail")
+}
+
+func TestField_Extract(t *testing.T) {
+	reply := strings.TrimSpace(sqmReply)
+	value, err := Field{Separator: ",", Index: 2}.Extract(reply)
+	assert.NoError(t, err, "should work")
+	assert.Equal(t, "06.70m", value, "they should be equal")
+
+	value, _ = Field{}.Extract(reply)
+	assert.Equal(t, reply, value, "index 0 should select the whole reply")
+
+	value, _ = Field{Index: 3}.Extract("roof  is open")
+	assert.Equal(t, "open", value, "an empty separator should split on whitespace")
+
+	_, err = Field{Separator: ",", Index: 7}.Extract(reply)
+	assert.Error(t, err, "should fail")
+}
+
+func TestParseNumber(t *testing.T) {
+	for field, expected := range map[string]float64{
+		"06.70m":   6.70,
+		"039.4C":   39.4,
+		"-005.3C":  -5.3,
+		"T=12":     12,
+		".5":       0.5,
+		"1013 hPa": 1013,
+	} {
+		value, err := ParseNumber(field)
+		assert.NoError(t, err, field)
+		assert.Equal(t, expected, value, field)
+	}
+	_, err := ParseNumber("open")
+	assert.Error(t, err, "should fail")
+}
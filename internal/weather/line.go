@@ -0,0 +1,267 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/thebuh/barn/internal/health"
+	"github.com/thebuh/barn/internal/record"
+	"github.com/thebuh/barn/internal/serial"
+	"github.com/thebuh/barn/internal/socket"
+)
+
+// LineSource asks a device that speaks a line protocol for a reply
+type LineSource interface {
+	Query() (string, error)
+	Close() error
+}
+
+// LineParser reads sensor values from a reply
+type LineParser interface {
+	// Sensors reports whether the parser provides a sensor
+	Sensors() map[string]bool
+	Parse(reply string) (map[string]float64, error)
+}
+
+// FieldParser reads sensors from separated fields of a reply
+type FieldParser struct {
+	separator string
+	fields    map[string]int
+}
+
+// NewFieldParser maps sensor names, in any case, to reply fields counting
+// from 1. An empty separator splits on whitespace.
+func NewFieldParser(separator string, fields map[string]int) (*FieldParser, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("no fields configured")
+	}
+	canonical := make(map[string]int, len(fields))
+	for sensorName, index := range fields {
+		sensor, valid := CanonicalSensor(sensorName)
+		if !valid || sensor == SensorAveragePeriod {
+			return nil, fmt.Errorf("unknown sensor %q", sensorName)
+		}
+		if index < 1 {
+			return nil, fmt.Errorf("sensor %s: fields count from 1", sensor)
+		}
+		canonical[sensor] = index
+	}
+	return &FieldParser{separator: separator, fields: canonical}, nil
+}
+
+func (p *FieldParser) Sensors() map[string]bool {
+	sensors := make(map[string]bool, len(p.fields))
+	for sensor := range p.fields {
+		sensors[sensor] = true
+	}
+	return sensors
+}
+
+func (p *FieldParser) Parse(reply string) (map[string]float64, error) {
+	values := make(map[string]float64, len(p.fields))
+	for sensor, index := range p.fields {
+		field, err := socket.Field{Separator: p.separator, Index: index}.Extract(reply)
+		if err != nil {
+			return nil, err
+		}
+		value, err := socket.ParseNumber(field)
+		if err != nil {
+			return nil, fmt.Errorf("sensor %s: %w", sensor, err)
+		}
+		values[sensor] = value
+	}
+	return values, nil
+}
+
+// RG15Parser reads the rain rate from a Hydreon RG-15
+type RG15Parser struct{}
+
+func (RG15Parser) Sensors() map[string]bool {
+	return map[string]bool{SensorRainRate: true}
+}
+
+func (RG15Parser) Parse(reply string) (map[string]float64, error) {
+	reading, err := serial.ParseRG15(reply)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]float64{SensorRainRate: reading.RainIntensity}, nil
+}
+
+// ValuesParser reads a JSON object of readings by sensor name, as recorded
+// for stations that do not fetch text, such as Modbus stations
+type ValuesParser struct {
+	sensors map[string]bool
+}
+
+// NewValuesParser creates a parser for the named sensors, in any case
+func NewValuesParser(sensors []string) (*ValuesParser, error) {
+	if len(sensors) == 0 {
+		return nil, errors.New("no sensors configured")
+	}
+	canonical := make(map[string]bool, len(sensors))
+	for _, sensorName := range sensors {
+		sensor, valid := CanonicalSensor(sensorName)
+		if !valid || sensor == SensorAveragePeriod {
+			return nil, fmt.Errorf("unknown sensor %q", sensorName)
+		}
+		canonical[sensor] = true
+	}
+	return &ValuesParser{sensors: canonical}, nil
+}
+
+func (p *ValuesParser) Sensors() map[string]bool {
+	return maps.Clone(p.sensors)
+}
+
+func (p *ValuesParser) Parse(reply string) (map[string]float64, error) {
+	var readings map[string]float64
+	if err := json.Unmarshal([]byte(reply), &readings); err != nil {
+		return nil, fmt.Errorf("failed to parse readings: %w", err)
+	}
+	values := make(map[string]float64, len(readings))
+	for sensorName, value := range readings {
+		if sensor, valid := CanonicalSensor(sensorName); valid && p.sensors[sensor] {
+			values[sensor] = value
+		}
+	}
+	return values, nil
+}
+
+// LinePreset describes the protocol of a known device
+type LinePreset struct {
+	Request   string
+	Delimiter string
+	Parser    LineParser
+}
+
+// LinePresets are known devices. The Unihedron SQM-LE answers rx with
+// "r, 06.70m,0000022921Hz,0000000020c,0000000.000s, 039.4C"; the last field
+// is the temperature inside the meter, so it is not reported.
+var LinePresets = map[string]LinePreset{
+	"sqm-le": {
+		Request:   "rx",
+		Delimiter: "\n",
+		Parser:    &FieldParser{separator: ",", fields: map[string]int{SensorSkyQuality: 2}},
+	},
+	"rg-15": {
+		Request:   serial.RG15Request,
+		Delimiter: "\n",
+		Parser:    RG15Parser{},
+	},
+}
+
+func linePreset(name string) (LinePreset, error) {
+	preset, exists := LinePresets[name]
+	if !exists {
+		return preset, fmt.Errorf("unknown preset %q", name)
+	}
+	return preset, nil
+}
+
+// ObservingConditionsLine reads sensors from replies of a device on a socket
+// or serial port
+type ObservingConditionsLine struct {
+	BaseObservingConditions
+	source  LineSource
+	parser  LineParser
+	sensors map[string]bool
+	record.Tap
+}
+
+func NewObservingConditionsLine(id string, name string, description string, source LineSource, parser LineParser) *ObservingConditionsLine {
+	cond := &ObservingConditionsLine{
+		BaseObservingConditions: BaseObservingConditions{
+			id:          id,
+			name:        name,
+			description: description,
+		},
+		source:  source,
+		parser:  parser,
+		sensors: parser.Sensors(),
+	}
+	cond.SetAveragePeriod(0)
+	cond.Refresh()
+	return cond
+}
+
+// NewObservingConditionsSocket creates a station for a TCP or UDP device
+func NewObservingConditionsSocket(id string, name string, description string, options socket.Options, parser LineParser) (*ObservingConditionsLine, error) {
+	client, err := socket.New(options)
+	if err != nil {
+		return nil, err
+	}
+	return NewObservingConditionsLine(id, name, description, client, parser), nil
+}
+
+// NewObservingConditionsSocketPreset creates a station for a known TCP or
+// UDP device
+func NewObservingConditionsSocketPreset(id string, name string, description string, preset string, options socket.Options) (*ObservingConditionsLine, error) {
+	p, err := linePreset(preset)
+	if err != nil {
+		return nil, err
+	}
+	if options.Request == "" {
+		options.Request = p.Request
+	}
+	if options.Delimiter == "" {
+		options.Delimiter = p.Delimiter
+	}
+	return NewObservingConditionsSocket(id, name, description, options, p.Parser)
+}
+
+// NewObservingConditionsSerial creates a station for a serial device
+func NewObservingConditionsSerial(id string, name string, description string, options serial.Options, parser LineParser) (*ObservingConditionsLine, error) {
+	client, err := serial.New(options)
+	if err != nil {
+		return nil, err
+	}
+	return NewObservingConditionsLine(id, name, description, client, parser), nil
+}
+
+// NewObservingConditionsSerialPreset creates a station for a known serial
+// device
+func NewObservingConditionsSerialPreset(id string, name string, description string, preset string, options serial.Options) (*ObservingConditionsLine, error) {
+	p, err := linePreset(preset)
+	if err != nil {
+		return nil, err
+	}
+	if options.Request == "" {
+		options.Request = p.Request
+	}
+	if options.Delimiter == "" {
+		options.Delimiter = p.Delimiter
+	}
+	return NewObservingConditionsSerial(id, name, description, options, p.Parser)
+}
+
+// SupportsSensor reports only the sensors the parser reads
+func (o *ObservingConditionsLine) SupportsSensor(sensorName string) bool {
+	return o.sensors[sensorName]
+}
+
+func (o *ObservingConditionsLine) Refresh() error {
+	reply, err := o.source.Query()
+	o.Record(reply, err)
+	if err != nil {
+		return err
+	}
+	values, err := o.parser.Parse(reply)
+	if err != nil {
+		return health.Parse(err)
+	}
+	condition, _ := o.snapshot()
+	for sensor, value := range values {
+		condition.set(sensor, value)
+	}
+	o.setCondition(condition, time.Now())
+	return nil
+}
+
+// Close hangs up the connection to the device
+func (o *ObservingConditionsLine) Close() error {
+	return o.source.Close()
+}
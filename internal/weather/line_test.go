@@ -0,0 +1,196 @@
+package weather
+
+import (
+	"net"
+	"testing"
+
+	"github.com/thebuh/barn/internal/serial"
+	"github.com/thebuh/barn/internal/socket"
+)
+
+// startSQMServer answers rx like a Unihedron SQM-LE
+func startSQMServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 16)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					if string(buf[:n]) == "rx" {
+						conn.Write([]byte("r, 21.35m,0000022921Hz,0000000020c,0000000.000s, 012.4C\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestObservingConditionsLine_SocketSQMPreset(t *testing.T) {
+	sqm, err := NewObservingConditionsSocketPreset("sqm", "SQM", "Sky quality meter", "sqm-le", socket.Options{Address: startSQMServer(t)})
+	if err != nil {
+		t.Fatalf("Failed to create socket station: %v", err)
+	}
+	defer sqm.Close()
+
+	if sqm.GetSkyQuality() != 21.35 {
+		t.Errorf("Expected sky quality 21.35, got %f", sqm.GetSkyQuality())
+	}
+	if sqm.GetTimeStamp().IsZero() {
+		t.Error("Expected the refresh time to be set")
+	}
+	if !SupportsSensor(sqm, "skyquality") {
+		t.Error("Expected SkyQuality to be supported")
+	}
+	if SupportsSensor(sqm, SensorTemperature) {
+		t.Error("Expected Temperature not to be supported")
+	}
+	sensors := StationSensors(sqm)
+	if len(sensors) != 1 || sensors[SensorSkyQuality] != 21.35 {
+		t.Errorf("Expected only SkyQuality, got %v", sensors)
+	}
+}
+
+func TestObservingConditionsLine_SocketFields(t *testing.T) {
+	parser, err := NewFieldParser(",", map[string]int{"skyquality": 2, "temperature": 6})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	station, err := NewObservingConditionsSocket("sqm", "SQM", "", socket.Options{Address: startSQMServer(t), Request: "rx"}, parser)
+	if err != nil {
+		t.Fatalf("Failed to create socket station: %v", err)
+	}
+	defer station.Close()
+	if err := station.Refresh(); err != nil {
+		t.Errorf("Expected no error from Refresh(), got %v", err)
+	}
+	if station.GetTemperature() != 12.4 {
+		t.Errorf("Expected temperature 12.4, got %f", station.GetTemperature())
+	}
+
+	parser, _ = NewFieldParser(",", map[string]int{SensorHumidity: 9})
+	missing, _ := NewObservingConditionsSocket("sqm", "SQM", "", socket.Options{Address: startSQMServer(t), Request: "rx"}, parser)
+	defer missing.Close()
+	if err := missing.Refresh(); err == nil {
+		t.Error("Expected error from Refresh() with a missing field, got nil")
+	}
+}
+
+func TestObservingConditionsLine_SocketInvalid(t *testing.T) {
+	for name, fields := range map[string]map[string]int{
+		"no fields":      {},
+		"unknown sensor": {"Brightness": 1},
+		"average period": {SensorAveragePeriod: 1},
+		"zero index":     {SensorTemperature: 0},
+	} {
+		if _, err := NewFieldParser(",", fields); err == nil {
+			t.Errorf("Expected error for %s, got nil", name)
+		}
+	}
+	if _, err := NewObservingConditionsSocketPreset("id", "name", "", "sqm-lu", socket.Options{Address: "127.0.0.1:1"}); err == nil {
+		t.Error("Expected error for an unknown preset, got nil")
+	}
+	if _, err := NewObservingConditionsSocket("id", "name", "", socket.Options{}, RG15Parser{}); err == nil {
+		t.Error("Expected error for a missing address, got nil")
+	}
+}
+
+func TestSupportsSensor_Default(t *testing.T) {
+	dummy := NewObservingConditionsDummy("dummy", "Dummy", "")
+	if !SupportsSensor(dummy, SensorTemperature) || SupportsSensor(dummy, SensorSkyQuality) {
+		t.Error("Expected stations without their own sensors to use AvailableSensors")
+	}
+	if len(StationSensors(dummy)) != len(dummy.GetCondition().Sensors()) {
+		t.Error("Expected the same sensors as the condition")
+	}
+}
+
+// replySource returns a fixed reply
+type replySource struct {
+	reply string
+}
+
+func (s *replySource) Query() (string, error) {
+	return s.reply, nil
+}
+
+func (s *replySource) Close() error {
+	return nil
+}
+
+func TestObservingConditionsLine_RG15(t *testing.T) {
+	source := &replySource{reply: "Acc 0.001 in, EventAcc 0.001 in, TotalAcc 0.010 in, RInt 0.100 iph"}
+	station := NewObservingConditionsLine("rain", "Rain", "RG-15", source, LinePresets["rg-15"].Parser)
+	if station.GetRainRate() != 2.54 {
+		t.Errorf("Expected rain rate 2.54, got %f", station.GetRainRate())
+	}
+	if !SupportsSensor(station, SensorRainRate) || SupportsSensor(station, SensorTemperature) {
+		t.Error("Expected only RainRate to be supported")
+	}
+
+	source.reply = "p"
+	if err := station.Refresh(); err == nil {
+		t.Error("Expected error from Refresh() with an invalid reply, got nil")
+	}
+}
+
+func TestObservingConditionsLine_SerialPreset(t *testing.T) {
+	if _, err := NewObservingConditionsSerialPreset("id", "name", "", "rg-16", serial.Options{Device: "/dev/ttyUSB0"}); err == nil {
+		t.Error("Expected error for an unknown preset, got nil")
+	}
+	if _, err := NewObservingConditionsSerial("id", "name", "", serial.Options{}, RG15Parser{}); err == nil {
+		t.Error("Expected error for a missing device, got nil")
+	}
+}
+
+func TestObservingConditionsLine_Recorder(t *testing.T) {
+	source := &replySource{reply: "12.5 80"}
+	parser, _ := NewFieldParser("", map[string]int{"temperature": 1, "humidity": 2})
+	station := NewObservingConditionsLine("station", "Station", "", source, parser)
+	var payloads []string
+	station.UseRecorder(func(payload string, err error) {
+		payloads = append(payloads, payload)
+	})
+	source.reply = "13 75"
+	if err := station.Refresh(); err != nil {
+		t.Fatalf("Expected no error from Refresh(), got %v", err)
+	}
+	if len(payloads) != 1 || payloads[0] != "13 75" {
+		t.Errorf("Expected the reply to be recorded, got %q", payloads)
+	}
+}
+
+func TestValuesParser(t *testing.T) {
+	parser, err := NewValuesParser([]string{"temperature", "RainRate"})
+	if err != nil {
+		t.Fatalf("Expected no error from NewValuesParser(), got %v", err)
+	}
+	station := NewObservingConditionsLine("station", "Station", "", &replySource{reply: `{"Temperature": 3.5, "RainRate": 1.2, "Humidity": 90}`}, parser)
+	if station.GetTemperature() != 3.5 || station.GetRainRate() != 1.2 {
+		t.Errorf("Expected temperature 3.5 and rain rate 1.2, got %f and %f", station.GetTemperature(), station.GetRainRate())
+	}
+	if station.GetHumidity() != 0 || SupportsSensor(station, SensorHumidity) {
+		t.Error("Expected unlisted sensors to be ignored")
+	}
+	if _, err := parser.Parse("RainRate=1"); err == nil {
+		t.Error("Expected error from Parse() with invalid JSON, got nil")
+	}
+	for _, sensors := range [][]string{nil, {"Visibility"}, {"AveragePeriod"}} {
+		if _, err := NewValuesParser(sensors); err == nil {
+			t.Errorf("Expected error from NewValuesParser(%q), got nil", sensors)
+		}
+	}
+}
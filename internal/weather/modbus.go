@@ -0,0 +1,88 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thebuh/barn/internal/modbus"
+	"github.com/thebuh/barn/internal/record"
+)
+
+// ObservingConditionsModbus reads sensors from holding or input registers of
+// a Modbus TCP device
+type ObservingConditionsModbus struct {
+	BaseObservingConditions
+	client *modbus.Client
+	fields map[string]modbus.Register
+	record.Tap
+}
+
+// NewObservingConditionsModbus maps sensor names, in any case, to registers
+func NewObservingConditionsModbus(id string, name string, description string, options modbus.Options, fields map[string]modbus.Register) (*ObservingConditionsModbus, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("no fields configured")
+	}
+	canonical := make(map[string]modbus.Register, len(fields))
+	for sensorName, register := range fields {
+		sensor, valid := CanonicalSensor(sensorName)
+		if !valid || sensor == SensorAveragePeriod {
+			return nil, fmt.Errorf("unknown sensor %q", sensorName)
+		}
+		if err := register.Validate(); err != nil {
+			return nil, fmt.Errorf("sensor %s: %w", sensor, err)
+		}
+		canonical[sensor] = register
+	}
+	client, err := modbus.New(options)
+	if err != nil {
+		return nil, err
+	}
+	cond := &ObservingConditionsModbus{
+		BaseObservingConditions: BaseObservingConditions{
+			id:          id,
+			name:        name,
+			description: description,
+		},
+		client: client,
+		fields: canonical,
+	}
+	cond.SetAveragePeriod(0)
+	cond.Refresh()
+	return cond, nil
+}
+
+// SupportsSensor reports only the sensors mapped to registers
+func (o *ObservingConditionsModbus) SupportsSensor(sensorName string) bool {
+	_, exists := o.fields[sensorName]
+	return exists
+}
+
+// Refresh reads every register and keeps the previous values if any read
+// fails. The readings are recorded as a JSON object by sensor name.
+func (o *ObservingConditionsModbus) Refresh() error {
+	values := make(map[string]float64, len(o.fields))
+	for sensor, register := range o.fields {
+		value, err := o.client.ReadRegister(register)
+		if err != nil {
+			err = fmt.Errorf("sensor %s: %w", sensor, err)
+			o.Record("", err)
+			return err
+		}
+		values[sensor] = value
+	}
+	payload, _ := json.Marshal(values)
+	o.Record(string(payload), nil)
+	condition, _ := o.snapshot()
+	for sensor, value := range values {
+		condition.set(sensor, value)
+	}
+	o.setCondition(condition, time.Now())
+	return nil
+}
+
+// Close hangs up the connection to the device
+func (o *ObservingConditionsModbus) Close() error {
+	return o.client.Close()
+}
@@ -0,0 +1,89 @@
+package weather
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/thebuh/barn/internal/modbus"
+	"github.com/thebuh/barn/internal/modbus/modbustest"
+)
+
+func TestObservingConditionsModbus(t *testing.T) {
+	server := modbustest.NewServer()
+	defer server.Close()
+	// Temperature in tenths of a degree, humidity as a float with swapped words
+	server.SetInputRegisters(0, uint16(0xFFFF-25+1))
+	humidity := math.Float32bits(63.5)
+	server.SetHoldingRegisters(10, uint16(humidity), uint16(humidity>>16))
+
+	station, err := NewObservingConditionsModbus("plc", "PLC", "Weather PLC", modbus.Options{Address: server.Addr(), Timeout: time.Second},
+		map[string]modbus.Register{
+			"temperature": {Table: modbus.TableInput, Address: 0, Type: modbus.TypeInt16, Scale: 0.1},
+			"Humidity":    {Table: modbus.TableHolding, Address: 10, Type: modbus.TypeFloat32, Order: modbus.OrderCDAB},
+		})
+	if err != nil {
+		t.Fatalf("Failed to create Modbus station: %v", err)
+	}
+	defer station.Close()
+
+	if math.Abs(station.GetTemperature()-(-2.5)) > 1e-9 {
+		t.Errorf("Expected temperature -2.5, got %f", station.GetTemperature())
+	}
+	if station.GetHumidity() != 63.5 {
+		t.Errorf("Expected humidity 63.5, got %f", station.GetHumidity())
+	}
+	if !SupportsSensor(station, SensorHumidity) || SupportsSensor(station, SensorPressure) {
+		t.Error("Expected only the mapped sensors to be supported")
+	}
+
+	var payloads []string
+	station.UseRecorder(func(payload string, err error) {
+		payloads = append(payloads, payload)
+	})
+	server.SetInputRegisters(0, 150)
+	if err := station.Refresh(); err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+	if station.GetTemperature() != 15 {
+		t.Errorf("Expected temperature 15, got %f", station.GetTemperature())
+	}
+	if len(payloads) != 1 || payloads[0] != `{"Humidity":63.5,"Temperature":15}` {
+		t.Errorf("Expected the readings to be recorded as JSON, got %q", payloads)
+	}
+}
+
+func TestObservingConditionsModbus_Errors(t *testing.T) {
+	server := modbustest.NewServer()
+	defer server.Close()
+	server.SetInputRegisters(0, 100)
+	options := modbus.Options{Address: server.Addr(), Timeout: time.Second}
+
+	station, err := NewObservingConditionsModbus("plc", "PLC", "", options, map[string]modbus.Register{
+		SensorPressure:  {Table: modbus.TableInput, Address: 0, Type: modbus.TypeUint16},
+		SensorRainRate:  {Table: modbus.TableInput, Address: 1, Type: modbus.TypeUint16},
+		SensorWindSpeed: {Table: modbus.TableInput, Address: 0, Type: modbus.TypeUint16},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Modbus station: %v", err)
+	}
+	defer station.Close()
+	if station.Refresh() == nil {
+		t.Error("Expected missing registers to fail the refresh")
+	}
+	if !station.GetTimeStamp().IsZero() || station.GetPressure() != 0 {
+		t.Error("Expected failed refreshes to keep the previous values")
+	}
+
+	invalid := []map[string]modbus.Register{
+		nil,
+		{"sunshine": {Table: modbus.TableInput, Type: modbus.TypeUint16}},
+		{SensorAveragePeriod: {Table: modbus.TableInput, Type: modbus.TypeUint16}},
+		{SensorPressure: {Table: modbus.TableCoil, Type: modbus.TypeUint16}},
+	}
+	for _, fields := range invalid {
+		if _, err := NewObservingConditionsModbus("plc", "PLC", "", options, fields); err == nil {
+			t.Errorf("Expected %v to be rejected", fields)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package weather
+
+import (
+	"time"
+
+	"github.com/thebuh/barn/internal/push"
+)
+
+// ObservingConditionsPush is a line station fed by pushed payloads instead
+// of queries. Its readings go stale when no push arrives within the expiry.
+type ObservingConditionsPush struct {
+	*ObservingConditionsLine
+	source *push.Source
+}
+
+// NewObservingConditionsPush creates a station for payloads pushed by
+// external scripts. A zero expiry keeps the last payload forever.
+func NewObservingConditionsPush(id string, name string, description string, expiry time.Duration, parser LineParser) (*ObservingConditionsPush, error) {
+	source, err := push.NewSource(expiry)
+	if err != nil {
+		return nil, err
+	}
+	return &ObservingConditionsPush{
+		ObservingConditionsLine: NewObservingConditionsLine(id, name, description, source, parser),
+		source:                  source,
+	}, nil
+}
+
+// Receive takes a payload for the next refresh
+func (o *ObservingConditionsPush) Receive(payload string) {
+	o.source.Push(payload)
+}
+
+// Push takes a payload and refreshes the readings from it
+func (o *ObservingConditionsPush) Push(payload string) error {
+	o.Receive(payload)
+	return o.Refresh()
+}
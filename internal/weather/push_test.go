@@ -0,0 +1,39 @@
+package weather
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thebuh/barn/internal/health"
+)
+
+func TestObservingConditionsPush(t *testing.T) {
+	parser, err := NewValuesParser([]string{"Temperature", "RainRate"})
+	if err != nil {
+		t.Fatalf("Expected no error from NewValuesParser(), got %v", err)
+	}
+	station, err := NewObservingConditionsPush("station", "Station", "", time.Minute, parser)
+	if err != nil {
+		t.Fatalf("Expected no error from NewObservingConditionsPush(), got %v", err)
+	}
+	if err := station.Refresh(); err == nil {
+		t.Error("Expected error from Refresh() before the first push, got nil")
+	}
+	if err := station.Push(`{"Temperature": 4.5, "RainRate": 0.2}`); err != nil {
+		t.Fatalf("Expected no error from Push(), got %v", err)
+	}
+	if station.GetTemperature() != 4.5 || station.GetRainRate() != 0.2 {
+		t.Errorf("Expected temperature 4.5 and rain rate 0.2, got %f and %f", station.GetTemperature(), station.GetRainRate())
+	}
+	if err := station.Push("raining"); err == nil {
+		t.Error("Expected error from Push() with invalid JSON, got nil")
+	} else if cause := health.Cause(err); cause != health.CauseParse {
+		t.Errorf("Expected a parse error from Push() with invalid JSON, got %s", cause)
+	}
+	if station.GetTemperature() != 4.5 {
+		t.Errorf("Expected failed pushes to keep the readings, got temperature %f", station.GetTemperature())
+	}
+	if _, err := NewObservingConditionsPush("station", "Station", "", -time.Minute, parser); err == nil {
+		t.Error("Expected error from NewObservingConditionsPush() with a negative expiry, got nil")
+	}
+}
@@ -0,0 +1,305 @@
+package weather
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Scenario describes the weather a Simulator generates. Showers and cloud
+// passages are random but repeat for the same seed; events are scripted.
+type Scenario struct {
+	// Temperature is the daily mean in °C. It peaks mid-afternoon and is
+	// TemperatureSwing lower before dawn than then.
+	Temperature      float64
+	TemperatureSwing float64
+	Humidity         float64
+	Pressure         float64
+	WindSpeed        float64
+	// Gustiness is how far gusts exceed the wind speed, as a fraction
+	Gustiness     float64
+	WindDirection float64
+	// CloudCover is the cover between passages
+	CloudCover float64
+	// CloudPassages and RainShowers are the mean number per hour
+	CloudPassages float64
+	RainShowers   float64
+	// SkyQuality is the sky quality of a clear sky
+	SkyQuality float64
+	Events     []ScenarioEvent
+}
+
+// DefaultScenario is a mild night with a few clouds and rare showers
+var DefaultScenario = Scenario{
+	Temperature:      12,
+	TemperatureSwing: 8,
+	Humidity:         70,
+	Pressure:         1013,
+	WindSpeed:        3,
+	Gustiness:        0.6,
+	WindDirection:    225,
+	CloudCover:       10,
+	CloudPassages:    0.5,
+	RainShowers:      0.05,
+	SkyQuality:       21,
+}
+
+// ScenarioEvent overrides readings for a while, such as rain at T+10min
+type ScenarioEvent struct {
+	// At counts from the start of the simulation
+	At time.Duration
+	// Duration of the event. Zero lasts until the end of the simulation.
+	Duration time.Duration
+	// Values overrides readings by sensor name
+	Values map[string]float64
+	// Safe overrides simulated safety monitors when set
+	Safe *bool
+}
+
+func (e ScenarioEvent) active(elapsed time.Duration) bool {
+	return elapsed >= e.At && (e.Duration == 0 || elapsed < e.At+e.Duration)
+}
+
+// Validate checks the scenario and canonicalizes event sensor names
+func (s *Scenario) Validate() error {
+	if s.Humidity < 0 || s.Humidity > 100 || s.CloudCover < 0 || s.CloudCover > 100 {
+		return errors.New("humidity and cloud cover must be between 0 and 100")
+	}
+	if s.TemperatureSwing < 0 || s.WindSpeed < 0 || s.Gustiness < 0 || s.CloudPassages < 0 || s.RainShowers < 0 {
+		return errors.New("temperature swing, wind speed, gustiness, cloud passages and rain showers must not be negative")
+	}
+	for i, event := range s.Events {
+		if event.At < 0 || event.Duration < 0 {
+			return fmt.Errorf("event %d: times must not be negative", i+1)
+		}
+		values := make(map[string]float64, len(event.Values))
+		for sensorName, value := range event.Values {
+			sensor, valid := CanonicalSensor(sensorName)
+			if !valid || !simulatedSensors[sensor] {
+				return fmt.Errorf("event %d: unknown sensor %q", i+1, sensorName)
+			}
+			values[sensor] = value
+		}
+		s.Events[i].Values = values
+	}
+	return nil
+}
+
+// simulatedSensors are the sensors a Simulator generates
+var simulatedSensors = map[string]bool{
+	SensorCloudCover:     true,
+	SensorDewPoint:       true,
+	SensorHumidity:       true,
+	SensorPressure:       true,
+	SensorRainRate:       true,
+	SensorSkyQuality:     true,
+	SensorSkyTemperature: true,
+	SensorTemperature:    true,
+	SensorWindDirection:  true,
+	SensorWindGust:       true,
+	SensorWindSpeed:      true,
+}
+
+// Noise channels keep the random series of the readings independent
+const (
+	noiseTemperature = iota + 1
+	noiseHumidity
+	noisePressure
+	noiseWind
+	noiseGust
+	noiseDirection
+	noiseRain
+	noiseClouds
+)
+
+// Draws of a burst
+const (
+	drawOccurs = iota
+	drawStart
+	drawLength
+	drawStrength
+)
+
+// Simulator generates readings from a scenario. Readings depend only on the
+// seed, the time since start and the time of day, so simulators with the same
+// seed, scenario and start agree.
+type Simulator struct {
+	scenario Scenario
+	seed     int64
+	start    time.Time
+}
+
+// NewSimulator creates a simulator whose events count from start
+func NewSimulator(scenario Scenario, seed int64, start time.Time) (*Simulator, error) {
+	scenario.Events = slices.Clone(scenario.Events)
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return &Simulator{scenario: scenario, seed: seed, start: start}, nil
+}
+
+// Condition returns the readings at t
+func (s *Simulator) Condition(t time.Time) WeatherCondition {
+	var c WeatherCondition
+	for sensor, value := range s.Values(t) {
+		c.set(sensor, value)
+	}
+	return c
+}
+
+// Values returns the readings at t by sensor name
+func (s *Simulator) Values(t time.Time) map[string]float64 {
+	sc := s.scenario
+	elapsed := t.Sub(s.start)
+	minutes := elapsed.Minutes()
+
+	clouds := sc.CloudCover
+	if passage, window := s.burst(noiseClouds, elapsed, sc.CloudPassages, 5*time.Minute, 25*time.Minute); passage > 0 {
+		peak := 60 + 40*s.uniform(noiseClouds, window, drawStrength)
+		clouds = math.Max(clouds, peak*passage)
+	}
+	rain := 0.0
+	if shower, window := s.burst(noiseRain, elapsed, sc.RainShowers, 5*time.Minute, 15*time.Minute); shower > 0 {
+		rain = (0.5 + 4.5*s.uniform(noiseRain, window, drawStrength)) * math.Sqrt(shower)
+		clouds = 100
+	}
+
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	temperature := sc.Temperature + sc.TemperatureSwing/2*math.Cos(2*math.Pi*(hour-15)/24) + 0.5*s.noise(noiseTemperature, minutes/20)
+	if rain > 0 {
+		temperature -= 2
+	}
+	humidity := sc.Humidity - 2.5*(temperature-sc.Temperature) + 5*s.noise(noiseHumidity, minutes/30)
+	if rain > 0 {
+		humidity = math.Max(humidity, 95)
+	}
+	humidity = math.Min(math.Max(humidity, 5), 100)
+	wind := math.Max(0, sc.WindSpeed*(1+0.4*s.noise(noiseWind, minutes/10)))
+	gust := wind * (1 + sc.Gustiness*(0.5+0.5*s.noise(noiseGust, minutes)))
+	direction := math.Mod(sc.WindDirection+40*s.noise(noiseDirection, minutes/60)+360, 360)
+
+	values := map[string]float64{
+		SensorCloudCover:     clouds,
+		SensorDewPoint:       dewPoint(temperature, humidity),
+		SensorHumidity:       humidity,
+		SensorPressure:       sc.Pressure + 2*s.noise(noisePressure, minutes/240),
+		SensorRainRate:       rain,
+		SensorSkyQuality:     sc.SkyQuality - 4*clouds/100,
+		SensorSkyTemperature: temperature - 25*(1-clouds/100),
+		SensorTemperature:    temperature,
+		SensorWindDirection:  direction,
+		SensorWindGust:       gust,
+		SensorWindSpeed:      wind,
+	}
+	for sensor, value := range values {
+		values[sensor] = math.Round(value*100) / 100
+	}
+	for _, event := range sc.Events {
+		if event.active(elapsed) {
+			for sensor, value := range event.Values {
+				values[sensor] = value
+			}
+		}
+	}
+	return values
+}
+
+// Safe returns the safety set by the last active event, if any
+func (s *Simulator) Safe(t time.Time) (safe bool, scripted bool) {
+	elapsed := t.Sub(s.start)
+	for _, event := range s.scenario.Events {
+		if event.Safe != nil && event.active(elapsed) {
+			safe, scripted = *event.Safe, true
+		}
+	}
+	return safe, scripted
+}
+
+// burst returns how far into a random burst, such as a shower, elapsed is,
+// rising from 0 to 1 and back, and the hour the burst started in. Each hour
+// has at most one burst, starting with a probability from the mean rate per
+// hour.
+func (s *Simulator) burst(channel int64, elapsed time.Duration, rate float64, shortest time.Duration, longest time.Duration) (float64, int64) {
+	if rate <= 0 {
+		return 0, 0
+	}
+	probability := 1 - math.Exp(-rate)
+	hour := int64(math.Floor(elapsed.Hours()))
+	// A burst starting late in the previous hour may still be going
+	for window := hour - 1; window <= hour; window++ {
+		if s.uniform(channel, window, drawOccurs) >= probability {
+			continue
+		}
+		start := time.Duration(window)*time.Hour + time.Duration(s.uniform(channel, window, drawStart)*float64(time.Hour))
+		length := shortest + time.Duration(s.uniform(channel, window, drawLength)*float64(longest-shortest))
+		if elapsed >= start && elapsed < start+length {
+			return math.Sin(math.Pi * float64(elapsed-start) / float64(length)), window
+		}
+	}
+	return 0, 0
+}
+
+// noise is smooth value noise between -1 and 1 with one random value per
+// unit of x
+func (s *Simulator) noise(channel int64, x float64) float64 {
+	knot := math.Floor(x)
+	a := 2*s.uniform(channel, int64(knot), 0) - 1
+	b := 2*s.uniform(channel, int64(knot)+1, 0) - 1
+	f := x - knot
+	f = f * f * (3 - 2*f)
+	return a + (b-a)*f
+}
+
+// uniform returns a random number in [0, 1) fixed by the seed, channel, n
+// and draw
+func (s *Simulator) uniform(channel int64, n int64, draw int64) float64 {
+	x := uint64(s.seed) ^ uint64(channel<<8|draw)*0x9E3779B97F4A7C15 ^ uint64(n)*0xBF58476D1CE4E5B9
+	// splitmix64
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// dewPoint uses the Magnus formula
+func dewPoint(temperature float64, humidity float64) float64 {
+	const a, b = 17.62, 243.12
+	gamma := math.Log(humidity/100) + a*temperature/(b+temperature)
+	return b * gamma / (a - gamma)
+}
+
+// ObservingConditionsSimulator reports the readings of a Simulator
+type ObservingConditionsSimulator struct {
+	BaseObservingConditions
+	simulator *Simulator
+	now       func() time.Time
+}
+
+// NewObservingConditionsSimulator creates a simulated weather station
+func NewObservingConditionsSimulator(id string, name string, description string, simulator *Simulator) *ObservingConditionsSimulator {
+	station := &ObservingConditionsSimulator{
+		BaseObservingConditions: BaseObservingConditions{
+			id:          id,
+			name:        name,
+			description: description,
+		},
+		simulator: simulator,
+		now:       time.Now,
+	}
+	station.Refresh()
+	return station
+}
+
+func (o *ObservingConditionsSimulator) Refresh() error {
+	now := o.now()
+	o.setCondition(o.simulator.Condition(now), now)
+	return nil
+}
+
+// SupportsSensor implements SensorSupporter
+func (o *ObservingConditionsSimulator) SupportsSensor(sensorName string) bool {
+	return simulatedSensors[sensorName]
+}
@@ -0,0 +1,148 @@
+package weather
+
+import (
+	"testing"
+	"time"
+)
+
+var simulationStart = time.Date(2025, 1, 1, 20, 0, 0, 0, time.UTC)
+
+func newTestSimulator(t *testing.T, scenario Scenario, seed int64) *Simulator {
+	simulator, err := NewSimulator(scenario, seed, simulationStart)
+	if err != nil {
+		t.Fatalf("Failed to create simulator: %v", err)
+	}
+	return simulator
+}
+
+func TestSimulator_Repeatable(t *testing.T) {
+	a := newTestSimulator(t, DefaultScenario, 42)
+	b := newTestSimulator(t, DefaultScenario, 42)
+	c := newTestSimulator(t, DefaultScenario, 7)
+	differs := false
+	for minute := 0; minute < 120; minute++ {
+		at := simulationStart.Add(time.Duration(minute) * time.Minute)
+		if a.Condition(at) != b.Condition(at) {
+			t.Fatalf("Expected the same seed to give the same readings at %v", at)
+		}
+		if a.Condition(at) != c.Condition(at) {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Error("Expected another seed to give other readings")
+	}
+}
+
+func TestSimulator_Realistic(t *testing.T) {
+	scenario := DefaultScenario
+	scenario.RainShowers = 3
+	scenario.CloudPassages = 3
+	simulator := newTestSimulator(t, scenario, 1)
+
+	afternoon := simulator.Condition(time.Date(2025, 1, 2, 15, 0, 0, 0, time.UTC))
+	dawn := simulator.Condition(time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC))
+	if afternoon.Temperature-dawn.Temperature < 5 {
+		t.Errorf("Expected afternoons to be warmer than dawn, got %f and %f", afternoon.Temperature, dawn.Temperature)
+	}
+
+	var rained, clouded bool
+	previous := simulator.Condition(simulationStart)
+	for minute := 1; minute < 24*60; minute++ {
+		c := simulator.Condition(simulationStart.Add(time.Duration(minute) * time.Minute))
+		if c.DewPoint > c.Temperature {
+			t.Fatalf("Expected the dew point %f below the temperature %f", c.DewPoint, c.Temperature)
+		}
+		if c.WindGust < c.WindSpeed {
+			t.Fatalf("Expected gusts %f above the wind speed %f", c.WindGust, c.WindSpeed)
+		}
+		if c.Humidity < 0 || c.Humidity > 100 || c.CloudCover < 0 || c.CloudCover > 100 {
+			t.Fatalf("Expected percentages, got %+v", c)
+		}
+		if diff := c.Temperature - previous.Temperature; diff > 3 || diff < -3 {
+			t.Fatalf("Expected smooth temperatures, got %f after %f", c.Temperature, previous.Temperature)
+		}
+		if c.RainRate > 0 {
+			rained = true
+			if c.CloudCover != 100 {
+				t.Errorf("Expected overcast showers, got %f", c.CloudCover)
+			}
+		}
+		if c.RainRate == 0 && c.CloudCover > scenario.CloudCover {
+			clouded = true
+		}
+		previous = c
+	}
+	if !rained || !clouded {
+		t.Errorf("Expected showers and cloud passages, got rain %v and clouds %v", rained, clouded)
+	}
+
+	calm := newTestSimulator(t, Scenario{Humidity: 50, SkyQuality: 21}, 1)
+	c := calm.Condition(simulationStart.Add(5 * time.Hour))
+	if c.RainRate != 0 || c.CloudCover != 0 || c.SkyQuality != 21 {
+		t.Errorf("Expected a clear dry sky without showers or passages, got %+v", c)
+	}
+}
+
+func TestSimulator_Events(t *testing.T) {
+	unsafe := false
+	scenario := DefaultScenario
+	scenario.RainShowers = 0
+	scenario.Events = []ScenarioEvent{
+		{At: 10 * time.Minute, Duration: 20 * time.Minute, Values: map[string]float64{"rainrate": 2.5, "CloudCover": 100}},
+		{At: time.Hour, Safe: &unsafe},
+	}
+	simulator := newTestSimulator(t, scenario, 42)
+
+	if c := simulator.Condition(simulationStart.Add(9 * time.Minute)); c.RainRate != 0 {
+		t.Errorf("Expected no rain before the event, got %f", c.RainRate)
+	}
+	c := simulator.Condition(simulationStart.Add(10 * time.Minute))
+	if c.RainRate != 2.5 || c.CloudCover != 100 {
+		t.Errorf("Expected rain at T+10min, got %+v", c)
+	}
+	if c := simulator.Condition(simulationStart.Add(30 * time.Minute)); c.RainRate != 0 {
+		t.Errorf("Expected the rain to stop after 20 minutes, got %f", c.RainRate)
+	}
+	if _, scripted := simulator.Safe(simulationStart.Add(59 * time.Minute)); scripted {
+		t.Error("Expected no scripted safety before the event")
+	}
+	if safe, scripted := simulator.Safe(simulationStart.Add(5 * time.Hour)); !scripted || safe {
+		t.Error("Expected events without a duration to last")
+	}
+}
+
+func TestSimulator_Validate(t *testing.T) {
+	for name, scenario := range map[string]Scenario{
+		"humidity":       {Humidity: 120},
+		"wind":           {WindSpeed: -1},
+		"event time":     {Events: []ScenarioEvent{{At: -time.Minute}}},
+		"unknown sensor": {Events: []ScenarioEvent{{Values: map[string]float64{"Snow": 1}}}},
+		"average period": {Events: []ScenarioEvent{{Values: map[string]float64{SensorAveragePeriod: 1}}}},
+	} {
+		if _, err := NewSimulator(scenario, 1, simulationStart); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestObservingConditionsSimulator(t *testing.T) {
+	scenario := DefaultScenario
+	scenario.Events = []ScenarioEvent{{Values: map[string]float64{SensorTemperature: -5}}}
+	station := NewObservingConditionsSimulator("sim", "Simulator", "Simulated weather", newTestSimulator(t, scenario, 42))
+	if station.GetTemperature() != -5 {
+		t.Errorf("Expected temperature -5, got %f", station.GetTemperature())
+	}
+	if station.GetTimeStamp().IsZero() {
+		t.Error("Expected the refresh time to be set")
+	}
+	if !SupportsSensor(station, "cloudcover") || !SupportsSensor(station, SensorSkyQuality) {
+		t.Error("Expected simulated sky sensors to be supported")
+	}
+	if SupportsSensor(station, SensorStarFWHM) {
+		t.Error("Expected StarFWHM not to be supported")
+	}
+	if err := station.Refresh(); err != nil {
+		t.Errorf("Expected no error from Refresh(), got %v", err)
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/thebuh/barn/internal/fetch"
+	"github.com/thebuh/barn/internal/health"
+	"github.com/thebuh/barn/internal/record"
 )
 
 // Common errors
@@ -71,6 +74,25 @@ var SensorDescriptions = map[string]string{
 	SensorWindSpeed:      "Wind speed measurement",
 }
 
+// SensorUnits maps sensor names to the units the ASCOM standard reports
+// them in
+var SensorUnits = map[string]string{
+	SensorAveragePeriod:  "h",
+	SensorCloudCover:     "%",
+	SensorDewPoint:       "°C",
+	SensorHumidity:       "%",
+	SensorPressure:       "hPa",
+	SensorRainRate:       "mm/h",
+	SensorSkyBrightness:  "lx",
+	SensorSkyQuality:     "mag/arcsec²",
+	SensorSkyTemperature: "°C",
+	SensorStarFWHM:       "arcsec",
+	SensorTemperature:    "°C",
+	SensorWindDirection:  "°",
+	SensorWindGust:       "m/s",
+	SensorWindSpeed:      "m/s",
+}
+
 // IsValidSensor checks if a sensor name is valid (case-insensitive)
 func IsValidSensor(sensorName string) bool {
 	for key := range AvailableSensors {
@@ -89,6 +111,42 @@ func IsSensorAvailable(sensorName string) bool {
 	return AvailableSensors[sensorName]
 }
 
+// CanonicalSensor returns the sensor name with the case used by the API
+func CanonicalSensor(sensorName string) (string, bool) {
+	for key := range AvailableSensors {
+		if strings.EqualFold(key, sensorName) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// SensorSupporter is implemented by stations whose sensors differ from
+// AvailableSensors
+type SensorSupporter interface {
+	SupportsSensor(sensorName string) bool
+}
+
+// SupportsSensor checks if a station reports a sensor
+func SupportsSensor(o ObservingConditions, sensorName string) bool {
+	if s, ok := o.(SensorSupporter); ok {
+		sensor, valid := CanonicalSensor(sensorName)
+		return valid && s.SupportsSensor(sensor)
+	}
+	return IsSensorAvailable(sensorName)
+}
+
+// StationSensors returns the readings of the sensors a station reports
+func StationSensors(o ObservingConditions) map[string]float64 {
+	values := o.GetCondition().values()
+	for sensor := range values {
+		if !SupportsSensor(o, sensor) {
+			delete(values, sensor)
+		}
+	}
+	return values
+}
+
 // GetSensorDescription returns the description for a given sensor name
 func GetSensorDescription(sensorName string) (string, bool) {
 	description, exists := SensorDescriptions[sensorName]
@@ -123,6 +181,12 @@ type ObservingConditions interface {
 
 	GetState() string
 
+	// GetCondition returns a consistent snapshot of all current readings
+	GetCondition() WeatherCondition
+
+	// GetTimeStamp returns the time of the last successful refresh
+	GetTimeStamp() time.Time
+
 	// ASCOM Alpaca observing conditions methods
 	GetAveragePeriod() float64
 	SetAveragePeriod(period float64) error
@@ -160,133 +224,305 @@ type WeatherCondition struct {
 	WindSpeed      float64 `json:"wind_speed"`
 }
 
-// BaseObservingConditions contains common fields for all observing conditions implementations
+// Sensors returns the readings keyed by sensor name, limited to available sensors
+func (c WeatherCondition) Sensors() map[string]float64 {
+	values := c.values()
+	for sensor := range values {
+		if !IsSensorAvailable(sensor) {
+			delete(values, sensor)
+		}
+	}
+	return values
+}
+
+// values returns all readings keyed by sensor name
+func (c WeatherCondition) values() map[string]float64 {
+	return map[string]float64{
+		SensorCloudCover:     c.CloudCover,
+		SensorDewPoint:       c.DewPoint,
+		SensorHumidity:       c.Humidity,
+		SensorPressure:       c.Pressure,
+		SensorRainRate:       c.RainRate,
+		SensorSkyBrightness:  c.SkyBrightness,
+		SensorSkyQuality:     c.SkyQuality,
+		SensorSkyTemperature: c.SkyTemperature,
+		SensorStarFWHM:       c.StarFWHM,
+		SensorTemperature:    c.Temperature,
+		SensorWindDirection:  c.WindDirection,
+		SensorWindGust:       c.WindGust,
+		SensorWindSpeed:      c.WindSpeed,
+	}
+}
+
+// set updates the reading of a sensor
+func (c *WeatherCondition) set(sensor string, value float64) {
+	switch sensor {
+	case SensorCloudCover:
+		c.CloudCover = value
+	case SensorDewPoint:
+		c.DewPoint = value
+	case SensorHumidity:
+		c.Humidity = value
+	case SensorPressure:
+		c.Pressure = value
+	case SensorRainRate:
+		c.RainRate = value
+	case SensorSkyBrightness:
+		c.SkyBrightness = value
+	case SensorSkyQuality:
+		c.SkyQuality = value
+	case SensorSkyTemperature:
+		c.SkyTemperature = value
+	case SensorStarFWHM:
+		c.StarFWHM = value
+	case SensorTemperature:
+		c.Temperature = value
+	case SensorWindDirection:
+		c.WindDirection = value
+	case SensorWindGust:
+		c.WindGust = value
+	case SensorWindSpeed:
+		c.WindSpeed = value
+	}
+}
+
+// BaseObservingConditions contains common fields for all observing conditions implementations.
+// mu guards condition and lastRefreshTime; implementations fetch new readings
+// without holding it and publish them with setCondition.
 type BaseObservingConditions struct {
 	id              string
 	name            string
 	description     string
+	mu              sync.RWMutex
 	lastRefreshTime time.Time
 	condition       WeatherCondition
 }
 
-// ObservingConditionsDummy implements ObservingConditions with static values
-type ObservingConditionsDummy struct {
-	BaseObservingConditions
-}
-
-// NewObservingConditionsDummy creates a new dummy weather station
-func NewObservingConditionsDummy(id string, name string, description string) *ObservingConditionsDummy {
-	return &ObservingConditionsDummy{
-		BaseObservingConditions: BaseObservingConditions{
-			id:          id,
-			name:        name,
-			description: description,
-		},
-	}
+// snapshot returns a consistent copy of the current readings
+func (o *BaseObservingConditions) snapshot() (WeatherCondition, time.Time) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.condition, o.lastRefreshTime
 }
 
-func (o *ObservingConditionsDummy) Refresh() error {
-	// Dummy implementation doesn't need to do anything
-	return nil
+// setCondition publishes new readings, keeping the configured average period
+func (o *BaseObservingConditions) setCondition(condition WeatherCondition, refreshed time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	condition.AveragePeriod = o.condition.AveragePeriod
+	o.condition = condition
+	o.lastRefreshTime = refreshed
 }
 
-func (o *ObservingConditionsDummy) GetId() string {
+func (o *BaseObservingConditions) GetId() string {
 	return o.id
 }
 
-func (o *ObservingConditionsDummy) GetName() string {
+func (o *BaseObservingConditions) GetName() string {
 	return o.name
 }
 
-func (o *ObservingConditionsDummy) GetDescription() string {
+func (o *BaseObservingConditions) GetDescription() string {
 	return o.description
 }
 
-func (o *ObservingConditionsDummy) GetAveragePeriod() float64 {
-	return o.condition.AveragePeriod
+func (o *BaseObservingConditions) GetAveragePeriod() float64 {
+	c, _ := o.snapshot()
+	return c.AveragePeriod
 }
 
-func (o *ObservingConditionsDummy) SetAveragePeriod(period float64) error {
+func (o *BaseObservingConditions) SetAveragePeriod(period float64) error {
 	if period < 0 {
 		return ErrInvalidPeriod
 	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	o.condition.AveragePeriod = period
 	return nil
 }
 
-func (o *ObservingConditionsDummy) GetCloudCover() float64 {
-	return o.condition.CloudCover
+func (o *BaseObservingConditions) GetCloudCover() float64 {
+	c, _ := o.snapshot()
+	return c.CloudCover
+}
+
+func (o *BaseObservingConditions) GetDewPoint() float64 {
+	c, _ := o.snapshot()
+	return c.DewPoint
+}
+
+func (o *BaseObservingConditions) GetHumidity() float64 {
+	c, _ := o.snapshot()
+	return c.Humidity
 }
 
-func (o *ObservingConditionsDummy) GetDewPoint() float64 {
-	return o.condition.DewPoint
+func (o *BaseObservingConditions) GetPressure() float64 {
+	c, _ := o.snapshot()
+	return c.Pressure
 }
 
-func (o *ObservingConditionsDummy) GetHumidity() float64 {
-	return o.condition.Humidity
+func (o *BaseObservingConditions) GetRainRate() float64 {
+	c, _ := o.snapshot()
+	return c.RainRate
 }
 
-func (o *ObservingConditionsDummy) GetPressure() float64 {
-	return o.condition.Pressure
+func (o *BaseObservingConditions) GetSkyBrightness() float64 {
+	c, _ := o.snapshot()
+	return c.SkyBrightness
 }
 
-func (o *ObservingConditionsDummy) GetRainRate() float64 {
-	return o.condition.RainRate
+func (o *BaseObservingConditions) GetSkyQuality() float64 {
+	c, _ := o.snapshot()
+	return c.SkyQuality
 }
 
-func (o *ObservingConditionsDummy) GetSkyBrightness() float64 {
-	return o.condition.SkyBrightness
+func (o *BaseObservingConditions) GetSkyTemperature() float64 {
+	c, _ := o.snapshot()
+	return c.SkyTemperature
 }
 
-func (o *ObservingConditionsDummy) GetSkyQuality() float64 {
-	return o.condition.SkyQuality
+func (o *BaseObservingConditions) GetStarFWHM() float64 {
+	c, _ := o.snapshot()
+	return c.StarFWHM
 }
 
-func (o *ObservingConditionsDummy) GetSkyTemperature() float64 {
-	return o.condition.SkyTemperature
+func (o *BaseObservingConditions) GetTemperature() float64 {
+	c, _ := o.snapshot()
+	return c.Temperature
 }
 
-func (o *ObservingConditionsDummy) GetStarFWHM() float64 {
-	return o.condition.StarFWHM
+func (o *BaseObservingConditions) GetWindDirection() float64 {
+	c, _ := o.snapshot()
+	return c.WindDirection
 }
 
-func (o *ObservingConditionsDummy) GetTemperature() float64 {
-	return o.condition.Temperature
+func (o *BaseObservingConditions) GetWindGust() float64 {
+	c, _ := o.snapshot()
+	return c.WindGust
 }
 
-func (o *ObservingConditionsDummy) GetWindDirection() float64 {
-	return o.condition.WindDirection
+func (o *BaseObservingConditions) GetWindSpeed() float64 {
+	c, _ := o.snapshot()
+	return c.WindSpeed
 }
 
-func (o *ObservingConditionsDummy) GetWindGust() float64 {
-	return o.condition.WindGust
+func (o *BaseObservingConditions) GetTimeStamp() time.Time {
+	_, refreshed := o.snapshot()
+	return refreshed
 }
 
-func (o *ObservingConditionsDummy) GetWindSpeed() float64 {
-	return o.condition.WindSpeed
+func (o *BaseObservingConditions) GetTimeSinceLastUpdate() float64 {
+	_, refreshed := o.snapshot()
+	return time.Since(refreshed).Seconds()
 }
 
-func (o *ObservingConditionsDummy) GetTimeSinceLastUpdate() float64 {
-	return time.Since(o.lastRefreshTime).Seconds()
+func (o *BaseObservingConditions) GetCondition() WeatherCondition {
+	c, _ := o.snapshot()
+	return c
 }
 
-func (o *ObservingConditionsDummy) GetState() string {
-	json, _ := json.Marshal(o.condition)
+func (o *BaseObservingConditions) GetState() string {
+	c, _ := o.snapshot()
+	json, _ := json.Marshal(c)
 	return string(json)
 }
 
+// ObservingConditionsDummy implements ObservingConditions with values set
+// from the configuration or at runtime
+type ObservingConditionsDummy struct {
+	BaseObservingConditions
+	// set lists the sensors given a value, which are supported besides the
+	// available sensors
+	set map[string]bool
+}
+
+// NewObservingConditionsDummy creates a new dummy weather station
+func NewObservingConditionsDummy(id string, name string, description string) *ObservingConditionsDummy {
+	return &ObservingConditionsDummy{
+		BaseObservingConditions: BaseObservingConditions{
+			id:          id,
+			name:        name,
+			description: description,
+		},
+		set: make(map[string]bool),
+	}
+}
+
+// NewObservingConditionsDummyWithValues creates a dummy weather station
+// reporting values by sensor name
+func NewObservingConditionsDummyWithValues(id string, name string, description string, values map[string]float64) (*ObservingConditionsDummy, error) {
+	dummy := NewObservingConditionsDummy(id, name, description)
+	if err := dummy.SetValues(values); err != nil {
+		return nil, err
+	}
+	return dummy, nil
+}
+
+func (o *ObservingConditionsDummy) Refresh() error {
+	// Dummy implementation doesn't need to do anything
+	return nil
+}
+
+// SetValues changes readings by sensor name, in any case and with or without
+// underscores, such as RainRate or rain_rate. Other readings are kept. Either
+// all values are set or, if a name is unknown, none.
+func (o *ObservingConditionsDummy) SetValues(values map[string]float64) error {
+	canonical := make(map[string]float64, len(values))
+	for sensorName, value := range values {
+		sensor, valid := CanonicalSensor(strings.ReplaceAll(sensorName, "_", ""))
+		if !valid {
+			return fmt.Errorf("unknown sensor %q", sensorName)
+		}
+		if sensor == SensorAveragePeriod && value < 0 {
+			return ErrInvalidPeriod
+		}
+		canonical[sensor] = value
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for sensor, value := range canonical {
+		if sensor == SensorAveragePeriod {
+			o.condition.AveragePeriod = value
+			continue
+		}
+		o.condition.set(sensor, value)
+		o.set[sensor] = true
+	}
+	o.lastRefreshTime = time.Now()
+	return nil
+}
+
+// SupportsSensor implements SensorSupporter
+func (o *ObservingConditionsDummy) SupportsSensor(sensorName string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return AvailableSensors[sensorName] || o.set[sensorName]
+}
+
 // ObservingConditionsHttp implements ObservingConditions by fetching data from an HTTP endpoint
 type ObservingConditionsHttp struct {
 	BaseObservingConditions
 	url    string
-	client *http.Client
+	client *fetch.Client
+	record.Tap
 }
 
 // NewObservingConditionsHttp creates a new HTTP-based weather station
 func NewObservingConditionsHttp(id string, name string, description string, url string) (*ObservingConditionsHttp, error) {
+	return NewObservingConditionsHttpWithOptions(id, name, description, url, fetch.Options{})
+}
+
+// NewObservingConditionsHttpWithOptions creates an HTTP-based weather station
+// with a customised request
+func NewObservingConditionsHttpWithOptions(id string, name string, description string, url string, options fetch.Options) (*ObservingConditionsHttp, error) {
 	if url == "" {
 		return nil, ErrInvalidURL
 	}
+	client, err := fetch.New(url, options)
+	if err != nil {
+		return nil, err
+	}
 
 	cond := &ObservingConditionsHttp{
 		BaseObservingConditions: BaseObservingConditions{
@@ -294,10 +530,8 @@ func NewObservingConditionsHttp(id string, name string, description string, url
 			name:        name,
 			description: description,
 		},
-		url: url,
-	}
-	cond.client = &http.Client{
-		Timeout: 5 * time.Second,
+		url:    url,
+		client: client,
 	}
 	cond.SetAveragePeriod(0)
 	cond.Refresh()
@@ -305,25 +539,42 @@ func NewObservingConditionsHttp(id string, name string, description string, url
 }
 
 func (o *ObservingConditionsHttp) Refresh() error {
-	resp, err := o.client.Get(o.url)
+	content, err := o.client.Fetch()
+	o.Record(string(content), err)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	values, err := HTTPParser{}.Parse(string(content))
+	if err != nil {
+		return health.Parse(err)
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP request failed with status code %d", resp.StatusCode)
+	// Update the condition values
+	condition, _ := o.snapshot()
+	for sensor, value := range values {
+		condition.set(sensor, value)
 	}
+	o.setCondition(condition, time.Now())
+	return nil
+}
 
-	buf := make([]byte, 4096)
-	n, err := resp.Body.Read(buf)
-	if err != nil && err != io.EOF {
-		return err
+// HTTPParser reads the JSON of a station served over HTTP
+type HTTPParser struct{}
+
+func (HTTPParser) Sensors() map[string]bool {
+	return map[string]bool{
+		SensorTemperature:   true,
+		SensorDewPoint:      true,
+		SensorHumidity:      true,
+		SensorPressure:      true,
+		SensorWindSpeed:     true,
+		SensorWindGust:      true,
+		SensorWindDirection: true,
+		SensorRainRate:      true,
 	}
-	buf = buf[:n]
-	_ = resp.Body.Close()
-	content := string(buf)
-	// Parse the JSON content
+}
+
+func (HTTPParser) Parse(reply string) (map[string]float64, error) {
 	var weatherData struct {
 		ID             int     `json:"id"`
 		IndoorTemp     float64 `json:"indoortemp"`
@@ -347,106 +598,17 @@ func (o *ObservingConditionsHttp) Refresh() error {
 		SoftwareType   string  `json:"softwaretype"`
 	}
 
-	if err := json.Unmarshal([]byte(content), &weatherData); err != nil {
-		return fmt.Errorf("failed to parse weather data: %w", err)
+	if err := json.Unmarshal([]byte(reply), &weatherData); err != nil {
+		return nil, fmt.Errorf("failed to parse weather data: %w", err)
 	}
-
-	// Update the condition values
-	o.condition.Temperature = weatherData.Temp
-	o.condition.DewPoint = weatherData.DewPt
-	o.condition.Humidity = float64(weatherData.Humidity)
-	o.condition.Pressure = weatherData.BaroMin
-	o.condition.WindSpeed = weatherData.WindSpeedMS
-	o.condition.WindGust = weatherData.WindGustMS
-	o.condition.WindDirection = float64(weatherData.WindDir)
-	o.condition.RainRate = weatherData.RainIn
-	o.lastRefreshTime = time.Now()
-
-	fmt.Println("Refreshed weather conditions from", o.url)
-	return nil
-}
-
-func (o *ObservingConditionsHttp) GetId() string {
-	return o.id
-}
-
-func (o *ObservingConditionsHttp) GetName() string {
-	return o.name
-}
-
-func (o *ObservingConditionsHttp) GetDescription() string {
-	return o.description
-}
-
-func (o *ObservingConditionsHttp) GetAveragePeriod() float64 {
-	return o.condition.AveragePeriod
-}
-
-func (o *ObservingConditionsHttp) SetAveragePeriod(period float64) error {
-	if period < 0 {
-		return ErrInvalidPeriod
-	}
-	o.condition.AveragePeriod = period
-	return nil
-}
-
-func (o *ObservingConditionsHttp) GetCloudCover() float64 {
-	return o.condition.CloudCover
-}
-
-func (o *ObservingConditionsHttp) GetDewPoint() float64 {
-	return o.condition.DewPoint
-}
-
-func (o *ObservingConditionsHttp) GetHumidity() float64 {
-	return o.condition.Humidity
-}
-
-func (o *ObservingConditionsHttp) GetPressure() float64 {
-	return o.condition.Pressure
-}
-
-func (o *ObservingConditionsHttp) GetRainRate() float64 {
-	return o.condition.RainRate
-}
-
-func (o *ObservingConditionsHttp) GetSkyBrightness() float64 {
-	return o.condition.SkyBrightness
-}
-
-func (o *ObservingConditionsHttp) GetSkyQuality() float64 {
-	return o.condition.SkyQuality
-}
-
-func (o *ObservingConditionsHttp) GetSkyTemperature() float64 {
-	return o.condition.SkyTemperature
-}
-
-func (o *ObservingConditionsHttp) GetStarFWHM() float64 {
-	return o.condition.StarFWHM
-}
-
-func (o *ObservingConditionsHttp) GetTemperature() float64 {
-	return o.condition.Temperature
-}
-
-func (o *ObservingConditionsHttp) GetWindDirection() float64 {
-	return o.condition.WindDirection
-}
-
-func (o *ObservingConditionsHttp) GetWindGust() float64 {
-	return o.condition.WindGust
-}
-
-func (o *ObservingConditionsHttp) GetWindSpeed() float64 {
-	return o.condition.WindSpeed
-}
-
-func (o *ObservingConditionsHttp) GetTimeSinceLastUpdate() float64 {
-	return time.Since(o.lastRefreshTime).Seconds()
-}
-
-func (o *ObservingConditionsHttp) GetState() string {
-	json, _ := json.Marshal(o.condition)
-	return string(json)
+	return map[string]float64{
+		SensorTemperature:   weatherData.Temp,
+		SensorDewPoint:      weatherData.DewPt,
+		SensorHumidity:      float64(weatherData.Humidity),
+		SensorPressure:      weatherData.BaroMin,
+		SensorWindSpeed:     weatherData.WindSpeedMS,
+		SensorWindGust:      weatherData.WindGustMS,
+		SensorWindDirection: float64(weatherData.WindDir),
+		SensorRainRate:      weatherData.RainIn,
+	}, nil
 }
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/thebuh/barn/internal/fetch"
 )
 
 func TestNewObservingConditionsDummy(t *testing.T) {
@@ -28,6 +31,42 @@ func TestNewObservingConditionsDummy(t *testing.T) {
 	}
 }
 
+func TestObservingConditionsDummy_SetValues(t *testing.T) {
+	dummy, err := NewObservingConditionsDummyWithValues("test", "Test", "", map[string]float64{
+		"temperature": 12.5,
+		"cloud_cover": 40,
+		"SkyQuality":  20.1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create dummy: %v", err)
+	}
+	if dummy.GetTemperature() != 12.5 || dummy.GetCloudCover() != 40 || dummy.GetSkyQuality() != 20.1 {
+		t.Errorf("Expected configured values, got %+v", dummy.GetCondition())
+	}
+	if !SupportsSensor(dummy, SensorCloudCover) || SupportsSensor(dummy, SensorStarFWHM) {
+		t.Error("Expected set sensors to be supported besides the available ones")
+	}
+	if !SupportsSensor(dummy, SensorHumidity) {
+		t.Error("Expected available sensors to stay supported")
+	}
+
+	if err := dummy.SetValues(map[string]float64{"RainRate": 2, "AveragePeriod": 0.5}); err != nil {
+		t.Fatalf("Expected no error from SetValues(), got %v", err)
+	}
+	if dummy.GetRainRate() != 2 || dummy.GetAveragePeriod() != 0.5 || dummy.GetTemperature() != 12.5 {
+		t.Errorf("Expected only the given values to change, got %+v", dummy.GetCondition())
+	}
+	if err := dummy.SetValues(map[string]float64{"RainRate": 0, "Visibility": 10}); err == nil {
+		t.Error("Expected error from SetValues() with an unknown sensor, got nil")
+	}
+	if dummy.GetRainRate() != 2 {
+		t.Error("Expected no values to change when a sensor is unknown")
+	}
+	if err := dummy.SetValues(map[string]float64{"average_period": -1}); err != ErrInvalidPeriod {
+		t.Errorf("Expected ErrInvalidPeriod, got %v", err)
+	}
+}
+
 func TestObservingConditionsDummy_Refresh(t *testing.T) {
 	dummy := NewObservingConditionsDummy("test", "Test", "Test Station")
 
@@ -443,6 +482,40 @@ func TestObservingConditionsHttp_Refresh(t *testing.T) {
 	}
 }
 
+func TestObservingConditionsHttp_Recorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"temp": 4.5, "rainin": 0.2}`))
+	}))
+	defer server.Close()
+
+	station, err := NewObservingConditionsHttp("test", "Test", "Test Station", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP client: %v", err)
+	}
+	var payloads []string
+	station.UseRecorder(func(payload string, err error) {
+		payloads = append(payloads, payload)
+	})
+	if err := station.Refresh(); err != nil {
+		t.Fatalf("Expected no error from Refresh(), got %v", err)
+	}
+	if len(payloads) != 1 || payloads[0] != `{"temp": 4.5, "rainin": 0.2}` {
+		t.Fatalf("Expected the response to be recorded, got %q", payloads)
+	}
+
+	// Replaying the recorded payload gives the same readings
+	values, err := HTTPParser{}.Parse(payloads[0])
+	if err != nil {
+		t.Fatalf("Expected no error from Parse(), got %v", err)
+	}
+	if values[SensorTemperature] != station.GetTemperature() || values[SensorRainRate] != station.GetRainRate() {
+		t.Errorf("Expected parsed values to match the station, got %v", values)
+	}
+	if _, err := (HTTPParser{}).Parse("not json"); err == nil {
+		t.Error("Expected error from Parse() with invalid JSON, got nil")
+	}
+}
+
 func TestObservingConditionsHttp_Refresh_InvalidResponse(t *testing.T) {
 	// Create a test server that returns invalid JSON
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -483,6 +556,38 @@ func TestObservingConditionsHttp_Refresh_ServerError(t *testing.T) {
 	}
 }
 
+func TestObservingConditionsHttp_Options(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "barn" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"temp": 12.5}`))
+	}))
+	defer server.Close()
+
+	http, err := NewObservingConditionsHttpWithOptions("test", "Test", "Test Station", server.URL, fetch.Options{Username: "barn", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create HTTP client: %v", err)
+	}
+	if err := http.Refresh(); err != nil {
+		t.Errorf("Expected no error from Refresh(), got %v", err)
+	}
+	if http.GetTemperature() != 12.5 {
+		t.Errorf("Expected temperature 12.5, got %f", http.GetTemperature())
+	}
+
+	unauthorized, _ := NewObservingConditionsHttp("test", "Test", "Test Station", server.URL)
+	if err := unauthorized.Refresh(); err == nil {
+		t.Error("Expected error from Refresh() without credentials, got nil")
+	}
+
+	if _, err := NewObservingConditionsHttpWithOptions("test", "Test", "Test Station", server.URL, fetch.Options{MaxBodySize: -1}); err == nil {
+		t.Error("Expected error for invalid options, got nil")
+	}
+}
+
 func TestObservingConditionsHttp_GetId(t *testing.T) {
 	http, _ := NewObservingConditionsHttp("test-id", "Test", "Test Station", "http://example.com")
 
@@ -822,3 +927,76 @@ func TestIsSensorAvailable(t *testing.T) {
 		t.Error("Expected invalid sensor to be unavailable")
 	}
 }
+
+func TestObservingConditionsHttp_ConcurrentRefresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"temp": 18.3, "humidity": 65, "windspeedms": 5.2}`))
+	}))
+	defer server.Close()
+
+	station, err := NewObservingConditionsHttp("test", "Test", "Test Station", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP client: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				station.Refresh()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				station.GetTemperature()
+				station.GetState()
+				station.GetTimeSinceLastUpdate()
+			}
+		}()
+		go func(period float64) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				station.SetAveragePeriod(period)
+				station.GetAveragePeriod()
+			}
+		}(float64(i))
+	}
+	wg.Wait()
+
+	if station.GetTemperature() != 18.3 {
+		t.Errorf("Expected temperature 18.3, got %f", station.GetTemperature())
+	}
+}
+
+func TestWeatherCondition_Sensors(t *testing.T) {
+	condition := WeatherCondition{Temperature: 12.5, Humidity: 80, SkyQuality: 21.3}
+	sensors := condition.Sensors()
+
+	if sensors[SensorTemperature] != 12.5 {
+		t.Errorf("Expected temperature 12.5, got %f", sensors[SensorTemperature])
+	}
+	if sensors[SensorHumidity] != 80 {
+		t.Errorf("Expected humidity 80, got %f", sensors[SensorHumidity])
+	}
+	if _, exists := sensors[SensorSkyQuality]; exists {
+		t.Errorf("Unavailable sensor %s should not be reported", SensorSkyQuality)
+	}
+	if _, exists := sensors[SensorAveragePeriod]; exists {
+		t.Errorf("%s is not a sensor reading and should not be reported", SensorAveragePeriod)
+	}
+}
+
+func TestSensorUnits(t *testing.T) {
+	for sensor := range AvailableSensors {
+		if SensorUnits[sensor] == "" {
+			t.Errorf("Expected a unit for %s", sensor)
+		}
+		if _, exists := GetSensorDescription(sensor); !exists {
+			t.Errorf("Expected a description for %s", sensor)
+		}
+	}
+}
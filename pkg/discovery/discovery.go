@@ -1,10 +1,14 @@
 package discovery
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
 )
 
 //Implementation of ASCOM Alpaca discovery protocol
@@ -17,6 +21,9 @@ type DiscoveryServer struct {
 	Conn         net.PacketConn
 	ApiPort      uint32
 	ListenString string
+
+	// mu guards Conn, which Start sets while Close may be called from another goroutine
+	mu sync.Mutex
 }
 
 func NewDiscoverySever(listenPort uint32, apiPort uint32) *DiscoveryServer {
@@ -35,24 +42,29 @@ func NewDiscoverySever(listenPort uint32, apiPort uint32) *DiscoveryServer {
 // Start listening on all interfaces
 func (s *DiscoveryServer) Start() {
 	udpServer, err := net.ListenPacket("udp", s.ListenString)
-
 	if err != nil {
-		fmt.Errorf(err.Error())
+		log.WithError(err).Error(fmt.Sprintf("[BARN] Discovery. Failed to listen on [%s]", s.ListenString))
+		return
 	}
+	s.mu.Lock()
 	s.Conn = udpServer
+	s.mu.Unlock()
 	defer s.Close()
 	//Listen for discovery packets on all interfaces
 	for {
 		buf := make([]byte, 1024)
 		_, addr, err := udpServer.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			continue
 		}
-		fmt.Sprintf("GOT Discovery packet From %s", addr)
+		log.Debug(fmt.Sprintf("[BARN] Discovery. Got discovery packet from %s", addr))
 		msg := string(buf)
 		//Only handle and reply to discovery packets 1st version
 		if strings.HasPrefix(msg, "alpacadiscovery1") {
-			go s.handleDiscoveryPacket(addr)
+			go s.handleDiscoveryPacket(udpServer, addr)
 		}
 	}
 }
@@ -61,10 +73,14 @@ func (s *DiscoveryServer) composeDiscoveryReply() string {
 }
 
 // Reply with our alpaca port
-func (s *DiscoveryServer) handleDiscoveryPacket(addr net.Addr) {
-	fmt.Sprintf("Sending discovery alpacaport packet to %s", addr)
-	s.Conn.WriteTo([]byte(s.composeDiscoveryReply()), addr)
+func (s *DiscoveryServer) handleDiscoveryPacket(conn net.PacketConn, addr net.Addr) {
+	log.Debug(fmt.Sprintf("[BARN] Discovery. Sending alpaca port to %s", addr))
+	conn.WriteTo([]byte(s.composeDiscoveryReply()), addr)
 }
 func (s *DiscoveryServer) Close() {
-	s.Conn.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Conn != nil {
+		s.Conn.Close()
+	}
 }